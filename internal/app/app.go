@@ -2,6 +2,7 @@ package app
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -9,8 +10,12 @@ import (
 	"time"
 
 	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/grpcserver"
 	"github.com/gtgaleevtimur/gofermart/internal/handler"
 	r "github.com/gtgaleevtimur/gofermart/internal/repository"
 )
@@ -21,9 +26,11 @@ func Run() {
 	log.Debug().Str("RUN_ADDRESS", conf.Address).
 		Str("DATABASE_URI", conf.DatabaseURI).
 		Str("ACCRUAL_SYSTEM_ADDRESS", conf.AccrualSystemAddress).
+		Str("GRPC_ADDRESS", conf.GRPCAddress).
+		Bool("TLS", conf.TLSEnabled()).
 		Msg("Receive config")
 	// Инициализируем хранилище.
-	repository, err := r.NewRepository(conf.DatabaseURI)
+	repository, err := r.NewRepository(conf)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Repository initialization failed")
 	}
@@ -32,11 +39,36 @@ func Run() {
 	signal.Notify(sig, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	server := &http.Server{
 		Addr:    conf.Address,
-		Handler: handler.NewRouter(repository),
+		Handler: handler.NewRouter(repository, conf),
+	}
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+	var certs *certReloader
+	if conf.TLSEnabled() {
+		// Настраиваем TLS с перечитыванием сертификатов и HTTP/2.
+		certs, err = newCertReloader(conf)
+		if err != nil {
+			log.Fatal().Err(err).Msg("TLS initialization failed")
+		}
+		server.TLSConfig = certs.TLSConfig()
+		err = http2.ConfigureServer(server, &http2.Server{})
+		if err != nil {
+			log.Fatal().Err(err).Msg("HTTP/2 initialization failed")
+		}
+		go certs.Watch(bgCtx, conf.TLSReloadInterval)
+	}
+	var grpcServer *grpc.Server
+	if conf.GRPCAddress != "" {
+		var opts []grpc.ServerOption
+		if certs != nil {
+			opts = append(opts, grpc.Creds(credentials.NewTLS(certs.TLSConfig())))
+		}
+		grpcServer = grpcserver.NewServer(repository, opts...)
 	}
 	// Запускаем горутину Grace-ful Shutdown.
 	go func() {
 		<-sig
+		bgCancel()
 		shutdownCtx, shutdownCtxCancel := context.WithTimeout(context.Background(), time.Second*20)
 		defer shutdownCtxCancel()
 		go func() {
@@ -45,6 +77,9 @@ func Run() {
 				log.Fatal().Msg("graceful shutdown timed out and forcing exit.")
 			}
 		}()
+		if grpcServer != nil {
+			grpcServer.GracefulStop()
+		}
 		err = server.Shutdown(context.Background())
 		if err != nil {
 			log.Fatal().Err(err).Msg("server shutdown error")
@@ -53,11 +88,36 @@ func Run() {
 	// Запускаем сервер.
 	go func() {
 		log.Info().Str("starting server at", server.Addr)
-		err = server.ListenAndServe()
+		if conf.TLSEnabled() {
+			// Сертификаты берутся из TLSConfig, поэтому пути к файлам не передаем.
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("failed to run server")
 		}
 	}()
+	// Запускаем gRPC-сервер на отдельном порту.
+	if grpcServer != nil {
+		go func() {
+			listener, err := net.Listen("tcp", conf.GRPCAddress)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to listen gRPC address")
+			}
+			log.Info().Str("address", conf.GRPCAddress).Msg("starting gRPC server")
+			err = grpcServer.Serve(listener)
+			if err != nil {
+				log.Fatal().Err(err).Msg("failed to run gRPC server")
+			}
+		}()
+	}
+	// Запускаем очистку просроченных сессий.
+	go r.NewSweeper(repository, conf.SessionSweepInterval).Start(bgCtx)
+	// Запускаем сгорание просроченных баллов.
+	go r.NewExpirer(repository, conf.PointsExpiryInterval).Start(bgCtx)
+	// Запускаем обработку заданий пакетной загрузки заказов.
+	go r.NewUploader(repository, conf.UploadInterval).Start(bgCtx)
 	// Запускаем сервис заказов.
 	blackbox := r.NewBlackbox(repository, conf.AccrualSystemAddress)
 	blackbox.Start()
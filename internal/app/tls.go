@@ -0,0 +1,157 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+)
+
+// certReloader - хранилище TLS-сертификата сервера и CA клиентов, перечитывающее файлы при их изменении на диске.
+type certReloader struct {
+	sync.RWMutex
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+	cert       *tls.Certificate
+	clientCAs  *x509.CertPool
+	modTimes   map[string]time.Time
+}
+
+// newCertReloader - конструктор хранилища сертификатов, сразу загружающий файлы из конфига.
+func newCertReloader(conf *config.Config) (*certReloader, error) {
+	clientAuth, err := parseClientAuth(conf.TLSClientAuth)
+	if err != nil {
+		return nil, err
+	}
+	cr := &certReloader{
+		certFile:   conf.TLSCertFile,
+		keyFile:    conf.TLSKeyFile,
+		caFile:     conf.TLSClientCAFile,
+		clientAuth: clientAuth,
+		modTimes:   make(map[string]time.Time),
+	}
+	if cr.caFile == "" {
+		cr.clientAuth = tls.NoClientCert
+	}
+	if _, err = cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// parseClientAuth - функция, переводящая режим mTLS из конфига в тип crypto/tls.
+func parseClientAuth(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return tls.NoClientCert, fmt.Errorf("unknown TLS client auth mode `%s`", mode)
+	}
+}
+
+// TLSConfig - метод, возвращающий конфиг TLS сервера, который всегда использует актуальные сертификаты.
+func (cr *certReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	// До Go 1.21 ServeTLS без Certificates и GetCertificate пытается загрузить сертификат из пустых путей.
+	base.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cr.RLock()
+		defer cr.RUnlock()
+		return cr.cert, nil
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		cr.RLock()
+		defer cr.RUnlock()
+		c := base.Clone()
+		c.GetConfigForClient = nil
+		c.GetCertificate = nil
+		c.Certificates = []tls.Certificate{*cr.cert}
+		c.ClientAuth = cr.clientAuth
+		c.ClientCAs = cr.clientCAs
+		return c, nil
+	}
+	return base
+}
+
+// Watch - метод, периодически проверяющий файлы сертификатов и перечитывающий их при изменении.
+func (cr *certReloader) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := cr.reload()
+			if err != nil {
+				log.Error().Err(err).Msg("failed to reload TLS certificates, keep using previous ones")
+				continue
+			}
+			if reloaded {
+				log.Info().Str("cert", cr.certFile).Msg("TLS certificates reloaded")
+			}
+		}
+	}
+}
+
+// reload - метод, перечитывающий сертификаты, если хотя бы один из файлов изменился.
+func (cr *certReloader) reload() (bool, error) {
+	files := []string{cr.certFile, cr.keyFile}
+	if cr.caFile != "" {
+		files = append(files, cr.caFile)
+	}
+	modTimes := make(map[string]time.Time, len(files))
+	changed := false
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return false, fmt.Errorf("failed to stat `%s` - %s", f, err.Error())
+		}
+		modTimes[f] = info.ModTime()
+		if !info.ModTime().Equal(cr.modTimes[f]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed to load key pair - %s", err.Error())
+	}
+	var pool *x509.CertPool
+	if cr.caFile != "" {
+		pem, err := os.ReadFile(cr.caFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to read client CA - %s", err.Error())
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return false, fmt.Errorf("no certificates found in `%s`", cr.caFile)
+		}
+	}
+	cr.Lock()
+	cr.cert = &cert
+	cr.clientCAs = pool
+	cr.modTimes = modTimes
+	cr.Unlock()
+	return true, nil
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+)
+
+// writeCert - функция, записывающая самоподписанный сертификат с серийным номером serial и его ключ в файлы.
+func writeCert(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	// Явное время изменения, чтобы перезапись в ту же секунду тоже считалась изменением.
+	require.NoError(t, os.Chtimes(certFile, modTime, modTime))
+	require.NoError(t, os.Chtimes(keyFile, modTime, modTime))
+}
+
+// servedSerial - функция, возвращающая серийный номер сертификата, который предъявляет сервер.
+func servedSerial(t *testing.T, addr string) int64 {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true, ServerName: "localhost"})
+	require.NoError(t, err)
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+	writeCert(t, certFile, keyFile, 1, start)
+
+	cr, err := newCertReloader(&config.Config{TLSCertFile: certFile, TLSKeyFile: keyFile})
+	require.NoError(t, err)
+
+	// ServeTLS до Go 1.21 считает сертификат настроенным только при Certificates или GetCertificate.
+	require.NotNil(t, cr.TLSConfig().GetCertificate)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{
+		Handler:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig:         cr.TLSConfig(),
+		ReadHeaderTimeout: time.Second,
+	}
+	served := make(chan error, 1)
+	go func() {
+		// Как и в Run, сертификаты берутся только из TLSConfig.
+		served <- server.ServeTLS(listener, "", "")
+	}()
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+		require.ErrorIs(t, <-served, http.ErrServerClosed)
+	})
+
+	require.Equal(t, int64(1), servedSerial(t, listener.Addr().String()))
+
+	reloaded, err := cr.reload()
+	require.NoError(t, err)
+	require.False(t, reloaded)
+
+	writeCert(t, certFile, keyFile, 2, start.Add(time.Second))
+	reloaded, err = cr.reload()
+	require.NoError(t, err)
+	require.True(t, reloaded)
+	require.Equal(t, int64(2), servedSerial(t, listener.Addr().String()))
+
+	// Испорченный файл не заменяет работающий сертификат.
+	require.NoError(t, os.WriteFile(certFile, []byte("broken"), 0o600))
+	require.NoError(t, os.Chtimes(certFile, start.Add(2*time.Second), start.Add(2*time.Second)))
+	_, err = cr.reload()
+	require.Error(t, err)
+	require.Equal(t, int64(2), servedSerial(t, listener.Addr().String()))
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		mode    string
+		want    tls.ClientAuthType
+		wantErr bool
+	}{
+		{"", tls.NoClientCert, false},
+		{"none", tls.NoClientCert, false},
+		{"optional", tls.VerifyClientCertIfGiven, false},
+		{"require", tls.RequireAndVerifyClientCert, false},
+		{"always", tls.NoClientCert, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			got, err := parseClientAuth(tt.mode)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// Precision - точность времени события. Совпадает с точностью timestamptz, чтобы хэш сходился после чтения из БД.
+const Precision = time.Microsecond
+
+// Hash - функция, вычисляющая хэш события от хэша предыдущего события prev.
+// Поля пишутся с префиксом длины, чтобы их нельзя было сдвинуть из одного в другое без смены хэша. ID в хэш не входит:
+// порядок задает сама цепочка. Имя цепочки входит, иначе первое событие цепочки можно перенести в пустую цепочку.
+func Hash(prev string, e *entity.AuditEvent) string {
+	h := sha256.New()
+	for _, field := range []string{
+		prev,
+		e.Chain,
+		e.CreatedAt.UTC().Truncate(Precision).Format(time.RFC3339Nano),
+		strconv.FormatUint(e.ActorID, 10),
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.Before,
+		e.After,
+		e.RequestID,
+		e.IP,
+	} {
+		h.Write([]byte(strconv.Itoa(len(field))))
+		h.Write([]byte{':'})
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify - функция, проверяющая события, идущие в журнале подряд. Журнал состоит из нескольких цепочек, события
+// которых перемежаются: last хранит хэш последнего проверенного события каждой цепочки (для начала журнала - пустой)
+// и обновляется по ходу проверки. Возвращает индекс первого события, у которого не сходится ссылка на предыдущее
+// событие своей цепочки или собственный хэш, либо -1.
+func Verify(last map[string]string, events []entity.AuditEvent) int {
+	for i := range events {
+		prev := last[events[i].Chain]
+		if events[i].PrevHash != prev || Hash(prev, &events[i]) != events[i].Hash {
+			return i
+		}
+		last[events[i].Chain] = events[i].Hash
+	}
+	return -1
+}
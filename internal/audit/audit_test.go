@@ -0,0 +1,128 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// chain - функция, собирающая корректную цепочку name из n событий.
+func chain(name string, n int) []entity.AuditEvent {
+	events := make([]entity.AuditEvent, 0, n)
+	prev := ""
+	at := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	for i := 0; i < n; i++ {
+		e := entity.AuditEvent{
+			Chain:      name,
+			CreatedAt:  at.Add(time.Duration(i) * time.Second),
+			ActorID:    uint64(i + 1),
+			Action:     entity.AuditWithdraw,
+			TargetType: entity.AuditTargetUser,
+			TargetID:   "1",
+			Before:     `{"current":1000}`,
+			After:      `{"current":900}`,
+			RequestID:  "req",
+			IP:         "127.0.0.1",
+			PrevHash:   prev,
+		}
+		e.Hash = Hash(prev, &e)
+		prev = e.Hash
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestHash(t *testing.T) {
+	e := chain(entity.AuditChainMain, 1)[0]
+	// Время после чтения из БД - с точностью до микросекунд и в другом часовом поясе.
+	read := e
+	read.CreatedAt = e.CreatedAt.Truncate(time.Microsecond).In(time.FixedZone("MSK", 3*60*60))
+	require.Equal(t, e.Hash, Hash("", &read))
+
+	// Перенос символов между полями меняет хэш.
+	shifted := e
+	shifted.Before, shifted.After = `{"current":1000}{`, `"current":900}`
+	require.NotEqual(t, e.Hash, Hash("", &shifted))
+
+	// Имя цепочки входит в хэш.
+	moved := e
+	moved.Chain = entity.AuditChainAuth
+	require.NotEqual(t, e.Hash, Hash("", &moved))
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(events []entity.AuditEvent) []entity.AuditEvent
+		broken int
+	}{
+		{
+			name:   "Intact chain",
+			tamper: func(events []entity.AuditEvent) []entity.AuditEvent { return events },
+			broken: -1,
+		},
+		{
+			name: "Changed value",
+			tamper: func(events []entity.AuditEvent) []entity.AuditEvent {
+				events[2].After = `{"current":100000}`
+				return events
+			},
+			broken: 2,
+		},
+		{
+			name: "Deleted event",
+			tamper: func(events []entity.AuditEvent) []entity.AuditEvent {
+				return append(events[:1], events[2:]...)
+			},
+			broken: 1,
+		},
+		{
+			name: "Rehashed event",
+			tamper: func(events []entity.AuditEvent) []entity.AuditEvent {
+				events[1].ActorID = 42
+				events[1].Hash = Hash(events[1].PrevHash, &events[1])
+				return events
+			},
+			broken: 2,
+		},
+		{
+			name: "Event moved to another chain",
+			tamper: func(events []entity.AuditEvent) []entity.AuditEvent {
+				events[1].Chain = entity.AuditChainAuth
+				return events
+			},
+			broken: 1,
+		},
+		{
+			// Ссылка на предыдущее событие у первого события любой цепочки пустая, выдает перенос только хэш.
+			name: "First event moved to another chain",
+			tamper: func(events []entity.AuditEvent) []entity.AuditEvent {
+				events[0].Chain = entity.AuditChainAuth
+				return events
+			},
+			broken: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.broken, Verify(map[string]string{}, tt.tamper(chain(entity.AuditChainMain, 4))))
+		})
+	}
+}
+
+func TestVerifyChains(t *testing.T) {
+	general := chain(entity.AuditChainMain, 3)
+	auth := chain(entity.AuditChainAuth, 2)
+	// События цепочек перемежаются в порядке записи, каждая ссылается только на свои.
+	events := []entity.AuditEvent{general[0], auth[0], general[1], auth[1], general[2]}
+	last := map[string]string{}
+	require.Equal(t, -1, Verify(last, events))
+	require.Equal(t, general[2].Hash, last[entity.AuditChainMain])
+	require.Equal(t, auth[1].Hash, last[entity.AuditChainAuth])
+
+	events[3].IP = "10.0.0.1"
+	require.Equal(t, 3, Verify(map[string]string{}, events))
+}
@@ -1,16 +1,96 @@
 package config
 
 import (
+	"errors"
 	"flag"
 	"log"
+	"time"
 
 	"github.com/caarlos0/env"
 )
 
 type Config struct {
-	Address              string `env:"RUN_ADDRESS"`
-	DatabaseURI          string `env:"DATABASE_URI"`
-	AccrualSystemAddress string `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	Address                  string        `env:"RUN_ADDRESS"`
+	DatabaseURI              string        `env:"DATABASE_URI"`
+	AccrualSystemAddress     string        `env:"ACCRUAL_SYSTEM_ADDRESS"`
+	GRPCAddress              string        `env:"GRPC_ADDRESS"`
+	TLSCertFile              string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile               string        `env:"TLS_KEY_FILE"`
+	TLSClientCAFile          string        `env:"TLS_CLIENT_CA_FILE"`
+	TLSClientAuth            string        `env:"TLS_CLIENT_AUTH"`
+	TLSReloadInterval        time.Duration `env:"TLS_RELOAD_INTERVAL"`
+	OpenAPIValidateResponses bool          `env:"OPENAPI_VALIDATE_RESPONSES"`
+	CookieSecure             bool          `env:"COOKIE_SECURE"`
+	CookieSameSite           string        `env:"COOKIE_SAMESITE"`
+	CookieDomain             string        `env:"COOKIE_DOMAIN"`
+	CookiePath               string        `env:"COOKIE_PATH"`
+	CSRFProtection           bool          `env:"CSRF_PROTECTION"`
+	CSRFTrustedOrigins       string        `env:"CSRF_TRUSTED_ORIGINS"`
+	TrustedProxies           string        `env:"TRUSTED_PROXIES"`
+	CORSAllowedOrigins       string        `env:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowedMethods       string        `env:"CORS_ALLOWED_METHODS"`
+	CORSAllowedHeaders       string        `env:"CORS_ALLOWED_HEADERS"`
+	CORSAllowCredentials     bool          `env:"CORS_ALLOW_CREDENTIALS"`
+	CORSMaxAge               time.Duration `env:"CORS_MAX_AGE"`
+	SessionTTL               time.Duration `env:"SESSION_TTL"`
+	SessionMaxLifetime       time.Duration `env:"SESSION_MAX_LIFETIME"`
+	SessionSweepInterval     time.Duration `env:"SESSION_SWEEP_INTERVAL"`
+	SessionTokenKeys         string        `env:"SESSION_TOKEN_KEYS"`
+	AccessTokenKey           string        `env:"ACCESS_TOKEN_KEY"`
+	AccessTokenTTL           time.Duration `env:"ACCESS_TOKEN_TTL"`
+	RefreshTokenTTL          time.Duration `env:"REFRESH_TOKEN_TTL"`
+	LoginLimitWindow         time.Duration `env:"LOGIN_LIMIT_WINDOW"`
+	LoginLimitPerIP          int           `env:"LOGIN_LIMIT_PER_IP"`
+	LoginLimitPerLogin       int           `env:"LOGIN_LIMIT_PER_LOGIN"`
+	LockoutThreshold         int           `env:"LOGIN_LOCKOUT_THRESHOLD"`
+	LockoutBase              time.Duration `env:"LOGIN_LOCKOUT_BASE"`
+	LockoutMax               time.Duration `env:"LOGIN_LOCKOUT_MAX"`
+	LoginMinLength           int           `env:"LOGIN_MIN_LENGTH"`
+	LoginMaxLength           int           `env:"LOGIN_MAX_LENGTH"`
+	LoginPattern             string        `env:"LOGIN_PATTERN"`
+	PasswordMinLength        int           `env:"PASSWORD_MIN_LENGTH"`
+	PasswordMaxBytes         int           `env:"PASSWORD_MAX_BYTES"`
+	PasswordBlocklistFile    string        `env:"PASSWORD_BLOCKLIST_FILE"`
+	PasswordHasher           string        `env:"PASSWORD_HASHER"`
+	Argon2Memory             uint          `env:"PASSWORD_ARGON2_MEMORY"`
+	Argon2Time               uint          `env:"PASSWORD_ARGON2_TIME"`
+	Argon2Threads            uint          `env:"PASSWORD_ARGON2_THREADS"`
+	BcryptCost               int           `env:"PASSWORD_BCRYPT_COST"`
+	PasswordResetTTL         time.Duration `env:"PASSWORD_RESET_TTL"`
+	ResetLimitWindow         time.Duration `env:"PASSWORD_RESET_LIMIT_WINDOW"`
+	ResetLimitPerIP          int           `env:"PASSWORD_RESET_LIMIT_PER_IP"`
+	ResetLimitPerLogin       int           `env:"PASSWORD_RESET_LIMIT_PER_LOGIN"`
+	PasswordResetURL         string        `env:"PASSWORD_RESET_URL"`
+	NotifyDriver             string        `env:"NOTIFY_DRIVER"`
+	NotifyFile               string        `env:"NOTIFY_FILE"`
+	SMTPAddress              string        `env:"SMTP_ADDRESS"`
+	SMTPUsername             string        `env:"SMTP_USERNAME"`
+	SMTPPassword             string        `env:"SMTP_PASSWORD"`
+	SMTPFrom                 string        `env:"SMTP_FROM"`
+	TOTPIssuer               string        `env:"TOTP_ISSUER"`
+	TOTPEncryptionKey        string        `env:"TOTP_ENCRYPTION_KEY"`
+	TOTPWithdrawThreshold    float64       `env:"TOTP_WITHDRAW_THRESHOLD"`
+	MFAChallengeTTL          time.Duration `env:"MFA_CHALLENGE_TTL"`
+	APIKeyTTL                time.Duration `env:"API_KEY_TTL"`
+	APIKeyMaxTTL             time.Duration `env:"API_KEY_MAX_TTL"`
+	AdminLogins              string        `env:"ADMIN_LOGINS"`
+	AdjustmentThreshold      float64       `env:"ADJUSTMENT_APPROVAL_THRESHOLD"`
+	AdjustmentDailyLimit     float64       `env:"ADJUSTMENT_DAILY_LIMIT"`
+	PointsExpiryMonths       int           `env:"POINTS_EXPIRY_MONTHS"`
+	PointsExpiringSoon       time.Duration `env:"POINTS_EXPIRING_SOON"`
+	PointsExpiryInterval     time.Duration `env:"POINTS_EXPIRY_INTERVAL"`
+	LoyaltyTiers             string        `env:"LOYALTY_TIERS"`
+	TierWindow               time.Duration `env:"LOYALTY_TIER_WINDOW"`
+	ReferrerBonus            float64       `env:"REFERRAL_REFERRER_BONUS"`
+	RefereeBonus             float64       `env:"REFERRAL_REFEREE_BONUS"`
+	ReferralMinAccrual       float64       `env:"REFERRAL_MIN_ACCRUAL"`
+	ReferralMaxPerUser       int           `env:"REFERRAL_MAX_PER_USER"`
+	ReferralMaxPerIP         int           `env:"REFERRAL_MAX_PER_IP"`
+	TransferDailyLimit       float64       `env:"TRANSFER_DAILY_LIMIT"`
+	UploadSyncLimit          int           `env:"ORDERS_UPLOAD_SYNC_LIMIT"`
+	UploadMaxLines           int           `env:"ORDERS_UPLOAD_MAX_LINES"`
+	UploadBatchSize          int           `env:"ORDERS_UPLOAD_BATCH_SIZE"`
+	UploadInterval           time.Duration `env:"ORDERS_UPLOAD_INTERVAL"`
 }
 
 // NewConfig - функция конструктор конфига с настройками окружения.
@@ -19,10 +99,110 @@ func NewConfig() *Config {
 	flag.StringVar(&c.Address, "a", ":8080", "RUN_ADDRESS")
 	flag.StringVar(&c.DatabaseURI, "d", "", "DATABASE_URI")
 	flag.StringVar(&c.AccrualSystemAddress, "r", "http://localhost:8081", "ACCRUAL_SYSTEM_ADDRESS")
+	flag.StringVar(&c.GRPCAddress, "g", "", "GRPC_ADDRESS")
+	flag.StringVar(&c.TLSCertFile, "tls-cert", "", "TLS_CERT_FILE")
+	flag.StringVar(&c.TLSKeyFile, "tls-key", "", "TLS_KEY_FILE")
+	flag.StringVar(&c.TLSClientCAFile, "tls-client-ca", "", "TLS_CLIENT_CA_FILE")
+	flag.StringVar(&c.TLSClientAuth, "tls-client-auth", "optional", "TLS_CLIENT_AUTH")
+	flag.DurationVar(&c.TLSReloadInterval, "tls-reload", 10*time.Second, "TLS_RELOAD_INTERVAL")
+	flag.BoolVar(&c.OpenAPIValidateResponses, "openapi-validate-responses", false, "OPENAPI_VALIDATE_RESPONSES")
+	flag.BoolVar(&c.CookieSecure, "cookie-secure", false, "COOKIE_SECURE")
+	flag.StringVar(&c.CookieSameSite, "cookie-samesite", "lax", "COOKIE_SAMESITE")
+	flag.StringVar(&c.CookieDomain, "cookie-domain", "", "COOKIE_DOMAIN")
+	flag.StringVar(&c.CookiePath, "cookie-path", "/", "COOKIE_PATH")
+	flag.BoolVar(&c.CSRFProtection, "csrf", true, "CSRF_PROTECTION")
+	flag.StringVar(&c.CSRFTrustedOrigins, "csrf-trusted-origins", "", "CSRF_TRUSTED_ORIGINS")
+	flag.StringVar(&c.TrustedProxies, "trusted-proxies", "", "TRUSTED_PROXIES")
+	flag.StringVar(&c.CORSAllowedOrigins, "cors-origins", "", "CORS_ALLOWED_ORIGINS")
+	flag.StringVar(&c.CORSAllowedMethods, "cors-methods", "GET,POST,PUT,PATCH,DELETE", "CORS_ALLOWED_METHODS")
+	flag.StringVar(&c.CORSAllowedHeaders, "cors-headers", "Accept,Authorization,Content-Type,X-TOTP,Idempotency-Key", "CORS_ALLOWED_HEADERS")
+	flag.BoolVar(&c.CORSAllowCredentials, "cors-credentials", false, "CORS_ALLOW_CREDENTIALS")
+	flag.DurationVar(&c.CORSMaxAge, "cors-max-age", 10*time.Minute, "CORS_MAX_AGE")
+	flag.DurationVar(&c.SessionTTL, "session-ttl", 10*time.Minute, "SESSION_TTL")
+	flag.DurationVar(&c.SessionMaxLifetime, "session-max-lifetime", 24*time.Hour, "SESSION_MAX_LIFETIME")
+	flag.DurationVar(&c.SessionSweepInterval, "session-sweep", time.Minute, "SESSION_SWEEP_INTERVAL")
+	flag.StringVar(&c.SessionTokenKeys, "session-keys", "", "SESSION_TOKEN_KEYS")
+	flag.StringVar(&c.AccessTokenKey, "access-key", "", "ACCESS_TOKEN_KEY")
+	flag.DurationVar(&c.AccessTokenTTL, "access-ttl", 5*time.Minute, "ACCESS_TOKEN_TTL")
+	flag.DurationVar(&c.RefreshTokenTTL, "refresh-ttl", 30*24*time.Hour, "REFRESH_TOKEN_TTL")
+	flag.DurationVar(&c.LoginLimitWindow, "login-window", time.Minute, "LOGIN_LIMIT_WINDOW")
+	flag.IntVar(&c.LoginLimitPerIP, "login-limit-ip", 20, "LOGIN_LIMIT_PER_IP")
+	flag.IntVar(&c.LoginLimitPerLogin, "login-limit-login", 10, "LOGIN_LIMIT_PER_LOGIN")
+	flag.IntVar(&c.LockoutThreshold, "lockout-threshold", 5, "LOGIN_LOCKOUT_THRESHOLD")
+	flag.DurationVar(&c.LockoutBase, "lockout-base", time.Minute, "LOGIN_LOCKOUT_BASE")
+	flag.DurationVar(&c.LockoutMax, "lockout-max", time.Hour, "LOGIN_LOCKOUT_MAX")
+	flag.IntVar(&c.LoginMinLength, "login-min", 3, "LOGIN_MIN_LENGTH")
+	flag.IntVar(&c.LoginMaxLength, "login-max", 64, "LOGIN_MAX_LENGTH")
+	flag.StringVar(&c.LoginPattern, "login-pattern", "", "LOGIN_PATTERN")
+	flag.IntVar(&c.PasswordMinLength, "password-min", 8, "PASSWORD_MIN_LENGTH")
+	flag.IntVar(&c.PasswordMaxBytes, "password-max-bytes", 72, "PASSWORD_MAX_BYTES")
+	flag.StringVar(&c.PasswordBlocklistFile, "password-blocklist", "", "PASSWORD_BLOCKLIST_FILE")
+	flag.StringVar(&c.PasswordHasher, "password-hasher", "argon2id", "PASSWORD_HASHER")
+	flag.UintVar(&c.Argon2Memory, "argon2-memory", 19456, "PASSWORD_ARGON2_MEMORY")
+	flag.UintVar(&c.Argon2Time, "argon2-time", 2, "PASSWORD_ARGON2_TIME")
+	flag.UintVar(&c.Argon2Threads, "argon2-threads", 1, "PASSWORD_ARGON2_THREADS")
+	flag.IntVar(&c.BcryptCost, "bcrypt-cost", 12, "PASSWORD_BCRYPT_COST")
+	flag.DurationVar(&c.PasswordResetTTL, "password-reset-ttl", 30*time.Minute, "PASSWORD_RESET_TTL")
+	flag.DurationVar(&c.ResetLimitWindow, "reset-window", time.Hour, "PASSWORD_RESET_LIMIT_WINDOW")
+	flag.IntVar(&c.ResetLimitPerIP, "reset-limit-ip", 10, "PASSWORD_RESET_LIMIT_PER_IP")
+	flag.IntVar(&c.ResetLimitPerLogin, "reset-limit-login", 3, "PASSWORD_RESET_LIMIT_PER_LOGIN")
+	flag.StringVar(&c.PasswordResetURL, "password-reset-url", "", "PASSWORD_RESET_URL")
+	flag.StringVar(&c.NotifyDriver, "notify", "none", "NOTIFY_DRIVER")
+	flag.StringVar(&c.NotifyFile, "notify-file", "", "NOTIFY_FILE")
+	flag.StringVar(&c.SMTPAddress, "smtp-addr", "", "SMTP_ADDRESS")
+	flag.StringVar(&c.SMTPUsername, "smtp-user", "", "SMTP_USERNAME")
+	flag.StringVar(&c.SMTPPassword, "smtp-password", "", "SMTP_PASSWORD")
+	flag.StringVar(&c.SMTPFrom, "smtp-from", "", "SMTP_FROM")
+	flag.StringVar(&c.TOTPIssuer, "totp-issuer", "Gophermart", "TOTP_ISSUER")
+	flag.StringVar(&c.TOTPEncryptionKey, "totp-key", "", "TOTP_ENCRYPTION_KEY")
+	flag.Float64Var(&c.TOTPWithdrawThreshold, "totp-withdraw-threshold", 1000, "TOTP_WITHDRAW_THRESHOLD")
+	flag.DurationVar(&c.MFAChallengeTTL, "mfa-ttl", 5*time.Minute, "MFA_CHALLENGE_TTL")
+	flag.DurationVar(&c.APIKeyTTL, "api-key-ttl", 90*24*time.Hour, "API_KEY_TTL")
+	flag.DurationVar(&c.APIKeyMaxTTL, "api-key-max-ttl", 365*24*time.Hour, "API_KEY_MAX_TTL")
+	flag.StringVar(&c.AdminLogins, "admins", "", "ADMIN_LOGINS")
+	flag.Float64Var(&c.AdjustmentThreshold, "adjustment-threshold", 1000, "ADJUSTMENT_APPROVAL_THRESHOLD")
+	flag.Float64Var(&c.AdjustmentDailyLimit, "adjustment-daily-limit", 5000, "ADJUSTMENT_DAILY_LIMIT")
+	flag.IntVar(&c.PointsExpiryMonths, "points-expiry-months", 0, "POINTS_EXPIRY_MONTHS")
+	flag.DurationVar(&c.PointsExpiringSoon, "points-expiring-soon", 30*24*time.Hour, "POINTS_EXPIRING_SOON")
+	flag.DurationVar(&c.PointsExpiryInterval, "points-expiry-interval", time.Hour, "POINTS_EXPIRY_INTERVAL")
+	flag.StringVar(&c.LoyaltyTiers, "tiers", "bronze:0:1,silver:1000:1.05,gold:5000:1.1", "LOYALTY_TIERS")
+	flag.DurationVar(&c.TierWindow, "tier-window", 0, "LOYALTY_TIER_WINDOW")
+	flag.Float64Var(&c.ReferrerBonus, "referrer-bonus", 100, "REFERRAL_REFERRER_BONUS")
+	flag.Float64Var(&c.RefereeBonus, "referee-bonus", 50, "REFERRAL_REFEREE_BONUS")
+	flag.Float64Var(&c.ReferralMinAccrual, "referral-min-accrual", 0, "REFERRAL_MIN_ACCRUAL")
+	flag.IntVar(&c.ReferralMaxPerUser, "referral-max-per-user", 20, "REFERRAL_MAX_PER_USER")
+	flag.IntVar(&c.ReferralMaxPerIP, "referral-max-per-ip", 3, "REFERRAL_MAX_PER_IP")
+	flag.Float64Var(&c.TransferDailyLimit, "transfer-daily-limit", 5000, "TRANSFER_DAILY_LIMIT")
+	flag.IntVar(&c.UploadSyncLimit, "upload-sync-limit", 100, "ORDERS_UPLOAD_SYNC_LIMIT")
+	flag.IntVar(&c.UploadMaxLines, "upload-max-lines", 50000, "ORDERS_UPLOAD_MAX_LINES")
+	flag.IntVar(&c.UploadBatchSize, "upload-batch-size", 500, "ORDERS_UPLOAD_BATCH_SIZE")
+	flag.DurationVar(&c.UploadInterval, "upload-interval", 2*time.Second, "ORDERS_UPLOAD_INTERVAL")
 	flag.Parse()
 	err := env.Parse(c)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	if err = c.Validate(); err != nil {
+		log.Fatalln(err)
+	}
 	return c
 }
+
+// Validate - метод, проверяющий согласованность настроек. Неполная настройка TLS - ошибка, а не тихий запуск без шифрования.
+func (c *Config) Validate() error {
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.TLSClientCAFile != "" && !c.TLSEnabled() {
+		return errors.New("TLS_CLIENT_CA_FILE requires TLS_CERT_FILE and TLS_KEY_FILE")
+	}
+	if c.TLSClientAuth == "require" && c.TLSClientCAFile == "" && c.TLSEnabled() {
+		return errors.New("TLS_CLIENT_AUTH=require requires TLS_CLIENT_CA_FILE")
+	}
+	return nil
+}
+
+// TLSEnabled - метод, сообщающий, настроен ли сервер на работу по TLS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		cert       string
+		key        string
+		ca         string
+		clientAuth string
+		wantErr    bool
+	}{
+		{name: "Plain HTTP", clientAuth: "optional"},
+		{name: "TLS", cert: "cert.pem", key: "key.pem", clientAuth: "optional"},
+		{name: "mTLS", cert: "cert.pem", key: "key.pem", ca: "ca.pem", clientAuth: "require"},
+		{name: "Only cert", cert: "cert.pem", clientAuth: "optional", wantErr: true},
+		{name: "Only key", key: "key.pem", clientAuth: "optional", wantErr: true},
+		{name: "Client CA without cert and key", ca: "ca.pem", clientAuth: "optional", wantErr: true},
+		{name: "Client CA with only cert", cert: "cert.pem", ca: "ca.pem", clientAuth: "optional", wantErr: true},
+		{name: "Required client cert without CA", cert: "cert.pem", key: "key.pem", clientAuth: "require", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{TLSCertFile: tt.cert, TLSKeyFile: tt.key, TLSClientCAFile: tt.ca, TLSClientAuth: tt.clientAuth}
+			err := c.Validate()
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
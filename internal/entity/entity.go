@@ -1,15 +1,20 @@
 package entity
 
 import (
+	"encoding/json"
 	"sync"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type AccountInfo struct {
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	Login        string `json:"login"`
+	Password     string `json:"password"`
+	Email        string `json:"email,omitempty"`
+	OTP          string `json:"otp,omitempty"`
+	ReferralCode string `json:"referral_code,omitempty"`
+	IP           string `json:"-"`
+	UserAgent    string `json:"-"`
+	RequestID    string `json:"-"`
 }
 
 type UsersMemory struct {
@@ -18,19 +23,64 @@ type UsersMemory struct {
 	ByID    map[uint64]User
 }
 
+// Роли пользователей: каждая следующая включает права предыдущей.
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// roleRanks - старшинство ролей.
+var roleRanks = map[string]int{RoleUser: 0, RoleSupport: 1, RoleAdmin: 2}
+
+// IsRole - функция, проверяющая, что роль существует.
+func IsRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
 type User struct {
-	ID       uint64
-	Login    string
-	Password []byte
+	ID           uint64
+	Login        string
+	Password     []byte
+	Email        string
+	FailedLogins int
+	LockedUntil  *time.Time
+	Role         string
+	DisabledAt   *time.Time
+	// TokenVersion - версия access-токенов пользователя. Ее увеличение отзывает все выданные access-токены.
+	TokenVersion int
 }
 
-// CheckPassword - функция, проверяющая пароль на соответствие.
-func (u *User) CheckPassword(password string) bool {
-	if err := bcrypt.CompareHashAndPassword(u.Password, []byte(password)); err != nil {
-		return false
-	}
+// IsLocked - метод, проверяющий, заблокирован ли вход пользователя после неудачных попыток.
+func (u *User) IsLocked() bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(time.Now())
+}
+
+// IsDisabled - метод, проверяющий, что аккаунт отключен администратором.
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
 
-	return true
+// HasRole - метод, проверяющий, что у пользователя роль role или старше.
+func (u *User) HasRole(role string) bool {
+	rank, ok := roleRanks[role]
+	return ok && roleRanks[u.Role] >= rank
+}
+
+type UserX struct {
+	ID           uint64 `json:"id"`
+	Login        string `json:"login"`
+	Email        string `json:"email,omitempty"`
+	Role         string `json:"role"`
+	MFAEnabled   bool   `json:"mfa_enabled"`
+	FailedLogins int    `json:"failed_logins"`
+	LockedUntil  string `json:"locked_until,omitempty"`
+	DisabledAt   string `json:"disabled_at,omitempty"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role"`
 }
 
 // NewUsers - конструктор,хэш-таблицы пользователей.
@@ -42,9 +92,16 @@ func NewUsers() *UsersMemory {
 }
 
 type Session struct {
-	UserID uint64
-	Token  string
-	Expiry time.Time
+	ID         uint64
+	UserID     uint64
+	Token      string
+	Expiry     time.Time
+	CreatedAt  time.Time
+	LastUsedAt time.Time
+	IP         string
+	UserAgent  string
+	// TokenFamily - семейство refresh-токенов, если запрос авторизован access-токеном, а не cookie.
+	TokenFamily string
 }
 
 // IsExpired - метод, проверяющий срок годности cookie.
@@ -52,6 +109,189 @@ func (s *Session) IsExpired() bool {
 	return s.Expiry.Before(time.Now())
 }
 
+type SessionX struct {
+	ID         uint64 `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	LastUsedAt string `json:"last_used_at"`
+	ExpiresAt  string `json:"expires_at"`
+	IP         string `json:"ip"`
+	UserAgent  string `json:"user_agent"`
+	Current    bool   `json:"current"`
+}
+
+type LoginAttempt struct {
+	Login     string
+	UserID    uint64
+	IP        string
+	UserAgent string
+	Success   bool
+	Reason    string
+	CreatedAt time.Time
+}
+
+type TokenPair struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type RefreshToken struct {
+	ID        uint64
+	UserID    uint64
+	Family    string
+	Token     string
+	Expiry    time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+	IP        string
+	UserAgent string
+}
+
+// IsExpired - метод, проверяющий срок годности refresh-токена.
+func (t *RefreshToken) IsExpired() bool {
+	return t.Expiry.Before(time.Now())
+}
+
+type PasswordReset struct {
+	ID        uint64
+	UserID    uint64
+	Token     string
+	Expiry    time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	IP        string
+}
+
+// IsExpired - метод, проверяющий срок годности токена восстановления пароля.
+func (p *PasswordReset) IsExpired() bool {
+	return p.Expiry.Before(time.Now())
+}
+
+type TOTP struct {
+	UserID      uint64
+	Secret      string
+	ConfirmedAt *time.Time
+	LastStep    int64
+	CreatedAt   time.Time
+}
+
+// IsEnabled - метод, проверяющий, что подключение TOTP подтверждено кодом.
+func (t *TOTP) IsEnabled() bool {
+	return t.ConfirmedAt != nil
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type MFAStatus struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type MFAChallenge struct {
+	Token     string   `json:"mfa_token"`
+	ExpiresIn int64    `json:"expires_in"`
+	Methods   []string `json:"methods"`
+}
+
+type Challenge struct {
+	UserID   uint64
+	Expiry   time.Time
+	Attempts int
+}
+
+type ChallengeMemory struct {
+	sync.Mutex
+	ByToken map[string]Challenge
+}
+
+// NewChallenges - конструктор хэш-таблицы незавершенных входов со вторым фактором.
+func NewChallenges() *ChallengeMemory {
+	return &ChallengeMemory{
+		ByToken: make(map[string]Challenge),
+	}
+}
+
+// Области действия API-ключей.
+const (
+	ScopeOrdersWrite = "orders:write"
+	ScopeBalanceRead = "balance:read"
+	ScopeWithdraw    = "withdraw"
+)
+
+// Scopes - все области действия, которые можно выдать API-ключу.
+var Scopes = []string{ScopeOrdersWrite, ScopeBalanceRead, ScopeWithdraw}
+
+type APIKey struct {
+	ID         uint64
+	UserID     uint64
+	Name       string
+	Prefix     string
+	Key        string
+	Scopes     []string
+	Expiry     time.Time
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// IsExpired - метод, проверяющий срок годности API-ключа.
+func (k *APIKey) IsExpired() bool {
+	return k.Expiry.Before(time.Now())
+}
+
+// HasScope - метод, проверяющий, что ключу выдана область действия scope.
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type APIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type APIKeyX struct {
+	ID         uint64   `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Key        string   `json:"key,omitempty"`
+	Scopes     []string `json:"scopes"`
+	CreatedAt  string   `json:"created_at"`
+	ExpiresAt  string   `json:"expires_at"`
+	LastUsedAt string   `json:"last_used_at,omitempty"`
+}
+
+// Principal - субъект запроса: пользователь с сессией или access-токеном либо API-ключ, выданный пользователем.
+// Для API-ключа Session - заглушка с ID владельца ключа.
+type Principal struct {
+	*Session
+	APIKey *APIKey
+	// User - пользователь с ролью, заполняется только в хэндлерах, требующих роль.
+	User *User
+}
+
+// IsAPIKey - метод, проверяющий, что запрос авторизован API-ключом, а не пользователем.
+func (p *Principal) IsAPIKey() bool {
+	return p.APIKey != nil
+}
+
+type AccessClaims struct {
+	UserID  uint64
+	Family  string
+	Version int
+	Expiry  time.Time
+}
+
 type SessionMemory struct {
 	sync.RWMutex
 	BySessionToken map[string]Session
@@ -91,6 +331,62 @@ type OrderX struct {
 	UploadedAt string  `json:"uploaded_at"`
 }
 
+// Результаты строк пакетной загрузки заказов.
+const (
+	UploadAccepted       = "accepted"
+	UploadDuplicateOwn   = "duplicate_own"
+	UploadDuplicateOther = "duplicate_other"
+	UploadInvalid        = "invalid"
+)
+
+// Статусы задания пакетной загрузки заказов.
+const (
+	UploadPending = "pending"
+	UploadRunning = "running"
+	UploadDone    = "done"
+)
+
+// OrderUploadLine - номер заказа из строки пакетной загрузки и результат его добавления. Line считается с 1.
+type OrderUploadLine struct {
+	Line   int    `json:"line"`
+	Order  string `json:"order"`
+	Result string `json:"result,omitempty"`
+}
+
+// OrderUpload - задание асинхронной пакетной загрузки заказов. Processed - сколько строк из Lines уже обработано.
+// У задания, прочитанного для ответа API, Lines содержит только обработанные строки с результатами.
+type OrderUpload struct {
+	ID         uint64
+	UserID     uint64
+	Status     string
+	Lines      []OrderUploadLine
+	Total      int
+	Processed  int
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+// OrderUploadSummary - число строк пакетной загрузки с каждым результатом.
+type OrderUploadSummary struct {
+	Accepted       int `json:"accepted"`
+	DuplicateOwn   int `json:"duplicate_own"`
+	DuplicateOther int `json:"duplicate_other"`
+	Invalid        int `json:"invalid"`
+}
+
+// OrderUploadX - пакетная загрузка заказов в ответе API. У синхронной загрузки нет ID, у асинхронной Results
+// содержит только уже обработанные строки.
+type OrderUploadX struct {
+	ID         uint64             `json:"id,omitempty"`
+	Status     string             `json:"status"`
+	Total      int                `json:"total"`
+	Processed  int                `json:"processed"`
+	Summary    OrderUploadSummary `json:"summary"`
+	Results    []OrderUploadLine  `json:"results"`
+	CreatedAt  string             `json:"created_at,omitempty"`
+	FinishedAt string             `json:"finished_at,omitempty"`
+}
+
 type Balance struct {
 	UserID    uint64
 	Current   uint64
@@ -110,14 +406,151 @@ func NewBalance() *BalanceMemory {
 }
 
 type BalanceX struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current      float64           `json:"current"`
+	Withdrawn    float64           `json:"withdrawn"`
+	ExpiringSoon []ExpiringPointsX `json:"expiring_soon,omitempty"`
+}
+
+// PointLot - партия начисленных баллов. Списания расходуют партии в порядке начисления (FIFO),
+// остаток партии сгорает в ExpiresAt. Пустой ExpiresAt - баллы бессрочные.
+type PointLot struct {
+	ID        uint64
+	UserID    uint64
+	Source    string
+	SourceID  string
+	Amount    uint64
+	Remaining uint64
+	CreatedAt time.Time
+	ExpiresAt *time.Time
+}
+
+// PointExpiration - запись о сгорании остатка партии баллов.
+type PointExpiration struct {
+	ID        uint64
+	LotID     uint64
+	UserID    uint64
+	Amount    uint64
+	ExpiredAt time.Time
+}
+
+// ExpiringPointsX - баллы, сгорающие в указанный день (UTC).
+type ExpiringPointsX struct {
+	Amount float64 `json:"amount"`
+	Date   string  `json:"date"`
+}
+
+// TierChange - запись истории смены уровня пользователя. Пустой From - первый присвоенный уровень.
+type TierChange struct {
+	ID         uint64
+	UserID     uint64
+	From       string
+	To         string
+	Qualifying uint64
+	ChangedAt  time.Time
+}
+
+// TierChangeX - смена уровня в ответе API.
+type TierChangeX struct {
+	From       string  `json:"from,omitempty"`
+	To         string  `json:"to"`
+	Qualifying float64 `json:"qualifying"`
+	ChangedAt  string  `json:"changed_at"`
+}
+
+// Статусы приглашения по реферальной программе.
+const (
+	ReferralPending  = "pending"
+	ReferralRewarded = "rewarded"
+	ReferralRejected = "rejected"
+)
+
+// Причины отказа в бонусе за приглашение.
+const (
+	ReferralReasonIPLimit          = "ip_limit"
+	ReferralReasonReferrerLimit    = "referrer_limit"
+	ReferralReasonReferrerDisabled = "referrer_disabled"
+)
+
+// Referral - приглашение пользователя RefereeID по реферальному коду пользователя ReferrerID. Бонусы в копейках.
+type Referral struct {
+	RefereeID     uint64
+	ReferrerID    uint64
+	Status        string
+	Reason        string
+	IP            string
+	CreatedAt     time.Time
+	RewardedAt    *time.Time
+	ReferrerBonus uint64
+	RefereeBonus  uint64
+	// RefereeLogin - логин приглашенного, заполняется только в списке приглашений.
+	RefereeLogin string
+}
+
+// ReferralX - приглашенный пользователь в ответе API. Bonus - бонус пригласившего.
+type ReferralX struct {
+	Login      string  `json:"login"`
+	Status     string  `json:"status"`
+	Reason     string  `json:"reason,omitempty"`
+	Bonus      float64 `json:"bonus"`
+	JoinedAt   string  `json:"joined_at"`
+	RewardedAt string  `json:"rewarded_at,omitempty"`
+}
+
+// ReferralsX - реферальный код пользователя, приглашенные им пользователи и заработанные бонусы.
+// Earned включает бонус за собственную регистрацию по приглашению.
+type ReferralsX struct {
+	Code      string      `json:"code"`
+	Earned    float64     `json:"earned"`
+	Referrals []ReferralX `json:"referrals"`
+}
+
+// TierX - уровень пользователя в программе лояльности и прогресс до следующего.
+// Qualifying - баллы, начисленные по заказам за все время или за скользящее окно WindowDays.
+type TierX struct {
+	Tier          string        `json:"tier"`
+	Multiplier    float64       `json:"multiplier"`
+	Qualifying    float64       `json:"qualifying"`
+	WindowDays    int           `json:"window_days,omitempty"`
+	NextTier      string        `json:"next_tier,omitempty"`
+	NextThreshold float64       `json:"next_threshold,omitempty"`
+	ToNextTier    float64       `json:"to_next_tier,omitempty"`
+	History       []TierChangeX `json:"history"`
+}
+
+// PointExpirationX - сгорание баллов в истории пользователя.
+type PointExpirationX struct {
+	Amount    float64 `json:"amount"`
+	ExpiredAt string  `json:"expired_at"`
+}
+
+type UserProfile struct {
+	ID         uint64 `json:"id"`
+	Login      string `json:"login"`
+	Email      string `json:"email,omitempty"`
+	MFAEnabled bool   `json:"mfa_enabled"`
+}
+
+// UserExport - все данные пользователя для выгрузки по запросу.
+type UserExport struct {
+	ExportedAt  string             `json:"exported_at"`
+	Profile     UserProfile        `json:"profile"`
+	Balance     BalanceX           `json:"balance"`
+	Orders      []*OrderX          `json:"orders"`
+	Withdrawals []WithdrawX        `json:"withdrawals"`
+	Adjustments []UserAdjustmentX  `json:"adjustments"`
+	Expirations []PointExpirationX `json:"expirations"`
+	Transfers   []TransferX        `json:"transfers"`
+	Sessions    []SessionX         `json:"sessions"`
+	APIKeys     []APIKeyX          `json:"api_keys"`
 }
 
 type WithdrawX struct {
 	Order       string  `json:"order"`
 	Sum         float64 `json:"sum"`
 	UserID      uint64  `json:"-"`
+	OTP         string  `json:"-"`
+	IP          string  `json:"-"`
+	RequestID   string  `json:"-"`
 	ProcessedAt string  `json:"processed_at"`
 }
 
@@ -127,3 +560,190 @@ type Withdraw struct {
 	Sum         uint64
 	ProcessedAt time.Time
 }
+
+// TransferRequest - запрос на перевод баллов другому пользователю по логину.
+type TransferRequest struct {
+	To      string  `json:"to"`
+	Amount  float64 `json:"amount"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+// Transfer - перевод баллов между пользователями. Amount в копейках.
+type Transfer struct {
+	ID             uint64
+	FromUserID     uint64
+	ToUserID       uint64
+	Amount         uint64
+	Comment        string
+	IdempotencyKey string
+	CreatedAt      time.Time
+	// FromLogin и ToLogin заполняются только в истории переводов.
+	FromLogin string
+	ToLogin   string
+}
+
+// Направления перевода в истории пользователя.
+const (
+	TransferOut = "out"
+	TransferIn  = "in"
+)
+
+// TransferX - перевод в ответе API глазами пользователя: Counterparty - логин другой стороны перевода.
+type TransferX struct {
+	ID           uint64  `json:"id"`
+	Direction    string  `json:"direction"`
+	Counterparty string  `json:"counterparty"`
+	Amount       float64 `json:"amount"`
+	Comment      string  `json:"comment,omitempty"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+// Статусы ручной корректировки баланса.
+const (
+	AdjustmentApplied  = "applied"
+	AdjustmentPending  = "pending"
+	AdjustmentRejected = "rejected"
+)
+
+// AdjustmentReasons - коды причин ручной корректировки баланса.
+var AdjustmentReasons = []string{"missing_accrual", "correction", "goodwill", "refund", "fraud_reversal"}
+
+// Adjustment - ручная корректировка баланса сотрудником. Amount в копейках, отрицательная сумма - списание.
+type Adjustment struct {
+	ID        uint64
+	UserID    uint64
+	Amount    int64
+	Reason    string
+	Comment   string
+	Status    string
+	CreatedBy uint64
+	CreatedAt time.Time
+	DecidedBy *uint64
+	DecidedAt *time.Time
+}
+
+type AdjustmentRequest struct {
+	Amount  float64 `json:"amount"`
+	Reason  string  `json:"reason"`
+	Comment string  `json:"comment"`
+}
+
+type AdjustmentX struct {
+	ID        uint64  `json:"id"`
+	UserID    uint64  `json:"user_id"`
+	Amount    float64 `json:"amount"`
+	Reason    string  `json:"reason"`
+	Comment   string  `json:"comment"`
+	Status    string  `json:"status"`
+	CreatedBy uint64  `json:"created_by"`
+	CreatedAt string  `json:"created_at"`
+	DecidedBy uint64  `json:"decided_by,omitempty"`
+	DecidedAt string  `json:"decided_at,omitempty"`
+}
+
+// UserAdjustmentX - корректировка баланса в истории пользователя, без комментария и данных сотрудников.
+type UserAdjustmentX struct {
+	Amount      float64 `json:"amount"`
+	Reason      string  `json:"reason"`
+	ProcessedAt string  `json:"processed_at"`
+}
+
+// Действия в журнале аудита.
+const (
+	AuditRegister      = "user.register"
+	AuditLogin         = "auth.login"
+	AuditLoginFailed   = "auth.login_failed"
+	AuditWithdraw      = "balance.withdraw"
+	AuditAccrual       = "balance.accrual"
+	AuditAdjust        = "balance.adjust"
+	AuditAdjustApprove = "balance.adjust_approve"
+	AuditAdjustReject  = "balance.adjust_reject"
+	AuditSetRole       = "admin.set_role"
+	AuditDisableUser   = "admin.disable_user"
+	AuditEnableUser    = "admin.enable_user"
+	AuditLogoutUser    = "admin.logout_user"
+	AuditExpire        = "balance.expire"
+	AuditTierChange    = "user.tier_change"
+	AuditReferralBonus = "balance.referral_bonus"
+	AuditTransfer      = "balance.transfer"
+)
+
+// Типы объектов действий в журнале аудита.
+const (
+	AuditTargetUser       = "user"
+	AuditTargetOrder      = "order"
+	AuditTargetAdjustment = "adjustment"
+	AuditTargetTransfer   = "transfer"
+)
+
+// Цепочки хэшей журнала аудита. Частые попытки входа пишутся в отдельную цепочку со своей блокировкой,
+// чтобы не задерживать изменения балансов и прав.
+const (
+	AuditChainMain = "main"
+	AuditChainAuth = "auth"
+)
+
+// Actor - инициатор действия для журнала аудита. Нулевой UserID - сама система, например начисление по заказу.
+type Actor struct {
+	UserID    uint64
+	IP        string
+	RequestID string
+}
+
+// AuditEvent - запись журнала аудита. Before и After - состояние до и после в JSON.
+// Hash считается от PrevHash и полей события, поэтому изменение или удаление записи разрывает цепочку.
+// PrevHash - хэш предыдущего события той же цепочки Chain.
+type AuditEvent struct {
+	ID         uint64
+	Chain      string
+	CreatedAt  time.Time
+	ActorID    uint64
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     string
+	After      string
+	RequestID  string
+	IP         string
+	PrevHash   string
+	Hash       string
+}
+
+// AuditEventX - событие журнала аудита в ответе API.
+type AuditEventX struct {
+	ID         uint64          `json:"id"`
+	Chain      string          `json:"chain"`
+	CreatedAt  string          `json:"created_at"`
+	ActorID    uint64          `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type"`
+	TargetID   string          `json:"target_id"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	PrevHash   string          `json:"prev_hash"`
+	Hash       string          `json:"hash"`
+}
+
+// AuditFilter - условия выборки из журнала аудита. Пустые поля не ограничивают выборку.
+type AuditFilter struct {
+	ActorID    *uint64
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	AfterID    uint64
+	Limit      int
+}
+
+// AuditVerification - результат проверки цепочек хэшей журнала аудита. LastHash и AuthLastHash - хэши последних
+// целых событий основной цепочки и цепочки попыток входа.
+type AuditVerification struct {
+	Valid        bool   `json:"valid"`
+	Checked      int64  `json:"checked"`
+	BrokenID     uint64 `json:"broken_id,omitempty"`
+	LastHash     string `json:"last_hash,omitempty"`
+	AuthLastHash string `json:"auth_last_hash,omitempty"`
+}
@@ -1,10 +1,13 @@
 package entity
 
+import "time"
+
 // Storager - сборный интерфейс бога сервиса.
 type Storager interface {
 	Databaser
 	Querer
 	Controlluser
+	Janitor
 }
 
 // Databaser - интерфейс, отвечающий за работу с БД.
@@ -17,26 +20,132 @@ type Databaser interface {
 	DeleteSessionDB(token string) error
 	AddSessionDB(session *Session) error
 	GetSessionDB(token string) (Session, error)
-	AddUserDB(u *User) (uint64, error)
+	GetSessionsDB(userID uint64) ([]Session, error)
+	DeleteUserSessionDB(userID, sessionID uint64) error
+	DeleteUserSessionsDB(userID uint64) error
+	DeleteOtherSessionsDB(userID, keepID uint64) error
+	TouchSessionDB(sessionID uint64, lastUsedAt, expiry time.Time) error
+	DeleteExpiredSessionsDB(now time.Time) (int64, error)
+	AddRefreshTokenDB(t *RefreshToken) error
+	GetRefreshTokenDB(token string) (RefreshToken, error)
+	RotateRefreshTokenDB(usedID uint64, usedAt time.Time, next *RefreshToken) (bool, error)
+	RevokeRefreshFamilyDB(family string, at time.Time) error
+	RevokeUserRefreshTokensDB(userID uint64, at time.Time) error
+	RevokeOtherRefreshTokensDB(userID uint64, keepFamily string, at time.Time) error
+	DeleteExpiredRefreshTokensDB(now time.Time) (int64, error)
+	AddUserDB(u *User, ref *Referral, e *AuditEvent) (uint64, error)
 	GetUserDB(byKey interface{}) (User, error)
-	AddWithdrawDB(withdraw *Withdraw) error
+	AddFailedLoginDB(userID uint64) (int, error)
+	LockUserDB(userID uint64, until time.Time) error
+	ResetFailedLoginsDB(userID uint64) error
+	UpdatePasswordDB(userID uint64, hash []byte) error
+	UpdateEmailDB(userID uint64, email string) error
+	BumpTokenVersionDB(userID uint64) (int, error)
+	DeleteUserDB(userID uint64, login, anonLogin string, at time.Time) error
+	SetUserRoleDB(userID uint64, role string, e *AuditEvent) error
+	SetUserDisabledDB(userID uint64, at *time.Time, e *AuditEvent) error
+	AddPasswordResetDB(p *PasswordReset) error
+	GetPasswordResetDB(token string) (PasswordReset, error)
+	ResetPasswordDB(resetID, userID uint64, hash []byte, at time.Time) (bool, error)
+	DeleteExpiredPasswordResetsDB(now time.Time) (int64, error)
+	AddTOTPDB(t *TOTP) (bool, error)
+	GetTOTPDB(userID uint64) (TOTP, error)
+	ConfirmTOTPDB(userID uint64, step int64, codes []string, at time.Time) (bool, error)
+	UseTOTPStepDB(userID uint64, step int64) (bool, error)
+	DeleteTOTPDB(userID uint64) error
+	ReplaceRecoveryCodesDB(userID uint64, codes []string, at time.Time) error
+	UseRecoveryCodeDB(userID uint64, code string, at time.Time) (bool, error)
+	CountRecoveryCodesDB(userID uint64) (int, error)
+	AddAPIKeyDB(k *APIKey) error
+	GetAPIKeyDB(key string) (APIKey, error)
+	GetAPIKeysDB(userID uint64) ([]APIKey, error)
+	TouchAPIKeyDB(id uint64, at time.Time) error
+	RevokeAPIKeyDB(userID, id uint64, at time.Time) error
+	RevokeUserAPIKeysDB(userID uint64, at time.Time) (int64, error)
+	DeleteExpiredAPIKeysDB(now time.Time) (int64, error)
+	AddLoginAttemptDB(a *LoginAttempt, e *AuditEvent) error
+	AddWithdrawDB(withdraw *Withdraw, e *AuditEvent) error
 	GetWithdrawalsDB(userID uint64) ([]Withdraw, error)
+	AddAdjustmentDB(a *Adjustment, e *AuditEvent) error
+	GetAdjustmentDB(id uint64) (Adjustment, error)
+	GetAdjustmentsDB(userID uint64) ([]Adjustment, error)
+	GetPendingAdjustmentsDB() ([]Adjustment, error)
+	DecideAdjustmentDB(a *Adjustment, e *AuditEvent) error
+	AddAuditEventDB(e *AuditEvent) error
+	GetAuditEventsDB(f *AuditFilter) ([]AuditEvent, error)
+	GetExpiringPointsDB(userID uint64, now, before time.Time) ([]PointLot, error)
+	GetExpiredUsersDB(now time.Time, limit int) ([]uint64, error)
+	ExpirePointsDB(userID uint64, now time.Time) (uint64, error)
+	GetPointExpirationsDB(userID uint64) ([]PointExpiration, error)
+	GetUserTierDB(userID uint64) (string, error)
+	GetQualifyingPointsDB(userID uint64, now time.Time) (uint64, error)
+	GetTierHistoryDB(userID uint64) ([]TierChange, error)
+	GetReferrerDB(code string) (uint64, error)
+	GetReferralCodeDB(userID uint64) (string, error)
+	GetReferralsDB(userID uint64) ([]Referral, error)
+	GetRefereeBonusDB(userID uint64) (uint64, error)
+	AddTransferDB(t *Transfer, e *AuditEvent) (bool, error)
+	GetTransferByKeyDB(fromUserID uint64, key string) (*Transfer, error)
+	GetTransfersDB(userID uint64) ([]Transfer, error)
+	AddOrdersBatchDB(userID uint64, lines []OrderUploadLine, now time.Time) error
+	AddOrderUploadDB(u *OrderUpload) error
+	ClaimOrderUploadDB(now time.Time) (*OrderUpload, error)
+	AddOrderUploadBatchDB(u *OrderUpload, lines []OrderUploadLine, now time.Time) (bool, error)
+	GetOrderUploadDB(userID, id uint64) (*OrderUpload, error)
 }
 
-// Querer - интерфейс, отвечающий за работу с blackbox.
+// Querer - интерфейс, отвечающий за работу с blackbox и фоновую пакетную загрузку заказов.
 type Querer interface {
 	GetPullOrders(limit uint32) (map[uint64]Order, error)
 	UpdateOrder(o Order) error
+	ProcessOrderUploads() (int64, error)
+}
+
+// Janitor - интерфейс, отвечающий за периодическую очистку устаревших данных.
+type Janitor interface {
+	SweepSessions() (int64, error)
+	ExpirePoints() (int64, error)
 }
 
 // Controlluser - интерфейс, отвечающий за методы контроллера хэндлера.
 type Controlluser interface {
 	Register(accInfo *AccountInfo) (*Session, error)
 	Login(accInfo *AccountInfo, oldToken string) (*Session, error)
+	CompleteLogin(mfaToken, code string, accInfo *AccountInfo, oldToken string) (*Session, error)
 	AddSession(session *Session) error
 	GetSession(token string) (*Session, error)
 	DeleteSession(token string) error
+	TouchSession(session *Session) error
+	GetSessions(userID uint64) ([]SessionX, error)
+	DeleteUserSession(userID, sessionID uint64) error
+	DeleteUserSessions(userID uint64) error
+	IssueTokens(accInfo *AccountInfo) (*TokenPair, error)
+	CompleteTokens(mfaToken, code string, accInfo *AccountInfo) (*TokenPair, error)
+	RefreshTokens(refreshToken string, accInfo *AccountInfo) (*TokenPair, error)
+	RevokeRefreshToken(refreshToken string) error
+	RevokeTokenFamily(family string) error
+	ParseAccessToken(accessToken string) (*AccessClaims, error)
+	ChangePassword(current *Session, oldPassword, newPassword string) error
+	ChangeEmail(userID uint64, password, email string) error
+	RequestPasswordReset(accInfo *AccountInfo) error
+	ResetPassword(resetToken, newPassword string) error
+	GetMFAStatus(userID uint64) (*MFAStatus, error)
+	EnrollTOTP(userID uint64) (*TOTPEnrollment, error)
+	ConfirmTOTP(userID uint64, code string) ([]string, error)
+	DisableTOTP(userID uint64, password, code string) error
+	RegenerateRecoveryCodes(userID uint64, code string) ([]string, error)
+	CreateAPIKey(userID uint64, req *APIKeyRequest) (*APIKeyX, error)
+	GetAPIKeys(userID uint64) ([]APIKeyX, error)
+	RevokeAPIKey(userID, id uint64) error
+	AuthAPIKey(key string) (*APIKey, error)
 	GetUser(byKey interface{}) (*User, error)
+	ExportUser(userID uint64) (*UserExport, error)
+	GetUserInfo(byKey interface{}) (*UserX, error)
+	SetUserRole(userID uint64, role string, actor *Actor) error
+	DisableUser(userID uint64, actor *Actor) error
+	EnableUser(userID uint64, actor *Actor) error
+	LogoutUser(userID uint64, actor *Actor) error
+	DeleteAccount(userID uint64, password, code string) error
 	PostOrders(orderID, userID uint64) error
 	AddOrders(orderID, userID uint64) error
 	GetOrder(orderID uint64) (*Order, error)
@@ -44,4 +153,19 @@ type Controlluser interface {
 	GetBalance(userID uint64) (*BalanceX, error)
 	PostWithdraw(wd *WithdrawX) error
 	GetWithdrawals(userID uint64) ([]WithdrawX, error)
+	AdjustBalance(userID uint64, actor *Actor, req *AdjustmentRequest) (*AdjustmentX, error)
+	GetAdjustments(userID uint64) ([]AdjustmentX, error)
+	GetPendingAdjustments() ([]AdjustmentX, error)
+	ApproveAdjustment(id uint64, actor *Actor) (*AdjustmentX, error)
+	RejectAdjustment(id uint64, actor *Actor) (*AdjustmentX, error)
+	GetUserAdjustments(userID uint64) ([]UserAdjustmentX, error)
+	GetPointExpirations(userID uint64) ([]PointExpirationX, error)
+	GetTier(userID uint64) (*TierX, error)
+	GetReferrals(userID uint64) (*ReferralsX, error)
+	Transfer(userID uint64, actor *Actor, req *TransferRequest, key, otp string) (*TransferX, bool, error)
+	GetTransfers(userID uint64) ([]TransferX, error)
+	UploadOrders(userID uint64, lines []OrderUploadLine) (*OrderUploadX, error)
+	GetOrderUpload(userID, id uint64) (*OrderUploadX, error)
+	GetAuditEvents(f *AuditFilter) ([]AuditEventX, error)
+	VerifyAudit() (*AuditVerification, error)
 }
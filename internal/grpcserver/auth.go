@@ -0,0 +1,124 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+type userIDKey struct{}
+
+// publicMethods - методы, не требующие токена в метаданных.
+var publicMethods = map[string]bool{
+	"/gophermart.Gophermart/Register": true,
+	"/gophermart.Gophermart/Login":    true,
+}
+
+// apiKeyScopes - методы, доступные по API-ключу, и область действия, которая для этого нужна ключу.
+var apiKeyScopes = map[string]string{
+	"/gophermart.Gophermart/UploadOrder": entity.ScopeOrdersWrite,
+	"/gophermart.Gophermart/GetBalance":  entity.ScopeBalanceRead,
+	"/gophermart.Gophermart/Withdraw":    entity.ScopeWithdraw,
+}
+
+// authInterceptor - перехватчик, авторизирующий пользователя по токену сессии, access-токену или API-ключу из метаданных `authorization`.
+func (s *Server) authInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if publicMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	token := tokenFromMetadata(ctx)
+	if token == "" {
+		return nil, toStatus(repository.ErrUnauthorizedAccess)
+	}
+	if repository.IsAPIKey(token) {
+		return s.authAPIKey(ctx, req, info, handler, token)
+	}
+	if isAccessToken(token) {
+		claims, err := s.storage.ParseAccessToken(token)
+		if err != nil {
+			if !errors.Is(err, repository.ErrAccessTokenExpired) && !errors.Is(err, repository.ErrAccountDisabled) {
+				err = repository.ErrAccessTokenInvalid
+			}
+			return nil, toStatus(err)
+		}
+		return handler(context.WithValue(ctx, userIDKey{}, claims.UserID), req)
+	}
+	session, err := s.storage.GetSession(token)
+	if err != nil {
+		return nil, toStatus(repository.ErrSessionNotFound)
+	}
+	if session.IsExpired() {
+		s.storage.DeleteSession(token)
+		return nil, toStatus(repository.ErrSessionExpired)
+	}
+	// Как и для access-токенов и API-ключей, отключенный аккаунт не принимается, даже если его сессия еще жива.
+	user, err := s.storage.GetUser(session.UserID)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil, toStatus(repository.ErrSessionNotFound)
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	if user.IsDisabled() {
+		return nil, toStatus(repository.ErrAccountDisabled)
+	}
+	err = s.storage.TouchSession(session)
+	if err != nil {
+		log.Error().Err(err).Uint64("session", session.ID).Msg("failed to update session last use")
+	}
+	return handler(context.WithValue(ctx, userIDKey{}, session.UserID), req)
+}
+
+// authAPIKey - метод, авторизирующий вызов по API-ключу, которому выдана нужная методу область действия.
+func (s *Server) authAPIKey(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler, key string) (interface{}, error) {
+	k, err := s.storage.AuthAPIKey(key)
+	if err != nil {
+		if !errors.Is(err, repository.ErrAPIKeyExpired) && !errors.Is(err, repository.ErrAccountDisabled) {
+			err = repository.ErrAPIKeyInvalid
+		}
+		return nil, toStatus(err)
+	}
+	scope, ok := apiKeyScopes[info.FullMethod]
+	if !ok {
+		return nil, toStatus(repository.ErrAPIKeyNotPermitted)
+	}
+	if !k.HasScope(scope) {
+		return nil, toStatus(repository.ErrInsufficientScope)
+	}
+	return handler(context.WithValue(ctx, userIDKey{}, k.UserID), req)
+}
+
+// tokenFromMetadata - функция, извлекающая токен сессии из метаданных запроса.
+func tokenFromMetadata(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return ""
+	}
+	token := strings.TrimSpace(values[0])
+	if len(token) > 7 && strings.EqualFold(token[:7], "bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	return token
+}
+
+// isAccessToken - функция, отличающая подписанный access-токен (JWT из трех частей) от токена сессии.
+func isAccessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// userID - функция, возвращающая ID авторизованного пользователя из контекста.
+func userID(ctx context.Context) uint64 {
+	id, _ := ctx.Value(userIDKey{}).(uint64)
+	return id
+}
@@ -0,0 +1,105 @@
+package grpcserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		token    string
+		disabled bool
+		deleted  bool
+		code     codes.Code
+	}{
+		{name: "Public method", method: "Login", code: codes.OK},
+		{name: "No token", method: "ListOrders", code: codes.Unauthenticated},
+		{name: "Session", method: "ListOrders", token: testSessionToken, code: codes.OK},
+		{name: "Unknown session", method: "ListOrders", token: "forged-session", code: codes.Unauthenticated},
+		{name: "Expired session", method: "ListOrders", token: testExpiredSessionToken, code: codes.Unauthenticated},
+		{name: "Session of disabled user", method: "ListOrders", token: testSessionToken, disabled: true, code: codes.PermissionDenied},
+		{name: "Session of deleted user", method: "ListOrders", token: testSessionToken, deleted: true, code: codes.Unauthenticated},
+		{name: "Access token", method: "ListOrders", token: testAccessToken, code: codes.OK},
+		{name: "Forged access token", method: "ListOrders", token: "forged.access.token", code: codes.Unauthenticated},
+		{name: "Access token of disabled user", method: "ListOrders", token: testAccessToken, disabled: true, code: codes.PermissionDenied},
+		{name: "API key", method: "GetBalance", token: testAPIKey, code: codes.OK},
+		{name: "API key without scope", method: "Withdraw", token: testAPIKey, code: codes.PermissionDenied},
+		{name: "API key on unsupported method", method: "ListOrders", token: testAPIKey, code: codes.PermissionDenied},
+		{name: "Forged API key", method: "GetBalance", token: "gmk_forged", code: codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestStorage()
+			if tt.disabled {
+				now := time.Now()
+				storage.user.DisabledAt = &now
+			}
+			if tt.deleted {
+				storage.user = nil
+			}
+			s := &Server{storage: storage}
+			ctx := context.Background()
+			if tt.token != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", "Bearer "+tt.token))
+			}
+			var called bool
+			var got uint64
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				got = userID(ctx)
+				return req, nil
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/gophermart.Gophermart/" + tt.method}
+			_, err := s.authInterceptor(ctx, nil, info, handler)
+			require.Equal(t, tt.code, status.Code(err), err)
+			require.Equal(t, tt.code == codes.OK, called)
+			if called && tt.token != "" {
+				require.Equal(t, uint64(1), got)
+			}
+		})
+	}
+}
+
+func TestAuthInterceptorDeletesExpiredSession(t *testing.T) {
+	storage := newTestStorage()
+	s := &Server{storage: storage}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", testExpiredSessionToken))
+	_, err := s.authInterceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/gophermart.Gophermart/GetBalance"},
+		func(ctx context.Context, req interface{}) (interface{}, error) { return req, nil })
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.Equal(t, []string{testExpiredSessionToken}, storage.deleted)
+}
+
+func TestTokenFromMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []string
+		want  string
+	}{
+		{name: "No metadata"},
+		{name: "Bare token", value: []string{"token"}, want: "token"},
+		{name: "Bearer", value: []string{"Bearer token"}, want: "token"},
+		{name: "Lowercase bearer", value: []string{"bearer  token "}, want: "token"},
+		{name: "First value", value: []string{"first", "second"}, want: "first"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.value != nil {
+				md := metadata.MD{}
+				md.Append("authorization", tt.value...)
+				ctx = metadata.NewIncomingContext(ctx, md)
+			}
+			require.Equal(t, tt.want, tokenFromMetadata(ctx))
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package grpcserver
+
+import (
+	"errors"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+// errorCodes - соответствие ошибок хранилища кодам статусов gRPC.
+var errorCodes = []struct {
+	err  error
+	code codes.Code
+}{
+	{repository.ErrLoginAlreadyTaken, codes.AlreadyExists},
+	{repository.ErrUserNotFound, codes.NotFound},
+	{repository.ErrInvalidPair, codes.Unauthenticated},
+	{repository.ErrUnauthorizedAccess, codes.Unauthenticated},
+	{repository.ErrSessionNotFound, codes.Unauthenticated},
+	{repository.ErrSessionExpired, codes.Unauthenticated},
+	{repository.ErrTooManyLoginAttempts, codes.ResourceExhausted},
+	{repository.ErrAccountLocked, codes.ResourceExhausted},
+	{repository.ErrAccessTokenInvalid, codes.Unauthenticated},
+	{repository.ErrAccessTokenExpired, codes.Unauthenticated},
+	{repository.ErrInvalidPassword, codes.PermissionDenied},
+	{repository.ErrResetTokenInvalid, codes.InvalidArgument},
+	{repository.ErrResetTokenExpired, codes.InvalidArgument},
+	{repository.ErrMFARequired, codes.Unauthenticated},
+	{repository.ErrInvalidMFACode, codes.Unauthenticated},
+	{repository.ErrMFAChallengeInvalid, codes.Unauthenticated},
+	{repository.ErrTOTPAlreadyEnabled, codes.FailedPrecondition},
+	{repository.ErrTOTPNotEnabled, codes.FailedPrecondition},
+	{repository.ErrAPIKeyInvalid, codes.Unauthenticated},
+	{repository.ErrAPIKeyExpired, codes.Unauthenticated},
+	{repository.ErrInsufficientScope, codes.PermissionDenied},
+	{repository.ErrAPIKeyNotPermitted, codes.PermissionDenied},
+	{repository.ErrAccountDisabled, codes.PermissionDenied},
+	{repository.ErrOrderAlreadyLoadedByUser, codes.AlreadyExists},
+	{repository.ErrOrderAlreadyLoadedByAnotherUser, codes.AlreadyExists},
+	{repository.ErrOrderInvalidFormat, codes.InvalidArgument},
+	{repository.ErrTooManyRequests, codes.ResourceExhausted},
+	{repository.ErrNoContent, codes.NotFound},
+	{repository.ErrNotEnoughFunds, codes.FailedPrecondition},
+}
+
+// toStatus - функция, переводящая ошибку хранилища в статус gRPC.
+func toStatus(err error) error {
+	var fields validate.Errors
+	if errors.As(err, &fields) {
+		// Клиенту нужны все нарушения по полям, а не только общий текст ошибки.
+		return status.Error(codes.InvalidArgument, fields.Error())
+	}
+	for _, e := range errorCodes {
+		if errors.Is(err, e.err) {
+			return status.Error(e.code, e.err.Error())
+		}
+	}
+	return status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,170 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	pb "github.com/gtgaleevtimur/gofermart/proto"
+)
+
+type Server struct {
+	pb.UnimplementedGophermartServer
+	storage entity.Storager
+}
+
+// NewServer - конструктор gRPC-сервера, использующего те же методы контроллера, что и HTTP API.
+func NewServer(st entity.Storager, opts ...grpc.ServerOption) *grpc.Server {
+	s := &Server{storage: st}
+	opts = append(opts, grpc.UnaryInterceptor(s.authInterceptor))
+	server := grpc.NewServer(opts...)
+	pb.RegisterGophermartServer(server, s)
+	return server
+}
+
+// Register - метод регистрации нового пользователя.
+func (s *Server) Register(ctx context.Context, in *pb.Credentials) (*pb.AuthResponse, error) {
+	session, err := s.storage.Register(accountInfo(ctx, in))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return authResponse(session), nil
+}
+
+// Login - метод аутентификации пользователя.
+func (s *Server) Login(ctx context.Context, in *pb.Credentials) (*pb.AuthResponse, error) {
+	session, err := s.storage.Login(accountInfo(ctx, in), "")
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			return nil, toStatus(repository.ErrInvalidPair)
+		}
+		return nil, toStatus(err)
+	}
+	return authResponse(session), nil
+}
+
+// UploadOrder - метод загрузки номера заказа для расчета баллов лояльности.
+func (s *Server) UploadOrder(ctx context.Context, in *pb.UploadOrderRequest) (*pb.UploadOrderResponse, error) {
+	orderID, err := strconv.ParseUint(in.GetNumber(), 10, 64)
+	if err != nil {
+		return nil, toStatus(repository.ErrOrderInvalidFormat)
+	}
+	err = s.storage.PostOrders(orderID, userID(ctx))
+	if errors.Is(err, repository.ErrOrderAlreadyLoadedByUser) {
+		return &pb.UploadOrderResponse{Accepted: false}, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.UploadOrderResponse{Accepted: true}, nil
+}
+
+// ListOrders - метод, возвращающий заказы пользователя. Нет заказов - пустой список, как и в ListWithdrawals.
+func (s *Server) ListOrders(ctx context.Context, _ *pb.ListOrdersRequest) (*pb.ListOrdersResponse, error) {
+	resp := &pb.ListOrdersResponse{}
+	ordersX, err := s.storage.GetOrders(userID(ctx))
+	if errors.Is(err, repository.ErrNoContent) {
+		return resp, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	for _, o := range ordersX {
+		resp.Orders = append(resp.Orders, &pb.Order{
+			Number:     o.Number,
+			Status:     o.Status,
+			Accrual:    o.Accrual,
+			UploadedAt: o.UploadedAt,
+		})
+	}
+	return resp, nil
+}
+
+// GetBalance - метод, возвращающий баланс пользователя.
+func (s *Server) GetBalance(ctx context.Context, _ *pb.GetBalanceRequest) (*pb.Balance, error) {
+	b, err := s.storage.GetBalance(userID(ctx))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.Balance{Current: b.Current, Withdrawn: b.Withdrawn}, nil
+}
+
+// Withdraw - метод списания баллов с накопительного счета пользователя.
+func (s *Server) Withdraw(ctx context.Context, in *pb.WithdrawRequest) (*pb.WithdrawResponse, error) {
+	err := s.storage.PostWithdraw(&entity.WithdrawX{
+		Order:  in.GetOrder(),
+		Sum:    in.GetSum(),
+		UserID: userID(ctx),
+		OTP:    in.GetOtp(),
+		IP:     peerIP(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &pb.WithdrawResponse{}, nil
+}
+
+// ListWithdrawals - метод, возвращающий списания пользователя. Нет списаний - пустой список.
+func (s *Server) ListWithdrawals(ctx context.Context, _ *pb.ListWithdrawalsRequest) (*pb.ListWithdrawalsResponse, error) {
+	resp := &pb.ListWithdrawalsResponse{}
+	wdx, err := s.storage.GetWithdrawals(userID(ctx))
+	if errors.Is(err, repository.ErrNoContent) {
+		return resp, nil
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	for _, w := range wdx {
+		resp.Withdrawals = append(resp.Withdrawals, &pb.Withdrawal{
+			Order:       w.Order,
+			Sum:         w.Sum,
+			ProcessedAt: w.ProcessedAt,
+		})
+	}
+	return resp, nil
+}
+
+// authResponse - функция, собирающая ответ с токеном сессии.
+func authResponse(s *entity.Session) *pb.AuthResponse {
+	return &pb.AuthResponse{
+		Token:     s.Token,
+		ExpiresAt: s.Expiry.Format(time.RFC3339),
+	}
+}
+
+// accountInfo - функция, собирающая данные для входа вместе с адресом и user agent клиента.
+func accountInfo(ctx context.Context, in *pb.Credentials) *entity.AccountInfo {
+	info := &entity.AccountInfo{
+		Login:    in.GetLogin(),
+		Password: in.GetPassword(),
+		OTP:      in.GetOtp(),
+		IP:       peerIP(ctx),
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			info.UserAgent = ua[0]
+		}
+	}
+	return info
+}
+
+// peerIP - функция, возвращающая IP клиента без порта.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	ip := p.Addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	return ip
+}
@@ -0,0 +1,216 @@
+package grpcserver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+	pb "github.com/gtgaleevtimur/gofermart/proto"
+)
+
+// Учетные данные, которые принимает fakeStorage.
+const (
+	testSessionToken        = "session-token"
+	testExpiredSessionToken = "expired-session-token"
+	testAccessToken         = "access.token.signature"
+	testAPIKey              = "gmk_test"
+)
+
+// fakeStorage - хранилище для тестов сервера: принимает учетные данные пользователя user с ID 1, отдает orders
+// и withdrawals, а методы, меняющие данные, возвращают err. Остальные методы не реализованы.
+type fakeStorage struct {
+	entity.Storager
+	user        *entity.User
+	orders      []*entity.OrderX
+	withdrawals []entity.WithdrawX
+	err         error
+	deleted     []string
+	withdraw    *entity.WithdrawX
+}
+
+// newTestStorage - функция, создающая fakeStorage с активным пользователем 1.
+func newTestStorage() *fakeStorage {
+	return &fakeStorage{user: &entity.User{ID: 1, Login: "user", Role: entity.RoleUser}}
+}
+
+func (s *fakeStorage) GetSession(token string) (*entity.Session, error) {
+	switch token {
+	case testSessionToken:
+		return &entity.Session{ID: 1, UserID: 1, Token: token, Expiry: time.Now().Add(time.Minute)}, nil
+	case testExpiredSessionToken:
+		return &entity.Session{ID: 2, UserID: 1, Token: token, Expiry: time.Now().Add(-time.Minute)}, nil
+	}
+	return nil, repository.ErrSessionNotFound
+}
+
+func (s *fakeStorage) DeleteSession(token string) error {
+	s.deleted = append(s.deleted, token)
+	return nil
+}
+
+func (s *fakeStorage) TouchSession(session *entity.Session) error {
+	return nil
+}
+
+func (s *fakeStorage) ParseAccessToken(accessToken string) (*entity.AccessClaims, error) {
+	if accessToken != testAccessToken {
+		return nil, repository.ErrAccessTokenInvalid
+	}
+	if s.user == nil {
+		return nil, repository.ErrAccessTokenInvalid
+	}
+	if s.user.IsDisabled() {
+		return nil, repository.ErrAccountDisabled
+	}
+	return &entity.AccessClaims{UserID: 1, Expiry: time.Now().Add(time.Minute)}, nil
+}
+
+func (s *fakeStorage) AuthAPIKey(key string) (*entity.APIKey, error) {
+	if key != testAPIKey {
+		return nil, repository.ErrAPIKeyInvalid
+	}
+	return &entity.APIKey{UserID: 1, Scopes: []string{entity.ScopeBalanceRead}}, nil
+}
+
+func (s *fakeStorage) GetUser(byKey interface{}) (*entity.User, error) {
+	if id, ok := byKey.(uint64); !ok || s.user == nil || id != s.user.ID {
+		return nil, repository.ErrUserNotFound
+	}
+	return s.user, nil
+}
+
+func (s *fakeStorage) Login(accInfo *entity.AccountInfo, oldToken string) (*entity.Session, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &entity.Session{UserID: 1, Token: testSessionToken, Expiry: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}, nil
+}
+
+func (s *fakeStorage) PostOrders(orderID, userID uint64) error {
+	return s.err
+}
+
+func (s *fakeStorage) GetOrders(userID uint64) ([]*entity.OrderX, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.orders, nil
+}
+
+func (s *fakeStorage) PostWithdraw(wd *entity.WithdrawX) error {
+	s.withdraw = wd
+	return s.err
+}
+
+func (s *fakeStorage) GetWithdrawals(userID uint64) ([]entity.WithdrawX, error) {
+	if len(s.withdrawals) == 0 {
+		return nil, repository.ErrNoContent
+	}
+	return s.withdrawals, nil
+}
+
+// authorized - функция, возвращающая контекст вызова, авторизованного пользователем 1.
+func authorized() context.Context {
+	return context.WithValue(context.Background(), userIDKey{}, uint64(1))
+}
+
+func TestListOrders(t *testing.T) {
+	s := &Server{storage: newTestStorage()}
+	resp, err := s.ListOrders(authorized(), &pb.ListOrdersRequest{})
+	require.NoError(t, err)
+	require.Empty(t, resp.GetOrders())
+
+	// Хранилище может сообщить об отсутствии заказов ошибкой - это тоже пустой список, а не NotFound.
+	s.storage.(*fakeStorage).err = repository.ErrNoContent
+	resp, err = s.ListOrders(authorized(), &pb.ListOrdersRequest{})
+	require.NoError(t, err)
+	require.Empty(t, resp.GetOrders())
+
+	s.storage = &fakeStorage{orders: []*entity.OrderX{{Number: "2377225624", Status: "PROCESSED", Accrual: 500, UploadedAt: "2024-06-01T10:00:00Z"}}}
+	resp, err = s.ListOrders(authorized(), &pb.ListOrdersRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetOrders(), 1)
+	require.Equal(t, "2377225624", resp.GetOrders()[0].GetNumber())
+	require.Equal(t, 500.0, resp.GetOrders()[0].GetAccrual())
+}
+
+func TestListWithdrawals(t *testing.T) {
+	s := &Server{storage: newTestStorage()}
+	resp, err := s.ListWithdrawals(authorized(), &pb.ListWithdrawalsRequest{})
+	require.NoError(t, err)
+	require.Empty(t, resp.GetWithdrawals())
+
+	s.storage = &fakeStorage{withdrawals: []entity.WithdrawX{{Order: "2377225624", Sum: 751, ProcessedAt: "2024-06-01T10:00:00Z"}}}
+	resp, err = s.ListWithdrawals(authorized(), &pb.ListWithdrawalsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetWithdrawals(), 1)
+	require.Equal(t, 751.0, resp.GetWithdrawals()[0].GetSum())
+}
+
+func TestUploadOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		number   string
+		err      error
+		accepted bool
+		code     codes.Code
+	}{
+		{name: "Accepted", number: "2377225624", accepted: true, code: codes.OK},
+		{name: "Not a number", number: "12ab", code: codes.InvalidArgument},
+		{name: "Invalid checksum", number: "2377225625", err: repository.ErrOrderInvalidFormat, code: codes.InvalidArgument},
+		{name: "Already uploaded by user", number: "2377225624", err: repository.ErrOrderAlreadyLoadedByUser, code: codes.OK},
+		{name: "Uploaded by another user", number: "2377225624", err: repository.ErrOrderAlreadyLoadedByAnotherUser, code: codes.AlreadyExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{storage: &fakeStorage{err: tt.err}}
+			resp, err := s.UploadOrder(authorized(), &pb.UploadOrderRequest{Number: tt.number})
+			require.Equal(t, tt.code, status.Code(err), err)
+			require.Equal(t, tt.accepted, resp.GetAccepted())
+		})
+	}
+}
+
+func TestWithdraw(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{name: "Success", code: codes.OK},
+		{name: "Not enough funds", err: repository.ErrNotEnoughFunds, code: codes.FailedPrecondition},
+		{name: "MFA required", err: repository.ErrMFARequired, code: codes.Unauthenticated},
+		{name: "Invalid field", err: validate.Errors{{Field: "sum", Code: "invalid_value", Message: "sum must be positive"}}, code: codes.InvalidArgument},
+		{name: "Unknown error", err: context.DeadlineExceeded, code: codes.Internal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := &fakeStorage{err: tt.err}
+			s := &Server{storage: storage}
+			_, err := s.Withdraw(authorized(), &pb.WithdrawRequest{Order: "2377225624", Sum: 751, Otp: "123456"})
+			require.Equal(t, tt.code, status.Code(err), err)
+			require.Equal(t, &entity.WithdrawX{Order: "2377225624", Sum: 751, UserID: 1, OTP: "123456"}, storage.withdraw)
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	s := &Server{storage: newTestStorage()}
+	resp, err := s.Login(context.Background(), &pb.Credentials{Login: "user", Password: "secret"})
+	require.NoError(t, err)
+	require.Equal(t, testSessionToken, resp.GetToken())
+	require.Equal(t, "2030-01-02T03:04:05Z", resp.GetExpiresAt())
+
+	// Неизвестный логин не отличается от неверного пароля.
+	s.storage = &fakeStorage{err: repository.ErrUserNotFound}
+	_, err = s.Login(context.Background(), &pb.Credentials{Login: "nobody", Password: "secret"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+	require.Equal(t, repository.ErrInvalidPair.Error(), status.Convert(err).Message())
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+type accountDeleteRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+// ExportUser - обработчик, выгружающий все данные пользователя одним JSON или, с параметром format=zip, ZIP-архивом.
+func (c *Controller) ExportUser(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "zip" {
+		c.error(w, r, fmt.Errorf("unknown export format `%s`", format), http.StatusBadRequest)
+		return
+	}
+	export, err := c.Storage.ExportUser(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to export user data - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	name := fmt.Sprintf("gophermart-export-%d-%s", st.UserID, time.Now().Format("20060102"))
+	if format != "zip" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, name))
+		c.writeJSON(w, r, http.StatusOK, export)
+		return
+	}
+	// Архив пишется сразу в ответ: после первого байта код ответа уже не изменить, поэтому ошибки только логируются.
+	w.Header().Set("Content-Type", ContentTypeApplicationZip)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, name))
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name string
+		v    interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"balance.json", export.Balance},
+		{"orders.json", export.Orders},
+		{"withdrawals.json", export.Withdrawals},
+		{"adjustments.json", export.Adjustments},
+		{"expirations.json", export.Expirations},
+		{"transfers.json", export.Transfers},
+		{"sessions.json", export.Sessions},
+		{"api_keys.json", export.APIKeys},
+	}
+	for _, f := range files {
+		fw, err := zw.Create(f.name)
+		if err == nil {
+			enc := json.NewEncoder(fw)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(f.v)
+		}
+		if err != nil {
+			log.Error().Err(err).Str("file", f.name).Uint64("user", st.UserID).Msg("failed to write export archive")
+			return
+		}
+	}
+	err = zw.Close()
+	if err != nil {
+		log.Error().Err(err).Uint64("user", st.UserID).Msg("failed to finish export archive")
+		return
+	}
+	c.log(r, fmt.Sprintf("data of user %d exported", st.UserID))
+}
+
+// DeleteAccount - обработчик удаления аккаунта пользователя. Требует пароль и код TOTP, если он подключен.
+// Персональные данные обезличиваются, все сессии и токены отзываются, заказы и списания остаются для учета.
+func (c *Controller) DeleteAccount(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	var req accountDeleteRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.DeleteAccount(st.UserID, req.Password, req.Code)
+	if err != nil {
+		if c.tooManyRequests(w, r, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, repository.ErrInvalidPassword), errors.Is(err, repository.ErrMFARequired),
+			errors.Is(err, repository.ErrInvalidMFACode):
+			c.error(w, r, err, http.StatusForbidden)
+		default:
+			c.error(w, r, fmt.Errorf("failed to delete account - %s", err.Error()), http.StatusInternalServerError)
+		}
+		return
+	}
+	c.clearSessionCookie(w)
+	c.log(r, fmt.Sprintf("account of user %d deleted", st.UserID))
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func (s *fakeStorage) ExportUser(userID uint64) (*entity.UserExport, error) {
+	return &entity.UserExport{
+		ExportedAt:  "2024-06-01T10:00:00Z",
+		Profile:     entity.UserProfile{ID: userID, Login: "user", Email: "user@example.com"},
+		Balance:     entity.BalanceX{Current: 249.5, Withdrawn: 751, ExpiringSoon: []entity.ExpiringPointsX{{Amount: 100, Date: "2024-07-01"}}},
+		Orders:      []*entity.OrderX{{Number: "2377225624", Status: "PROCESSED", Accrual: 500, UploadedAt: "2024-05-01T10:00:00Z"}},
+		Withdrawals: []entity.WithdrawX{{Order: "2377225624", Sum: 751, ProcessedAt: "2024-05-02T10:00:00Z"}},
+		Adjustments: []entity.UserAdjustmentX{{Amount: 500, Reason: "goodwill", ProcessedAt: "2024-05-03T10:00:00Z"}},
+		Expirations: []entity.PointExpirationX{{Amount: 10, ExpiredAt: "2024-05-04T10:00:00Z"}},
+		Transfers:   []entity.TransferX{{ID: 1, Direction: entity.TransferOut, Counterparty: "friend", Amount: 5, CreatedAt: "2024-05-05T10:00:00Z"}},
+		Sessions:    []entity.SessionX{{ID: 1, CreatedAt: "2024-05-06T10:00:00Z", IP: "192.0.2.1"}},
+		APIKeys:     []entity.APIKeyX{{ID: 1, Name: "shop", Prefix: "gmk_abcd", Scopes: []string{entity.ScopeBalanceRead}}},
+	}, nil
+}
+
+// exportUser - функция, выгружающая данные пользователя 1 в формате format.
+func exportUser(t *testing.T, format string) *httptest.ResponseRecorder {
+	t.Helper()
+	c := newTestController(&config.Config{}, entity.RoleUser)
+	r := httptest.NewRequest(http.MethodGet, "/api/user/export?format="+format, nil)
+	r.Header.Set("Authorization", "Bearer "+testAccessToken)
+	w := httptest.NewRecorder()
+	c.ExportUser(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Equal(t, "no-store", w.Header().Get("Cache-Control"))
+	return w
+}
+
+func TestExportUserFormats(t *testing.T) {
+	w := exportUser(t, "json")
+	require.Equal(t, ContentTypeApplicationJSON, w.Header().Get("Content-Type"))
+	require.True(t, strings.HasSuffix(w.Header().Get("Content-Disposition"), `.json"`))
+	var export map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &export))
+
+	w = exportUser(t, "zip")
+	require.Equal(t, ContentTypeApplicationZip, w.Header().Get("Content-Type"))
+	require.True(t, strings.HasSuffix(w.Header().Get("Content-Disposition"), `.zip"`))
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	require.NoError(t, err)
+
+	// Каждый раздел JSON-выгрузки, кроме времени выгрузки, лежит в архиве отдельным файлом с тем же содержимым.
+	files := make(map[string]bool)
+	for _, f := range zr.File {
+		section := strings.TrimSuffix(f.Name, ".json")
+		files[section] = true
+		want, ok := export[section]
+		require.True(t, ok, "unexpected file %s", f.Name)
+		rc, err := f.Open()
+		require.NoError(t, err)
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		require.JSONEq(t, string(want), string(got), f.Name)
+	}
+	for section := range export {
+		if section != "exported_at" {
+			require.True(t, files[section], "section %s is missing in archive", section)
+		}
+	}
+}
+
+func TestExportUserUnknownFormat(t *testing.T) {
+	c := newTestController(&config.Config{}, entity.RoleUser)
+	r := httptest.NewRequest(http.MethodGet, "/api/user/export?format=xml", nil)
+	r.Header.Set("Authorization", "Bearer "+testAccessToken)
+	w := httptest.NewRecorder()
+	c.ExportUser(w, r)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+}
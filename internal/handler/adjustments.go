@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// GetAdjustments - обработчик, возвращающий примененные ручные корректировки баланса пользователя.
+func (c *Controller) GetAdjustments(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r, entity.ScopeBalanceRead)
+	if err != nil {
+		return
+	}
+	list, err := c.Storage.GetUserAdjustments(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get balance adjustments - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, list)
+}
+
+// GetExpirations - обработчик, возвращающий сгорания баллов пользователя.
+func (c *Controller) GetExpirations(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r, entity.ScopeBalanceRead)
+	if err != nil {
+		return
+	}
+	list, err := c.Storage.GetPointExpirations(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get point expirations - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, list)
+}
+
+// AdminGetAdjustments - обработчик, возвращающий все корректировки баланса пользователя, включая ожидающие и отклоненные.
+func (c *Controller) AdminGetAdjustments(w http.ResponseWriter, r *http.Request) {
+	userID, ok := c.userID(w, r)
+	if !ok {
+		return
+	}
+	list, err := c.Storage.GetAdjustments(userID)
+	if err != nil {
+		c.adminError(w, r, err, "get balance adjustments")
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, list)
+}
+
+// AdminAdjustBalance - обработчик, начисляющий или списывающий баллы пользователя с указанием причины.
+// Отвечает 201, если корректировка применена, и 202, если она ждет одобрения другим администратором.
+func (c *Controller) AdminAdjustBalance(w http.ResponseWriter, r *http.Request) {
+	userID, ok := c.userID(w, r)
+	if !ok {
+		return
+	}
+	var req entity.AdjustmentRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	a, err := c.Storage.AdjustBalance(userID, actor(r), &req)
+	if err != nil {
+		c.adminError(w, r, err, "adjust balance")
+		return
+	}
+	status := http.StatusCreated
+	if a.Status == entity.AdjustmentPending {
+		status = http.StatusAccepted
+	}
+	c.writeJSON(w, r, status, a)
+	c.log(r, fmt.Sprintf("balance adjustment %d for user %d by user %d is %s", a.ID, userID, a.CreatedBy, a.Status))
+}
+
+// AdminGetPendingAdjustments - обработчик, возвращающий корректировки баланса, ожидающие одобрения.
+func (c *Controller) AdminGetPendingAdjustments(w http.ResponseWriter, r *http.Request) {
+	list, err := c.Storage.GetPendingAdjustments()
+	if err != nil {
+		c.adminError(w, r, err, "get pending balance adjustments")
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, list)
+}
+
+// AdminApproveAdjustment - обработчик, одобряющий корректировку баланса.
+func (c *Controller) AdminApproveAdjustment(w http.ResponseWriter, r *http.Request) {
+	c.decideAdjustment(w, r, c.Storage.ApproveAdjustment)
+}
+
+// AdminRejectAdjustment - обработчик, отклоняющий корректировку баланса.
+func (c *Controller) AdminRejectAdjustment(w http.ResponseWriter, r *http.Request) {
+	c.decideAdjustment(w, r, c.Storage.RejectAdjustment)
+}
+
+// decideAdjustment - метод, записывающий решение decide по корректировке баланса из пути запроса.
+func (c *Controller) decideAdjustment(w http.ResponseWriter, r *http.Request, decide func(id uint64, actor *entity.Actor) (*entity.AdjustmentX, error)) {
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("invalid adjustment ID - %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	a, err := decide(id, actor(r))
+	if err != nil {
+		c.adminError(w, r, err, "decide balance adjustment")
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, a)
+	c.log(r, fmt.Sprintf("balance adjustment %d is %s by user %d", a.ID, a.Status, a.DecidedBy))
+}
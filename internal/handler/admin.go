@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+// staffKey - ключ контекста запроса, под которым requireRole сохраняет сотрудника.
+type staffKey struct{}
+
+// adminRoutes - метод, регистрирующий маршруты API для сотрудников.
+func (c *Controller) adminRoutes(rout chi.Router) {
+	rout.Use(c.validate)
+	rout.Use(c.csrf)
+
+	rout.Group(func(rout chi.Router) {
+		rout.Use(c.requireRole(entity.RoleSupport))
+		rout.Get("/users", c.AdminFindUser)
+		rout.Get("/users/{id}", c.AdminGetUser)
+		rout.Get("/users/{id}/orders", c.AdminGetOrders)
+		rout.Get("/users/{id}/withdrawals", c.AdminGetWithdrawals)
+		rout.Get("/users/{id}/balance", c.AdminGetBalance)
+		rout.Post("/users/{id}/logout", c.AdminLogoutUser)
+		rout.Get("/users/{id}/adjustments", c.AdminGetAdjustments)
+	})
+	rout.Group(func(rout chi.Router) {
+		rout.Use(c.requireRole(entity.RoleAdmin))
+		rout.Post("/users/{id}/disable", c.AdminDisableUser)
+		rout.Post("/users/{id}/enable", c.AdminEnableUser)
+		rout.Put("/users/{id}/role", c.AdminSetRole)
+		rout.Post("/users/{id}/adjustments", c.AdminAdjustBalance)
+		rout.Get("/adjustments", c.AdminGetPendingAdjustments)
+		rout.Post("/adjustments/{id}/approve", c.AdminApproveAdjustment)
+		rout.Post("/adjustments/{id}/reject", c.AdminRejectAdjustment)
+		rout.Get("/audit", c.AdminGetAuditEvents)
+		rout.Get("/audit/verify", c.AdminVerifyAudit)
+	})
+
+	rout.NotFound(c.notFound)
+}
+
+// requireRole - middleware, пропускающий только пользователей с ролью role или старше.
+// API-ключи не принимаются: у них нет роли, только области действия. Запрос проверяется по спецификации после проверки роли.
+func (c *Controller) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			st, err := c.authenticate(w, r)
+			if err != nil {
+				return
+			}
+			u, err := c.Storage.GetUser(st.UserID)
+			if err != nil {
+				if errors.Is(err, repository.ErrUserNotFound) {
+					c.error(w, r, repository.ErrUnauthorizedAccess, http.StatusUnauthorized)
+					return
+				}
+				c.error(w, r, fmt.Errorf("failed to get user by ID - %s", err.Error()), http.StatusInternalServerError)
+				return
+			}
+			if u.IsDisabled() {
+				// Access-токен отключенного пользователя действует до истечения срока, поэтому статус проверяется здесь.
+				c.error(w, r, repository.ErrAccountDisabled, http.StatusForbidden)
+				return
+			}
+			if !u.HasRole(role) {
+				c.error(w, r, repository.ErrForbidden, http.StatusForbidden)
+				return
+			}
+			err = c.validateAuthenticated(w, r)
+			if err != nil {
+				return
+			}
+			st.User = u
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), staffKey{}, st)))
+		})
+	}
+}
+
+// staff - функция, возвращающая сотрудника, авторизованного requireRole.
+func staff(r *http.Request) *entity.Principal {
+	st, _ := r.Context().Value(staffKey{}).(*entity.Principal)
+	return st
+}
+
+// actor - функция, возвращающая сотрудника, авторизованного requireRole, как инициатора действия для журнала аудита.
+func actor(r *http.Request) *entity.Actor {
+	return &entity.Actor{
+		UserID:    staff(r).UserID,
+		IP:        clientIP(r),
+		RequestID: requestID(r),
+	}
+}
+
+// AdminFindUser - обработчик, ищущий пользователя по логину.
+func (c *Controller) AdminFindUser(w http.ResponseWriter, r *http.Request) {
+	login := r.URL.Query().Get("login")
+	if login == "" {
+		c.error(w, r, validate.Errors{{Field: "login", Code: "required", Message: "login is required"}}, http.StatusBadRequest)
+		return
+	}
+	c.writeUserInfo(w, r, login)
+}
+
+// AdminGetUser - обработчик, возвращающий сведения о пользователе по его ID.
+func (c *Controller) AdminGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := c.userID(w, r)
+	if !ok {
+		return
+	}
+	c.writeUserInfo(w, r, userID)
+}
+
+// writeUserInfo - метод, отдающий сведения о пользователе по логину или ID.
+func (c *Controller) writeUserInfo(w http.ResponseWriter, r *http.Request, byKey interface{}) {
+	ux, err := c.Storage.GetUserInfo(byKey)
+	if err != nil {
+		c.adminError(w, r, err, "get user")
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, ux)
+}
+
+// AdminGetOrders - обработчик, возвращающий заказы пользователя.
+func (c *Controller) AdminGetOrders(w http.ResponseWriter, r *http.Request) {
+	u, ok := c.targetUser(w, r)
+	if !ok {
+		return
+	}
+	orders, err := c.Storage.GetOrders(u.ID)
+	if err != nil {
+		c.adminError(w, r, err, "get all orders")
+		return
+	}
+	if orders == nil {
+		orders = make([]*entity.OrderX, 0)
+	}
+	c.writeJSON(w, r, http.StatusOK, orders)
+}
+
+// AdminGetWithdrawals - обработчик, возвращающий списания пользователя.
+func (c *Controller) AdminGetWithdrawals(w http.ResponseWriter, r *http.Request) {
+	u, ok := c.targetUser(w, r)
+	if !ok {
+		return
+	}
+	wdx, err := c.Storage.GetWithdrawals(u.ID)
+	if err != nil && !errors.Is(err, repository.ErrNoContent) {
+		c.adminError(w, r, err, "get withdrawals")
+		return
+	}
+	if wdx == nil {
+		wdx = make([]entity.WithdrawX, 0)
+	}
+	c.writeJSON(w, r, http.StatusOK, wdx)
+}
+
+// AdminGetBalance - обработчик, возвращающий баланс пользователя.
+func (c *Controller) AdminGetBalance(w http.ResponseWriter, r *http.Request) {
+	u, ok := c.targetUser(w, r)
+	if !ok {
+		return
+	}
+	balance, err := c.Storage.GetBalance(u.ID)
+	if err != nil {
+		c.adminError(w, r, err, "get balance")
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, balance)
+}
+
+// AdminLogoutUser - обработчик, завершающий все сессии пользователя и отзывающий его refresh-токены.
+func (c *Controller) AdminLogoutUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := c.targetUser(w, r)
+	if !ok {
+		return
+	}
+	err := c.Storage.LogoutUser(u.ID, actor(r))
+	if err != nil {
+		c.adminError(w, r, err, "delete sessions")
+		return
+	}
+	c.log(r, fmt.Sprintf("sessions of user %d ended by user %d", u.ID, staff(r).UserID))
+}
+
+// AdminDisableUser - обработчик, отключающий аккаунт пользователя.
+func (c *Controller) AdminDisableUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := c.otherUser(w, r)
+	if !ok {
+		return
+	}
+	err := c.Storage.DisableUser(u.ID, actor(r))
+	if err != nil {
+		c.adminError(w, r, err, "disable user")
+		return
+	}
+	c.log(r, fmt.Sprintf("user %d disabled by user %d", u.ID, staff(r).UserID))
+}
+
+// AdminEnableUser - обработчик, включающий отключенный аккаунт пользователя.
+func (c *Controller) AdminEnableUser(w http.ResponseWriter, r *http.Request) {
+	u, ok := c.otherUser(w, r)
+	if !ok {
+		return
+	}
+	err := c.Storage.EnableUser(u.ID, actor(r))
+	if err != nil {
+		c.adminError(w, r, err, "enable user")
+		return
+	}
+	c.log(r, fmt.Sprintf("user %d enabled by user %d", u.ID, staff(r).UserID))
+}
+
+// AdminSetRole - обработчик, назначающий пользователю роль.
+func (c *Controller) AdminSetRole(w http.ResponseWriter, r *http.Request) {
+	u, ok := c.otherUser(w, r)
+	if !ok {
+		return
+	}
+	var req entity.RoleRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.SetUserRole(u.ID, req.Role, actor(r))
+	if err != nil {
+		c.adminError(w, r, err, "set role")
+		return
+	}
+	c.log(r, fmt.Sprintf("user %d granted role `%s` by user %d", u.ID, req.Role, staff(r).UserID))
+}
+
+// userID - метод, читающий ID пользователя из пути запроса.
+func (c *Controller) userID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	userID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("invalid user ID - %s", err.Error()), http.StatusBadRequest)
+		return 0, false
+	}
+	return userID, true
+}
+
+// targetUser - метод, возвращающий пользователя, над которым выполняется действие.
+func (c *Controller) targetUser(w http.ResponseWriter, r *http.Request) (*entity.User, bool) {
+	userID, ok := c.userID(w, r)
+	if !ok {
+		return nil, false
+	}
+	u, err := c.Storage.GetUser(userID)
+	if err != nil {
+		c.adminError(w, r, err, "get user by ID")
+		return nil, false
+	}
+	return u, true
+}
+
+// otherUser - метод, как targetUser, но не позволяющий сотруднику менять роль и статус своего аккаунта.
+func (c *Controller) otherUser(w http.ResponseWriter, r *http.Request) (*entity.User, bool) {
+	u, ok := c.targetUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if u.ID == staff(r).UserID {
+		c.error(w, r, repository.ErrCannotModifySelf, http.StatusConflict)
+		return nil, false
+	}
+	return u, true
+}
+
+// adminError - метод, отвечающий на ошибку хранилища в API для сотрудников. action описывает неудавшееся действие для лога.
+func (c *Controller) adminError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	switch {
+	case errors.Is(err, repository.ErrUserNotFound):
+		c.error(w, r, repository.ErrUserNotFound, http.StatusNotFound)
+	case errors.Is(err, repository.ErrAdjustmentNotFound):
+		c.error(w, r, repository.ErrAdjustmentNotFound, http.StatusNotFound)
+	case errors.Is(err, repository.ErrAdjustmentNotPending), errors.Is(err, repository.ErrSelfApproval),
+		errors.Is(err, repository.ErrSelfAdjustment), errors.Is(err, repository.ErrNotEnoughFunds):
+		c.error(w, r, err, http.StatusConflict)
+	case errors.Is(err, validate.ErrValidation):
+		c.error(w, r, err, http.StatusBadRequest)
+	default:
+		c.error(w, r, fmt.Errorf("failed to %s - %s", action, err.Error()), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     string
+		disabled bool
+		deleted  bool
+		bearer   string
+		required string
+		want     int
+	}{
+		{name: "Anonymous", role: entity.RoleAdmin, required: entity.RoleSupport, want: http.StatusUnauthorized},
+		{name: "Invalid token", role: entity.RoleAdmin, bearer: "forged", required: entity.RoleSupport, want: http.StatusUnauthorized},
+		{name: "API key", role: entity.RoleAdmin, bearer: testAPIKey, required: entity.RoleSupport, want: http.StatusForbidden},
+		{name: "User", role: entity.RoleUser, bearer: testAccessToken, required: entity.RoleSupport, want: http.StatusForbidden},
+		{name: "Support", role: entity.RoleSupport, bearer: testAccessToken, required: entity.RoleSupport, want: http.StatusNoContent},
+		{name: "Support on admin route", role: entity.RoleSupport, bearer: testAccessToken, required: entity.RoleAdmin, want: http.StatusForbidden},
+		{name: "Admin on support route", role: entity.RoleAdmin, bearer: testAccessToken, required: entity.RoleSupport, want: http.StatusNoContent},
+		{name: "Disabled admin", role: entity.RoleAdmin, disabled: true, bearer: testAccessToken, required: entity.RoleSupport, want: http.StatusForbidden},
+		{name: "Deleted admin", role: entity.RoleAdmin, deleted: true, bearer: testAccessToken, required: entity.RoleSupport, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestStorage(tt.role)
+			if tt.disabled {
+				now := time.Now()
+				storage.users[1].DisabledAt = &now
+			}
+			if tt.deleted {
+				delete(storage.users, 1)
+			}
+			c := newController(storage, &config.Config{}).withProblems()
+			h := c.requireRole(tt.required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				st := staff(r)
+				require.NotNil(t, st)
+				require.Equal(t, uint64(1), st.UserID)
+				require.Equal(t, tt.role, st.User.Role)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/users/1", nil)
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			require.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestRequireRoleBeforeValidation(t *testing.T) {
+	// Сотрудник без нужной роли получает 403, даже если запрос не соответствует спецификации.
+	for role, want := range map[string]int{entity.RoleSupport: http.StatusForbidden, entity.RoleAdmin: http.StatusBadRequest} {
+		c := newTestController(&config.Config{}, role).withProblems()
+		h := c.validate(c.requireRole(entity.RoleAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})))
+		r := httptest.NewRequest(http.MethodPut, "/api/admin/users/1/role", strings.NewReader(`{"role": 1}`))
+		r.Header.Set("Content-Type", ContentTypeApplicationJSON)
+		r.Header.Set("Authorization", "Bearer "+testAccessToken)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		require.Equal(t, want, w.Code, role)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+// GetAPIKeys - обработчик, возвращающий действующие API-ключи пользователя.
+func (c *Controller) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	keys, err := c.Storage.GetAPIKeys(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get API keys - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, keys)
+}
+
+// CreateAPIKey - обработчик, выпускающий API-ключ для клиента, работающего от имени пользователя.
+// Ключ отдается только в этом ответе.
+func (c *Controller) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	var req entity.APIKeyRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	key, err := c.Storage.CreateAPIKey(st.UserID, &req)
+	if err != nil {
+		if errors.Is(err, validate.ErrValidation) {
+			c.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+		c.error(w, r, fmt.Errorf("failed to create API key - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	c.writeJSON(w, r, http.StatusCreated, key)
+	c.log(r, fmt.Sprintf("API key %d for user %d created", key.ID, st.UserID))
+}
+
+// RevokeAPIKey - обработчик, отзывающий API-ключ пользователя по его ID.
+func (c *Controller) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	keyID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("invalid API key ID - %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.RevokeAPIKey(st.UserID, keyID)
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			c.error(w, r, repository.ErrAPIKeyNotFound, http.StatusNotFound)
+			return
+		}
+		c.error(w, r, fmt.Errorf("failed to revoke API key - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.log(r, fmt.Sprintf("API key %d has been revoked", keyID))
+}
@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+// AdminGetAuditEvents - обработчик, возвращающий страницу журнала аудита по фильтру из параметров запроса.
+func (c *Controller) AdminGetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	f, err := auditFilter(r.URL.Query())
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	events, err := c.Storage.GetAuditEvents(f)
+	if err != nil {
+		c.adminError(w, r, err, "get audit events")
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, events)
+}
+
+// AdminVerifyAudit - обработчик, проверяющий цепочку хэшей журнала аудита.
+func (c *Controller) AdminVerifyAudit(w http.ResponseWriter, r *http.Request) {
+	v, err := c.Storage.VerifyAudit()
+	if err != nil {
+		c.adminError(w, r, err, "verify audit log")
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, v)
+}
+
+// auditFilter - функция, читающая фильтр журнала аудита из параметров запроса. Время задается в RFC 3339.
+func auditFilter(q url.Values) (*entity.AuditFilter, error) {
+	f := &entity.AuditFilter{
+		Action:     q.Get("action"),
+		TargetType: q.Get("target_type"),
+		TargetID:   q.Get("target_id"),
+	}
+	var errs validate.Errors
+	parseUint := func(field string) uint64 {
+		v, err := strconv.ParseUint(q.Get(field), 10, 64)
+		if err != nil {
+			errs = append(errs, validate.FieldError{Field: field, Code: "invalid_format", Message: "must be a non-negative integer"})
+		}
+		return v
+	}
+	parseTime := func(field string) *time.Time {
+		v, err := time.Parse(time.RFC3339, q.Get(field))
+		if err != nil {
+			errs = append(errs, validate.FieldError{Field: field, Code: "invalid_format", Message: "must be an RFC 3339 date-time"})
+			return nil
+		}
+		return &v
+	}
+	if q.Get("actor_id") != "" {
+		actorID := parseUint("actor_id")
+		f.ActorID = &actorID
+	}
+	if q.Get("from") != "" {
+		f.From = parseTime("from")
+	}
+	if q.Get("to") != "" {
+		f.To = parseTime("to")
+	}
+	if q.Get("after_id") != "" {
+		f.AfterID = parseUint("after_id")
+	}
+	if q.Get("limit") != "" {
+		f.Limit = int(parseUint("limit"))
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return f, nil
+}
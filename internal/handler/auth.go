@@ -1,16 +1,46 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
 
 	"github.com/gtgaleevtimur/gofermart/internal/entity"
 	"github.com/gtgaleevtimur/gofermart/internal/repository"
 )
 
-// auth - обработчик, авторизирующий пользовтаеля и его сессию.
-func (c *Controller) auth(w http.ResponseWriter, r *http.Request) (*entity.Session, error) {
-	st, err := r.Cookie("session_token")
+// auth - обработчик, авторизирующий пользовтаеля и его сессию, а затем проверяющий запрос по спецификации.
+// Принимает cookie сессии или access-токен в заголовке `Authorization: Bearer`.
+// API-ключ в том же заголовке принимается, только если хэндлер передал scopes и ключу выдана одна из них.
+func (c *Controller) auth(w http.ResponseWriter, r *http.Request, scopes ...string) (*entity.Principal, error) {
+	p, err := c.authenticate(w, r, scopes...)
+	if err != nil {
+		return nil, err
+	}
+	err = c.validateAuthenticated(w, r)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// authenticate - метод, авторизирующий пользователя и его сессию без проверки запроса по спецификации.
+func (c *Controller) authenticate(w http.ResponseWriter, r *http.Request, scopes ...string) (*entity.Principal, error) {
+	if hasBearer(r) {
+		token := bearerToken(r)
+		if repository.IsAPIKey(token) {
+			return c.authAPIKey(w, r, token, scopes)
+		}
+		session, err := c.authBearer(w, r, token)
+		if err != nil {
+			return nil, err
+		}
+		return &entity.Principal{Session: session}, nil
+	}
+	st, err := r.Cookie(sessionCookieName)
 	if err != nil {
 		if err == http.ErrNoCookie {
 			c.error(w, r, repository.ErrUnauthorizedAccess, http.StatusUnauthorized)
@@ -22,15 +52,84 @@ func (c *Controller) auth(w http.ResponseWriter, r *http.Request) (*entity.Sessi
 	sessionToken := st.Value
 	session, err := c.Storage.GetSession(sessionToken)
 	if err != nil {
-		err = fmt.Errorf("session token is not present")
-		c.error(w, r, err, http.StatusUnauthorized)
-		return nil, err
+		c.error(w, r, repository.ErrSessionNotFound, http.StatusUnauthorized)
+		return nil, repository.ErrSessionNotFound
 	}
 	if session.IsExpired() {
 		c.Storage.DeleteSession(sessionToken)
-		err = fmt.Errorf("session has expired")
+		c.error(w, r, repository.ErrSessionExpired, http.StatusUnauthorized)
+		return nil, repository.ErrSessionExpired
+	}
+	expiry := session.Expiry
+	err = c.Storage.TouchSession(session)
+	if err != nil {
+		log.Error().Err(err).Uint64("session", session.ID).Msg("failed to update session last use")
+	}
+	if session.Expiry.After(expiry) {
+		// Сессия продлена - продлеваем и cookie.
+		c.setSessionCookie(w, session)
+	}
+	return &entity.Principal{Session: session}, nil
+}
+
+// authBearer - метод, авторизирующий пользователя по подписанному access-токену. Токен отключенного
+// или удаленного пользователя и токен, выпущенный до отзыва токенов пользователя, не принимаются.
+// Возвращает сессию-заглушку с ID пользователя и семейством refresh-токенов.
+func (c *Controller) authBearer(w http.ResponseWriter, r *http.Request, accessToken string) (*entity.Session, error) {
+	claims, err := c.Storage.ParseAccessToken(accessToken)
+	if errors.Is(err, repository.ErrAccountDisabled) {
+		c.error(w, r, err, http.StatusForbidden)
+		return nil, err
+	}
+	if err != nil {
+		if !errors.Is(err, repository.ErrAccessTokenExpired) {
+			err = repository.ErrAccessTokenInvalid
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
 		c.error(w, r, err, http.StatusUnauthorized)
 		return nil, err
 	}
-	return session, nil
+	return &entity.Session{
+		UserID:      claims.UserID,
+		Expiry:      claims.Expiry,
+		TokenFamily: claims.Family,
+	}, nil
+}
+
+// authAPIKey - метод, авторизирующий клиента по API-ключу. Ключу должна быть выдана одна из областей действия scopes.
+// Возвращает субъект с сессией-заглушкой владельца ключа.
+func (c *Controller) authAPIKey(w http.ResponseWriter, r *http.Request, key string, scopes []string) (*entity.Principal, error) {
+	k, err := c.Storage.AuthAPIKey(key)
+	if errors.Is(err, repository.ErrAccountDisabled) {
+		c.error(w, r, err, http.StatusForbidden)
+		return nil, err
+	}
+	if err != nil {
+		if !errors.Is(err, repository.ErrAPIKeyExpired) {
+			err = repository.ErrAPIKeyInvalid
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		c.error(w, r, err, http.StatusUnauthorized)
+		return nil, err
+	}
+	if len(scopes) == 0 {
+		c.error(w, r, repository.ErrAPIKeyNotPermitted, http.StatusForbidden)
+		return nil, repository.ErrAPIKeyNotPermitted
+	}
+	for _, scope := range scopes {
+		if k.HasScope(scope) {
+			return &entity.Principal{
+				Session: &entity.Session{UserID: k.UserID, Expiry: k.Expiry},
+				APIKey:  k,
+			}, nil
+		}
+	}
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, strings.Join(scopes, " ")))
+	c.error(w, r, repository.ErrInsufficientScope, http.StatusForbidden)
+	return nil, repository.ErrInsufficientScope
+}
+
+// bearerToken - функция, возвращающая токен из заголовка `Authorization: Bearer`.
+func bearerToken(r *http.Request) string {
+	return strings.TrimSpace(r.Header.Get("Authorization")[7:])
 }
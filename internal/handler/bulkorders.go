@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/upload"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+// maxOrderUploadBytes - наибольший размер тела пакетной загрузки заказов.
+const maxOrderUploadBytes = 8 << 20
+
+// PostOrdersBulk - обработчик пакетной загрузки номеров заказов в JSON, тексте или CSV. Небольшая загрузка
+// обрабатывается сразу, для крупной создается задание, адрес которого передается в заголовке Location.
+func (c *Controller) PostOrdersBulk(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r, entity.ScopeOrdersWrite)
+	if err != nil {
+		return
+	}
+	reqBody, err := io.ReadAll(io.LimitReader(r.Body, maxOrderUploadBytes+1))
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to read request body - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	if len(reqBody) > maxOrderUploadBytes {
+		err = fmt.Errorf("%w - body exceeds %d bytes", repository.ErrOrderUploadTooLarge, maxOrderUploadBytes)
+		c.error(w, r, err, http.StatusRequestEntityTooLarge)
+		return
+	}
+	lines, err := upload.Parse(r.Header.Get("Content-Type"), reqBody)
+	if errors.Is(err, upload.ErrUnsupportedFormat) {
+		err = fmt.Errorf("wrong content type, %s, %s or %s needed", upload.FormatJSON, upload.FormatText, upload.FormatCSV)
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to parse upload - %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	u, err := c.Storage.UploadOrders(st.UserID, lines)
+	if err != nil {
+		switch {
+		case errors.Is(err, validate.ErrValidation):
+			c.error(w, r, err, http.StatusBadRequest)
+		case errors.Is(err, repository.ErrOrderUploadTooLarge):
+			c.error(w, r, err, http.StatusRequestEntityTooLarge)
+		default:
+			c.error(w, r, fmt.Errorf("failed to upload orders - %s", err.Error()), http.StatusInternalServerError)
+		}
+		return
+	}
+	if u.Status != entity.UploadDone {
+		w.Header().Set("Location", fmt.Sprintf("%s/%d", r.URL.Path, u.ID))
+		c.writeJSON(w, r, http.StatusAccepted, u)
+		c.log(r, fmt.Sprintf("order upload %d with %d lines has been queued", u.ID, u.Total))
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, u)
+	c.log(r, fmt.Sprintf("order upload with %d lines has been processed", u.Total))
+}
+
+// GetOrdersBulk - обработчик, возвращающий состояние задания пакетной загрузки заказов и результаты обработанных строк.
+func (c *Controller) GetOrdersBulk(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r, entity.ScopeOrdersWrite)
+	if err != nil {
+		return
+	}
+	id, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("invalid upload ID - %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	u, err := c.Storage.GetOrderUpload(st.UserID, id)
+	if errors.Is(err, repository.ErrOrderUploadNotFound) {
+		c.error(w, r, repository.ErrOrderUploadNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get order upload - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, u)
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+const sessionCookieName = "session_token"
+
+// setSessionCookie - метод, выставляющий cookie сессии с атрибутами из конфига.
+func (c *Controller) setSessionCookie(w http.ResponseWriter, session *entity.Session) {
+	cookie := c.sessionCookie()
+	cookie.Value = session.Token
+	cookie.Expires = session.Expiry
+	http.SetCookie(w, cookie)
+}
+
+// clearSessionCookie - метод, удаляющий cookie сессии в браузере.
+func (c *Controller) clearSessionCookie(w http.ResponseWriter) {
+	cookie := c.sessionCookie()
+	cookie.MaxAge = -1
+	http.SetCookie(w, cookie)
+}
+
+// sessionCookie - метод, возвращающий заготовку cookie сессии без значения.
+func (c *Controller) sessionCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     c.conf.CookiePath,
+		Domain:   c.conf.CookieDomain,
+		Secure:   c.conf.CookieSecure || c.conf.TLSEnabled(),
+		HttpOnly: true,
+		SameSite: parseSameSite(c.conf.CookieSameSite),
+	}
+}
+
+// parseSameSite - функция, переводящая значение атрибута SameSite из конфига в тип net/http.
+func parseSameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	default:
+		return http.SameSiteDefaultMode
+	}
+}
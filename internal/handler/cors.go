@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/cors"
+)
+
+// corsHandler - метод, возвращающий middleware CORS для браузерных клиентов с других origin.
+// Если список разрешенных origin пуст, CORS-заголовки не выставляются.
+func (c *Controller) corsHandler() func(http.Handler) http.Handler {
+	origins := splitList(c.conf.CORSAllowedOrigins)
+	if len(origins) == 0 {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+	return cors.Handler(cors.Options{
+		AllowedOrigins:   origins,
+		AllowedMethods:   splitList(c.conf.CORSAllowedMethods),
+		AllowedHeaders:   splitList(c.conf.CORSAllowedHeaders),
+		ExposedHeaders:   []string{"Location", "Retry-After", idempotentReplayedHeader},
+		AllowCredentials: c.conf.CORSAllowCredentials,
+		MaxAge:           int(c.conf.CORSMaxAge.Seconds()),
+	})
+}
+
+// isCORSOrigin - метод, проверяющий, что origin явно разрешен для запросов с cookie через CORS.
+func (c *Controller) isCORSOrigin(origin string) bool {
+	if !c.conf.CORSAllowCredentials {
+		return false
+	}
+	for _, allowed := range splitList(c.conf.CORSAllowedOrigins) {
+		if !strings.Contains(allowed, "*") && strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+)
+
+// corsConfig - функция, возвращающая настройки CORS для тестов с разрешенными origins.
+func corsConfig(origins string, credentials bool) *config.Config {
+	return &config.Config{
+		CORSAllowedOrigins:   origins,
+		CORSAllowedMethods:   "GET,POST,DELETE",
+		CORSAllowedHeaders:   "Accept,Authorization,Content-Type",
+		CORSAllowCredentials: credentials,
+		CORSMaxAge:           10 * time.Minute,
+	}
+}
+
+func TestCORSHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        *config.Config
+		method      string
+		origin      string
+		wantOrigin  string
+		wantHeaders map[string]string
+	}{
+		{
+			name:   "Disabled",
+			conf:   corsConfig("", false),
+			method: http.MethodOptions,
+			origin: "https://app.example",
+		},
+		{
+			name:       "Preflight from allowed origin",
+			conf:       corsConfig("https://app.example", true),
+			method:     http.MethodOptions,
+			origin:     "https://app.example",
+			wantOrigin: "https://app.example",
+			wantHeaders: map[string]string{
+				"Access-Control-Allow-Methods":     "DELETE",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Max-Age":           "600",
+			},
+		},
+		{
+			name:   "Preflight from other origin",
+			conf:   corsConfig("https://app.example", true),
+			method: http.MethodOptions,
+			origin: "https://evil.example",
+		},
+		{
+			name:       "Simple request",
+			conf:       corsConfig("https://*.example", false),
+			method:     http.MethodGet,
+			origin:     "https://app.example",
+			wantOrigin: "https://app.example",
+			wantHeaders: map[string]string{
+				"Access-Control-Expose-Headers": "Location, Retry-After, " + idempotentReplayedHeader,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(tt.conf, "")
+			h := c.corsHandler()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			r := httptest.NewRequest(tt.method, "/api/user/orders", nil)
+			r.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				r.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			require.Equal(t, tt.wantOrigin, w.Header().Get("Access-Control-Allow-Origin"))
+			for k, v := range tt.wantHeaders {
+				require.Equal(t, v, w.Header().Get(k), k)
+			}
+		})
+	}
+}
+
+func TestIsCORSOrigin(t *testing.T) {
+	c := newTestController(corsConfig("https://App.example/, https://*.example", true), "")
+	require.True(t, c.isCORSOrigin("https://app.example"))
+	// Шаблоны не делают origin доверенным для запросов с cookie.
+	require.False(t, c.isCORSOrigin("https://other.example"))
+	require.False(t, c.isCORSOrigin("https://evil.example.com"))
+
+	c = newTestController(corsConfig("https://app.example", false), "")
+	require.False(t, c.isCORSOrigin("https://app.example"))
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+var ErrCSRFDetected = errors.New("cross-site request rejected")
+
+// csrf - middleware, отклоняющий изменяющие запросы с cookie-сессией, пришедшие с чужого origin.
+// Клиенты, аутентифицированные заголовком `Authorization: Bearer`, не используют cookie и проверку не проходят.
+// Одного наличия заголовка мало: с недействительным токеном хэндлеры без аутентификации, например вход,
+// использовали бы cookie межсайтового запроса.
+func (c *Controller) csrf(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.conf.CSRFProtection || isSafeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, err := r.Cookie(sessionCookieName); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if hasBearer(r) && c.bearerAuthenticates(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !c.isTrustedOrigin(r) {
+			c.error(w, r, ErrCSRFDetected, http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isTrustedOrigin - метод, проверяющий источник запроса по заголовкам Origin, Referer и Sec-Fetch-Site.
+func (c *Controller) isTrustedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		if ref, err := url.Parse(r.Referer()); err == nil && ref.Host != "" {
+			origin = ref.Scheme + "://" + ref.Host
+		}
+	}
+	if origin == "" {
+		// Браузеры всегда присылают Origin на межсайтовые POST, его отсутствие означает не браузерного клиента.
+		return r.Header.Get("Sec-Fetch-Site") != "cross-site"
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) || c.isCORSOrigin(origin) {
+		return true
+	}
+	for _, trusted := range splitList(c.conf.CSRFTrustedOrigins) {
+		if strings.EqualFold(strings.TrimSuffix(trusted, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// isSafeMethod - функция, проверяющая, что метод не изменяет состояние.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// hasBearer - функция, проверяющая наличие токена в заголовке Authorization.
+func hasBearer(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	return len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ")
+}
+
+// bearerAuthenticates - метод, проверяющий, что access-токен или API-ключ из заголовка Authorization действителен.
+func (c *Controller) bearerAuthenticates(r *http.Request) bool {
+	token := bearerToken(r)
+	if repository.IsAPIKey(token) {
+		_, err := c.Storage.AuthAPIKey(token)
+		return err == nil
+	}
+	_, err := c.Storage.ParseAccessToken(token)
+	return err == nil
+}
+
+// splitList - функция, разбирающая список значений через запятую из конфига.
+func splitList(s string) []string {
+	list := make([]string, 0)
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
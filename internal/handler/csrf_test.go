@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func TestCSRF(t *testing.T) {
+	tests := []struct {
+		name     string
+		disabled bool
+		method   string
+		cookie   bool
+		bearer   string
+		headers  map[string]string
+		want     int
+	}{
+		{
+			name:     "Protection disabled",
+			disabled: true,
+			method:   http.MethodPost,
+			cookie:   true,
+			headers:  map[string]string{"Origin": "https://evil.example"},
+			want:     http.StatusNoContent,
+		},
+		{
+			name:    "Safe method",
+			method:  http.MethodGet,
+			cookie:  true,
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "No session cookie",
+			method:  http.MethodPost,
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "Cross-site origin",
+			method:  http.MethodPost,
+			cookie:  true,
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "Same origin",
+			method:  http.MethodPost,
+			cookie:  true,
+			headers: map[string]string{"Origin": "https://shop.example"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "Trusted origin",
+			method:  http.MethodDelete,
+			cookie:  true,
+			headers: map[string]string{"Origin": "https://app.example"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "Cross-site referer",
+			method:  http.MethodPost,
+			cookie:  true,
+			headers: map[string]string{"Referer": "https://evil.example/page"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:    "Cross-site fetch without origin",
+			method:  http.MethodPost,
+			cookie:  true,
+			headers: map[string]string{"Sec-Fetch-Site": "cross-site"},
+			want:    http.StatusForbidden,
+		},
+		{
+			name:   "Non-browser client",
+			method: http.MethodPost,
+			cookie: true,
+			want:   http.StatusNoContent,
+		},
+		{
+			name:    "Valid access token",
+			method:  http.MethodPost,
+			cookie:  true,
+			bearer:  testAccessToken,
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "Valid API key",
+			method:  http.MethodPost,
+			cookie:  true,
+			bearer:  testAPIKey,
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusNoContent,
+		},
+		{
+			name:    "Invalid bearer token",
+			method:  http.MethodPost,
+			cookie:  true,
+			bearer:  "forged",
+			headers: map[string]string{"Origin": "https://evil.example"},
+			want:    http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(&config.Config{
+				CSRFProtection:     !tt.disabled,
+				CSRFTrustedOrigins: "https://app.example/",
+			}, entity.RoleUser)
+			h := c.csrf(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			r := httptest.NewRequest(tt.method, "https://shop.example/api/user/login", nil)
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "session"})
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			require.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -2,37 +2,66 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
 )
 
 // error - обработчик-хелпер, пишущий ошибки.
 func (c *Controller) error(w http.ResponseWriter, r *http.Request, err error, statusCode int) {
 	reqID := middleware.GetReqID(r.Context())
-	type errorJSON struct {
-		Error      string
-		StatusCode int
-	}
-	e := errorJSON{
-		Error:      err.Error(),
-		StatusCode: statusCode,
-	}
 	prefix := "[ERROR]"
 	if reqID != "" {
 		prefix = fmt.Sprintf("[%s] [ERROR]", reqID)
 	}
-	b, errMarshal := json.Marshal(e)
+	var body interface{}
+	contentType := ContentTypeApplicationJSON
+	if c.problems {
+		p := newProblem(r, err, statusCode)
+		p.RequestID = reqID
+		body = p
+		contentType = ContentTypeApplicationProblemJSON
+	} else {
+		type errorJSON struct {
+			Error      string
+			StatusCode int
+		}
+		body = errorJSON{
+			Error:      err.Error(),
+			StatusCode: statusCode,
+		}
+	}
+	b, errMarshal := json.Marshal(body)
 	if errMarshal != nil {
 		msg := fmt.Sprintf("Failed to marshal error - %s, StatusCode: 500", err.Error())
 		w.Write([]byte(msg))
 		log.Info().Str(prefix, msg)
 		return
 	}
-	w.Header().Set("Content-Type", ContentTypeApplicationJSON)
+	w.Header().Set("Content-Type", contentType)
 	w.WriteHeader(statusCode)
 	w.Write(b)
 	log.Info().Str(prefix, string(b))
 }
+
+// tooManyRequests - метод, отвечающий 429 с заголовком Retry-After, если ошибка хранилища этого требует.
+func (c *Controller) tooManyRequests(w http.ResponseWriter, r *http.Request, err error) bool {
+	var retry *repository.RetryAfterError
+	if !errors.As(err, &retry) {
+		return false
+	}
+	seconds := int(math.Ceil(retry.RetryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	c.error(w, r, err, http.StatusTooManyRequests)
+	return true
+}
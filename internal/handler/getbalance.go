@@ -4,11 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
 )
 
 // GetBalance - обработчик, обрабатывающий запрос на проверку баланса пользователя в системе.
 func (c *Controller) GetBalance(w http.ResponseWriter, r *http.Request) {
-	st, err := c.auth(w, r)
+	st, err := c.auth(w, r, entity.ScopeBalanceRead)
 	if err != nil {
 		return
 	}
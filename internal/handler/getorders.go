@@ -24,6 +24,10 @@ func (c *Controller) GetOrders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if len(ordersX) == 0 {
+		if c.problems {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
 		c.error(w, r, fmt.Errorf("orders not found for this user"), http.StatusNoContent)
 		return
 	}
@@ -24,6 +24,10 @@ func (c *Controller) GetWithdrawals(w http.ResponseWriter, r *http.Request) {
 	wdx, err := c.Storage.GetWithdrawals(u.ID)
 	if err != nil {
 		if errors.Is(err, repository.ErrNoContent) {
+			if c.problems {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
 			c.error(w, r, repository.ErrNoContent, http.StatusNoContent)
 			return
 		}
@@ -1,55 +1,167 @@
 package handler
 
 import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
 	"net/http"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/v5/middleware"
 
+	"github.com/gtgaleevtimur/gofermart/internal/config"
 	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/openapi"
 )
 
 const (
 	ContentTypeApplicationJSON = "application/json"
 	ContentTypeTextPlain       = "text/plain"
+	ContentTypeApplicationZip  = "application/zip"
 )
 
 // NewRouter - функция инициализирующая и настраивающая роутер сервиса.
-func NewRouter(r entity.Storager) chi.Router {
+func NewRouter(r entity.Storager, conf *config.Config) chi.Router {
 	router := chi.NewRouter()
-	controller := newController(r)
+	controller := newController(r, conf)
+	controllerV2 := controller.withProblems()
 	router.Use(middleware.Compress(3, "gzip"))
 	router.Use(middleware.RequestID)
-	router.Use(middleware.RealIP)
+	router.Use(controller.realIP)
 	router.Use(middleware.Logger)
 	router.Use(middleware.Recoverer)
+	router.Use(controller.corsHandler())
 
-	router.Route("/api/user", func(rout chi.Router) {
-		rout.Post("/register", controller.Register)
-		rout.Post("/login", controller.Login)
+	router.Get("/api/openapi.json", controller.OpenAPI)
+	// Метрики раскрывают внутреннее состояние сервиса, поэтому доступны только администраторам.
+	router.With(controllerV2.requireRole(entity.RoleAdmin)).Get("/debug/vars", controller.Metrics)
 
-		rout.Post("/orders", controller.PostOrders)
-		rout.Get("/orders", controller.GetOrders)
+	// Исходная версия API с прежними форматом ошибок и кодами ответов.
+	router.Route("/api/user", controller.userRoutes)
 
-		rout.Get("/balance", controller.GetBalance)
-
-		rout.Post("/balance/withdraw", controller.PostWithdraw)
-		rout.Get("/withdrawals", controller.GetWithdrawals)
+	// Версия API с ошибками в формате RFC 7807 и исправленными кодами ответов.
+	router.Route("/api/v2", func(rout chi.Router) {
+		rout.Route("/user", controllerV2.userRoutes)
+		rout.NotFound(controllerV2.notFound)
+		rout.MethodNotAllowed(controllerV2.notAllowed(router))
 	})
 
+	// API для сотрудников с проверкой роли, ошибки в формате RFC 7807.
+	router.Route("/api/admin", controllerV2.adminRoutes)
+
 	router.NotFound(NotFound())
 	router.MethodNotAllowed(NotAllowed())
 
 	return router
 }
 
+// userRoutes - метод, регистрирующий маршруты пользователя.
+func (c *Controller) userRoutes(rout chi.Router) {
+	rout.Use(c.validate)
+	rout.Use(c.csrf)
+
+	rout.Post("/register", c.Register)
+	rout.Post("/login", c.Login)
+	rout.Post("/login/2fa", c.CompleteLogin)
+	rout.Post("/logout", c.Logout)
+
+	rout.Post("/password", c.ChangePassword)
+	rout.Post("/password/reset", c.RequestPasswordReset)
+	rout.Post("/password/reset/confirm", c.ResetPassword)
+	rout.Put("/email", c.ChangeEmail)
+
+	rout.Get("/export", c.ExportUser)
+	rout.Delete("/", c.DeleteAccount)
+
+	rout.Get("/2fa", c.GetMFA)
+	rout.Post("/2fa/totp", c.EnrollTOTP)
+	rout.Post("/2fa/totp/verify", c.ConfirmTOTP)
+	rout.Post("/2fa/totp/disable", c.DisableTOTP)
+	rout.Post("/2fa/recovery-codes", c.RegenerateRecoveryCodes)
+
+	rout.Post("/token", c.IssueToken)
+	rout.Post("/token/2fa", c.CompleteToken)
+	rout.Post("/token/refresh", c.RefreshToken)
+	rout.Post("/token/revoke", c.RevokeToken)
+
+	rout.Get("/sessions", c.GetSessions)
+	rout.Delete("/sessions", c.DeleteSessions)
+	rout.Delete("/sessions/{id}", c.DeleteSession)
+
+	rout.Get("/api-keys", c.GetAPIKeys)
+	rout.Post("/api-keys", c.CreateAPIKey)
+	rout.Delete("/api-keys/{id}", c.RevokeAPIKey)
+
+	rout.Post("/orders", c.PostOrders)
+	rout.Get("/orders", c.GetOrders)
+	rout.Post("/orders/bulk", c.PostOrdersBulk)
+	rout.Get("/orders/bulk/{id}", c.GetOrdersBulk)
+
+	rout.Get("/balance", c.GetBalance)
+
+	rout.Post("/balance/withdraw", c.PostWithdraw)
+	rout.Get("/withdrawals", c.GetWithdrawals)
+	rout.Get("/balance/adjustments", c.GetAdjustments)
+	rout.Get("/balance/expirations", c.GetExpirations)
+	rout.Post("/balance/transfer", c.PostTransfer)
+	rout.Get("/balance/transfers", c.GetTransfers)
+	rout.Get("/tier", c.GetTier)
+	rout.Get("/referrals", c.GetReferrals)
+}
+
 type Controller struct {
-	Storage entity.Storager
+	Storage        entity.Storager
+	conf           *config.Config
+	validator      *validator
+	trustedProxies []*net.IPNet
+	problems       bool
 }
 
 // newController - функция-конструктор контролера хэндлера.
-func newController(s entity.Storager) *Controller {
-	return &Controller{Storage: s}
+func newController(s entity.Storager, conf *config.Config) *Controller {
+	v, err := newValidator(openapi.MustLoad(), conf.OpenAPIValidateResponses)
+	if err != nil {
+		panic(err)
+	}
+	proxies, err := parseTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		panic(err)
+	}
+	return &Controller{
+		Storage:        s,
+		conf:           conf,
+		validator:      v,
+		trustedProxies: proxies,
+	}
+}
+
+// withProblems - метод, возвращающий копию контроллера, пишущую ошибки в формате RFC 7807.
+func (c *Controller) withProblems() *Controller {
+	v2 := *c
+	v2.problems = true
+	return &v2
+}
+
+// hasContentType - функция, проверяющая медиа-тип тела запроса без учета параметров вроде charset.
+func hasContentType(r *http.Request, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == contentType
+}
+
+// writeJSON - метод, отдающий тело ответа в JSON с указанным кодом.
+func (c *Controller) writeJSON(w http.ResponseWriter, r *http.Request, statusCode int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to marshal JSON - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeApplicationJSON)
+	w.WriteHeader(statusCode)
+	w.Write(body)
 }
 
 // NotFound - обработчик неподдерживаемых маршрутов.
@@ -67,3 +179,17 @@ func NotAllowed() http.HandlerFunc {
 		w.Write([]byte("method does not allowed"))
 	}
 }
+
+// clientIP - функция, возвращающая IP клиента без порта (после middleware realIP).
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestID - функция, возвращающая ID запроса, выданный middleware.RequestID.
+func requestID(r *http.Request) string {
+	return middleware.GetReqID(r.Context())
+}
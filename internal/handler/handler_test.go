@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+// Учетные данные, которые принимает fakeStorage.
+const (
+	testAccessToken = "access.token"
+	testAPIKey      = "gmk_test"
+)
+
+// fakeStorage - хранилище для тестов хэндлеров: принимает testAccessToken и testAPIKey пользователя users[1],
+// отдает пользователей из users, а у пользователя нет заказов и списаний. PostOrders возвращает err.
+// Остальные методы не реализованы.
+type fakeStorage struct {
+	entity.Storager
+	users map[uint64]*entity.User
+	err   error
+}
+
+func (s *fakeStorage) ParseAccessToken(accessToken string) (*entity.AccessClaims, error) {
+	if accessToken != testAccessToken {
+		return nil, repository.ErrAccessTokenInvalid
+	}
+	return &entity.AccessClaims{UserID: 1, Expiry: time.Now().Add(time.Minute)}, nil
+}
+
+func (s *fakeStorage) AuthAPIKey(key string) (*entity.APIKey, error) {
+	if key != testAPIKey {
+		return nil, repository.ErrAPIKeyInvalid
+	}
+	return &entity.APIKey{UserID: 1, Scopes: []string{entity.ScopeWithdraw}}, nil
+}
+
+func (s *fakeStorage) GetUser(byKey interface{}) (*entity.User, error) {
+	id, ok := byKey.(uint64)
+	if !ok || s.users[id] == nil {
+		return nil, repository.ErrUserNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *fakeStorage) PostOrders(orderID, userID uint64) error {
+	return s.err
+}
+
+func (s *fakeStorage) GetOrders(userID uint64) ([]*entity.OrderX, error) {
+	return nil, nil
+}
+
+func (s *fakeStorage) GetWithdrawals(userID uint64) ([]entity.WithdrawX, error) {
+	return nil, repository.ErrNoContent
+}
+
+// newTestStorage - функция, создающая fakeStorage с пользователем 1 с ролью role.
+func newTestStorage(role string) *fakeStorage {
+	return &fakeStorage{users: map[uint64]*entity.User{1: {ID: 1, Login: "user", Role: role}}}
+}
+
+// newTestController - функция, создающая контроллер с newTestStorage.
+func newTestController(conf *config.Config, role string) *Controller {
+	return newController(newTestStorage(role), conf)
+}
+
+// errorV1 - тело ошибки исходной версии API.
+type errorV1 struct {
+	Error      string
+	StatusCode int
+}
+
+func TestProblemResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		bearer      string
+		err         error
+		// v1 - ответ /api/user, errV1 - ошибка в его теле, пустая для ответа без тела JSON.
+		v1    int
+		errV1 error
+		// v2 - ответ /api/v2/user, code - код проблемы, пустой для ответа без тела.
+		v2   int
+		code string
+	}{
+		{
+			name: "Anonymous", method: http.MethodGet, path: "/orders",
+			v1: http.StatusUnauthorized, errV1: repository.ErrUnauthorizedAccess,
+			v2: http.StatusUnauthorized, code: "unauthorized",
+		},
+		{
+			name: "Forged access token", method: http.MethodGet, path: "/orders", bearer: "forged",
+			v1: http.StatusUnauthorized, errV1: repository.ErrAccessTokenInvalid,
+			v2: http.StatusUnauthorized, code: "invalid_access_token",
+		},
+		{
+			name: "No orders", method: http.MethodGet, path: "/orders", bearer: testAccessToken,
+			v1: http.StatusNoContent, v2: http.StatusNoContent,
+		},
+		{
+			name: "No withdrawals", method: http.MethodGet, path: "/withdrawals", bearer: testAccessToken,
+			v1: http.StatusNoContent, errV1: repository.ErrNoContent, v2: http.StatusNoContent,
+		},
+		{
+			name: "Order of another user", method: http.MethodPost, path: "/orders", contentType: ContentTypeTextPlain,
+			body: "2377225624", bearer: testAccessToken, err: repository.ErrOrderAlreadyLoadedByAnotherUser,
+			v1: http.StatusConflict, errV1: repository.ErrOrderAlreadyLoadedByAnotherUser,
+			v2: http.StatusConflict, code: "order_uploaded_by_another_user",
+		},
+		{
+			name: "Invalid order number", method: http.MethodPost, path: "/orders", contentType: ContentTypeTextPlain,
+			body: "2377225625", bearer: testAccessToken, err: repository.ErrOrderInvalidFormat,
+			v1: http.StatusUnprocessableEntity, errV1: repository.ErrOrderInvalidFormat,
+			v2: http.StatusUnprocessableEntity, code: "invalid_order_number",
+		},
+		{
+			name: "Request not matching specification", method: http.MethodPost, path: "/balance/withdraw",
+			contentType: ContentTypeApplicationJSON, body: `{"order": 1}`, bearer: testAccessToken,
+			v1: http.StatusBadRequest, errV1: ErrInvalidRequest,
+			v2: http.StatusBadRequest, code: "invalid_request",
+		},
+		// Исходная версия отвечает на неизвестный маршрут и метод 400 без JSON.
+		{name: "Unknown route", method: http.MethodGet, path: "/unknown", v1: http.StatusBadRequest, v2: http.StatusNotFound, code: "route_not_found"},
+		{name: "Method not allowed", method: http.MethodDelete, path: "/balance", v1: http.StatusBadRequest, v2: http.StatusMethodNotAllowed, code: "method_not_allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newTestStorage(entity.RoleUser)
+			storage.err = tt.err
+			router := NewRouter(storage, &config.Config{})
+			do := func(prefix string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(tt.method, prefix+tt.path, strings.NewReader(tt.body))
+				if tt.contentType != "" {
+					r.Header.Set("Content-Type", tt.contentType)
+				}
+				if tt.bearer != "" {
+					r.Header.Set("Authorization", "Bearer "+tt.bearer)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+				return w
+			}
+
+			w := do("/api/user")
+			require.Equal(t, tt.v1, w.Code, w.Body.String())
+			if tt.errV1 != nil {
+				require.Equal(t, ContentTypeApplicationJSON, w.Header().Get("Content-Type"))
+				var got errorV1
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+				require.Contains(t, got.Error, tt.errV1.Error())
+				require.Equal(t, tt.v1, got.StatusCode)
+			}
+
+			w = do("/api/v2/user")
+			require.Equal(t, tt.v2, w.Code, w.Body.String())
+			if tt.code == "" {
+				require.Empty(t, w.Body.String())
+				return
+			}
+			require.Equal(t, ContentTypeApplicationProblemJSON, w.Header().Get("Content-Type"))
+			var p Problem
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &p))
+			require.Equal(t, tt.code, p.Code)
+			require.Equal(t, problemTypePrefix+tt.code, p.Type)
+			require.Equal(t, tt.v2, p.Status)
+			require.NotEmpty(t, p.Title)
+			require.Equal(t, "/api/v2/user"+tt.path, p.Instance)
+			require.NotEmpty(t, p.RequestID)
+			if tt.v2 == http.StatusMethodNotAllowed {
+				require.Equal(t, http.MethodGet, w.Header().Get("Allow"))
+			}
+		})
+	}
+}
+
+func TestProblemHidesInternalErrors(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/v2/user/orders", nil)
+	p := newProblem(r, errors.New("pq: connection refused"), http.StatusInternalServerError)
+	require.Equal(t, "internal_server_error", p.Code)
+	require.Equal(t, http.StatusText(http.StatusInternalServerError), p.Detail)
+
+	p = newProblem(r, validate.Errors{{Field: "sum", Code: "invalid_value", Message: "sum must be positive"}}, http.StatusBadRequest)
+	require.Equal(t, "validation_failed", p.Code)
+	require.Len(t, p.Errors, 1)
+	require.Equal(t, "sum", p.Errors[0].Field)
+}
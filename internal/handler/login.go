@@ -18,17 +18,35 @@ func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
 		c.error(w, r, err, http.StatusBadRequest)
 		return
 	}
+	if creds == nil {
+		c.error(w, r, fmt.Errorf("empty credentials"), http.StatusBadRequest)
+		return
+	}
+	creds.IP = clientIP(r)
+	creds.UserAgent = r.UserAgent()
+	creds.RequestID = requestID(r)
 	var sessionToken string
-	st, err := r.Cookie("session_token")
+	st, err := r.Cookie(sessionCookieName)
 	if err == nil {
 		sessionToken = st.Value
 	}
 	session, err := c.Storage.Login(creds, sessionToken)
 	if err != nil {
+		if c.tooManyRequests(w, r, err) || c.mfaRequired(w, r, err) {
+			return
+		}
 		if errors.Is(err, repository.ErrInvalidPair) || errors.Is(err, repository.ErrUserNotFound) {
 			c.error(w, r, repository.ErrInvalidPair, http.StatusUnauthorized)
 			return
 		}
+		if errors.Is(err, repository.ErrInvalidMFACode) {
+			c.error(w, r, err, http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, repository.ErrAccountDisabled) {
+			c.error(w, r, err, http.StatusForbidden)
+			return
+		}
 		c.error(w, r, err, http.StatusInternalServerError)
 		return
 	}
@@ -36,11 +54,7 @@ func (c *Controller) Login(w http.ResponseWriter, r *http.Request) {
 		c.error(w, r, fmt.Errorf("got nil session"), http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   session.Token,
-		Expires: session.Expiry,
-	})
+	c.setSessionCookie(w, session)
 	msg := fmt.Sprintf("session for user `%s` successfully created", creds.Login)
 	c.log(r, msg)
 }
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Logout - обработчик завершения текущей сессии пользователя.
+// Для access-токена отзывается его семейство refresh-токенов.
+func (c *Controller) Logout(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	if st.TokenFamily != "" {
+		err = c.Storage.RevokeTokenFamily(st.TokenFamily)
+		if err != nil {
+			c.error(w, r, fmt.Errorf("failed to revoke tokens - %s", err.Error()), http.StatusInternalServerError)
+			return
+		}
+		c.log(r, fmt.Sprintf("tokens of user %d have been revoked", st.UserID))
+		return
+	}
+	err = c.Storage.DeleteSession(st.Token)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to delete session - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.clearSessionCookie(w)
+	c.log(r, fmt.Sprintf("session %d has been closed", st.ID))
+}
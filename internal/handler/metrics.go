@@ -0,0 +1,26 @@
+package handler
+
+import (
+	"expvar"
+	"fmt"
+	"net/http"
+)
+
+// Metrics - обработчик, отдающий метрики expvar в JSON.
+// В отличие от expvar.Handler не отдает cmdline: в аргументах запуска может быть строка подключения к БД.
+func (c *Controller) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentTypeApplicationJSON)
+	fmt.Fprint(w, "{")
+	first := true
+	expvar.Do(func(kv expvar.KeyValue) {
+		if kv.Key == "cmdline" {
+			return
+		}
+		if !first {
+			fmt.Fprint(w, ",")
+		}
+		first = false
+		fmt.Fprintf(w, "%q:%s", kv.Key, kv.Value)
+	})
+	fmt.Fprint(w, "}")
+}
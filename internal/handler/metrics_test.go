@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func TestMetricsRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		bearer string
+		want   int
+	}{
+		{name: "Anonymous", role: entity.RoleAdmin, want: http.StatusUnauthorized},
+		{name: "Support", role: entity.RoleSupport, bearer: testAccessToken, want: http.StatusForbidden},
+		{name: "Admin", role: entity.RoleAdmin, bearer: testAccessToken, want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewRouter(newTestStorage(tt.role), &config.Config{})
+			r := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tt.want, w.Code)
+			if tt.want == http.StatusOK {
+				require.Contains(t, w.Body.String(), `"memstats"`)
+				require.NotContains(t, w.Body.String(), `"cmdline"`)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+// totpHeader - заголовок со свежим кодом TOTP для операций, требующих повторного подтверждения.
+const totpHeader = "X-TOTP"
+
+type mfaCompleteRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type mfaDisableRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type recoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaRequired - метод, отвечающий 202 с challenge второго фактора, если вход требует его.
+func (c *Controller) mfaRequired(w http.ResponseWriter, r *http.Request, err error) bool {
+	var mfa *repository.MFARequiredError
+	if !errors.As(err, &mfa) {
+		return false
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	c.writeJSON(w, r, http.StatusAccepted, mfa.Challenge)
+	c.log(r, "second factor required")
+	return true
+}
+
+// mfaError - метод, отвечающий на ошибку входа со вторым фактором.
+func (c *Controller) mfaError(w http.ResponseWriter, r *http.Request, err error) {
+	if c.tooManyRequests(w, r, err) {
+		return
+	}
+	if errors.Is(err, repository.ErrMFAChallengeInvalid) || errors.Is(err, repository.ErrInvalidMFACode) {
+		c.error(w, r, err, http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, repository.ErrAccountDisabled) {
+		c.error(w, r, err, http.StatusForbidden)
+		return
+	}
+	c.error(w, r, fmt.Errorf("failed to complete login - %s", err.Error()), http.StatusInternalServerError)
+}
+
+// decodeMFAComplete - метод, читающий токен challenge и код второго фактора.
+func (c *Controller) decodeMFAComplete(w http.ResponseWriter, r *http.Request) (*mfaCompleteRequest, bool) {
+	var req mfaCompleteRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return nil, false
+	}
+	if req.MFAToken == "" || req.Code == "" {
+		c.error(w, r, fmt.Errorf("empty mfa token or code"), http.StatusBadRequest)
+		return nil, false
+	}
+	return &req, true
+}
+
+// CompleteLogin - обработчик второго шага входа: код TOTP или код восстановления в обмен на cookie сессии.
+func (c *Controller) CompleteLogin(w http.ResponseWriter, r *http.Request) {
+	req, ok := c.decodeMFAComplete(w, r)
+	if !ok {
+		return
+	}
+	var sessionToken string
+	st, err := r.Cookie(sessionCookieName)
+	if err == nil {
+		sessionToken = st.Value
+	}
+	session, err := c.Storage.CompleteLogin(req.MFAToken, req.Code, &entity.AccountInfo{
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: requestID(r),
+	}, sessionToken)
+	if err != nil {
+		c.mfaError(w, r, err)
+		return
+	}
+	c.setSessionCookie(w, session)
+	c.log(r, fmt.Sprintf("session for user %d successfully created", session.UserID))
+}
+
+// CompleteToken - обработчик второго шага выдачи токенов: код TOTP или код восстановления в обмен на пару токенов.
+func (c *Controller) CompleteToken(w http.ResponseWriter, r *http.Request) {
+	req, ok := c.decodeMFAComplete(w, r)
+	if !ok {
+		return
+	}
+	pair, err := c.Storage.CompleteTokens(req.MFAToken, req.Code, &entity.AccountInfo{
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: requestID(r),
+	})
+	if err != nil {
+		c.mfaError(w, r, err)
+		return
+	}
+	c.writeTokenPair(w, r, pair)
+}
+
+// GetMFA - обработчик, возвращающий состояние второго фактора пользователя.
+func (c *Controller) GetMFA(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	status, err := c.Storage.GetMFAStatus(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get two-factor status - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, status)
+}
+
+// EnrollTOTP - обработчик, создающий секрет TOTP и otpauth:// URI для QR-кода.
+func (c *Controller) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	enrollment, err := c.Storage.EnrollTOTP(st.UserID)
+	if err != nil {
+		c.totpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	c.writeJSON(w, r, http.StatusOK, enrollment)
+}
+
+// ConfirmTOTP - обработчик, включающий TOTP по первому коду из приложения. Возвращает коды восстановления.
+func (c *Controller) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	var req mfaCodeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	codes, err := c.Storage.ConfirmTOTP(st.UserID, req.Code)
+	if err != nil {
+		c.totpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	c.writeJSON(w, r, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+	c.log(r, fmt.Sprintf("TOTP for user %d enabled", st.UserID))
+}
+
+// DisableTOTP - обработчик, отключающий TOTP по паролю и коду TOTP или коду восстановления.
+func (c *Controller) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	var req mfaDisableRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.DisableTOTP(st.UserID, req.Password, req.Code)
+	if err != nil {
+		c.totpError(w, r, err)
+		return
+	}
+	c.log(r, fmt.Sprintf("TOTP for user %d disabled", st.UserID))
+}
+
+// RegenerateRecoveryCodes - обработчик, заменяющий коды восстановления новыми по коду TOTP.
+func (c *Controller) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	var req mfaCodeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	codes, err := c.Storage.RegenerateRecoveryCodes(st.UserID, req.Code)
+	if err != nil {
+		c.totpError(w, r, err)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	c.writeJSON(w, r, http.StatusOK, recoveryCodesResponse{RecoveryCodes: codes})
+	c.log(r, fmt.Sprintf("recovery codes for user %d regenerated", st.UserID))
+}
+
+// totpError - метод, отвечающий на ошибку управления TOTP вошедшим пользователем.
+func (c *Controller) totpError(w http.ResponseWriter, r *http.Request, err error) {
+	if c.tooManyRequests(w, r, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, repository.ErrInvalidMFACode), errors.Is(err, repository.ErrInvalidPassword):
+		c.error(w, r, err, http.StatusForbidden)
+	case errors.Is(err, repository.ErrTOTPAlreadyEnabled), errors.Is(err, repository.ErrTOTPNotEnabled):
+		c.error(w, r, err, http.StatusConflict)
+	default:
+		c.error(w, r, fmt.Errorf("two-factor operation failed - %s", err.Error()), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,13 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/openapi"
+)
+
+// OpenAPI - обработчик, отдающий спецификацию HTTP API в формате OpenAPI 3.
+func (c *Controller) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", ContentTypeApplicationJSON)
+	w.Write(openapi.JSON())
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+type passwordChangeRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type emailChangeRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type passwordResetRequest struct {
+	Login string `json:"login"`
+}
+
+type passwordResetConfirm struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// ChangePassword - обработчик смены пароля вошедшим пользователем. Остальные сессии пользователя завершаются.
+func (c *Controller) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	var req passwordChangeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.ChangePassword(st.Session, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		c.credentialsError(w, r, fmt.Errorf("failed to change password - %w", err))
+		return
+	}
+	c.log(r, fmt.Sprintf("password of user %d has been changed", st.UserID))
+}
+
+// ChangeEmail - обработчик смены адреса почты для восстановления пароля. Требует текущий пароль.
+func (c *Controller) ChangeEmail(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	var req emailChangeRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.ChangeEmail(st.UserID, req.Password, req.Email)
+	if err != nil {
+		c.credentialsError(w, r, fmt.Errorf("failed to change email - %w", err))
+		return
+	}
+	c.log(r, fmt.Sprintf("email of user %d has been changed", st.UserID))
+}
+
+// credentialsError - метод, отвечающий на ошибку смены учетных данных вошедшим пользователем.
+func (c *Controller) credentialsError(w http.ResponseWriter, r *http.Request, err error) {
+	if c.tooManyRequests(w, r, err) {
+		return
+	}
+	switch {
+	case errors.Is(err, validate.ErrValidation):
+		c.error(w, r, err, http.StatusBadRequest)
+	case errors.Is(err, repository.ErrInvalidPassword):
+		c.error(w, r, repository.ErrInvalidPassword, http.StatusForbidden)
+	default:
+		c.error(w, r, err, http.StatusInternalServerError)
+	}
+}
+
+// RequestPasswordReset - обработчик запроса восстановления пароля: токен отправляется на почту пользователя.
+// Ответ всегда 202, чтобы не выдавать, существует ли логин.
+func (c *Controller) RequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var req passwordResetRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if req.Login == "" {
+		c.error(w, r, fmt.Errorf("empty login"), http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.RequestPasswordReset(&entity.AccountInfo{
+		Login:     req.Login,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		if c.tooManyRequests(w, r, err) {
+			return
+		}
+		c.error(w, r, fmt.Errorf("failed to request password reset - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	c.log(r, "password reset requested")
+}
+
+// ResetPassword - обработчик, устанавливающий новый пароль по токену восстановления. Все сессии пользователя завершаются.
+func (c *Controller) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req passwordResetConfirm
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if req.Token == "" {
+		c.error(w, r, repository.ErrResetTokenInvalid, http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.ResetPassword(req.Token, req.NewPassword)
+	if err != nil {
+		if errors.Is(err, validate.ErrValidation) || errors.Is(err, repository.ErrResetTokenInvalid) ||
+			errors.Is(err, repository.ErrResetTokenExpired) {
+			c.error(w, r, err, http.StatusBadRequest)
+			return
+		}
+		c.error(w, r, fmt.Errorf("failed to reset password - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.clearSessionCookie(w)
+	c.log(r, "password has been reset")
+}
@@ -7,19 +7,19 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
 	"github.com/gtgaleevtimur/gofermart/internal/repository"
 )
 
 // PostOrders - обработчик загрузки пользователем номера заказа для расчета баллов лояльности.
 func (c *Controller) PostOrders(w http.ResponseWriter, r *http.Request) {
 	var err error
-	ct := r.Header.Get("Content-Type")
-	if ct != ContentTypeTextPlain {
+	if !hasContentType(r, ContentTypeTextPlain) {
 		err = fmt.Errorf("wrong content type, %s needed", ContentTypeTextPlain)
 		c.error(w, r, err, http.StatusBadRequest)
 		return
 	}
-	st, err := c.auth(w, r)
+	st, err := c.auth(w, r, entity.ScopeOrdersWrite)
 	if err != nil {
 		return
 	}
@@ -36,7 +36,7 @@ func (c *Controller) PostOrders(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	orderID, err := strconv.Atoi(string(reqBody))
 	if err != nil {
-		c.error(w, r, fmt.Errorf("%s - %s", repository.ErrOrderInvalidFormat, err.Error()), http.StatusUnprocessableEntity)
+		c.error(w, r, fmt.Errorf("%w - %s", repository.ErrOrderInvalidFormat, err.Error()), http.StatusUnprocessableEntity)
 		return
 	}
 	err = c.Storage.PostOrders(uint64(orderID), u.ID)
@@ -14,13 +14,12 @@ import (
 // PostWithdraw - обработчик запроса на списание баллов с накопительного счета пользователя в счет оплаты нового заказа.
 func (c *Controller) PostWithdraw(w http.ResponseWriter, r *http.Request) {
 	var err error
-	ct := r.Header.Get("Content-Type")
-	if ct != ContentTypeApplicationJSON {
+	if !hasContentType(r, ContentTypeApplicationJSON) {
 		err = fmt.Errorf("wrong content type, %s needed", ContentTypeApplicationJSON)
 		c.error(w, r, err, http.StatusBadRequest)
 		return
 	}
-	st, err := c.auth(w, r)
+	st, err := c.auth(w, r, entity.ScopeWithdraw)
 	if err != nil {
 		return
 	}
@@ -42,8 +41,18 @@ func (c *Controller) PostWithdraw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	wd.UserID = u.ID
+	wd.OTP = r.Header.Get(totpHeader)
+	wd.IP = clientIP(r)
+	wd.RequestID = requestID(r)
 	err = c.Storage.PostWithdraw(wd)
 	if err != nil {
+		if c.tooManyRequests(w, r, err) {
+			return
+		}
+		if errors.Is(err, repository.ErrMFARequired) || errors.Is(err, repository.ErrInvalidMFACode) {
+			c.error(w, r, err, http.StatusForbidden)
+			return
+		}
 		if errors.Is(err, repository.ErrNotEnoughFunds) {
 			c.error(w, r, repository.ErrNotEnoughFunds, http.StatusPaymentRequired)
 			return
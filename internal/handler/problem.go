@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+const (
+	ContentTypeApplicationProblemJSON = "application/problem+json"
+
+	problemTypePrefix = "urn:gophermart:problem:"
+)
+
+// Problem - тело ошибки в формате RFC 7807.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"request_id,omitempty"`
+	// Errors - нарушения по полям запроса для ошибок проверки.
+	Errors []validate.FieldError `json:"errors,omitempty"`
+}
+
+type problemKind struct {
+	err   error
+	code  string
+	title string
+}
+
+// problemKinds - стабильные машиночитаемые коды для известных ошибок хранилища.
+var problemKinds = []problemKind{
+	{repository.ErrLoginAlreadyTaken, "login_already_taken", "Login already taken"},
+	{repository.ErrUserNotFound, "user_not_found", "User not found"},
+	{repository.ErrInvalidPair, "invalid_credentials", "Invalid login or password"},
+	{repository.ErrUnauthorizedAccess, "unauthorized", "Authentication required"},
+	{repository.ErrSessionNotFound, "session_not_found", "Session not found"},
+	{repository.ErrSessionExpired, "session_expired", "Session has expired"},
+	{repository.ErrTooManyLoginAttempts, "too_many_login_attempts", "Too many login attempts"},
+	{repository.ErrAccountLocked, "account_locked", "Account temporarily locked"},
+	{repository.ErrAccessTokenInvalid, "invalid_access_token", "Invalid access token"},
+	{repository.ErrAccessTokenExpired, "access_token_expired", "Access token has expired"},
+	{repository.ErrRefreshTokenInvalid, "invalid_refresh_token", "Invalid refresh token"},
+	{repository.ErrRefreshTokenExpired, "refresh_token_expired", "Refresh token has expired"},
+	{repository.ErrRefreshTokenReused, "refresh_token_reused", "Refresh token reuse detected"},
+	{repository.ErrInvalidPassword, "invalid_password", "Current password is incorrect"},
+	{repository.ErrResetTokenInvalid, "invalid_reset_token", "Invalid password reset token"},
+	{repository.ErrResetTokenExpired, "reset_token_expired", "Password reset token has expired"},
+	{repository.ErrMFARequired, "mfa_required", "Two-factor authentication code required"},
+	{repository.ErrInvalidMFACode, "invalid_mfa_code", "Invalid two-factor authentication code"},
+	{repository.ErrMFAChallengeInvalid, "invalid_mfa_challenge", "Invalid two-factor authentication challenge"},
+	{repository.ErrTOTPAlreadyEnabled, "totp_already_enabled", "Two-factor authentication already enabled"},
+	{repository.ErrTOTPNotEnabled, "totp_not_enabled", "Two-factor authentication not enabled"},
+	{repository.ErrAPIKeyInvalid, "invalid_api_key", "Invalid API key"},
+	{repository.ErrAPIKeyExpired, "api_key_expired", "API key expired"},
+	{repository.ErrAPIKeyNotFound, "api_key_not_found", "API key not found"},
+	{repository.ErrInsufficientScope, "insufficient_scope", "Insufficient API key scope"},
+	{repository.ErrAPIKeyNotPermitted, "api_key_not_permitted", "Operation not available with an API key"},
+	{repository.ErrAccountDisabled, "account_disabled", "Account disabled"},
+	{repository.ErrForbidden, "forbidden", "Insufficient role"},
+	{repository.ErrCannotModifySelf, "cannot_modify_self", "Cannot change own role or status"},
+	{repository.ErrAdjustmentNotFound, "adjustment_not_found", "Balance adjustment not found"},
+	{repository.ErrAdjustmentNotPending, "adjustment_not_pending", "Balance adjustment already decided"},
+	{repository.ErrSelfApproval, "self_approval", "Approval by another admin required"},
+	{repository.ErrSelfAdjustment, "self_adjustment", "Cannot adjust own balance"},
+	{repository.ErrTiersDisabled, "tiers_disabled", "Loyalty tiers disabled"},
+	{repository.ErrTransferLimitExceeded, "transfer_limit_exceeded", "Daily transfer limit exceeded"},
+	{repository.ErrIdempotencyKeyReused, "idempotency_key_reused", "Idempotency key reused"},
+	{repository.ErrOrderAlreadyLoadedByUser, "order_already_uploaded", "Order already uploaded"},
+	{repository.ErrOrderAlreadyLoadedByAnotherUser, "order_uploaded_by_another_user", "Order uploaded by another user"},
+	{repository.ErrOrderInvalidFormat, "invalid_order_number", "Invalid order number"},
+	{repository.ErrOrderUploadTooLarge, "order_upload_too_large", "Order upload too large"},
+	{repository.ErrOrderUploadNotFound, "order_upload_not_found", "Order upload not found"},
+	{repository.ErrTooManyRequests, "too_many_requests", "Too many requests"},
+	{repository.ErrNoContent, "no_content", "No content"},
+	{repository.ErrNotEnoughFunds, "not_enough_funds", "Not enough funds"},
+	{validate.ErrValidation, "validation_failed", "Validation failed"},
+	{ErrInvalidRequest, "invalid_request", "Invalid request"},
+	{ErrRouteNotFound, "route_not_found", "Route not found"},
+	{ErrMethodNotAllowed, "method_not_allowed", "Method not allowed"},
+	{ErrCSRFDetected, "csrf_rejected", "Cross-site request rejected"},
+}
+
+var (
+	ErrInvalidRequest   = errors.New("request does not match API specification")
+	ErrRouteNotFound    = errors.New("route does not exist")
+	ErrMethodNotAllowed = errors.New("method not allowed")
+)
+
+// newProblem - функция, собирающая описание ошибки по RFC 7807.
+func newProblem(r *http.Request, err error, statusCode int) *Problem {
+	p := &Problem{
+		Status:   statusCode,
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+	for _, k := range problemKinds {
+		if errors.Is(err, k.err) {
+			p.Code = k.code
+			p.Title = k.title
+			break
+		}
+	}
+	var fields validate.Errors
+	if errors.As(err, &fields) {
+		p.Errors = fields
+	}
+	if p.Code == "" {
+		p.Title = http.StatusText(statusCode)
+		p.Code = strings.ToLower(strings.ReplaceAll(p.Title, " ", "_"))
+	}
+	if statusCode >= http.StatusInternalServerError {
+		// Подробности внутренних ошибок остаются только в логах.
+		p.Detail = http.StatusText(statusCode)
+	}
+	p.Type = problemTypePrefix + p.Code
+	return p
+}
+
+// notFound - обработчик неподдерживаемых маршрутов с ответом 404.
+func (c *Controller) notFound(w http.ResponseWriter, r *http.Request) {
+	c.error(w, r, ErrRouteNotFound, http.StatusNotFound)
+}
+
+// notAllowed - обработчик неподдерживаемых методов с ответом 405 и заголовком Allow.
+func (c *Controller) notAllowed(routes chi.Routes) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed := make([]string, 0)
+		for _, m := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+			if routes.Match(chi.NewRouteContext(), m, r.URL.Path) {
+				allowed = append(allowed, m)
+			}
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		c.error(w, r, ErrMethodNotAllowed, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies - функция, разбирающая список доверенных прокси через запятую: адреса IP или подсети CIDR.
+func parseTrustedProxies(list string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0)
+	for _, v := range splitList(list) {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy `%s`", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy `%s` - %s", v, err.Error())
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// isTrustedProxy - метод, проверяющий, что адрес ip входит в список доверенных прокси.
+func (c *Controller) isTrustedProxy(ip net.IP) bool {
+	for _, n := range c.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// realIP - middleware, подставляющий в RemoteAddr IP клиента из X-Forwarded-For или X-Real-IP.
+// Заголовки учитываются, только если запрос пришел от доверенного прокси: иначе их может подделать сам клиент
+// и обойти ограничения по IP.
+func (c *Controller) realIP(next http.Handler) http.Handler {
+	if len(c.trustedProxies) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := c.forwardedIP(r); ip != "" {
+			r.RemoteAddr = ip
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedIP - метод, возвращающий IP клиента, переданный доверенным прокси, или пустую строку.
+// Каждый прокси дописывает адрес в конец X-Forwarded-For, поэтому клиент - первый справа адрес не из списка
+// доверенных: все, что левее, мог прислать сам клиент.
+func (c *Controller) forwardedIP(r *http.Request) string {
+	peer := net.ParseIP(clientIP(r))
+	if peer == nil || !c.isTrustedProxy(peer) {
+		return ""
+	}
+	hops := splitList(strings.Join(r.Header.Values("X-Forwarded-For"), ","))
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			return ""
+		}
+		if !c.isTrustedProxy(ip) || i == 0 {
+			return ip.String()
+		}
+	}
+	if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-IP"))); ip != nil {
+		return ip.String()
+	}
+	return ""
+}
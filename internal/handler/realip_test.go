@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies(" 10.0.0.0/8, 192.168.1.5,::1 ")
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+	require.Equal(t, "192.168.1.5/32", nets[1].String())
+	require.Equal(t, "::1/128", nets[2].String())
+
+	_, err = parseTrustedProxies("10.0.0.0/33")
+	require.Error(t, err)
+	_, err = parseTrustedProxies("proxy.local")
+	require.Error(t, err)
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8")
+	require.NoError(t, err)
+	tests := []struct {
+		name    string
+		trusted bool
+		remote  string
+		xff     []string
+		xRealIP string
+		want    string
+	}{
+		{
+			name:   "No trusted proxies",
+			remote: "203.0.113.7:5000",
+			xff:    []string{"198.51.100.1"},
+			want:   "203.0.113.7",
+		},
+		{
+			name:    "Untrusted peer",
+			trusted: true,
+			remote:  "203.0.113.7:5000",
+			xff:     []string{"198.51.100.1"},
+			xRealIP: "198.51.100.2",
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "Trusted proxy",
+			trusted: true,
+			remote:  "10.0.0.2:5000",
+			xff:     []string{"198.51.100.1"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "Spoofed hops are skipped",
+			trusted: true,
+			remote:  "10.0.0.2:5000",
+			xff:     []string{"1.2.3.4, 198.51.100.1", "10.0.0.3"},
+			want:    "198.51.100.1",
+		},
+		{
+			name:    "Only trusted hops",
+			trusted: true,
+			remote:  "10.0.0.2:5000",
+			xff:     []string{"10.0.0.4, 10.0.0.3"},
+			want:    "10.0.0.4",
+		},
+		{
+			name:    "Invalid hop",
+			trusted: true,
+			remote:  "10.0.0.2:5000",
+			xff:     []string{"unknown"},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "X-Real-IP",
+			trusted: true,
+			remote:  "10.0.0.2:5000",
+			xRealIP: "198.51.100.2",
+			want:    "198.51.100.2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{}
+			if tt.trusted {
+				c.trustedProxies = proxies
+			}
+			var got string
+			h := c.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = clientIP(r)
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if tt.xRealIP != "" {
+				r.Header.Set("X-Real-IP", tt.xRealIP)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// GetReferrals - обработчик, возвращающий реферальный код пользователя, приглашенных им пользователей и заработанные бонусы.
+func (c *Controller) GetReferrals(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r, entity.ScopeBalanceRead)
+	if err != nil {
+		return
+	}
+	refs, err := c.Storage.GetReferrals(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get referrals - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, refs)
+}
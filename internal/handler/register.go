@@ -9,12 +9,12 @@ import (
 
 	"github.com/gtgaleevtimur/gofermart/internal/entity"
 	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
 )
 
 // Register - обработчик регистрации нового пользователя.
 func (c *Controller) Register(w http.ResponseWriter, r *http.Request) {
-	content := r.Header.Get("Content-Type")
-	if content != "application/json" {
+	if !hasContentType(r, ContentTypeApplicationJSON) {
 		err := fmt.Errorf("wrong content type, JSON needed")
 		c.error(w, r, err, http.StatusBadRequest)
 		return
@@ -31,11 +31,18 @@ func (c *Controller) Register(w http.ResponseWriter, r *http.Request) {
 		c.error(w, r, fmt.Errorf("failed to unmarshal body - %s", err.Error()), http.StatusBadRequest)
 		return
 	}
+	accInfo.IP = clientIP(r)
+	accInfo.UserAgent = r.UserAgent()
+	accInfo.RequestID = requestID(r)
 	session, err := c.Storage.Register(&accInfo)
 	if err != nil {
 		msg := "failed to register new user"
+		if errors.Is(err, validate.ErrValidation) {
+			c.error(w, r, err, http.StatusBadRequest)
+			return
+		}
 		if errors.Is(err, repository.ErrLoginAlreadyTaken) {
-			c.error(w, r, fmt.Errorf("%s - %s", msg, err.Error()), http.StatusConflict)
+			c.error(w, r, fmt.Errorf("%s - %w", msg, err), http.StatusConflict)
 			return
 		}
 		c.error(w, r, fmt.Errorf("%s - %s", msg, err.Error()), http.StatusInternalServerError)
@@ -45,11 +52,7 @@ func (c *Controller) Register(w http.ResponseWriter, r *http.Request) {
 		c.error(w, r, fmt.Errorf("got nil session"), http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   session.Token,
-		Expires: session.Expiry,
-	})
+	c.setSessionCookie(w, session)
 	msg := fmt.Sprintf("session for user `%s` successfully created", accInfo.Login)
 	c.log(r, msg)
 }
@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+// GetSessions - обработчик, возвращающий активные сессии пользователя.
+func (c *Controller) GetSessions(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	sessions, err := c.Storage.GetSessions(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get sessions - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == st.ID
+	}
+	body, err := json.Marshal(&sessions)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to marshal JSON - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeApplicationJSON)
+	w.Write(body)
+}
+
+// DeleteSession - обработчик, завершающий сессию пользователя по ее ID.
+func (c *Controller) DeleteSession(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	sessionID, err := strconv.ParseUint(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("invalid session ID - %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.DeleteUserSession(st.UserID, sessionID)
+	if err != nil {
+		if errors.Is(err, repository.ErrSessionNotFound) {
+			c.error(w, r, repository.ErrSessionNotFound, http.StatusNotFound)
+			return
+		}
+		c.error(w, r, fmt.Errorf("failed to delete session - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if sessionID == st.ID {
+		c.clearSessionCookie(w)
+	}
+	c.log(r, fmt.Sprintf("session %d has been closed", sessionID))
+}
+
+// DeleteSessions - обработчик, завершающий все сессии пользователя ("выйти везде").
+func (c *Controller) DeleteSessions(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	err = c.Storage.DeleteUserSessions(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to delete sessions - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.clearSessionCookie(w)
+	c.log(r, fmt.Sprintf("all sessions of user %d have been closed", st.UserID))
+}
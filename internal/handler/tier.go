@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+// GetTier - обработчик, возвращающий уровень пользователя в программе лояльности, прогресс до следующего уровня и историю.
+func (c *Controller) GetTier(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r, entity.ScopeBalanceRead)
+	if err != nil {
+		return
+	}
+	t, err := c.Storage.GetTier(st.UserID)
+	if errors.Is(err, repository.ErrTiersDisabled) {
+		c.error(w, r, repository.ErrTiersDisabled, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get loyalty tier - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, t)
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// IssueToken - обработчик, выдающий access- и refresh-токены по паре логин/пароль для клиентов без cookie.
+func (c *Controller) IssueToken(w http.ResponseWriter, r *http.Request) {
+	var creds *entity.AccountInfo
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if creds == nil {
+		c.error(w, r, fmt.Errorf("empty credentials"), http.StatusBadRequest)
+		return
+	}
+	creds.IP = clientIP(r)
+	creds.UserAgent = r.UserAgent()
+	creds.RequestID = requestID(r)
+	pair, err := c.Storage.IssueTokens(creds)
+	if err != nil {
+		if c.tooManyRequests(w, r, err) || c.mfaRequired(w, r, err) {
+			return
+		}
+		if errors.Is(err, repository.ErrInvalidPair) || errors.Is(err, repository.ErrUserNotFound) {
+			c.error(w, r, repository.ErrInvalidPair, http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, repository.ErrInvalidMFACode) {
+			c.error(w, r, err, http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, repository.ErrAccountDisabled) {
+			c.error(w, r, err, http.StatusForbidden)
+			return
+		}
+		c.error(w, r, fmt.Errorf("failed to issue tokens - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeTokenPair(w, r, pair)
+	c.log(r, fmt.Sprintf("tokens for user `%s` successfully issued", creds.Login))
+}
+
+// RefreshToken - обработчик, обменивающий refresh-токен на новую пару токенов.
+func (c *Controller) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if req.RefreshToken == "" {
+		c.error(w, r, fmt.Errorf("empty refresh token"), http.StatusBadRequest)
+		return
+	}
+	pair, err := c.Storage.RefreshTokens(req.RefreshToken, &entity.AccountInfo{
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		if errors.Is(err, repository.ErrRefreshTokenInvalid) || errors.Is(err, repository.ErrRefreshTokenExpired) ||
+			errors.Is(err, repository.ErrRefreshTokenReused) {
+			c.error(w, r, err, http.StatusUnauthorized)
+			return
+		}
+		c.error(w, r, fmt.Errorf("failed to refresh tokens - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeTokenPair(w, r, pair)
+}
+
+// RevokeToken - обработчик, отзывающий refresh-токен вместе с его семейством.
+// Неизвестный токен не считается ошибкой (RFC 7009).
+func (c *Controller) RevokeToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	err = c.Storage.RevokeRefreshToken(req.RefreshToken)
+	if err != nil && !errors.Is(err, repository.ErrRefreshTokenInvalid) {
+		c.error(w, r, fmt.Errorf("failed to revoke token - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.log(r, "refresh token revoked")
+}
+
+// writeTokenPair - метод, отдающий пару токенов клиенту с запретом кэширования.
+func (c *Controller) writeTokenPair(w http.ResponseWriter, r *http.Request, pair *entity.TokenPair) {
+	body, err := json.Marshal(pair)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to marshal JSON - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ContentTypeApplicationJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(body)
+}
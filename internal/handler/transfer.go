@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+const (
+	// idempotencyKeyHeader - заголовок с ключом идемпотентности перевода.
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotentReplayedHeader - заголовок ответа на повтор запроса, уже выполненного с тем же ключом.
+	idempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// PostTransfer - обработчик перевода баллов другому пользователю по логину.
+// API-ключи не принимаются: сервисы, работающие от имени пользователя, не переводят его баллы другим.
+func (c *Controller) PostTransfer(w http.ResponseWriter, r *http.Request) {
+	if !hasContentType(r, ContentTypeApplicationJSON) {
+		err := fmt.Errorf("wrong content type, %s needed", ContentTypeApplicationJSON)
+		c.error(w, r, err, http.StatusBadRequest)
+		return
+	}
+	st, err := c.auth(w, r)
+	if err != nil {
+		return
+	}
+	reqBody, err := io.ReadAll(r.Body)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to read request body - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	defer r.Body.Close()
+	req := &entity.TransferRequest{}
+	err = json.Unmarshal(reqBody, req)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to unmarshal body - %s", err.Error()), http.StatusBadRequest)
+		return
+	}
+	actor := &entity.Actor{UserID: st.UserID, IP: clientIP(r), RequestID: requestID(r)}
+	t, replayed, err := c.Storage.Transfer(st.UserID, actor, req, r.Header.Get(idempotencyKeyHeader), r.Header.Get(totpHeader))
+	if err != nil {
+		if c.tooManyRequests(w, r, err) {
+			return
+		}
+		switch {
+		case errors.Is(err, validate.ErrValidation):
+			c.error(w, r, err, http.StatusBadRequest)
+		case errors.Is(err, repository.ErrMFARequired), errors.Is(err, repository.ErrInvalidMFACode),
+			errors.Is(err, repository.ErrTransferLimitExceeded):
+			c.error(w, r, err, http.StatusForbidden)
+		case errors.Is(err, repository.ErrNotEnoughFunds):
+			c.error(w, r, repository.ErrNotEnoughFunds, http.StatusPaymentRequired)
+		case errors.Is(err, repository.ErrIdempotencyKeyReused):
+			c.error(w, r, repository.ErrIdempotencyKeyReused, http.StatusUnprocessableEntity)
+		default:
+			c.error(w, r, fmt.Errorf("failed to transfer points - %s", err.Error()), http.StatusInternalServerError)
+		}
+		return
+	}
+	if replayed {
+		w.Header().Set(idempotentReplayedHeader, "true")
+	}
+	c.writeJSON(w, r, http.StatusOK, t)
+	c.log(r, fmt.Sprintf("transfer %d to `%s` has been made", t.ID, t.Counterparty))
+}
+
+// GetTransfers - обработчик, возвращающий исходящие и входящие переводы баллов пользователя.
+func (c *Controller) GetTransfers(w http.ResponseWriter, r *http.Request) {
+	st, err := c.auth(w, r, entity.ScopeBalanceRead)
+	if err != nil {
+		return
+	}
+	list, err := c.Storage.GetTransfers(st.UserID)
+	if err != nil {
+		c.error(w, r, fmt.Errorf("failed to get transfers - %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	c.writeJSON(w, r, http.StatusOK, list)
+}
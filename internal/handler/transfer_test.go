@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/repository"
+)
+
+func (s *fakeStorage) Transfer(userID uint64, actor *entity.Actor, req *entity.TransferRequest, key, otp string) (*entity.TransferX, bool, error) {
+	return &entity.TransferX{ID: 1, Counterparty: req.To, Amount: req.Amount}, false, nil
+}
+
+func TestPostTransferAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		bearer string
+		want   int
+		err    error
+	}{
+		{name: "Anonymous", want: http.StatusUnauthorized, err: repository.ErrUnauthorizedAccess},
+		{name: "Access token", bearer: testAccessToken, want: http.StatusOK},
+		// Ключу выдана область withdraw, но переводить баллы другим он все равно не может.
+		{name: "API key", bearer: testAPIKey, want: http.StatusForbidden, err: repository.ErrAPIKeyNotPermitted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(&config.Config{}, entity.RoleUser)
+			r := httptest.NewRequest(http.MethodPost, "/api/user/balance/transfer", strings.NewReader(`{"to": "friend", "amount": 10}`))
+			r.Header.Set("Content-Type", ContentTypeApplicationJSON)
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			c.PostTransfer(w, r)
+			require.Equal(t, tt.want, w.Code, w.Body.String())
+			if tt.err != nil {
+				require.Contains(t, w.Body.String(), tt.err.Error())
+			}
+		})
+	}
+}
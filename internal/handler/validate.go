@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/rs/zerolog/log"
+)
+
+type validator struct {
+	router    routers.Router
+	responses bool
+	options   *openapi3filter.Options
+	// noBody - те же настройки без проверки тела запроса.
+	noBody *openapi3filter.Options
+}
+
+// pendingValidationKey - ключ контекста запроса, под которым validate оставляет проверку запроса до аутентификации.
+type pendingValidationKey struct{}
+
+// pendingValidation - проверка запроса защищенного маршрута, которую выполняет auth после успешной аутентификации.
+type pendingValidation struct {
+	in   *openapi3filter.RequestValidationInput
+	done bool
+}
+
+// newValidator - конструктор валидатора запросов и ответов по спецификации OpenAPI.
+func newValidator(doc *openapi3.T, responses bool) (*validator, error) {
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	options := &openapi3filter.Options{
+		// Аутентификацию выполняет сам контроллер.
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+		MultiError:         true,
+	}
+	options.WithCustomSchemaErrorFunc(schemaErrorMessage)
+	noBody := *options
+	noBody.ExcludeRequestBody = true
+	return &validator{
+		router:    router,
+		responses: responses,
+		options:   options,
+		noBody:    &noBody,
+	}, nil
+}
+
+// schemaErrorMessage - функция, описывающая нарушение схемы для клиента: клиенту достаточно описания ошибки,
+// без дампа всей схемы и значения.
+func schemaErrorMessage(err *openapi3.SchemaError) string {
+	reason := err.Reason
+	if err.Origin != nil {
+		reason = err.Origin.Error()
+	} else if reason == "" {
+		reason = fmt.Sprintf("doesn't match schema %q", err.SchemaField)
+	}
+	if pointer := err.JSONPointer(); len(pointer) > 0 {
+		return fmt.Sprintf("error at \"/%s\": %s", strings.Join(pointer, "/"), reason)
+	}
+	return reason
+}
+
+// requiresAuth - функция, проверяющая, что операция маршрута требует аутентификации.
+func requiresAuth(route *routers.Route) bool {
+	security := route.Operation.Security
+	if security == nil {
+		security = &route.Spec.Security
+	}
+	for _, requirement := range *security {
+		if len(requirement) == 0 {
+			// Пустое требование разрешает анонимный доступ.
+			return false
+		}
+	}
+	return len(*security) > 0
+}
+
+// validate - middleware, проверяющий запросы (и при необходимости ответы) описанных в спецификации маршрутов.
+// Запрос маршрута, требующего аутентификации, проверяется только после нее в auth, чтобы клиент без учетных данных
+// получал 401, а не 400. Исходная версия API принимает тело без Content-Type, поэтому такое тело не проверяется.
+func (c *Controller) validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, pathParams, err := c.validator.router.FindRoute(r)
+		if err != nil {
+			// Маршрут не описан в спецификации - проверять нечего.
+			next.ServeHTTP(w, r)
+			return
+		}
+		in := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: pathParams,
+			Route:      route,
+			Options:    c.validator.options,
+		}
+		if !c.problems && r.Header.Get("Content-Type") == "" {
+			in.Options = c.validator.noBody
+		}
+		if requiresAuth(route) {
+			r = r.WithContext(context.WithValue(r.Context(), pendingValidationKey{}, &pendingValidation{in: in}))
+		} else {
+			err = openapi3filter.ValidateRequest(r.Context(), in)
+			if err != nil {
+				c.error(w, r, fmt.Errorf("%w - %s", ErrInvalidRequest, err.Error()), http.StatusBadRequest)
+				return
+			}
+		}
+		if !c.validator.responses {
+			next.ServeHTTP(w, r)
+			return
+		}
+		body := &bytes.Buffer{}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(body)
+		next.ServeHTTP(ww, r)
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		err = openapi3filter.ValidateResponse(r.Context(), &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: in,
+			Status:                 status,
+			Header:                 ww.Header(),
+			Body:                   io.NopCloser(body),
+			Options:                c.validator.options,
+		})
+		if err != nil {
+			// Ответ уже отправлен клиенту, поэтому несоответствие только логируем.
+			log.Warn().Err(err).
+				Str("reqID", middleware.GetReqID(r.Context())).
+				Str("route", route.Path).
+				Msg("response does not match API specification")
+		}
+	})
+}
+
+// validateAuthenticated - метод, выполняющий проверку запроса, отложенную validate до аутентификации.
+// Если запрос не соответствует спецификации, отвечает 400 и возвращает ошибку.
+func (c *Controller) validateAuthenticated(w http.ResponseWriter, r *http.Request) error {
+	pending, ok := r.Context().Value(pendingValidationKey{}).(*pendingValidation)
+	if !ok || pending.done {
+		return nil
+	}
+	pending.done = true
+	// Тело читается и возвращается в тот запрос, который дойдет до хэндлера.
+	pending.in.Request = r
+	err := openapi3filter.ValidateRequest(r.Context(), pending.in)
+	if err != nil {
+		err = fmt.Errorf("%w - %s", ErrInvalidRequest, err.Error())
+		c.error(w, r, err, http.StatusBadRequest)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// serveValidated - функция, пропускающая запрос через validate к хэндлеру, который аутентифицирует клиента,
+// если маршрут этого требует, и отвечает 204.
+func serveValidated(c *Controller, r *http.Request, auth bool) *httptest.ResponseRecorder {
+	h := c.validate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth {
+			_, err := c.auth(w, r, entity.ScopeWithdraw)
+			if err != nil {
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		bearer      string
+		body        string
+		auth        bool
+		want        int
+	}{
+		{
+			name:        "Unauthenticated invalid body",
+			path:        "/api/v2/user/balance/withdraw",
+			contentType: ContentTypeApplicationJSON,
+			body:        `{"order": 1}`,
+			auth:        true,
+			want:        http.StatusUnauthorized,
+		},
+		{
+			name:        "Authenticated invalid body",
+			path:        "/api/v2/user/balance/withdraw",
+			contentType: ContentTypeApplicationJSON,
+			bearer:      testAccessToken,
+			body:        `{"order": 1}`,
+			auth:        true,
+			want:        http.StatusBadRequest,
+		},
+		{
+			name:        "Authenticated valid body",
+			path:        "/api/v2/user/balance/withdraw",
+			contentType: ContentTypeApplicationJSON,
+			bearer:      testAccessToken,
+			body:        `{"order": "2377225624", "sum": 751}`,
+			auth:        true,
+			want:        http.StatusNoContent,
+		},
+		{
+			name: "Missing content type in v1",
+			path: "/api/user/login",
+			body: `{"login": "user", "password": "secret"}`,
+			want: http.StatusNoContent,
+		},
+		{
+			name: "Missing content type in v2",
+			path: "/api/v2/user/login",
+			body: `{"login": "user", "password": "secret"}`,
+			want: http.StatusBadRequest,
+		},
+		{
+			name:        "Invalid public request",
+			path:        "/api/user/login",
+			contentType: ContentTypeApplicationJSON,
+			body:        `{"login": 1}`,
+			want:        http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(&config.Config{}, entity.RoleUser)
+			if strings.HasPrefix(tt.path, "/api/v2/") {
+				c = c.withProblems()
+			}
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := serveValidated(c, r, tt.auth)
+			require.Equal(t, tt.want, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestValidateErrorWithoutSchema(t *testing.T) {
+	c := newTestController(&config.Config{}, entity.RoleUser).withProblems()
+	r := httptest.NewRequest(http.MethodPost, "/api/v2/user/login", strings.NewReader(`{"login": 1, "password": "secret"}`))
+	r.Header.Set("Content-Type", ContentTypeApplicationJSON)
+	w := serveValidated(c, r, false)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	require.Contains(t, w.Body.String(), `error at \"/login\"`)
+	require.NotContains(t, w.Body.String(), "Schema:")
+	// Общая настройка библиотеки не меняется.
+	require.False(t, openapi3.SchemaErrorDetailsDisabled)
+}
@@ -0,0 +1,79 @@
+package limiter
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindow - ограничитель частоты событий по ключу со скользящим окном.
+// Хранит время каждого события в окне, поэтому рассчитан на небольшие лимиты.
+type SlidingWindow struct {
+	sync.Mutex
+	limit     int
+	window    time.Duration
+	events    map[string][]time.Time
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewSlidingWindow - конструктор ограничителя, пропускающего не больше limit событий на ключ за window.
+// При limit <= 0 ограничение выключено.
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		limit:  limit,
+		window: window,
+		events: make(map[string][]time.Time),
+		now:    time.Now,
+	}
+}
+
+// Allow - метод, учитывающий событие по ключу. Если лимит исчерпан, событие не учитывается,
+// а вторым значением возвращается время, через которое освободится место в окне.
+func (sw *SlidingWindow) Allow(key string) (bool, time.Duration) {
+	if sw.limit <= 0 {
+		return true, 0
+	}
+	sw.Lock()
+	defer sw.Unlock()
+	now := sw.now()
+	sw.sweep(now)
+	events := trim(sw.events[key], now.Add(-sw.window))
+	if len(events) >= sw.limit {
+		sw.events[key] = events
+		return false, events[0].Add(sw.window).Sub(now)
+	}
+	sw.events[key] = append(events, now)
+	return true, 0
+}
+
+// Reset - метод, забывающий события по ключу.
+func (sw *SlidingWindow) Reset(key string) {
+	sw.Lock()
+	delete(sw.events, key)
+	sw.Unlock()
+}
+
+// sweep - метод, раз в окно удаляющий ключи без событий в окне, чтобы таблица не росла бесконечно.
+func (sw *SlidingWindow) sweep(now time.Time) {
+	if now.Sub(sw.lastSweep) < sw.window {
+		return
+	}
+	sw.lastSweep = now
+	since := now.Add(-sw.window)
+	for key, events := range sw.events {
+		if events = trim(events, since); len(events) == 0 {
+			delete(sw.events, key)
+		} else {
+			sw.events[key] = events
+		}
+	}
+}
+
+// trim - функция, отбрасывающая события раньше since.
+func trim(events []time.Time, since time.Time) []time.Time {
+	i := 0
+	for i < len(events) && !events[i].After(since) {
+		i++
+	}
+	return events[i:]
+}
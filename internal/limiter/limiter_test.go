@@ -0,0 +1,61 @@
+package limiter
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlidingWindow(t *testing.T) {
+	start := time.Date(2022, 11, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		limit      int
+		at         []time.Duration
+		allowed    []bool
+		retryAfter time.Duration
+	}{
+		{
+			name:    "Under limit",
+			limit:   3,
+			at:      []time.Duration{0, time.Second, 2 * time.Second},
+			allowed: []bool{true, true, true},
+		},
+		{
+			name:       "Over limit",
+			limit:      2,
+			at:         []time.Duration{0, 10 * time.Second, 20 * time.Second},
+			allowed:    []bool{true, true, false},
+			retryAfter: 40 * time.Second,
+		},
+		{
+			name:    "Window slides",
+			limit:   2,
+			at:      []time.Duration{0, 10 * time.Second, 61 * time.Second},
+			allowed: []bool{true, true, true},
+		},
+		{
+			name:    "Disabled",
+			limit:   0,
+			at:      []time.Duration{0, 0, 0},
+			allowed: []bool{true, true, true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sw := NewSlidingWindow(tt.limit, time.Minute)
+			var retryAfter time.Duration
+			for i, at := range tt.at {
+				sw.now = func() time.Time { return start.Add(at) }
+				var ok bool
+				ok, retryAfter = sw.Allow("key")
+				require.Equal(t, tt.allowed[i], ok, "event %d", i)
+				other, _ := sw.Allow("other")
+				require.True(t, other)
+				sw.Reset("other")
+			}
+			require.Equal(t, tt.retryAfter, retryAfter)
+		})
+	}
+}
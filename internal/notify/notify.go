@@ -0,0 +1,135 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Message - письмо пользователю.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier - способ доставки писем пользователям.
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// New - конструктор доставки по имени драйвера: smtp, file, log или none (по умолчанию).
+func New(driver, file, smtpAddr, smtpUser, smtpPassword, smtpFrom string) (Notifier, error) {
+	switch driver {
+	case "", "none":
+		return &Discard{}, nil
+	case "log":
+		return &Log{}, nil
+	case "file":
+		if file == "" {
+			return nil, fmt.Errorf("notify file is not set")
+		}
+		return NewFile(file), nil
+	case "smtp":
+		if smtpAddr == "" || smtpFrom == "" {
+			return nil, fmt.Errorf("smtp address and sender must be set")
+		}
+		return NewSMTP(smtpAddr, smtpUser, smtpPassword, smtpFrom), nil
+	default:
+		return nil, fmt.Errorf("unknown notify driver `%s`", driver)
+	}
+}
+
+// SMTP - доставка писем через SMTP-сервер. STARTTLS используется, если сервер его поддерживает.
+type SMTP struct {
+	Addr string
+	From string
+	auth smtp.Auth
+}
+
+// NewSMTP - конструктор доставки через SMTP-сервер addr (host:port). Пустой user - без аутентификации.
+func NewSMTP(addr, user, password, from string) *SMTP {
+	s := &SMTP{
+		Addr: addr,
+		From: from,
+	}
+	if user != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			host = addr[:i]
+		}
+		s.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return s
+}
+
+func (s *SMTP) Notify(ctx context.Context, msg Message) error {
+	err := smtp.SendMail(s.Addr, s.auth, s.From, []string{msg.To}, s.compose(msg))
+	if err != nil {
+		return fmt.Errorf("failed to send mail - %s", err.Error())
+	}
+	return nil
+}
+
+// compose - метод, собирающий письмо с заголовками.
+func (s *SMTP) compose(msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + s.From + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// File - доставка для разработки: письма дописываются в файл.
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFile - конструктор доставки в файл path.
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Notify(ctx context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open notify file - %s", err.Error())
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	if err != nil {
+		return fmt.Errorf("failed to write notify file - %s", err.Error())
+	}
+	return nil
+}
+
+// Log - доставка для отладки: в лог сервиса на уровне Debug пишутся только адрес и тема письма.
+// Тело не пишется, потому что в нем бывают токены восстановления пароля.
+type Log struct{}
+
+func (l *Log) Notify(ctx context.Context, msg Message) error {
+	log.Debug().Str("to", msg.To).Str("subject", msg.Subject).Int("body_length", len(msg.Body)).Msg("notification")
+	return nil
+}
+
+// Discard - доставка по умолчанию, пока способ отправки не настроен: письма не отправляются.
+type Discard struct{}
+
+func (d *Discard) Notify(ctx context.Context, msg Message) error {
+	log.Warn().Str("subject", msg.Subject).Msg("notification dropped, notify driver is not configured")
+	return nil
+}
@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name   string
+		driver string
+		file   string
+		addr   string
+		from   string
+		want   Notifier
+		err    bool
+	}{
+		{
+			name: "Default discard",
+			want: &Discard{},
+		},
+		{
+			name:   "Log",
+			driver: "log",
+			want:   &Log{},
+		},
+		{
+			name:   "File",
+			driver: "file",
+			file:   "mail.txt",
+			want:   &File{path: "mail.txt"},
+		},
+		{
+			name:   "File without path",
+			driver: "file",
+			err:    true,
+		},
+		{
+			name:   "SMTP",
+			driver: "smtp",
+			addr:   "localhost:25",
+			from:   "noreply@gophermart.local",
+			want:   &SMTP{Addr: "localhost:25", From: "noreply@gophermart.local"},
+		},
+		{
+			name:   "SMTP without sender",
+			driver: "smtp",
+			addr:   "localhost:25",
+			err:    true,
+		},
+		{
+			name:   "Unknown driver",
+			driver: "pigeon",
+			err:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := New(tt.driver, tt.file, tt.addr, "", "", tt.from)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, n)
+		})
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	f := NewFile(path)
+	require.NoError(t, f.Notify(context.Background(), Message{To: "gopher@example.com", Subject: "first", Body: "token 1"}))
+	require.NoError(t, f.Notify(context.Background(), Message{To: "gopher@example.com", Subject: "second", Body: "token 2"}))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(b), "To: gopher@example.com"))
+	require.Contains(t, string(b), "Subject: second\n\ntoken 2")
+}
+
+func TestLogRedactsBody(t *testing.T) {
+	var buf bytes.Buffer
+	prev := log.Logger
+	log.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { log.Logger = prev })
+
+	msg := Message{To: "gopher@example.com", Subject: "Gophermart password reset", Body: "token 0f8e2a"}
+	require.NoError(t, (&Log{}).Notify(context.Background(), msg))
+	require.NoError(t, (&Discard{}).Notify(context.Background(), msg))
+	require.Contains(t, buf.String(), `"level":"debug"`)
+	require.Contains(t, buf.String(), "gopher@example.com")
+	require.NotContains(t, buf.String(), "0f8e2a")
+}
@@ -0,0 +1,41 @@
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// spec - спецификация HTTP API сервиса в формате OpenAPI 3.
+//
+//go:embed openapi.json
+var spec []byte
+
+// JSON - функция, возвращающая спецификацию API в исходном виде.
+func JSON() []byte {
+	return spec
+}
+
+// Load - функция, разбирающая и проверяющая встроенную спецификацию API.
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI specification - %s", err.Error())
+	}
+	err = doc.Validate(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI specification - %s", err.Error())
+	}
+	return doc, nil
+}
+
+// MustLoad - функция, аналогичная Load, но паникующая при ошибке: спецификация встроена в бинарник и должна быть корректной.
+func MustLoad() *openapi3.T {
+	doc, err := Load()
+	if err != nil {
+		panic(err)
+	}
+	return doc
+}
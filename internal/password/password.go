@@ -0,0 +1,190 @@
+package password
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+// Hasher - алгоритм хэширования паролей. Хэш хранит в себе алгоритм и параметры, поэтому их можно менять без потери старых паролей.
+type Hasher interface {
+	// Name - имя алгоритма.
+	Name() string
+	// Hash - хэширует пароль с новой солью.
+	Hash(password string) ([]byte, error)
+	// Match - проверяет, что хэш создан этим алгоритмом.
+	Match(hash []byte) bool
+	// Verify - сравнивает пароль с хэшем этого алгоритма.
+	Verify(hash []byte, password string) (bool, error)
+	// Outdated - проверяет, что хэш создан с параметрами слабее текущих.
+	Outdated(hash []byte) bool
+}
+
+// Manager - хэширует пароли текущим алгоритмом и проверяет хэши любого из известных.
+type Manager struct {
+	current Hasher
+	known   []Hasher
+}
+
+// NewManager - конструктор с текущим алгоритмом и алгоритмами, хэши которых еще встречаются в БД.
+func NewManager(current Hasher, legacy ...Hasher) *Manager {
+	return &Manager{
+		current: current,
+		known:   append([]Hasher{current}, legacy...),
+	}
+}
+
+// Current - метод, возвращающий текущий алгоритм.
+func (m *Manager) Current() Hasher {
+	return m.current
+}
+
+// Hash - метод, хэширующий пароль текущим алгоритмом.
+func (m *Manager) Hash(password string) ([]byte, error) {
+	return m.current.Hash(password)
+}
+
+// Verify - метод, проверяющий пароль по хэшу любого известного алгоритма.
+func (m *Manager) Verify(hash []byte, password string) (bool, error) {
+	for _, h := range m.known {
+		if h.Match(hash) {
+			return h.Verify(hash, password)
+		}
+	}
+	return false, ErrUnknownAlgorithm
+}
+
+// NeedsRehash - метод, проверяющий, что хэш создан не текущим алгоритмом или с устаревшими параметрами.
+func (m *Manager) NeedsRehash(hash []byte) bool {
+	return !m.current.Match(hash) || m.current.Outdated(hash)
+}
+
+// Argon2id - хэширование Argon2id (RFC 9106) с хэшем в формате PHC: $argon2id$v=19$m=...,t=...,p=...$соль$ключ.
+type Argon2id struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// argon2idPrefix - начало хэша Argon2id в формате PHC.
+const argon2idPrefix = "$argon2id$"
+
+// NewArgon2id - конструктор с параметрами стоимости: память в КиБ, число проходов и потоков.
+func NewArgon2id(memory, time uint32, threads uint8) *Argon2id {
+	return &Argon2id{
+		Memory:  memory,
+		Time:    time,
+		Threads: threads,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+func (a *Argon2id) Name() string {
+	return "argon2id"
+}
+
+func (a *Argon2id) Hash(password string) ([]byte, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt - %s", err.Error())
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return []byte(fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+func (a *Argon2id) Match(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte(argon2idPrefix))
+}
+
+func (a *Argon2id) Verify(hash []byte, password string) (bool, error) {
+	p, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+	other := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (a *Argon2id) Outdated(hash []byte) bool {
+	p, _, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return true
+	}
+	return p.Memory < a.Memory || p.Time < a.Time || p.Threads < a.Threads || uint32(len(key)) < a.KeyLen
+}
+
+// decodeArgon2id - функция, разбирающая хэш Argon2id в формате PHC на параметры, соль и ключ.
+func decodeArgon2id(hash []byte) (*Argon2id, []byte, []byte, error) {
+	parts := strings.Split(string(hash), "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, fmt.Errorf("%w - malformed argon2id hash", ErrUnknownAlgorithm)
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version `%s`", parts[2])
+	}
+	p := &Argon2id{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed argon2id parameters - %s", err.Error())
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed argon2id salt - %s", err.Error())
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("malformed argon2id key - %s", err.Error())
+	}
+	return p, salt, key, nil
+}
+
+// Bcrypt - хэширование bcrypt, которым сохранены пароли до перехода на Argon2id.
+type Bcrypt struct {
+	Cost int
+}
+
+// NewBcrypt - конструктор с параметром стоимости.
+func NewBcrypt(cost int) *Bcrypt {
+	return &Bcrypt{Cost: cost}
+}
+
+func (b *Bcrypt) Name() string {
+	return "bcrypt"
+}
+
+func (b *Bcrypt) Hash(password string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+}
+
+func (b *Bcrypt) Match(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2a$")) || bytes.HasPrefix(hash, []byte("$2b$")) || bytes.HasPrefix(hash, []byte("$2y$"))
+}
+
+func (b *Bcrypt) Verify(hash []byte, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *Bcrypt) Outdated(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost < b.Cost
+}
@@ -0,0 +1,80 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestManager(t *testing.T) {
+	argon := NewArgon2id(1024, 1, 1)
+	manager := NewManager(argon, NewBcrypt(10))
+
+	current, err := manager.Hash("secret")
+	require.NoError(t, err)
+	weakArgon, err := NewArgon2id(512, 1, 1).Hash("secret")
+	require.NoError(t, err)
+	legacy, err := bcrypt.GenerateFromPassword([]byte("secret"), 8)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		hash     []byte
+		password string
+		ok       bool
+		rehash   bool
+		err      bool
+	}{
+		{
+			name:     "Current hash",
+			hash:     current,
+			password: "secret",
+			ok:       true,
+		},
+		{
+			name:     "Wrong password",
+			hash:     current,
+			password: "Secret",
+		},
+		{
+			name:     "Weaker argon2id parameters",
+			hash:     weakArgon,
+			password: "secret",
+			ok:       true,
+			rehash:   true,
+		},
+		{
+			name:     "Legacy bcrypt",
+			hash:     legacy,
+			password: "secret",
+			ok:       true,
+			rehash:   true,
+		},
+		{
+			name:     "Legacy bcrypt wrong password",
+			hash:     legacy,
+			password: "other",
+			rehash:   true,
+		},
+		{
+			name:     "Unknown algorithm",
+			hash:     []byte("plain"),
+			password: "plain",
+			rehash:   true,
+			err:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := manager.Verify(tt.hash, tt.password)
+			if tt.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.rehash, manager.NeedsRehash(tt.hash))
+		})
+	}
+}
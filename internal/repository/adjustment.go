@@ -0,0 +1,347 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// adjustmentsColumns - порядок колонок таблицы корректировок баланса, в котором их читает scanAdjustment.
+const adjustmentsColumns = "id, user_id, amount, reason, comment, status, created_by, created_at, decided_by, decided_at"
+
+// adjustmentLockClass - первый ключ advisory-блокировки по автору корректировок, второй ключ - ID сотрудника.
+// Двухключевые блокировки не пересекаются с одноключевой блокировкой журнала аудита.
+const adjustmentLockClass = 0x61646a
+
+// initAdjustments - метод, создающий таблицу ручных корректировок баланса, если ее нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initAdjustments(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS balance_adjustments (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				amount bigint NOT NULL,
+				reason varchar NOT NULL,
+				comment varchar NOT NULL,
+				status varchar NOT NULL,
+				created_by bigint NOT NULL,
+				created_at timestamptz NOT NULL DEFAULT now(),
+				decided_by bigint,
+				decided_at timestamptz)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS balance_adjustments_user_id_idx ON balance_adjustments (user_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS balance_adjustments_created_by_idx ON balance_adjustments (created_by, created_at)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table balance_adjustments created")
+	err = r.initAdjustmentsStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initAdjustmentsStatements - метод, подготавливающий стейтменты БД для работы с корректировками баланса.
+func (r *Repository) initAdjustmentsStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO balance_adjustments (user_id, amount, reason, comment, status, created_by, created_at, decided_by, decided_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["adjustmentsInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+adjustmentsColumns+" FROM balance_adjustments WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["adjustmentsGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+adjustmentsColumns+" FROM balance_adjustments WHERE user_id=$1 ORDER BY created_at DESC",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["adjustmentsGetForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+adjustmentsColumns+" FROM balance_adjustments WHERE status=$1 ORDER BY created_at",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["adjustmentsGetByStatus"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE balance_adjustments SET status = $2, decided_by = $3, decided_at = $4 WHERE id = $1 AND status = $5",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["adjustmentsDecide"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT pg_advisory_xact_lock($1, $2)",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["adjustmentsLockCreator"] = stmt
+	// Корректировки, которые сотрудник применил сам, без одобрения второго администратора.
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`SELECT COALESCE(sum(abs(amount)), 0) FROM balance_adjustments
+			WHERE created_by=$1 AND decided_by=created_by AND status=$2 AND created_at > $3`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["adjustmentsSumSelfApplied"] = stmt
+	return nil
+}
+
+// scanAdjustment - функция, читающая корректировку баланса из строки результата в порядке adjustmentsColumns.
+func scanAdjustment(row scanner, a *entity.Adjustment) error {
+	return row.Scan(&a.ID, &a.UserID, &a.Amount, &a.Reason, &a.Comment, &a.Status, &a.CreatedBy, &a.CreatedAt, &a.DecidedBy, &a.DecidedAt)
+}
+
+// AddAdjustmentDB - метод, добавляющий корректировку баланса в БД и событие e в журнал аудита.
+// Примененная корректировка меняет баланс в той же транзакции, ожидающая одобрения только записывается.
+// Если вместе с корректировками, примененными автором без одобрения за последние сутки, сумма по модулю
+// превышает AdjustmentDailyLimit, корректировка записывается ожидающей одобрения.
+func (r *Repository) AddAdjustmentDB(a *entity.Adjustment, e *entity.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if a.Status == entity.AdjustmentApplied && r.conf.AdjustmentDailyLimit > 0 {
+		err = r.checkAdjustmentDailyLimit(tx, a)
+		if err != nil {
+			return err
+		}
+	}
+	row := tx.StmtContext(r.ctx, r.stmts["adjustmentsInsert"]).QueryRowContext(r.ctx,
+		a.UserID, a.Amount, a.Reason, a.Comment, a.Status, a.CreatedBy, a.CreatedAt, a.DecidedBy, a.DecidedAt)
+	err = row.Scan(&a.ID)
+	if err != nil {
+		return err
+	}
+	e.TargetID = strconv.FormatUint(a.ID, 10)
+	var balance *entity.Balance
+	if a.Status == entity.AdjustmentApplied {
+		balance, err = r.adjustBalance(tx, a)
+		if err != nil {
+			return err
+		}
+		e.Before = balanceState(balanceBefore(*balance, a.Amount))
+	}
+	e.After = adjustmentState(a, balance)
+	err = r.appendAudit(tx, e)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("add balance adjustment transaction failed - %s", err.Error())
+	}
+	return nil
+}
+
+// checkAdjustmentDailyLimit - метод, переводящий корректировку a в ожидающие одобрения, если вместе с корректировками,
+// примененными ее автором без одобрения за последние сутки, сумма по модулю превышает AdjustmentDailyLimit.
+// Блокировка по автору не дает параллельным запросам одного сотрудника разделить лимит.
+func (r *Repository) checkAdjustmentDailyLimit(tx *sql.Tx, a *entity.Adjustment) error {
+	_, err := r.txStmt(tx, "adjustmentsLockCreator").ExecContext(r.ctx, adjustmentLockClass, int32(a.CreatedBy))
+	if err != nil {
+		return fmt.Errorf("failed to lock adjustment author - %s", err.Error())
+	}
+	var total int64
+	err = r.txStmt(tx, "adjustmentsSumSelfApplied").QueryRowContext(r.ctx,
+		a.CreatedBy, entity.AdjustmentApplied, a.CreatedAt.Add(-24*time.Hour)).Scan(&total)
+	if err != nil {
+		return fmt.Errorf("failed to sum adjustments - %s", err.Error())
+	}
+	amount := a.Amount
+	if amount < 0 {
+		amount = -amount
+	}
+	if total+amount > int64(math.Round(r.conf.AdjustmentDailyLimit*100)) {
+		a.Status = entity.AdjustmentPending
+		a.DecidedBy = nil
+		a.DecidedAt = nil
+	}
+	return nil
+}
+
+// DecideAdjustmentDB - метод, записывающий решение по ожидающей корректировке: a.Status, a.DecidedBy и a.DecidedAt,
+// и событие e в журнал аудита. Одобренная корректировка меняет баланс в той же транзакции.
+// Возвращает ErrAdjustmentNotPending, если решение уже принято.
+func (r *Repository) DecideAdjustmentDB(a *entity.Adjustment, e *entity.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.StmtContext(r.ctx, r.stmts["adjustmentsDecide"]).ExecContext(r.ctx,
+		a.ID, a.Status, a.DecidedBy, a.DecidedAt, entity.AdjustmentPending)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAdjustmentNotPending
+	}
+	var balance *entity.Balance
+	if a.Status == entity.AdjustmentApplied {
+		balance, err = r.adjustBalance(tx, a)
+		if err != nil {
+			return err
+		}
+		e.Before = balanceState(balanceBefore(*balance, a.Amount))
+	}
+	e.After = adjustmentState(a, balance)
+	err = r.appendAudit(tx, e)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("decide balance adjustment transaction failed - %s", err.Error())
+	}
+	return nil
+}
+
+// adjustBalance - метод, применяющий корректировку к текущему балансу пользователя в транзакции tx и возвращающий новый баланс.
+// Начисление становится новой партией баллов, списание расходует старые партии.
+// Перед списанием сгорают просроченные партии. Возвращает ErrNotEnoughFunds, если списание увело бы баланс в минус.
+func (r *Repository) adjustBalance(tx *sql.Tx, a *entity.Adjustment) (*entity.Balance, error) {
+	userID := a.UserID
+	now := time.Now()
+	if a.Amount < 0 {
+		b := entity.Balance{}
+		err := tx.StmtContext(r.ctx, r.stmts["balanceGetForUpdate"]).QueryRowContext(r.ctx, userID).Scan(&b.UserID, &b.Current, &b.Withdrawn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user balance - %s", err.Error())
+		}
+		_, err = r.expireDueLots(tx, &b, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, err := tx.StmtContext(r.ctx, r.stmts["balanceAdjust"]).ExecContext(r.ctx, userID, a.Amount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user balance - %s", err.Error())
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rows == 0 {
+		return nil, ErrNotEnoughFunds
+	}
+	if a.Amount > 0 {
+		err = r.addPointLot(tx, userID, lotAdjustment, strconv.FormatUint(a.ID, 10), uint64(a.Amount), now)
+	} else {
+		_, err = r.consumePointLots(tx, userID, uint64(-a.Amount), now)
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := &entity.Balance{}
+	err = tx.StmtContext(r.ctx, r.stmts["balanceGet"]).QueryRowContext(r.ctx, userID).Scan(&b.UserID, &b.Current, &b.Withdrawn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user balance - %s", err.Error())
+	}
+	return b, nil
+}
+
+// balanceBefore - функция, восстанавливающая баланс до корректировки на amount.
+func balanceBefore(after entity.Balance, amount int64) entity.Balance {
+	after.Current = uint64(int64(after.Current) - amount)
+	return after
+}
+
+// adjustmentState - функция, возвращающая для журнала аудита корректировку и, если она применена, баланс после нее.
+func adjustmentState(a *entity.Adjustment, balance *entity.Balance) string {
+	state := struct {
+		UserID  uint64           `json:"user_id"`
+		Amount  float64          `json:"amount"`
+		Reason  string           `json:"reason"`
+		Status  string           `json:"status"`
+		Balance *entity.BalanceX `json:"balance,omitempty"`
+	}{a.UserID, float64(a.Amount) / 100, a.Reason, a.Status, nil}
+	if balance != nil {
+		state.Balance = &entity.BalanceX{
+			Current:   float64(balance.Current) / 100,
+			Withdrawn: float64(balance.Withdrawn) / 100,
+		}
+	}
+	return auditState(state)
+}
+
+// GetAdjustmentDB - метод, возвращающий корректировку баланса по ее ID.
+func (r *Repository) GetAdjustmentDB(id uint64) (entity.Adjustment, error) {
+	a := entity.Adjustment{}
+	row := r.stmts["adjustmentsGet"].QueryRowContext(r.ctx, id)
+	err := scanAdjustment(row, &a)
+	if err == sql.ErrNoRows {
+		return a, ErrAdjustmentNotFound
+	}
+	if err != nil {
+		return a, fmt.Errorf("failed to get balance adjustment - %s", err.Error())
+	}
+	return a, nil
+}
+
+// GetAdjustmentsDB - метод, возвращающий все корректировки баланса пользователя, начиная с новых.
+func (r *Repository) GetAdjustmentsDB(userID uint64) ([]entity.Adjustment, error) {
+	return r.queryAdjustments("adjustmentsGetForUser", userID)
+}
+
+// GetPendingAdjustmentsDB - метод, возвращающий корректировки, ожидающие одобрения, начиная со старых.
+func (r *Repository) GetPendingAdjustmentsDB() ([]entity.Adjustment, error) {
+	return r.queryAdjustments("adjustmentsGetByStatus", entity.AdjustmentPending)
+}
+
+// queryAdjustments - метод, читающий список корректировок баланса стейтментом stmt.
+func (r *Repository) queryAdjustments(stmt string, arg interface{}) ([]entity.Adjustment, error) {
+	list := make([]entity.Adjustment, 0)
+	rows, err := r.stmts[stmt].QueryContext(r.ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a entity.Adjustment
+		err = scanAdjustment(rows, &a)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
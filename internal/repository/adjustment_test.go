@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// adjust - функция, корректирующая баланс пользователя userID на amount баллов от имени сотрудника staffID.
+func adjust(r *Repository, userID, staffID uint64, amount float64) (*entity.AdjustmentX, error) {
+	return r.AdjustBalance(userID, &entity.Actor{UserID: staffID},
+		&entity.AdjustmentRequest{Amount: amount, Reason: "goodwill", Comment: "test"})
+}
+
+func TestAdjustBalanceApproval(t *testing.T) {
+	conf := testConfig()
+	conf.AdjustmentThreshold = 1000
+	conf.AdjustmentDailyLimit = 0
+	r := newTestRepository(t, conf)
+	author := addTestUser(t, r, "admin")
+	approver := addTestUser(t, r, "admin")
+	u := addTestUser(t, r, "user")
+
+	a, err := adjust(r, u.ID, author.ID, 1000)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentApplied, a.Status)
+	require.Equal(t, author.ID, a.DecidedBy)
+	require.Equal(t, uint64(100000), testBalance(t, r, u.ID))
+
+	a, err = adjust(r, u.ID, author.ID, 1000.01)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentPending, a.Status)
+	require.Equal(t, uint64(100000), testBalance(t, r, u.ID))
+
+	_, err = r.ApproveAdjustment(a.ID, &entity.Actor{UserID: author.ID})
+	require.ErrorIs(t, err, ErrSelfApproval)
+	_, err = r.ApproveAdjustment(a.ID, &entity.Actor{UserID: u.ID})
+	require.ErrorIs(t, err, ErrSelfAdjustment)
+	require.Equal(t, uint64(100000), testBalance(t, r, u.ID))
+
+	approved, err := r.ApproveAdjustment(a.ID, &entity.Actor{UserID: approver.ID})
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentApplied, approved.Status)
+	require.Equal(t, approver.ID, approved.DecidedBy)
+	require.Equal(t, uint64(200001), testBalance(t, r, u.ID))
+
+	_, err = r.ApproveAdjustment(a.ID, &entity.Actor{UserID: approver.ID})
+	require.ErrorIs(t, err, ErrAdjustmentNotPending)
+
+	// Свою корректировку можно отклонить.
+	a, err = adjust(r, u.ID, author.ID, -5000)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentPending, a.Status)
+	rejected, err := r.RejectAdjustment(a.ID, &entity.Actor{UserID: author.ID})
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentRejected, rejected.Status)
+	require.Equal(t, uint64(200001), testBalance(t, r, u.ID))
+}
+
+func TestAdjustBalanceSelf(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	admin := addTestUser(t, r, "admin")
+
+	_, err := adjust(r, admin.ID, admin.ID, 10)
+	require.ErrorIs(t, err, ErrSelfAdjustment)
+	require.Equal(t, uint64(0), testBalance(t, r, admin.ID))
+}
+
+func TestAdjustBalanceNotEnoughFunds(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	admin := addTestUser(t, r, "admin")
+	u := addTestUser(t, r, "user")
+	creditTestUser(t, r, u.ID, 500, nil)
+
+	_, err := adjust(r, u.ID, admin.ID, -10)
+	require.ErrorIs(t, err, ErrNotEnoughFunds)
+	a, err := adjust(r, u.ID, admin.ID, -2)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentApplied, a.Status)
+	require.Equal(t, uint64(300), testBalance(t, r, u.ID))
+}
+
+func TestAdjustmentDailyLimit(t *testing.T) {
+	conf := testConfig()
+	conf.AdjustmentThreshold = 1000
+	conf.AdjustmentDailyLimit = 1500
+	r := newTestRepository(t, conf)
+	admin := addTestUser(t, r, "admin")
+	other := addTestUser(t, r, "admin")
+	u := addTestUser(t, r, "user")
+
+	a, err := adjust(r, u.ID, admin.ID, 1000)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentApplied, a.Status)
+	// Списания учитываются в лимите по модулю.
+	a, err = adjust(r, u.ID, admin.ID, -400)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentApplied, a.Status)
+	a, err = adjust(r, u.ID, admin.ID, 200)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentPending, a.Status)
+	require.Equal(t, uint64(60000), testBalance(t, r, u.ID))
+
+	// Лимит считается по автору: у другого администратора он свой.
+	b, err := adjust(r, u.ID, other.ID, 200)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentApplied, b.Status)
+
+	// Одобренная другим администратором корректировка не расходует лимит автора.
+	_, err = r.ApproveAdjustment(a.ID, &entity.Actor{UserID: other.ID})
+	require.NoError(t, err)
+	require.Equal(t, uint64(100000), testBalance(t, r, u.ID))
+	a, err = adjust(r, u.ID, admin.ID, 100)
+	require.NoError(t, err)
+	require.Equal(t, entity.AdjustmentApplied, a.Status)
+}
@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// apiKeysColumns - порядок колонок таблицы API-ключей, в котором их читает scanAPIKey.
+const apiKeysColumns = "id, user_id, name, prefix, key, scopes, expiry, created_at, last_used_at, revoked_at"
+
+// initAPIKeys - метод, создающий таблицу API-ключей, если ее нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initAPIKeys(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS api_keys (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				name varchar NOT NULL,
+				prefix varchar NOT NULL,
+				key varchar NOT NULL UNIQUE,
+				scopes varchar NOT NULL,
+				expiry timestamptz NOT NULL,
+				created_at timestamptz NOT NULL DEFAULT now(),
+				last_used_at timestamptz,
+				revoked_at timestamptz)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS api_keys_user_id_idx ON api_keys (user_id)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table api_keys created")
+	err = r.initAPIKeysStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initAPIKeysStatements - метод, подготавливающий стейтменты БД для работы с API-ключами.
+func (r *Repository) initAPIKeysStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO api_keys (user_id, name, prefix, key, scopes, expiry, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+apiKeysColumns+" FROM api_keys WHERE key=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+apiKeysColumns+" FROM api_keys WHERE user_id=$1 AND revoked_at IS NULL ORDER BY created_at DESC",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysGetForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE api_keys SET last_used_at = $2 WHERE id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysTouch"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE api_keys SET revoked_at = $3 WHERE user_id = $1 AND id = $2 AND revoked_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysRevoke"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE api_keys SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysRevokeForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM api_keys WHERE user_id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysDeleteForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM api_keys WHERE expiry < $1 OR revoked_at IS NOT NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["apiKeysDeleteExpired"] = stmt
+	return nil
+}
+
+// scanAPIKey - функция, читающая API-ключ из строки результата в порядке apiKeysColumns.
+func scanAPIKey(row scanner, k *entity.APIKey) error {
+	var scopes string
+	err := row.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.Key, &scopes, &k.Expiry, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt)
+	if err != nil {
+		return err
+	}
+	k.Scopes = strings.Split(scopes, ",")
+	return nil
+}
+
+// hashAPIKey - функция, возвращающая хэш API-ключа для хранения в БД.
+// Ключ случайный и длинный, поэтому хэшируется без секрета: ротация SESSION_TOKEN_KEYS не должна делать ключи недействительными.
+func hashAPIKey(key string) string {
+	return hashToken(nil, key)
+}
+
+// AddAPIKeyDB - метод, добавляющий API-ключ в БД. Вместо ключа сохраняется его хэш.
+func (r *Repository) AddAPIKeyDB(k *entity.APIKey) error {
+	row := r.stmts["apiKeysInsert"].QueryRowContext(r.ctx, k.UserID, k.Name, k.Prefix, hashAPIKey(k.Key),
+		strings.Join(k.Scopes, ","), k.Expiry, k.CreatedAt)
+	return row.Scan(&k.ID)
+}
+
+// GetAPIKeyDB - метод, возвращающий API-ключ по его значению, включая отозванные.
+func (r *Repository) GetAPIKeyDB(key string) (entity.APIKey, error) {
+	k := entity.APIKey{}
+	row := r.stmts["apiKeysGet"].QueryRowContext(r.ctx, hashAPIKey(key))
+	err := scanAPIKey(row, &k)
+	if err == sql.ErrNoRows {
+		return k, ErrAPIKeyInvalid
+	}
+	if err != nil {
+		return k, fmt.Errorf("failed to get API key - %s", err.Error())
+	}
+	return k, nil
+}
+
+// GetAPIKeysDB - метод, возвращающий неотозванные API-ключи пользователя, начиная с новых.
+func (r *Repository) GetAPIKeysDB(userID uint64) ([]entity.APIKey, error) {
+	keys := make([]entity.APIKey, 0)
+	rows, err := r.stmts["apiKeysGetForUser"].QueryContext(r.ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k entity.APIKey
+		err = scanAPIKey(rows, &k)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// TouchAPIKeyDB - метод, обновляющий в БД время последнего использования API-ключа.
+func (r *Repository) TouchAPIKeyDB(id uint64, at time.Time) error {
+	_, err := r.stmts["apiKeysTouch"].ExecContext(r.ctx, id, at)
+	return err
+}
+
+// RevokeAPIKeyDB - метод, отзывающий API-ключ пользователя по его ID.
+func (r *Repository) RevokeAPIKeyDB(userID, id uint64, at time.Time) error {
+	res, err := r.stmts["apiKeysRevoke"].ExecContext(r.ctx, userID, id, at)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// RevokeUserAPIKeysDB - метод, отзывающий все действующие API-ключи пользователя. Возвращает число отозванных ключей.
+func (r *Repository) RevokeUserAPIKeysDB(userID uint64, at time.Time) (int64, error) {
+	res, err := r.stmts["apiKeysRevokeForUser"].ExecContext(r.ctx, userID, at)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// DeleteExpiredAPIKeysDB - метод, удаляющий из БД API-ключи, истекшие к моменту now или отозванные.
+func (r *Repository) DeleteExpiredAPIKeysDB(now time.Time) (int64, error) {
+	res, err := r.stmts["apiKeysDeleteExpired"].ExecContext(r.ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// Причины в журнале попыток входа.
+const (
+	attemptSuccess         = "success"
+	attemptUnknownLogin    = "unknown_login"
+	attemptInvalidPassword = "invalid_password"
+	attemptLocked          = "locked"
+	attemptMFARequired     = "mfa_required"
+	attemptInvalidMFACode  = "invalid_mfa_code"
+	attemptDisabled        = "disabled"
+)
+
+// initLoginAttempts - метод, создающий таблицу журнала попыток входа, если ее нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initLoginAttempts(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS login_attempts (
+				id bigserial PRIMARY KEY,
+				login varchar NOT NULL,
+				user_id bigint,
+				ip varchar NOT NULL DEFAULT '',
+				user_agent varchar NOT NULL DEFAULT '',
+				success boolean NOT NULL,
+				reason varchar NOT NULL,
+				created_at timestamptz NOT NULL DEFAULT now())`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS login_attempts_login_idx ON login_attempts (login, created_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON login_attempts (ip, created_at)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table login_attempts created")
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO login_attempts (login, user_id, ip, user_agent, success, reason, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["loginAttemptsInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE login_attempts SET login = $3, ip = '', user_agent = '' WHERE user_id = $1 OR login = $2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["loginAttemptsAnonymize"] = stmt
+	return nil
+}
+
+// AddLoginAttemptDB - метод, записывающий попытку входа в журнал, а событие e, если оно есть, - в журнал аудита.
+func (r *Repository) AddLoginAttemptDB(a *entity.LoginAttempt, e *entity.AuditEvent) error {
+	var userID interface{}
+	if a.UserID != 0 {
+		userID = a.UserID
+	}
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.StmtContext(r.ctx, r.stmts["loginAttemptsInsert"]).ExecContext(r.ctx, a.Login, userID, a.IP, a.UserAgent, a.Success, a.Reason, a.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if e != nil {
+		err = r.appendAudit(tx, e)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
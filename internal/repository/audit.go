@@ -0,0 +1,257 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/audit"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// auditEventsColumns - порядок колонок журнала аудита, в котором их читает scanAuditEvent.
+const auditEventsColumns = "id, chain, created_at, actor_id, action, target_type, target_id, before, after, request_id, ip, prev_hash, hash"
+
+// auditLockKeys - ключи advisory-блокировок, по очереди пропускающих транзакции к концу каждой цепочки хэшей.
+var auditLockKeys = map[string]int64{
+	entity.AuditChainMain: 0x617564697400,
+	entity.AuditChainAuth: 0x617574680000,
+}
+
+// initAudit - метод, создающий журнал аудита, если его нет, и запрещающий изменять и удалять его записи.
+// Подготавливает стейтменты для базы данных.
+func (r *Repository) initAudit(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS audit_events (
+				id bigserial PRIMARY KEY,
+				chain varchar NOT NULL DEFAULT 'main',
+				created_at timestamptz NOT NULL,
+				actor_id bigint NOT NULL,
+				action varchar NOT NULL,
+				target_type varchar NOT NULL,
+				target_id varchar NOT NULL,
+				before text NOT NULL,
+				after text NOT NULL,
+				request_id varchar NOT NULL,
+				ip varchar NOT NULL,
+				prev_hash varchar NOT NULL,
+				hash varchar NOT NULL UNIQUE)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS chain varchar NOT NULL DEFAULT 'main'`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS audit_events_chain_idx ON audit_events (chain, id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS audit_events_target_idx ON audit_events (target_type, target_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS audit_events_actor_id_idx ON audit_events (actor_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_events is append-only';
+			END;
+			$$ LANGUAGE plpgsql`)
+	if err != nil {
+		return err
+	}
+	for _, query := range []string{
+		`DROP TRIGGER IF EXISTS audit_events_no_change ON audit_events`,
+		`CREATE TRIGGER audit_events_no_change BEFORE UPDATE OR DELETE ON audit_events
+			FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only()`,
+		`DROP TRIGGER IF EXISTS audit_events_no_truncate ON audit_events`,
+		`CREATE TRIGGER audit_events_no_truncate BEFORE TRUNCATE ON audit_events
+			FOR EACH STATEMENT EXECUTE PROCEDURE audit_events_append_only()`,
+	} {
+		_, err = r.db.ExecContext(ctx, query)
+		if err != nil {
+			return err
+		}
+	}
+	log.Debug().Msg("table audit_events created")
+	err = r.initAuditStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initAuditStatements - метод, подготавливающий стейтменты БД для работы с журналом аудита.
+func (r *Repository) initAuditStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"SELECT pg_advisory_xact_lock($1)",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["auditLock"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT hash FROM audit_events WHERE chain=$1 ORDER BY id DESC LIMIT 1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["auditLastHash"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO audit_events (chain, created_at, actor_id, action, target_type, target_id, before, after, request_id, ip, prev_hash, hash)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["auditInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`SELECT `+auditEventsColumns+` FROM audit_events
+			WHERE id > $1
+				AND ($2::bigint IS NULL OR actor_id = $2)
+				AND ($3::varchar = '' OR action = $3)
+				AND ($4::varchar = '' OR target_type = $4)
+				AND ($5::varchar = '' OR target_id = $5)
+				AND ($6::timestamptz IS NULL OR created_at >= $6)
+				AND ($7::timestamptz IS NULL OR created_at < $7)
+			ORDER BY id LIMIT $8`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["auditQuery"] = stmt
+	return nil
+}
+
+// scanAuditEvent - функция, читающая событие аудита из строки результата в порядке auditEventsColumns.
+func scanAuditEvent(row scanner, e *entity.AuditEvent) error {
+	return row.Scan(&e.ID, &e.Chain, &e.CreatedAt, &e.ActorID, &e.Action, &e.TargetType, &e.TargetID,
+		&e.Before, &e.After, &e.RequestID, &e.IP, &e.PrevHash, &e.Hash)
+}
+
+// appendAudit - метод, дописывающий событие в конец его цепочки журнала аудита (по умолчанию основной)
+// в транзакции tx, меняющей данные. Блокировка держится до конца транзакции, поэтому вызывать его стоит последним перед Commit.
+func (r *Repository) appendAudit(tx *sql.Tx, e *entity.AuditEvent) error {
+	if e.Chain == "" {
+		e.Chain = entity.AuditChainMain
+	}
+	key, ok := auditLockKeys[e.Chain]
+	if !ok {
+		return fmt.Errorf("unknown audit chain `%s`", e.Chain)
+	}
+	_, err := tx.StmtContext(r.ctx, r.stmts["auditLock"]).ExecContext(r.ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to lock audit log - %s", err.Error())
+	}
+	prev := ""
+	err = tx.StmtContext(r.ctx, r.stmts["auditLastHash"]).QueryRowContext(r.ctx, e.Chain).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to get last audit event - %s", err.Error())
+	}
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.CreatedAt = e.CreatedAt.Truncate(audit.Precision)
+	e.PrevHash = prev
+	e.Hash = audit.Hash(prev, e)
+	row := tx.StmtContext(r.ctx, r.stmts["auditInsert"]).QueryRowContext(r.ctx, e.Chain, e.CreatedAt, e.ActorID, e.Action,
+		e.TargetType, e.TargetID, e.Before, e.After, e.RequestID, e.IP, e.PrevHash, e.Hash)
+	err = row.Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("failed to add audit event - %s", err.Error())
+	}
+	return nil
+}
+
+// auditState - функция, сериализующая состояние объекта до или после действия для журнала аудита.
+func auditState(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		log.Error().Err(err).Msg("failed to marshal audit state")
+		return ""
+	}
+	return string(b)
+}
+
+// balanceState - функция, возвращающая баланс для журнала аудита в баллах.
+func balanceState(b entity.Balance) string {
+	return auditState(entity.BalanceX{
+		Current:   float64(b.Current) / 100,
+		Withdrawn: float64(b.Withdrawn) / 100,
+	})
+}
+
+// AddAuditEventDB - метод, записывающий в журнал аудита событие, не связанное с изменением данных в той же БД.
+func (r *Repository) AddAuditEventDB(e *entity.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = r.appendAudit(tx, e)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAuditEventsDB - метод, возвращающий события журнала аудита по фильтру в порядке записи.
+func (r *Repository) GetAuditEventsDB(f *entity.AuditFilter) ([]entity.AuditEvent, error) {
+	var actorID interface{}
+	if f.ActorID != nil {
+		actorID = *f.ActorID
+	}
+	var from, to interface{}
+	if f.From != nil {
+		from = *f.From
+	}
+	if f.To != nil {
+		to = *f.To
+	}
+	events := make([]entity.AuditEvent, 0)
+	rows, err := r.stmts["auditQuery"].QueryContext(r.ctx, f.AfterID, actorID, f.Action, f.TargetType, f.TargetID, from, to, f.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e entity.AuditEvent
+		err = scanAuditEvent(rows, &e)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// auditEvent - функция, собирающая событие журнала аудита от имени actor.
+func auditEvent(actor *entity.Actor, action, targetType string, targetID uint64) *entity.AuditEvent {
+	e := &entity.AuditEvent{
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   strconv.FormatUint(targetID, 10),
+	}
+	if actor != nil {
+		e.ActorID = actor.UserID
+		e.IP = actor.IP
+		e.RequestID = actor.RequestID
+	}
+	return e
+}
@@ -46,6 +46,15 @@ func (r *Repository) initBalanceStatements() error {
 		return err
 	}
 	r.stmts["balanceGet"] = stmt
+	// Чтение с блокировкой для транзакций, которые пересчитывают баланс целиком.
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT * FROM balance WHERE user_id=$1 FOR UPDATE",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["balanceGetForUpdate"] = stmt
 	stmt, err = r.db.PrepareContext(
 		r.ctx,
 		"UPDATE balance SET current = $2, withdrawn = $3 WHERE user_id = $1",
@@ -54,6 +63,15 @@ func (r *Repository) initBalanceStatements() error {
 		return err
 	}
 	r.stmts["balanceUpdate"] = stmt
+	// Изменение на месте: баланс не уходит в минус и не теряет параллельные начисления.
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE balance SET current = current + $2 WHERE user_id = $1 AND current + $2 >= 0",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["balanceAdjust"] = stmt
 	return nil
 }
 
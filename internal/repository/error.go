@@ -1,6 +1,11 @@
 package repository
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
 
 var (
 	ErrLoginAlreadyTaken  = errors.New("login already taken")
@@ -8,13 +13,85 @@ var (
 	ErrInvalidPair        = errors.New("invalid pair: login/password")
 	ErrUnauthorizedAccess = errors.New("unauthorized access detected: incident will be reported")
 	ErrSessionNotFound    = errors.New("session not found")
+	ErrSessionExpired     = errors.New("session has expired")
+
+	ErrTooManyLoginAttempts = errors.New("too many login attempts")
+	ErrAccountLocked        = errors.New("account is temporarily locked after failed login attempts")
+
+	ErrAccessTokenInvalid  = errors.New("invalid access token")
+	ErrAccessTokenExpired  = errors.New("access token has expired")
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token has expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected: all tokens of this login revoked")
+
+	ErrInvalidPassword   = errors.New("current password is incorrect")
+	ErrResetTokenInvalid = errors.New("invalid or already used password reset token")
+	ErrResetTokenExpired = errors.New("password reset token has expired")
+
+	ErrMFARequired         = errors.New("two-factor authentication code required")
+	ErrInvalidMFACode      = errors.New("invalid two-factor authentication code")
+	ErrMFAChallengeInvalid = errors.New("invalid or expired two-factor authentication challenge")
+	ErrTOTPAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled      = errors.New("two-factor authentication is not enabled")
+
+	ErrAPIKeyInvalid      = errors.New("invalid or revoked API key")
+	ErrAPIKeyExpired      = errors.New("API key has expired")
+	ErrAPIKeyNotFound     = errors.New("API key not found")
+	ErrInsufficientScope  = errors.New("API key does not grant access to this operation")
+	ErrAPIKeyNotPermitted = errors.New("operation is not available with an API key")
+
+	ErrAccountDisabled  = errors.New("account is disabled")
+	ErrForbidden        = errors.New("insufficient role for this operation")
+	ErrCannotModifySelf = errors.New("cannot change role or status of own account")
+
+	ErrAdjustmentNotFound   = errors.New("balance adjustment not found")
+	ErrAdjustmentNotPending = errors.New("balance adjustment already decided")
+	ErrSelfApproval         = errors.New("balance adjustment must be approved by another admin")
+	ErrSelfAdjustment       = errors.New("cannot adjust own balance")
+
+	ErrTiersDisabled = errors.New("loyalty tiers are disabled")
+
+	ErrReferralCodeNotFound = errors.New("referral code not found")
+
+	ErrTransferLimitExceeded = errors.New("daily transfer limit exceeded")
+	ErrIdempotencyKeyReused  = errors.New("idempotency key already used for a different request")
 
 	ErrOrderAlreadyLoadedByUser        = errors.New("the order number has already been uploaded by this user")
 	ErrOrderAlreadyLoadedByAnotherUser = errors.New("the order number has already been uploaded by another user")
 	ErrOrderInvalidFormat              = errors.New("invalid order number format")
+	ErrOrderUploadTooLarge             = errors.New("order upload has too many lines")
+	ErrOrderUploadNotFound             = errors.New("order upload not found")
 
 	ErrTooManyRequests = errors.New("too many requests")
 	ErrNoContent       = errors.New("no content")
 
-	ErrNotEnoughFunds = errors.New("not enough funds on account")
+	ErrNotEnoughFunds     = errors.New("not enough funds on account")
+	ErrPointLotsShortfall = errors.New("point lots do not cover balance")
 )
+
+// RetryAfterError - ошибка, после которой запрос можно повторить не раньше, чем через RetryAfter.
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// MFARequiredError - ошибка входа, для завершения которого нужен второй фактор. Challenge передается клиенту.
+type MFARequiredError struct {
+	Challenge *entity.MFAChallenge
+}
+
+func (e *MFARequiredError) Error() string {
+	return ErrMFARequired.Error()
+}
+
+func (e *MFARequiredError) Unwrap() error {
+	return ErrMFARequired
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+type Expirer struct {
+	storage  entity.Storager
+	interval time.Duration
+}
+
+// NewExpirer - конструктор фонового сгорания просроченных баллов.
+func NewExpirer(st entity.Storager, interval time.Duration) *Expirer {
+	return &Expirer{
+		storage:  st,
+		interval: interval,
+	}
+}
+
+// Start - запуск периодического сгорания баллов до отмены контекста. Первый проход выполняется сразу.
+func (e *Expirer) Start(ctx context.Context) {
+	if e.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		e.expire()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire - метод, выполняющий один проход сгорания.
+func (e *Expirer) expire() {
+	n, err := e.storage.ExpirePoints()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to expire points")
+		return
+	}
+	if n > 0 {
+		log.Debug().Int64("users", n).Msg("expired points burned")
+	}
+}
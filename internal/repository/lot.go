@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// Источники партий баллов.
+const (
+	lotAccrual    = "accrual"
+	lotAdjustment = "adjustment"
+	// lotOpening - остаток баланса, начисленный до появления партий. Он не сгорает.
+	lotOpening = "opening"
+)
+
+// pointLotsColumns - порядок колонок таблицы партий баллов, в котором их читает scanPointLot.
+const pointLotsColumns = "id, user_id, source, source_id, amount, remaining, created_at, expires_at"
+
+// initPointLots - метод, создающий таблицы партий баллов и их сгорания, если их нет.
+// Баланс, накопленный до появления партий, переносится в бессрочную партию. Подготавливает стейтменты для базы данных.
+func (r *Repository) initPointLots(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS point_lots (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				source varchar NOT NULL,
+				source_id varchar NOT NULL,
+				amount bigint NOT NULL,
+				remaining bigint NOT NULL,
+				created_at timestamptz NOT NULL,
+				expires_at timestamptz)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS point_lots_user_id_idx ON point_lots (user_id, id) WHERE remaining > 0`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS point_lots_expires_at_idx ON point_lots (expires_at) WHERE remaining > 0`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS point_expirations (
+				id bigserial PRIMARY KEY,
+				lot_id bigint NOT NULL,
+				user_id bigint NOT NULL,
+				amount bigint NOT NULL,
+				expired_at timestamptz NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS point_expirations_user_id_idx ON point_expirations (user_id)`)
+	if err != nil {
+		return err
+	}
+	// Перенос разовый: пользователю, у которого уже есть хоть одна партия, все начисления записываются партиями,
+	// и расхождение баланса с партиями - ошибка, а не повод начислить бессрочные баллы.
+	res, err := r.db.ExecContext(ctx, `
+			INSERT INTO point_lots (user_id, source, source_id, amount, remaining, created_at)
+			SELECT b.user_id, $1, '', b.current, b.current, now()
+			FROM balance b
+			WHERE b.current > 0 AND NOT EXISTS (SELECT 1 FROM point_lots l WHERE l.user_id = b.user_id)`, lotOpening)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		log.Info().Int64("count", n).Msg("opening point lots created")
+	}
+	log.Debug().Msg("table point_lots created")
+	err = r.initPointLotsStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initPointLotsStatements - метод, подготавливающий стейтменты БД для работы с партиями баллов.
+func (r *Repository) initPointLotsStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO point_lots (user_id, source, source_id, amount, remaining, created_at, expires_at)
+			VALUES ($1, $2, $3, $4, $4, $5, $6)`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointLotsInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`SELECT `+pointLotsColumns+` FROM point_lots
+			WHERE user_id = $1 AND remaining > 0 AND (expires_at IS NULL OR expires_at > $2)
+			ORDER BY expires_at NULLS LAST, id FOR UPDATE`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointLotsGetOpen"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE point_lots SET remaining = remaining - $2 WHERE id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointLotsConsume"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`SELECT `+pointLotsColumns+` FROM point_lots
+			WHERE user_id = $1 AND remaining > 0 AND expires_at > $2 AND expires_at < $3 ORDER BY expires_at`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointLotsGetExpiring"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT DISTINCT user_id FROM point_lots WHERE remaining > 0 AND expires_at <= $1 LIMIT $2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointLotsGetExpiredUsers"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+pointLotsColumns+" FROM point_lots WHERE user_id = $1 AND remaining > 0 AND expires_at <= $2 ORDER BY id FOR UPDATE",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointLotsGetExpired"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO point_expirations (lot_id, user_id, amount, expired_at) VALUES ($1, $2, $3, $4)",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointExpirationsInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT id, lot_id, user_id, amount, expired_at FROM point_expirations WHERE user_id = $1 ORDER BY expired_at DESC",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["pointExpirationsGetForUser"] = stmt
+	return nil
+}
+
+// scanPointLot - функция, читающая партию баллов из строки результата в порядке pointLotsColumns.
+func scanPointLot(row scanner, l *entity.PointLot) error {
+	return row.Scan(&l.ID, &l.UserID, &l.Source, &l.SourceID, &l.Amount, &l.Remaining, &l.CreatedAt, &l.ExpiresAt)
+}
+
+// queryPointLots - метод, читающий список партий баллов стейтментом stmt в транзакции tx.
+func (r *Repository) queryPointLots(tx *sql.Tx, stmt string, args ...interface{}) ([]entity.PointLot, error) {
+	rows, err := r.txStmt(tx, stmt).QueryContext(r.ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	lots := make([]entity.PointLot, 0)
+	for rows.Next() {
+		var l entity.PointLot
+		err = scanPointLot(rows, &l)
+		if err != nil {
+			return nil, err
+		}
+		lots = append(lots, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+// addPointLot - метод, добавляющий в транзакции tx партию из amount баллов, начисленных в at.
+// Срок жизни партии задает POINTS_EXPIRY_MONTHS, при нуле баллы бессрочные.
+func (r *Repository) addPointLot(tx *sql.Tx, userID uint64, source, sourceID string, amount uint64, at time.Time) error {
+	if amount == 0 {
+		return nil
+	}
+	var expiresAt *time.Time
+	if r.conf.PointsExpiryMonths > 0 {
+		t := at.AddDate(0, r.conf.PointsExpiryMonths, 0)
+		expiresAt = &t
+	}
+	return r.insertPointLot(tx, userID, source, sourceID, amount, at, expiresAt)
+}
+
+// insertPointLot - метод, добавляющий в транзакции tx партию баллов с заданным сроком сгорания, nil - бессрочную.
+func (r *Repository) insertPointLot(tx *sql.Tx, userID uint64, source, sourceID string, amount uint64, at time.Time, expiresAt *time.Time) error {
+	_, err := tx.StmtContext(r.ctx, r.stmts["pointLotsInsert"]).ExecContext(r.ctx, userID, source, sourceID, amount, at, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to add point lot - %s", err.Error())
+	}
+	return nil
+}
+
+// consumePointLots - метод, расходующий в транзакции tx amount баллов из несгоревших к моменту now партий пользователя,
+// начиная с тех, что сгорают раньше, а среди бессрочных и сгорающих одновременно - с самых старых.
+// Просроченные партии вызывающий сжигает заранее через expireDueLots.
+// Возвращает израсходованные части партий: Amount каждой - сколько из нее взято.
+// Если партий не хватает, баланс разошелся с партиями: возвращается ErrPointLotsShortfall, и транзакция должна откатиться.
+func (r *Repository) consumePointLots(tx *sql.Tx, userID, amount uint64, now time.Time) ([]entity.PointLot, error) {
+	lots, err := r.queryPointLots(tx, "pointLotsGetOpen", userID, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get point lots - %s", err.Error())
+	}
+	consumed := make([]entity.PointLot, 0)
+	txConsume := tx.StmtContext(r.ctx, r.stmts["pointLotsConsume"])
+	for _, l := range lots {
+		if amount == 0 {
+			break
+		}
+		take := l.Remaining
+		if take > amount {
+			take = amount
+		}
+		_, err = txConsume.ExecContext(r.ctx, l.ID, take)
+		if err != nil {
+			return nil, fmt.Errorf("failed to consume point lot - %s", err.Error())
+		}
+		amount -= take
+		l.Amount = take
+		l.Remaining -= take
+		consumed = append(consumed, l)
+	}
+	if amount > 0 {
+		return nil, fmt.Errorf("%w - user %d, %d uncovered", ErrPointLotsShortfall, userID, amount)
+	}
+	return consumed, nil
+}
+
+// GetExpiringPointsDB - метод, возвращающий партии пользователя с остатком, сгорающие после now и до before, начиная с ближайших.
+// Уже просроченные партии, которые еще не успело списать фоновое сгорание, не возвращаются.
+func (r *Repository) GetExpiringPointsDB(userID uint64, now, before time.Time) ([]entity.PointLot, error) {
+	return r.queryPointLots(nil, "pointLotsGetExpiring", userID, now, before)
+}
+
+// GetExpiredUsersDB - метод, возвращающий до limit пользователей, у которых к моменту now есть несгоревшие просроченные партии.
+func (r *Repository) GetExpiredUsersDB(now time.Time, limit int) ([]uint64, error) {
+	rows, err := r.stmts["pointLotsGetExpiredUsers"].QueryContext(r.ctx, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := make([]uint64, 0)
+	for rows.Next() {
+		var id uint64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// ExpirePointsDB - метод, в одной транзакции сжигающий остатки просроченных к моменту now партий пользователя.
+// Возвращает сгоревшую сумму.
+func (r *Repository) ExpirePointsDB(userID uint64, now time.Time) (uint64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	// Баланс блокируется первым, как и при списании, чтобы транзакции не ждали друг друга по кругу.
+	b := entity.Balance{}
+	err = tx.StmtContext(r.ctx, r.stmts["balanceGetForUpdate"]).QueryRowContext(r.ctx, userID).Scan(&b.UserID, &b.Current, &b.Withdrawn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user balance - %s", err.Error())
+	}
+	expired, err := r.expireDueLots(tx, &b, now)
+	if err != nil {
+		return 0, err
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("expire points transaction failed - %s", err.Error())
+	}
+	return expired, nil
+}
+
+// expireDueLots - метод, сжигающий в транзакции tx остатки просроченных к моменту now партий пользователя
+// с уже заблокированным балансом b: уменьшает b и баланс в БД, обнуляет партии, записывает сгорания и событие
+// в журнал аудита. Списания вызывают его перед проверкой баланса, чтобы не тратить баллы, которые фоновое сгорание
+// еще не успело сжечь. Возвращает сгоревшую сумму.
+func (r *Repository) expireDueLots(tx *sql.Tx, b *entity.Balance, now time.Time) (uint64, error) {
+	userID := b.UserID
+	lots, err := r.queryPointLots(tx, "pointLotsGetExpired", userID, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get expired point lots - %s", err.Error())
+	}
+	txConsume := tx.StmtContext(r.ctx, r.stmts["pointLotsConsume"])
+	txExpire := tx.StmtContext(r.ctx, r.stmts["pointExpirationsInsert"])
+	var expired uint64
+	for _, l := range lots {
+		_, err = txConsume.ExecContext(r.ctx, l.ID, l.Remaining)
+		if err != nil {
+			return 0, fmt.Errorf("failed to expire point lot - %s", err.Error())
+		}
+		_, err = txExpire.ExecContext(r.ctx, l.ID, userID, l.Remaining, now)
+		if err != nil {
+			return 0, fmt.Errorf("failed to add point expiration - %s", err.Error())
+		}
+		expired += l.Remaining
+	}
+	if expired == 0 {
+		return 0, nil
+	}
+	current := uint64(0)
+	if b.Current > expired {
+		current = b.Current - expired
+	}
+	_, err = tx.StmtContext(r.ctx, r.stmts["balanceUpdate"]).ExecContext(r.ctx, userID, current, b.Withdrawn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update user balance - %s", err.Error())
+	}
+	e := auditEvent(nil, entity.AuditExpire, entity.AuditTargetUser, userID)
+	e.Before = balanceState(*b)
+	b.Current = current
+	e.After = balanceState(*b)
+	err = r.appendAudit(tx, e)
+	if err != nil {
+		return 0, err
+	}
+	return expired, nil
+}
+
+// GetPointExpirationsDB - метод, возвращающий сгорания баллов пользователя, начиная с новых.
+func (r *Repository) GetPointExpirationsDB(userID uint64) ([]entity.PointExpiration, error) {
+	rows, err := r.stmts["pointExpirationsGetForUser"].QueryContext(r.ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]entity.PointExpiration, 0)
+	for rows.Next() {
+		var p entity.PointExpiration
+		err = rows.Scan(&p.ID, &p.LotID, &p.UserID, &p.Amount, &p.ExpiredAt)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
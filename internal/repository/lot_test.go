@@ -0,0 +1,145 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// testLots - функция, возвращающая все партии пользователя в порядке начисления.
+func testLots(t *testing.T, r *Repository, userID uint64) []entity.PointLot {
+	t.Helper()
+	rows, err := r.db.QueryContext(r.ctx, "SELECT "+pointLotsColumns+" FROM point_lots WHERE user_id = $1 ORDER BY id", userID)
+	require.NoError(t, err)
+	defer rows.Close()
+	lots := make([]entity.PointLot, 0)
+	for rows.Next() {
+		var l entity.PointLot
+		require.NoError(t, scanPointLot(rows, &l))
+		lots = append(lots, l)
+	}
+	require.NoError(t, rows.Err())
+	return lots
+}
+
+// lotRemainders - функция, возвращающая остатки партий.
+func lotRemainders(lots []entity.PointLot) []uint64 {
+	remaining := make([]uint64, 0, len(lots))
+	for _, l := range lots {
+		remaining = append(remaining, l.Remaining)
+	}
+	return remaining
+}
+
+// adjustTestBalance - функция, меняющая баланс пользователя на amount копеек без партии, как баланс до появления партий.
+func adjustTestBalance(t *testing.T, r *Repository, userID uint64, amount int64) {
+	t.Helper()
+	_, err := r.stmts["balanceAdjust"].ExecContext(r.ctx, userID, amount)
+	require.NoError(t, err)
+	r.forgetBalance(userID)
+}
+
+func TestConsumePointLots(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	u := addTestUser(t, r, "lots")
+	now := time.Now()
+	soon, later, past := now.Add(24*time.Hour), now.Add(48*time.Hour), now.Add(-time.Hour)
+	creditTestUser(t, r, u.ID, 1000, nil)
+	creditTestUser(t, r, u.ID, 500, &later)
+	creditTestUser(t, r, u.ID, 300, &soon)
+	// Просроченная партия, которую фоновое сгорание еще не сожгло.
+	creditTestUser(t, r, u.ID, 200, &past)
+
+	tx, err := r.db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	consumed, err := r.consumePointLots(tx, u.ID, 1000, now)
+	require.NoError(t, err)
+	taken := make([]uint64, 0, len(consumed))
+	for _, l := range consumed {
+		taken = append(taken, l.Amount)
+	}
+	// Сначала партии, которые сгорают раньше, бессрочная - последней.
+	require.Equal(t, []uint64{300, 500, 200}, taken)
+	require.NoError(t, tx.Commit())
+	require.Equal(t, []uint64{800, 0, 0, 200}, lotRemainders(testLots(t, r, u.ID)))
+
+	// Просроченная партия не покрывает нехватку.
+	tx, err = r.db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = r.consumePointLots(tx, u.ID, 900, now)
+	require.ErrorIs(t, err, ErrPointLotsShortfall)
+}
+
+func TestWithdrawBurnsExpiredLots(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	u := addTestUser(t, r, "lots")
+	past := time.Now().Add(-time.Hour)
+	creditTestUser(t, r, u.ID, 500, &past)
+	creditTestUser(t, r, u.ID, 300, nil)
+	withdraw := func(sum uint64) error {
+		orderID := uint64(time.Now().UnixNano())
+		return r.AddWithdrawDB(&entity.Withdraw{OrderID: orderID, UserID: u.ID, Sum: sum},
+			auditEvent(&entity.Actor{UserID: u.ID}, entity.AuditWithdraw, entity.AuditTargetOrder, orderID))
+	}
+
+	require.ErrorIs(t, withdraw(400), ErrNotEnoughFunds)
+	require.Equal(t, uint64(800), testBalance(t, r, u.ID))
+
+	require.NoError(t, withdraw(200))
+	require.Equal(t, uint64(100), testBalance(t, r, u.ID))
+	require.Equal(t, []uint64{0, 100}, lotRemainders(testLots(t, r, u.ID)))
+	expirations, err := r.GetPointExpirationsDB(u.ID)
+	require.NoError(t, err)
+	require.Len(t, expirations, 1)
+	require.Equal(t, uint64(500), expirations[0].Amount)
+}
+
+func TestExpirePoints(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	u := addTestUser(t, r, "lots")
+	past, later := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	creditTestUser(t, r, u.ID, 500, &past)
+	creditTestUser(t, r, u.ID, 300, &later)
+	creditTestUser(t, r, u.ID, 100, nil)
+
+	n, err := r.ExpirePoints()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, int64(1))
+	require.Equal(t, uint64(400), testBalance(t, r, u.ID))
+	lots := testLots(t, r, u.ID)
+	require.Equal(t, []uint64{0, 300, 100}, lotRemainders(lots))
+	expirations, err := r.GetPointExpirationsDB(u.ID)
+	require.NoError(t, err)
+	require.Len(t, expirations, 1)
+	require.Equal(t, lots[0].ID, expirations[0].LotID)
+	require.Equal(t, uint64(500), expirations[0].Amount)
+
+	// Повторный проход ничего не сжигает.
+	amount, err := r.ExpirePointsDB(u.ID, time.Now())
+	require.NoError(t, err)
+	require.Zero(t, amount)
+	require.Equal(t, uint64(400), testBalance(t, r, u.ID))
+}
+
+func TestOpeningLotBackfill(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	u := addTestUser(t, r, "lots")
+	adjustTestBalance(t, r, u.ID, 700)
+
+	require.NoError(t, r.initPointLots(r.ctx))
+	lots := testLots(t, r, u.ID)
+	require.Len(t, lots, 1)
+	require.Equal(t, lotOpening, lots[0].Source)
+	require.Equal(t, uint64(700), lots[0].Remaining)
+	require.Nil(t, lots[0].ExpiresAt)
+
+	// Расхождение баланса с партиями у пользователя с партиями не превращается в новые бессрочные баллы.
+	adjustTestBalance(t, r, u.ID, 200)
+	require.NoError(t, r.initPointLots(r.ctx))
+	require.Equal(t, []uint64{700}, lotRemainders(testLots(t, r, u.ID)))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -200,7 +201,8 @@ func (r *Repository) GetPullOrders(limit uint32) (map[uint64]entity.Order, error
 	return orders, nil
 }
 
-// UpdateOrder - метод, обновляющий состояние заказа в БД.
+// UpdateOrder - метод, обновляющий состояние заказа в БД. Начисление баллов по обработанному заказу умножается
+// на множитель уровня пользователя, становится новой партией баллов и пишется в журнал аудита, после чего уровень пересчитывается.
 func (r *Repository) UpdateOrder(o entity.Order) error {
 	tx, err := r.db.Begin()
 	if err != nil {
@@ -209,23 +211,58 @@ func (r *Repository) UpdateOrder(o entity.Order) error {
 	defer tx.Rollback()
 	txUpdateOrder := tx.StmtContext(r.ctx, r.stmts["ordersUpdate"])
 	txUpdateBalance := tx.StmtContext(r.ctx, r.stmts["balanceUpdate"])
-	txGetBalance := tx.StmtContext(r.ctx, r.stmts["balanceGet"])
+	txGetBalance := tx.StmtContext(r.ctx, r.stmts["balanceGetForUpdate"])
+	var referrerID uint64
 	if o.Status == "PROCESSED" {
-		_, err = txUpdateOrder.ExecContext(r.ctx, o.ID, o.Status, o.Accrual)
-		if err != nil {
-			return fmt.Errorf("failed to update order - %s", err.Error())
-		}
+		now := time.Now()
 		b := &entity.Balance{}
 		row := txGetBalance.QueryRowContext(r.ctx, o.UserID)
 		err = row.Scan(&b.UserID, &b.Current, &b.Withdrawn)
 		if err != nil {
 			return fmt.Errorf("failed to get user balance - %s", err.Error())
 		}
+		err = r.lockReferrerBalance(tx, o.UserID)
+		if err != nil {
+			return err
+		}
+		t, err := r.accrualTier(tx, o.UserID, now)
+		if err != nil {
+			return err
+		}
+		// В заказе сохраняется фактически начисленная сумма с учетом множителя уровня.
+		o.Accrual = t.Apply(o.Accrual)
+		_, err = txUpdateOrder.ExecContext(r.ctx, o.ID, o.Status, o.Accrual)
+		if err != nil {
+			return fmt.Errorf("failed to update order - %s", err.Error())
+		}
 		current := b.Current + o.Accrual
 		_, err = txUpdateBalance.ExecContext(r.ctx, b.UserID, current, b.Withdrawn)
 		if err != nil {
 			return fmt.Errorf("failed to update user balance - %s", err.Error())
 		}
+		err = r.addPointLot(tx, b.UserID, lotAccrual, strconv.FormatUint(o.ID, 10), o.Accrual, now)
+		if err != nil {
+			return err
+		}
+		err = r.recalcTier(tx, b.UserID, now)
+		if err != nil {
+			return err
+		}
+		// Начисление делает сама система, поэтому инициатор в журнале аудита нулевой.
+		err = r.appendAudit(tx, &entity.AuditEvent{
+			Action:     entity.AuditAccrual,
+			TargetType: entity.AuditTargetOrder,
+			TargetID:   strconv.FormatUint(o.ID, 10),
+			Before:     balanceState(*b),
+			After:      balanceState(entity.Balance{UserID: b.UserID, Current: current, Withdrawn: b.Withdrawn}),
+		})
+		if err != nil {
+			return err
+		}
+		referrerID, err = r.rewardReferral(tx, b.UserID, o.Accrual, now)
+		if err != nil {
+			return err
+		}
 	} else {
 		_, err = txUpdateOrder.ExecContext(r.ctx, o.ID, o.Status, o.Accrual)
 		if err != nil {
@@ -236,5 +273,11 @@ func (r *Repository) UpdateOrder(o entity.Order) error {
 	if err != nil {
 		return fmt.Errorf("update order transaction failed - %s", err.Error())
 	}
+	if o.Status == "PROCESSED" {
+		r.forgetBalance(o.UserID)
+	}
+	if referrerID != 0 {
+		r.forgetBalance(referrerID)
+	}
 	return nil
 }
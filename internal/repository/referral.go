@@ -0,0 +1,420 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// lotReferral - источник партии баллов, начисленной бонусом за приглашение.
+const lotReferral = "referral"
+
+// referralsColumns - порядок колонок таблицы приглашений, в котором их читает scanReferral.
+const referralsColumns = "r.referee_id, r.referrer_id, r.status, r.reason, r.ip, r.created_at, r.rewarded_at, r.referrer_bonus, r.referee_bonus"
+
+// referralCodeAlphabet - символы реферального кода без похожих друг на друга 0/O и 1/I.
+const referralCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const (
+	referralCodeLength   = 8
+	referralCodeAttempts = 5
+	// referralIPWindow - окно, за которое считаются регистрации по приглашениям с одного IP.
+	referralIPWindow = 24 * time.Hour
+)
+
+// initReferrals - метод, создающий таблицы реферальных кодов и приглашений, если их нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initReferrals(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS referral_codes (
+				user_id bigint PRIMARY KEY,
+				code varchar NOT NULL UNIQUE)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS referrals (
+				referee_id bigint PRIMARY KEY,
+				referrer_id bigint NOT NULL,
+				status varchar NOT NULL,
+				reason varchar NOT NULL DEFAULT '',
+				ip varchar NOT NULL,
+				created_at timestamptz NOT NULL,
+				rewarded_at timestamptz,
+				referrer_bonus bigint NOT NULL DEFAULT 0,
+				referee_bonus bigint NOT NULL DEFAULT 0)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS referrals_referrer_id_idx ON referrals (referrer_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS referrals_ip_idx ON referrals (ip, created_at)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table referrals created")
+	err = r.initReferralsStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initReferralsStatements - метод, подготавливающий стейтменты БД для работы с реферальной программой.
+func (r *Repository) initReferralsStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO referral_codes (user_id, code) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralCodesInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT code FROM referral_codes WHERE user_id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralCodesGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`SELECT c.user_id FROM referral_codes c JOIN users u ON u.id = c.user_id
+			WHERE c.code=$1 AND u.disabled_at IS NULL AND u.deleted_at IS NULL`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralCodesGetUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO referrals (referee_id, referrer_id, status, reason, ip, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT COUNT(*) FROM referrals WHERE ip=$1 AND created_at >= $2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsCountByIP"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT referrer_id FROM referrals WHERE referee_id=$1 AND status=$2 FOR UPDATE",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsGetPendingForUpdate"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT COUNT(*) FROM referrals WHERE referrer_id=$1 AND status=$2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsCountByStatus"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT disabled_at IS NULL AND deleted_at IS NULL FROM users WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsReferrerActive"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`UPDATE referrals SET status = $2, reason = $3, rewarded_at = $4, referrer_bonus = $5, referee_bonus = $6
+			WHERE referee_id = $1`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsDecide"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`SELECT `+referralsColumns+`, u.login FROM referrals r JOIN users u ON u.id = r.referee_id
+			WHERE r.referrer_id=$1 ORDER BY r.created_at DESC`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsGetForReferrer"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT referee_bonus FROM referrals WHERE referee_id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["referralsGetRefereeBonus"] = stmt
+	return nil
+}
+
+// newReferralCode - функция, создающая случайный реферальный код из referralCodeAlphabet.
+func newReferralCode() (string, error) {
+	b := make([]byte, referralCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate referral code - %s", err.Error())
+	}
+	for i := range b {
+		// Длина алфавита делит 256, поэтому символы распределены равномерно.
+		b[i] = referralCodeAlphabet[int(b[i])%len(referralCodeAlphabet)]
+	}
+	return string(b), nil
+}
+
+// normalizeReferralCode - функция, приводящая введенный пользователем реферальный код к виду, в котором он хранится.
+func normalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// referralCode - метод, возвращающий реферальный код пользователя и создающий его, если кода еще нет.
+// Коды пользователям, зарегистрированным до появления реферальной программы, выдаются при первом запросе.
+func (r *Repository) referralCode(tx *sql.Tx, userID uint64) (string, error) {
+	for i := 0; i < referralCodeAttempts; i++ {
+		var code string
+		err := r.txStmt(tx, "referralCodesGet").QueryRowContext(r.ctx, userID).Scan(&code)
+		if err == nil {
+			return code, nil
+		}
+		if err != sql.ErrNoRows {
+			return "", fmt.Errorf("failed to get referral code - %s", err.Error())
+		}
+		code, err = newReferralCode()
+		if err != nil {
+			return "", err
+		}
+		// При совпадении кода с чужим вставка пропускается, и следующая попытка берет новый код.
+		_, err = r.txStmt(tx, "referralCodesInsert").ExecContext(r.ctx, userID, code)
+		if err != nil {
+			return "", fmt.Errorf("failed to add referral code - %s", err.Error())
+		}
+	}
+	return "", fmt.Errorf("failed to generate unique referral code for user %d", userID)
+}
+
+// addReferral - метод, записывающий приглашение нового пользователя в транзакции tx.
+// Приглашение сверх REFERRAL_MAX_PER_IP регистраций с одного IP за сутки сразу отклоняется.
+func (r *Repository) addReferral(tx *sql.Tx, ref *entity.Referral) error {
+	if r.conf.ReferralMaxPerIP > 0 && ref.IP != "" {
+		var count int
+		err := tx.StmtContext(r.ctx, r.stmts["referralsCountByIP"]).QueryRowContext(r.ctx,
+			ref.IP, ref.CreatedAt.Add(-referralIPWindow)).Scan(&count)
+		if err != nil {
+			return fmt.Errorf("failed to count referrals - %s", err.Error())
+		}
+		if count >= r.conf.ReferralMaxPerIP {
+			ref.Status = entity.ReferralRejected
+			ref.Reason = entity.ReferralReasonIPLimit
+		}
+	}
+	_, err := tx.StmtContext(r.ctx, r.stmts["referralsInsert"]).ExecContext(r.ctx,
+		ref.RefereeID, ref.ReferrerID, ref.Status, ref.Reason, ref.IP, ref.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to add referral - %s", err.Error())
+	}
+	return nil
+}
+
+// lockReferrerBalance - метод, блокирующий в транзакции tx баланс пригласившего, если приглашение refereeID ждет бонусов.
+// Вызывается сразу после блокировки баланса приглашенного: пригласивший зарегистрирован раньше и его ID меньше,
+// поэтому балансы блокируются по убыванию ID, как и при переводах, и до advisory-блокировки журнала аудита,
+// как при списаниях и корректировках.
+func (r *Repository) lockReferrerBalance(tx *sql.Tx, refereeID uint64) error {
+	if r.conf.ReferrerBonus == 0 && r.conf.RefereeBonus == 0 {
+		return nil
+	}
+	var referrerID uint64
+	err := tx.StmtContext(r.ctx, r.stmts["referralsGetPendingForUpdate"]).QueryRowContext(r.ctx,
+		refereeID, entity.ReferralPending).Scan(&referrerID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get referral - %s", err.Error())
+	}
+	b := entity.Balance{}
+	err = tx.StmtContext(r.ctx, r.stmts["balanceGetForUpdate"]).QueryRowContext(r.ctx, referrerID).Scan(&b.UserID, &b.Current, &b.Withdrawn)
+	if err != nil {
+		return fmt.Errorf("failed to get user balance - %s", err.Error())
+	}
+	return nil
+}
+
+// rewardReferral - метод, начисляющий бонусы обоим участникам приглашения после начисления accrual по заказу
+// приглашенного refereeID в транзакции tx. Возвращает ID пригласившего, если бонусы начислены.
+// Заказ с начислением меньше REFERRAL_MIN_ACCRUAL оставляет приглашение ждать следующего.
+func (r *Repository) rewardReferral(tx *sql.Tx, refereeID, accrual uint64, now time.Time) (uint64, error) {
+	referrerBonus := uint64(math.Round(r.conf.ReferrerBonus * 100))
+	refereeBonus := uint64(math.Round(r.conf.RefereeBonus * 100))
+	if referrerBonus == 0 && refereeBonus == 0 {
+		return 0, nil
+	}
+	var referrerID uint64
+	err := tx.StmtContext(r.ctx, r.stmts["referralsGetPendingForUpdate"]).QueryRowContext(r.ctx,
+		refereeID, entity.ReferralPending).Scan(&referrerID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get referral - %s", err.Error())
+	}
+	if accrual == 0 || accrual < uint64(math.Round(r.conf.ReferralMinAccrual*100)) {
+		return 0, nil
+	}
+	// Баланс пригласившего уже заблокирован в lockReferrerBalance до первой записи в журнал аудита.
+	reason, err := r.referralRejection(tx, referrerID)
+	if err != nil {
+		return 0, err
+	}
+	txDecide := tx.StmtContext(r.ctx, r.stmts["referralsDecide"])
+	if reason != "" {
+		_, err = txDecide.ExecContext(r.ctx, refereeID, entity.ReferralRejected, reason, nil, 0, 0)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update referral - %s", err.Error())
+		}
+		log.Info().Uint64("referrer", referrerID).Uint64("referee", refereeID).Str("reason", reason).Msg("referral bonus rejected")
+		return 0, nil
+	}
+	err = r.creditReferralBonus(tx, referrerID, referrerBonus, refereeID, now)
+	if err != nil {
+		return 0, err
+	}
+	err = r.creditReferralBonus(tx, refereeID, refereeBonus, refereeID, now)
+	if err != nil {
+		return 0, err
+	}
+	_, err = txDecide.ExecContext(r.ctx, refereeID, entity.ReferralRewarded, "", now, referrerBonus, refereeBonus)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update referral - %s", err.Error())
+	}
+	log.Info().Uint64("referrer", referrerID).Uint64("referee", refereeID).Msg("referral bonus credited")
+	return referrerID, nil
+}
+
+// referralRejection - метод, возвращающий причину отказа пригласившему в бонусе или пустую строку.
+func (r *Repository) referralRejection(tx *sql.Tx, referrerID uint64) (string, error) {
+	var active bool
+	err := tx.StmtContext(r.ctx, r.stmts["referralsReferrerActive"]).QueryRowContext(r.ctx, referrerID).Scan(&active)
+	if err == sql.ErrNoRows {
+		return entity.ReferralReasonReferrerDisabled, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get referrer - %s", err.Error())
+	}
+	if !active {
+		return entity.ReferralReasonReferrerDisabled, nil
+	}
+	if r.conf.ReferralMaxPerUser > 0 {
+		var count int
+		err = tx.StmtContext(r.ctx, r.stmts["referralsCountByStatus"]).QueryRowContext(r.ctx,
+			referrerID, entity.ReferralRewarded).Scan(&count)
+		if err != nil {
+			return "", fmt.Errorf("failed to count referrals - %s", err.Error())
+		}
+		if count >= r.conf.ReferralMaxPerUser {
+			return entity.ReferralReasonReferrerLimit, nil
+		}
+	}
+	return "", nil
+}
+
+// creditReferralBonus - метод, начисляющий пользователю бонус за приглашение refereeID новой партией баллов
+// в транзакции tx и записывающий начисление в журнал аудита.
+func (r *Repository) creditReferralBonus(tx *sql.Tx, userID, amount, refereeID uint64, now time.Time) error {
+	if amount == 0 {
+		return nil
+	}
+	b := entity.Balance{}
+	err := tx.StmtContext(r.ctx, r.stmts["balanceGet"]).QueryRowContext(r.ctx, userID).Scan(&b.UserID, &b.Current, &b.Withdrawn)
+	if err != nil {
+		return fmt.Errorf("failed to get user balance - %s", err.Error())
+	}
+	_, err = tx.StmtContext(r.ctx, r.stmts["balanceAdjust"]).ExecContext(r.ctx, userID, int64(amount))
+	if err != nil {
+		return fmt.Errorf("failed to update user balance - %s", err.Error())
+	}
+	err = r.addPointLot(tx, userID, lotReferral, strconv.FormatUint(refereeID, 10), amount, now)
+	if err != nil {
+		return err
+	}
+	e := auditEvent(nil, entity.AuditReferralBonus, entity.AuditTargetUser, userID)
+	e.Before = balanceState(b)
+	b.Current += amount
+	e.After = balanceState(b)
+	return r.appendAudit(tx, e)
+}
+
+// scanReferral - функция, читающая приглашение из строки результата в порядке referralsColumns и логин приглашенного.
+func scanReferral(row scanner, ref *entity.Referral) error {
+	return row.Scan(&ref.RefereeID, &ref.ReferrerID, &ref.Status, &ref.Reason, &ref.IP, &ref.CreatedAt,
+		&ref.RewardedAt, &ref.ReferrerBonus, &ref.RefereeBonus, &ref.RefereeLogin)
+}
+
+// GetReferrerDB - метод, возвращающий ID активного пользователя по его реферальному коду.
+// Возвращает ErrReferralCodeNotFound, если такого кода нет.
+func (r *Repository) GetReferrerDB(code string) (uint64, error) {
+	var userID uint64
+	err := r.stmts["referralCodesGetUser"].QueryRowContext(r.ctx, code).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, ErrReferralCodeNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get referrer - %s", err.Error())
+	}
+	return userID, nil
+}
+
+// GetReferralCodeDB - метод, возвращающий реферальный код пользователя, при необходимости создавая его.
+func (r *Repository) GetReferralCodeDB(userID uint64) (string, error) {
+	return r.referralCode(nil, userID)
+}
+
+// GetReferralsDB - метод, возвращающий приглашения пользователя, начиная с новых.
+func (r *Repository) GetReferralsDB(userID uint64) ([]entity.Referral, error) {
+	rows, err := r.stmts["referralsGetForReferrer"].QueryContext(r.ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]entity.Referral, 0)
+	for rows.Next() {
+		var ref entity.Referral
+		err = scanReferral(rows, &ref)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, ref)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// GetRefereeBonusDB - метод, возвращающий бонус, полученный пользователем за регистрацию по приглашению.
+func (r *Repository) GetRefereeBonusDB(userID uint64) (uint64, error) {
+	var bonus uint64
+	err := r.stmts["referralsGetRefereeBonus"].QueryRowContext(r.ctx, userID).Scan(&bonus)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return bonus, err
+}
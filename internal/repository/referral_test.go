@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func TestNewReferralCode(t *testing.T) {
+	// Остаток от деления байта на длину алфавита распределен равномерно, только если длина делит 256.
+	require.Zero(t, 256%len(referralCodeAlphabet))
+	require.NotContains(t, referralCodeAlphabet, "0")
+	require.NotContains(t, referralCodeAlphabet, "O")
+	require.NotContains(t, referralCodeAlphabet, "1")
+	require.NotContains(t, referralCodeAlphabet, "I")
+
+	codes := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		code, err := newReferralCode()
+		require.NoError(t, err)
+		require.Len(t, code, referralCodeLength)
+		for _, ch := range code {
+			require.Contains(t, referralCodeAlphabet, string(ch))
+		}
+		require.Equal(t, code, normalizeReferralCode(code))
+		codes[code] = true
+	}
+	require.Len(t, codes, 100)
+}
+
+func TestNormalizeReferralCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"ABCD2345", "ABCD2345"},
+		{" abcd2345\n", "ABCD2345"},
+		{"AbCd2345", "ABCD2345"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			require.Equal(t, tt.want, normalizeReferralCode(tt.code))
+		})
+	}
+}
+
+// rowValues - строка результата для тестов, отдающая значения колонок по порядку.
+type rowValues []interface{}
+
+func (v rowValues) Scan(dest ...interface{}) error {
+	for i := range dest {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v[i]))
+	}
+	return nil
+}
+
+func TestScanReferral(t *testing.T) {
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	rewarded := created.Add(time.Hour)
+	row := rowValues{uint64(2), uint64(1), entity.ReferralRewarded, "", "10.0.0.1", created, &rewarded, uint64(10000), uint64(5000), "friend"}
+	// Колонки referralsColumns и логин приглашенного.
+	require.Len(t, row, len(strings.Split(referralsColumns, ","))+1)
+
+	var ref entity.Referral
+	require.NoError(t, scanReferral(row, &ref))
+	require.Equal(t, entity.Referral{
+		RefereeID:     2,
+		ReferrerID:    1,
+		Status:        entity.ReferralRewarded,
+		IP:            "10.0.0.1",
+		CreatedAt:     created,
+		RewardedAt:    &rewarded,
+		ReferrerBonus: 10000,
+		RefereeBonus:  5000,
+		RefereeLogin:  "friend",
+	}, ref)
+}
@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// refreshTokensColumns - порядок колонок таблицы refresh-токенов, в котором их читает scanRefreshToken.
+const refreshTokensColumns = "id, user_id, family, token, expiry, created_at, used_at, revoked_at, ip, user_agent"
+
+// initRefreshTokens - метод, создающий таблицу refresh-токенов, если ее нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initRefreshTokens(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS refresh_tokens (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				family varchar NOT NULL,
+				token varchar NOT NULL UNIQUE,
+				expiry timestamptz NOT NULL,
+				created_at timestamptz NOT NULL DEFAULT now(),
+				used_at timestamptz,
+				revoked_at timestamptz,
+				ip varchar NOT NULL DEFAULT '',
+				user_agent varchar NOT NULL DEFAULT '')`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON refresh_tokens (family)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS refresh_tokens_user_id_idx ON refresh_tokens (user_id)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table refresh_tokens created")
+	err = r.initRefreshTokensStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initRefreshTokensStatements - метод, подготавливающий стейтменты БД для работы с refresh-токенами.
+func (r *Repository) initRefreshTokensStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO refresh_tokens (user_id, family, token, expiry, created_at, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["refreshTokensInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+refreshTokensColumns+" FROM refresh_tokens WHERE token=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["refreshTokensGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE refresh_tokens SET used_at = $2 WHERE id = $1 AND used_at IS NULL AND revoked_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["refreshTokensUse"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE refresh_tokens SET revoked_at = $2 WHERE family = $1 AND revoked_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["refreshTokensRevokeFamily"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE refresh_tokens SET revoked_at = $2 WHERE user_id = $1 AND revoked_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["refreshTokensRevokeForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE refresh_tokens SET revoked_at = $3 WHERE user_id = $1 AND family <> $2 AND revoked_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["refreshTokensRevokeOthers"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM refresh_tokens WHERE expiry < $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["refreshTokensDeleteExpired"] = stmt
+	return nil
+}
+
+// scanRefreshToken - функция, читающая refresh-токен из строки результата в порядке refreshTokensColumns.
+func scanRefreshToken(row scanner, t *entity.RefreshToken) error {
+	return row.Scan(&t.ID, &t.UserID, &t.Family, &t.Token, &t.Expiry, &t.CreatedAt, &t.UsedAt, &t.RevokedAt, &t.IP, &t.UserAgent)
+}
+
+// AddRefreshTokenDB - метод, добавляющий refresh-токен в БД. Вместо токена сохраняется его хэш.
+func (r *Repository) AddRefreshTokenDB(t *entity.RefreshToken) error {
+	row := r.stmts["refreshTokensInsert"].QueryRowContext(r.ctx, t.UserID, t.Family, r.tokens.Hash(t.Token), t.Expiry,
+		t.CreatedAt, t.IP, t.UserAgent)
+	return row.Scan(&t.ID)
+}
+
+// GetRefreshTokenDB - метод, возвращающий refresh-токен по его значению, включая использованные и отозванные.
+func (r *Repository) GetRefreshTokenDB(token string) (entity.RefreshToken, error) {
+	t := entity.RefreshToken{}
+	for _, hash := range r.tokens.Candidates(token) {
+		row := r.stmts["refreshTokensGet"].QueryRowContext(r.ctx, hash)
+		err := scanRefreshToken(row, &t)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return t, fmt.Errorf("failed to get refresh token - %s", err.Error())
+		}
+		t.Token = token
+		return t, nil
+	}
+	return t, ErrRefreshTokenInvalid
+}
+
+// RotateRefreshTokenDB - метод, в одной транзакции помечающий refresh-токен использованным и добавляющий следующий токен семейства.
+// Возвращает false, если токен уже был использован или отозван параллельным запросом.
+func (r *Repository) RotateRefreshTokenDB(usedID uint64, usedAt time.Time, next *entity.RefreshToken) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	txUse := tx.StmtContext(r.ctx, r.stmts["refreshTokensUse"])
+	txInsert := tx.StmtContext(r.ctx, r.stmts["refreshTokensInsert"])
+	res, err := txUse.ExecContext(r.ctx, usedID, usedAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	row := txInsert.QueryRowContext(r.ctx, next.UserID, next.Family, r.tokens.Hash(next.Token), next.Expiry,
+		next.CreatedAt, next.IP, next.UserAgent)
+	err = row.Scan(&next.ID)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("rotate refresh token transaction failed - %s", err.Error())
+	}
+	return true, nil
+}
+
+// RevokeRefreshFamilyDB - метод, отзывающий все refresh-токены семейства.
+func (r *Repository) RevokeRefreshFamilyDB(family string, at time.Time) error {
+	_, err := r.stmts["refreshTokensRevokeFamily"].ExecContext(r.ctx, family, at)
+	return err
+}
+
+// RevokeUserRefreshTokensDB - метод, отзывающий все refresh-токены пользователя.
+func (r *Repository) RevokeUserRefreshTokensDB(userID uint64, at time.Time) error {
+	_, err := r.stmts["refreshTokensRevokeForUser"].ExecContext(r.ctx, userID, at)
+	return err
+}
+
+// RevokeOtherRefreshTokensDB - метод, отзывающий все refresh-токены пользователя, кроме семейства keepFamily.
+func (r *Repository) RevokeOtherRefreshTokensDB(userID uint64, keepFamily string, at time.Time) error {
+	_, err := r.stmts["refreshTokensRevokeOthers"].ExecContext(r.ctx, userID, keepFamily, at)
+	return err
+}
+
+// DeleteExpiredRefreshTokensDB - метод, удаляющий из БД refresh-токены, истекшие к моменту now.
+func (r *Repository) DeleteExpiredRefreshTokensDB(now time.Time) (int64, error) {
+	res, err := r.stmts["refreshTokensDeleteExpired"].ExecContext(r.ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
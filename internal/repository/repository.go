@@ -3,44 +3,129 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"expvar"
 	"fmt"
 	"time"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 
+	"github.com/gtgaleevtimur/gofermart/internal/config"
 	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/limiter"
+	"github.com/gtgaleevtimur/gofermart/internal/notify"
+	"github.com/gtgaleevtimur/gofermart/internal/password"
+	"github.com/gtgaleevtimur/gofermart/internal/tier"
+	"github.com/gtgaleevtimur/gofermart/internal/token"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
 )
 
 type Repository struct {
+	conf          *config.Config
 	db            *sql.DB
 	ctx           context.Context
 	cancel        context.CancelFunc
 	stmts         map[string]*sql.Stmt
+	tokens        *tokenHasher
+	access        *token.Issuer
+	loginsByIP    *limiter.SlidingWindow
+	loginsByLogin *limiter.SlidingWindow
+	resetsByIP    *limiter.SlidingWindow
+	resetsByLogin *limiter.SlidingWindow
+	policy        *validate.Policy
+	passwords     *password.Manager
+	notifier      notify.Notifier
+	secrets       *secretBox
+	challenges    *entity.ChallengeMemory
 	userMemory    *entity.UsersMemory
 	sessionMemory *entity.SessionMemory
 	ordersMemory  *entity.OrdersMemory
 	balanceMemory *entity.BalanceMemory
+	tiers         tier.Ladder
+}
+
+// scanner - общий интерфейс *sql.Row и *sql.Rows для чтения строк результата.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// txStmt - метод, возвращающий стейтмент name в транзакции tx или, если tx пустой, вне транзакции.
+func (r *Repository) txStmt(tx *sql.Tx, name string) *sql.Stmt {
+	if tx == nil {
+		return r.stmts[name]
+	}
+	return tx.StmtContext(r.ctx, r.stmts[name])
 }
 
 // NewRepository - конструктор новой базы данных.
-func NewRepository(addr string) (entity.Storager, error) {
+func NewRepository(conf *config.Config) (entity.Storager, error) {
 	ctx, cancel := context.WithCancel(context.Background())
 	r := &Repository{
+		conf:          conf,
 		ctx:           ctx,
 		cancel:        cancel,
 		stmts:         make(map[string]*sql.Stmt),
+		tokens:        newTokenHasher(conf.SessionTokenKeys),
+		loginsByIP:    limiter.NewSlidingWindow(conf.LoginLimitPerIP, conf.LoginLimitWindow),
+		loginsByLogin: limiter.NewSlidingWindow(conf.LoginLimitPerLogin, conf.LoginLimitWindow),
+		resetsByIP:    limiter.NewSlidingWindow(conf.ResetLimitPerIP, conf.ResetLimitWindow),
+		resetsByLogin: limiter.NewSlidingWindow(conf.ResetLimitPerLogin, conf.ResetLimitWindow),
 		userMemory:    entity.NewUsers(),
 		sessionMemory: entity.NewSessions(),
 		ordersMemory:  entity.NewOrders(),
 		balanceMemory: entity.NewBalance(),
+		challenges:    entity.NewChallenges(),
+	}
+	accessKey := []byte(conf.AccessTokenKey)
+	if len(accessKey) == 0 {
+		accessKey = randomKey("ACCESS_TOKEN_KEY")
+	}
+	r.access = token.NewIssuer(accessKey, conf.AccessTokenTTL)
+	policy, err := validate.NewPolicy(conf.LoginMinLength, conf.LoginMaxLength, conf.LoginPattern,
+		conf.PasswordMinLength, conf.PasswordMaxBytes, conf.PasswordBlocklistFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials policy - %s", err.Error())
+	}
+	r.policy = policy
+	r.passwords, err = newPasswordManager(conf)
+	if err != nil {
+		return nil, err
+	}
+	r.notifier, err = notify.New(conf.NotifyDriver, conf.NotifyFile, conf.SMTPAddress, conf.SMTPUsername, conf.SMTPPassword, conf.SMTPFrom)
+	if err != nil {
+		return nil, fmt.Errorf("invalid notify settings - %s", err.Error())
+	}
+	r.secrets, err = newSecretBox(conf.TOTPEncryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TOTP encryption key - %s", err.Error())
 	}
-	err := r.init(addr)
+	r.tiers, err = tier.Parse(conf.LoyaltyTiers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid loyalty tiers - %s", err.Error())
+	}
+	err = r.init(conf.DatabaseURI)
 	if err != nil {
 		return nil, fmt.Errorf("database initialization failed - %s", err.Error())
 	}
 	return r, nil
 }
 
+// legacyPasswordHashes - метрика числа пользователей, чей пароль еще хэширован не текущим алгоритмом.
+var legacyPasswordHashes = expvar.NewInt("legacy_password_hashes")
+
+// newPasswordManager - функция, собирающая хэширование паролей из конфига. Хэши другого алгоритма проверяются и перехэшируются при входе.
+func newPasswordManager(conf *config.Config) (*password.Manager, error) {
+	argon := password.NewArgon2id(uint32(conf.Argon2Memory), uint32(conf.Argon2Time), uint8(conf.Argon2Threads))
+	bcrypt := password.NewBcrypt(conf.BcryptCost)
+	switch conf.PasswordHasher {
+	case "", "argon2id":
+		return password.NewManager(argon, bcrypt), nil
+	case "bcrypt":
+		return password.NewManager(bcrypt, argon), nil
+	default:
+		return nil, fmt.Errorf("unknown password hasher `%s`", conf.PasswordHasher)
+	}
+}
+
 // init - метод инициализирующий таблицы и настройки БД при создании.
 func (r *Repository) init(addr string) (err error) {
 	r.db, err = sql.Open("pgx", addr)
@@ -60,10 +145,30 @@ func (r *Repository) init(addr string) (err error) {
 	if err != nil {
 		return fmt.Errorf("failed to create 'users' table - %s", err.Error())
 	}
+	err = r.initLoginAttempts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'login_attempts' table - %s", err.Error())
+	}
 	err = r.initSessions(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to create 'sessions' table - %s", err.Error())
 	}
+	err = r.initRefreshTokens(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'refresh_tokens' table - %s", err.Error())
+	}
+	err = r.initPasswordResets(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'password_resets' table - %s", err.Error())
+	}
+	err = r.initTOTP(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'user_totp' tables - %s", err.Error())
+	}
+	err = r.initAPIKeys(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'api_keys' table - %s", err.Error())
+	}
 	err = r.initBalance(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to create 'balance' table - %s", err.Error())
@@ -76,5 +181,33 @@ func (r *Repository) init(addr string) (err error) {
 	if err != nil {
 		return fmt.Errorf("failed to create 'orders' table - %s", err.Error())
 	}
+	err = r.initAdjustments(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'balance_adjustments' table - %s", err.Error())
+	}
+	err = r.initAudit(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'audit_events' table - %s", err.Error())
+	}
+	err = r.initPointLots(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'point_lots' table - %s", err.Error())
+	}
+	err = r.initTiers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'user_tiers' table - %s", err.Error())
+	}
+	err = r.initReferrals(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'referrals' table - %s", err.Error())
+	}
+	err = r.initTransfers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'transfers' table - %s", err.Error())
+	}
+	err = r.initOrderUploads(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create 'order_uploads' tables - %s", err.Error())
+	}
 	return nil
 }
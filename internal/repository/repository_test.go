@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// testDatabaseURIEnv - переменная окружения с адресом Postgres для тестов репозитория, которым нужна БД.
+// Без нее такие тесты пропускаются. Каждый тест создает своих пользователей, поэтому БД можно не очищать.
+const testDatabaseURIEnv = "TEST_DATABASE_URI"
+
+// testConfig - функция, возвращающая конфиг репозитория со значениями по умолчанию и быстрым хэшированием паролей.
+func testConfig() *config.Config {
+	return &config.Config{
+		SessionTTL:            10 * time.Minute,
+		SessionMaxLifetime:    24 * time.Hour,
+		SessionTokenKeys:      "test-session-key",
+		AccessTokenKey:        "test-access-key",
+		AccessTokenTTL:        5 * time.Minute,
+		RefreshTokenTTL:       30 * 24 * time.Hour,
+		LoginLimitWindow:      time.Minute,
+		LoginLimitPerIP:       1000,
+		LoginLimitPerLogin:    1000,
+		LockoutThreshold:      5,
+		LockoutBase:           time.Minute,
+		LockoutMax:            time.Hour,
+		LoginMinLength:        3,
+		LoginMaxLength:        64,
+		PasswordMinLength:     8,
+		PasswordMaxBytes:      72,
+		PasswordHasher:        "bcrypt",
+		BcryptCost:            4,
+		PasswordResetTTL:      30 * time.Minute,
+		ResetLimitWindow:      time.Hour,
+		ResetLimitPerIP:       1000,
+		ResetLimitPerLogin:    1000,
+		TOTPIssuer:            "Gophermart",
+		TOTPWithdrawThreshold: 1000,
+		MFAChallengeTTL:       5 * time.Minute,
+		APIKeyTTL:             90 * 24 * time.Hour,
+		APIKeyMaxTTL:          365 * 24 * time.Hour,
+		AdjustmentThreshold:   1000,
+		AdjustmentDailyLimit:  5000,
+		PointsExpiringSoon:    30 * 24 * time.Hour,
+		ReferralMaxPerUser:    20,
+		ReferralMaxPerIP:      3,
+		TransferDailyLimit:    5000,
+		UploadSyncLimit:       100,
+		UploadMaxLines:        50000,
+		UploadBatchSize:       500,
+	}
+}
+
+// newTestRepository - функция, создающая репозиторий на БД из TEST_DATABASE_URI с конфигом conf
+// или пропускающая тест, если БД не задана.
+func newTestRepository(t *testing.T, conf *config.Config) *Repository {
+	t.Helper()
+	uri := os.Getenv(testDatabaseURIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", testDatabaseURIEnv)
+	}
+	conf.DatabaseURI = uri
+	st, err := NewRepository(conf)
+	require.NoError(t, err)
+	r := st.(*Repository)
+	t.Cleanup(func() {
+		r.cancel()
+		r.db.Close()
+	})
+	return r
+}
+
+// testLogin - функция, возвращающая уникальный логин с префиксом prefix, чтобы тесты не пересекались в общей БД.
+func testLogin(prefix string) string {
+	return fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+}
+
+// testOrderNumber - функция, возвращающая уникальный номер заказа с верной контрольной цифрой Луна.
+func testOrderNumber() uint64 {
+	base := uint64(time.Now().UnixNano()) / 10 % 1e15
+	var sum uint64
+	double := true
+	for n := base; n > 0; n /= 10 {
+		d := n % 10
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return base*10 + (10-sum%10)%10
+}
+
+// addTestUser - функция, регистрирующая пользователя с уникальным логином и возвращающая его.
+func addTestUser(t *testing.T, r *Repository, prefix string) *entity.User {
+	t.Helper()
+	login := testLogin(prefix)
+	_, err := r.Register(&entity.AccountInfo{Login: login, Password: "correct horse battery"})
+	require.NoError(t, err)
+	u, err := r.GetUser(login)
+	require.NoError(t, err)
+	return u
+}
+
+// creditTestUser - функция, начисляющая пользователю amount копеек баллов партией со сроком сгорания expiresAt,
+// nil - бессрочной.
+func creditTestUser(t *testing.T, r *Repository, userID, amount uint64, expiresAt *time.Time) {
+	t.Helper()
+	tx, err := r.db.Begin()
+	require.NoError(t, err)
+	defer tx.Rollback()
+	_, err = tx.StmtContext(r.ctx, r.stmts["balanceAdjust"]).ExecContext(r.ctx, userID, int64(amount))
+	require.NoError(t, err)
+	require.NoError(t, r.insertPointLot(tx, userID, lotAdjustment, "test", amount, time.Now(), expiresAt))
+	require.NoError(t, tx.Commit())
+	r.forgetBalance(userID)
+}
+
+// testBalance - функция, возвращающая текущий баланс пользователя из БД в копейках.
+func testBalance(t *testing.T, r *Repository, userID uint64) uint64 {
+	t.Helper()
+	b, err := r.GetBalanceDB(userID)
+	require.NoError(t, err)
+	return b.Current
+}
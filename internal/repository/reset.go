@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// passwordResetsColumns - порядок колонок таблицы восстановления паролей, в котором их читает scanPasswordReset.
+const passwordResetsColumns = "id, user_id, token, expiry, created_at, used_at, ip"
+
+// initPasswordResets - метод, создающий таблицу токенов восстановления пароля, если ее нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initPasswordResets(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS password_resets (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				token varchar NOT NULL UNIQUE,
+				expiry timestamptz NOT NULL,
+				created_at timestamptz NOT NULL DEFAULT now(),
+				used_at timestamptz,
+				ip varchar NOT NULL DEFAULT '')`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS password_resets_user_id_idx ON password_resets (user_id)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table password_resets created")
+	err = r.initPasswordResetsStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initPasswordResetsStatements - метод, подготавливающий стейтменты БД для работы с токенами восстановления пароля.
+func (r *Repository) initPasswordResetsStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO password_resets (user_id, token, expiry, created_at, ip) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["passwordResetsInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+passwordResetsColumns+" FROM password_resets WHERE token=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["passwordResetsGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE password_resets SET used_at = $2 WHERE id = $1 AND used_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["passwordResetsUse"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE password_resets SET used_at = $2 WHERE user_id = $1 AND used_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["passwordResetsUseForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM password_resets WHERE user_id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["passwordResetsDeleteForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM password_resets WHERE expiry < $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["passwordResetsDeleteExpired"] = stmt
+	return nil
+}
+
+// scanPasswordReset - функция, читающая токен восстановления пароля из строки результата в порядке passwordResetsColumns.
+func scanPasswordReset(row scanner, p *entity.PasswordReset) error {
+	return row.Scan(&p.ID, &p.UserID, &p.Token, &p.Expiry, &p.CreatedAt, &p.UsedAt, &p.IP)
+}
+
+// AddPasswordResetDB - метод, добавляющий токен восстановления пароля в БД. Вместо токена сохраняется его хэш.
+func (r *Repository) AddPasswordResetDB(p *entity.PasswordReset) error {
+	row := r.stmts["passwordResetsInsert"].QueryRowContext(r.ctx, p.UserID, r.tokens.Hash(p.Token), p.Expiry, p.CreatedAt, p.IP)
+	return row.Scan(&p.ID)
+}
+
+// GetPasswordResetDB - метод, возвращающий токен восстановления пароля по его значению, включая использованные.
+func (r *Repository) GetPasswordResetDB(token string) (entity.PasswordReset, error) {
+	p := entity.PasswordReset{}
+	for _, hash := range r.tokens.Candidates(token) {
+		row := r.stmts["passwordResetsGet"].QueryRowContext(r.ctx, hash)
+		err := scanPasswordReset(row, &p)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return p, fmt.Errorf("failed to get password reset - %s", err.Error())
+		}
+		p.Token = token
+		return p, nil
+	}
+	return p, ErrResetTokenInvalid
+}
+
+// ResetPasswordDB - метод, в одной транзакции использующий токен восстановления, заменяющий хэш пароля
+// и гасящий остальные токены пользователя. Возвращает false, если токен уже был использован параллельным запросом.
+func (r *Repository) ResetPasswordDB(resetID, userID uint64, hash []byte, at time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	txUse := tx.StmtContext(r.ctx, r.stmts["passwordResetsUse"])
+	txUseForUser := tx.StmtContext(r.ctx, r.stmts["passwordResetsUseForUser"])
+	txUpdatePassword := tx.StmtContext(r.ctx, r.stmts["usersUpdatePassword"])
+	txResetFailed := tx.StmtContext(r.ctx, r.stmts["usersResetFailedLogins"])
+	res, err := txUse.ExecContext(r.ctx, resetID, at)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	_, err = txUseForUser.ExecContext(r.ctx, userID, at)
+	if err != nil {
+		return false, err
+	}
+	_, err = txUpdatePassword.ExecContext(r.ctx, userID, hash)
+	if err != nil {
+		return false, err
+	}
+	_, err = txResetFailed.ExecContext(r.ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("reset password transaction failed - %s", err.Error())
+	}
+	return true, nil
+}
+
+// DeleteExpiredPasswordResetsDB - метод, удаляющий из БД токены восстановления пароля, истекшие к моменту now.
+func (r *Repository) DeleteExpiredPasswordResetsDB(now time.Time) (int64, error) {
+	res, err := r.stmts["passwordResetsDeleteExpired"].ExecContext(r.ctx, now)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// sealedPrefix - начало зашифрованного значения в БД, по нему отличаются секреты, сохраненные до настройки ключа.
+const sealedPrefix = "gcm:"
+
+// secretBox - шифрует секреты TOTP (AES-256-GCM) и хэширует коды восстановления ключом из конфига.
+// В отличие от токенов сессий, эти данные живут долго, поэтому ключ не генерируется случайно и не должен меняться.
+type secretBox struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+// newSecretBox - конструктор из ключа конфига. Без ключа секреты хранятся открыто, а коды хэшируются без ключа.
+func newSecretBox(key string) (*secretBox, error) {
+	if key == "" {
+		log.Warn().Msg("TOTP_ENCRYPTION_KEY is not set, TOTP secrets are stored unencrypted")
+		return &secretBox{}, nil
+	}
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{key: sum[:], aead: aead}, nil
+}
+
+// Seal - метод, шифрующий секрет для хранения в БД.
+func (b *secretBox) Seal(secret string) (string, error) {
+	if b.aead == nil {
+		return secret, nil
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce - %s", err.Error())
+	}
+	sealed := b.aead.Seal(nonce, nonce, []byte(secret), nil)
+	return sealedPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Open - метод, расшифровывающий секрет из БД. Значение без префикса сохранено открыто и возвращается как есть.
+func (b *secretBox) Open(stored string) (string, error) {
+	if !strings.HasPrefix(stored, sealedPrefix) {
+		return stored, nil
+	}
+	if b.aead == nil {
+		return "", fmt.Errorf("secret is encrypted, but TOTP_ENCRYPTION_KEY is not set")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, sealedPrefix))
+	if err != nil || len(sealed) < b.aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted secret")
+	}
+	nonce, data := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	plain, err := b.aead.Open(nil, nonce, data, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret - %s", err.Error())
+	}
+	return string(plain), nil
+}
+
+// Hash - метод, возвращающий хэш кода восстановления для хранения в БД.
+func (b *secretBox) Hash(code string) string {
+	return hashToken(b.key, code)
+}
@@ -4,19 +4,53 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/gtgaleevtimur/gofermart/internal/entity"
 )
 
+// sessionsColumns - порядок колонок таблицы сессий, в котором их читает scanSession.
+const sessionsColumns = "id, user_id, token, expiry, created_at, last_used_at, ip, user_agent"
+
 // initSessions - метод, создающий таблицу сессии авторизации пользователей, если ее нет. Подготавливает стейтменты для базы данных.
 func (r *Repository) initSessions(ctx context.Context) error {
 	_, err := r.db.ExecContext(ctx, `
 			CREATE TABLE IF NOT EXISTS sessions (
 				user_id bigint NOT NULL,
 				token varchar NOT NULL, 
-				expiry time NOT NULL)`)
+				expiry timestamptz NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	err = r.migrateSessionsExpiry(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			ALTER TABLE sessions
+				ADD COLUMN IF NOT EXISTS id bigserial,
+				ADD COLUMN IF NOT EXISTS created_at timestamptz NOT NULL DEFAULT now(),
+				ADD COLUMN IF NOT EXISTS last_used_at timestamptz NOT NULL DEFAULT now(),
+				ADD COLUMN IF NOT EXISTS ip varchar NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS user_agent varchar NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS sessions_id_idx ON sessions (id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS sessions_token_idx ON sessions (token)`)
+	if err != nil {
+		return err
+	}
+	err = r.dropRawSessionTokens(ctx)
 	if err != nil {
 		return err
 	}
@@ -28,11 +62,52 @@ func (r *Repository) initSessions(ctx context.Context) error {
 	return nil
 }
 
+// migrateSessionsExpiry - метод, переводящий колонку expiry старых таблиц из time (без даты) в timestamptz.
+func (r *Repository) migrateSessionsExpiry(ctx context.Context) error {
+	var dataType string
+	row := r.db.QueryRowContext(ctx, `
+			SELECT data_type FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'sessions' AND column_name = 'expiry'`)
+	err := row.Scan(&dataType)
+	if err != nil {
+		return err
+	}
+	if dataType != "time without time zone" && dataType != "time with time zone" {
+		return nil
+	}
+	// Дата у старых значений не сохранилась, считаем их сегодняшними.
+	_, err = r.db.ExecContext(ctx, `
+			ALTER TABLE sessions ALTER COLUMN expiry TYPE timestamptz
+			USING (current_date + expiry::time)::timestamptz`)
+	if err != nil {
+		return err
+	}
+	log.Info().Msg("sessions.expiry migrated to timestamptz")
+	return nil
+}
+
+// dropRawSessionTokens - метод, удаляющий сессии, сохраненные до хэширования токенов.
+// Сырые UUID короче хэша, поэтому отличаются по длине; их владельцам придется войти заново.
+func (r *Repository) dropRawSessionTokens(ctx context.Context) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE length(token) <> $1`, tokenHashLen)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Info().Int64("count", n).Msg("sessions with raw tokens dropped")
+	}
+	return nil
+}
+
 // initSessionsStatements - метод, подготавливающий стейтменты БД для работы с сессиями пользователей.
 func (r *Repository) initSessionsStatements() error {
 	stmt, err := r.db.PrepareContext(
 		r.ctx,
-		"INSERT INTO sessions (user_id, token, expiry) VALUES ($1, $2, $3)",
+		"INSERT INTO sessions (user_id, token, expiry, created_at, last_used_at, ip, user_agent) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id",
 	)
 	if err != nil {
 		return err
@@ -40,12 +115,20 @@ func (r *Repository) initSessionsStatements() error {
 	r.stmts["sessionsInsert"] = stmt
 	stmt, err = r.db.PrepareContext(
 		r.ctx,
-		"SELECT * FROM sessions WHERE token=$1",
+		"SELECT "+sessionsColumns+" FROM sessions WHERE token=$1",
 	)
 	if err != nil {
 		return err
 	}
 	r.stmts["sessionsGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+sessionsColumns+" FROM sessions WHERE user_id=$1 ORDER BY last_used_at DESC",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["sessionsGetForUser"] = stmt
 	stmt, err = r.db.PrepareContext(
 		r.ctx,
 		"DELETE FROM sessions WHERE token=$1",
@@ -54,30 +137,88 @@ func (r *Repository) initSessionsStatements() error {
 		return err
 	}
 	r.stmts["sessionsDelete"] = stmt
-	return nil
-}
-
-// DeleteSessionDB - метод, удаляющий сессию из БД по его токену.
-func (r *Repository) DeleteSessionDB(token string) error {
-	res, err := r.stmts["sessionsDelete"].ExecContext(r.ctx, token)
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM sessions WHERE user_id=$1 AND id=$2",
+	)
 	if err != nil {
 		return err
 	}
-
-	rows, err := res.RowsAffected()
+	r.stmts["sessionsDeleteByID"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM sessions WHERE user_id=$1",
+	)
 	if err != nil {
 		return err
 	}
-	if rows == 0 {
+	r.stmts["sessionsDeleteForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM sessions WHERE user_id=$1 AND id<>$2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["sessionsDeleteOthers"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE sessions SET last_used_at = $2, expiry = $3 WHERE id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["sessionsTouch"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE sessions SET token = $2 WHERE id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["sessionsRehash"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM sessions WHERE expiry < $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["sessionsDeleteExpired"] = stmt
+	return nil
+}
+
+// scanSession - функция, читающая сессию из строки результата в порядке sessionsColumns.
+func scanSession(row scanner, s *entity.Session) error {
+	return row.Scan(&s.ID, &s.UserID, &s.Token, &s.Expiry, &s.CreatedAt, &s.LastUsedAt, &s.IP, &s.UserAgent)
+}
+
+// DeleteSessionDB - метод, удаляющий сессию из БД по его токену.
+func (r *Repository) DeleteSessionDB(token string) error {
+	var deleted int64
+	for _, hash := range r.tokens.Candidates(token) {
+		res, err := r.stmts["sessionsDelete"].ExecContext(r.ctx, hash)
+		if err != nil {
+			return err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		deleted += rows
+	}
+	if deleted == 0 {
 		return fmt.Errorf("session not found")
 	}
 
 	return nil
 }
 
-// AddSessionDB - метод, добавляющий сессию пользователя в БД.
+// AddSessionDB - метод, добавляющий сессию пользователя в БД. Вместо токена сохраняется его хэш.
 func (r *Repository) AddSessionDB(session *entity.Session) error {
-	_, err := r.stmts["sessionsInsert"].ExecContext(r.ctx, session.UserID, session.Token, session.Expiry)
+	row := r.stmts["sessionsInsert"].QueryRowContext(r.ctx, session.UserID, r.tokens.Hash(session.Token), session.Expiry,
+		session.CreatedAt, session.LastUsedAt, session.IP, session.UserAgent)
+	err := row.Scan(&session.ID)
 	if err != nil {
 		return err
 	}
@@ -85,15 +226,91 @@ func (r *Repository) AddSessionDB(session *entity.Session) error {
 }
 
 // GetSessionDB - метод, возвращающий сессию пользователя по токену.
+// Хэш ищется всеми ключами; найденный предыдущим ключом перехэшируется текущим.
 func (r *Repository) GetSessionDB(token string) (entity.Session, error) {
 	session := &entity.Session{}
-	row := r.stmts["sessionsGet"].QueryRowContext(r.ctx, token)
-	err := row.Scan(&session.UserID, &session.Token, &session.Expiry)
-	if err == sql.ErrNoRows {
-		return *session, ErrSessionNotFound
+	for i, hash := range r.tokens.Candidates(token) {
+		row := r.stmts["sessionsGet"].QueryRowContext(r.ctx, hash)
+		err := scanSession(row, session)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return *session, fmt.Errorf("failed to get session - %s", err.Error())
+		}
+		if i > 0 {
+			_, err = r.stmts["sessionsRehash"].ExecContext(r.ctx, session.ID, r.tokens.Hash(token))
+			if err != nil {
+				log.Error().Err(err).Uint64("session", session.ID).Msg("failed to rehash session token")
+			}
+		}
+		session.Token = token
+		return *session, nil
+	}
+	return *session, ErrSessionNotFound
+}
+
+// GetSessionsDB - метод, возвращающий все сессии пользователя, начиная с последней использованной.
+func (r *Repository) GetSessionsDB(userID uint64) ([]entity.Session, error) {
+	sessions := make([]entity.Session, 0)
+	rows, err := r.stmts["sessionsGetForUser"].QueryContext(r.ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s entity.Session
+		err = scanSession(rows, &s)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// DeleteUserSessionDB - метод, удаляющий сессию пользователя из БД по ее ID.
+func (r *Repository) DeleteUserSessionDB(userID, sessionID uint64) error {
+	res, err := r.stmts["sessionsDeleteByID"].ExecContext(r.ctx, userID, sessionID)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// DeleteUserSessionsDB - метод, удаляющий все сессии пользователя из БД.
+func (r *Repository) DeleteUserSessionsDB(userID uint64) error {
+	_, err := r.stmts["sessionsDeleteForUser"].ExecContext(r.ctx, userID)
+	return err
+}
+
+// DeleteOtherSessionsDB - метод, удаляющий из БД все сессии пользователя, кроме сессии keepID.
+func (r *Repository) DeleteOtherSessionsDB(userID, keepID uint64) error {
+	_, err := r.stmts["sessionsDeleteOthers"].ExecContext(r.ctx, userID, keepID)
+	return err
+}
+
+// TouchSessionDB - метод, обновляющий время последнего использования и срок действия сессии в БД.
+func (r *Repository) TouchSessionDB(sessionID uint64, lastUsedAt, expiry time.Time) error {
+	_, err := r.stmts["sessionsTouch"].ExecContext(r.ctx, sessionID, lastUsedAt, expiry)
+	return err
+}
+
+// DeleteExpiredSessionsDB - метод, удаляющий из БД сессии, истекшие к моменту now.
+func (r *Repository) DeleteExpiredSessionsDB(now time.Time) (int64, error) {
+	res, err := r.stmts["sessionsDeleteExpired"].ExecContext(r.ctx, now)
 	if err != nil {
-		return *session, fmt.Errorf("failed to get session - %s", err.Error())
+		return 0, err
 	}
-	return *session, nil
+	return res.RowsAffected()
 }
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/config"
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func TestSessionExpiry(t *testing.T) {
+	created := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		maxLifetime time.Duration
+		lastUsed    time.Time
+		want        time.Time
+	}{
+		{name: "New session", maxLifetime: 24 * time.Hour, lastUsed: created, want: created.Add(10 * time.Minute)},
+		{name: "Slides from last use", maxLifetime: 24 * time.Hour, lastUsed: created.Add(time.Hour), want: created.Add(70 * time.Minute)},
+		{name: "Capped by max lifetime", maxLifetime: 24 * time.Hour, lastUsed: created.Add(23*time.Hour + 55*time.Minute), want: created.Add(24 * time.Hour)},
+		{name: "No max lifetime", lastUsed: created.Add(48 * time.Hour), want: created.Add(48*time.Hour + 10*time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &Repository{conf: &config.Config{SessionTTL: 10 * time.Minute, SessionMaxLifetime: tt.maxLifetime}}
+			require.Equal(t, tt.want, r.sessionExpiry(created, tt.lastUsed))
+		})
+	}
+}
+
+func TestTokenHasher(t *testing.T) {
+	h := newTokenHasher(" new-key , old-key,")
+	hash := h.Hash("token")
+	require.Len(t, hash, tokenHashLen)
+	require.Equal(t, hashToken([]byte("new-key"), "token"), hash)
+	require.Equal(t, []string{hash, hashToken([]byte("old-key"), "token")}, h.Candidates("token"))
+	require.NotEqual(t, hash, h.Hash("other-token"))
+
+	// Без ключей хэшер работает со случайным ключом.
+	require.Len(t, newTokenHasher("").Candidates("token"), 1)
+}
+
+// addTestSession - функция, сохраняющая в БД сессию пользователя userID с новым токеном.
+func addTestSession(t *testing.T, r *Repository, userID uint64, createdAt, lastUsedAt, expiry time.Time) *entity.Session {
+	t.Helper()
+	s := &entity.Session{
+		UserID:     userID,
+		Token:      uuid.NewString(),
+		Expiry:     expiry,
+		CreatedAt:  createdAt,
+		LastUsedAt: lastUsedAt,
+	}
+	require.NoError(t, r.AddSessionDB(s))
+	return s
+}
+
+// storedSessionToken - функция, возвращающая хэш токена, под которым сессия сохранена в БД.
+func storedSessionToken(t *testing.T, r *Repository, sessionID uint64) string {
+	t.Helper()
+	var token string
+	require.NoError(t, r.db.QueryRowContext(r.ctx, "SELECT token FROM sessions WHERE id = $1", sessionID).Scan(&token))
+	return token
+}
+
+func TestSessionKeyRotation(t *testing.T) {
+	conf := testConfig()
+	conf.SessionTokenKeys = "old-key"
+	old := newTestRepository(t, conf)
+	u := addTestUser(t, old, "session")
+	now := time.Now()
+	s := addTestSession(t, old, u.ID, now, now, now.Add(time.Hour))
+	require.Equal(t, hashToken([]byte("old-key"), s.Token), storedSessionToken(t, old, s.ID))
+
+	// Новый ключ первым, старый оставлен на время ротации: сессия находится и перехэшируется новым ключом.
+	conf = testConfig()
+	conf.SessionTokenKeys = "new-key,old-key"
+	rotating := newTestRepository(t, conf)
+	found, err := rotating.GetSessionDB(s.Token)
+	require.NoError(t, err)
+	require.Equal(t, s.ID, found.ID)
+	require.Equal(t, s.Token, found.Token)
+	require.Equal(t, hashToken([]byte("new-key"), s.Token), storedSessionToken(t, rotating, s.ID))
+
+	// После удаления старого ключа сессия по-прежнему находится.
+	conf = testConfig()
+	conf.SessionTokenKeys = "new-key"
+	rotated := newTestRepository(t, conf)
+	found, err = rotated.GetSessionDB(s.Token)
+	require.NoError(t, err)
+	require.Equal(t, s.ID, found.ID)
+
+	// Токен, который ни один ключ не дает, не находится.
+	_, err = rotated.GetSessionDB(uuid.NewString())
+	require.ErrorIs(t, err, ErrSessionNotFound)
+}
+
+func TestTouchSession(t *testing.T) {
+	conf := testConfig()
+	conf.SessionTTL = 10 * time.Minute
+	conf.SessionMaxLifetime = 24 * time.Hour
+	r := newTestRepository(t, conf)
+	u := addTestUser(t, r, "session")
+	now := time.Now()
+
+	// Срок продлевается на TTL от последнего использования.
+	s := addTestSession(t, r, u.ID, now.Add(-time.Hour), now.Add(-2*time.Minute), now.Add(8*time.Minute))
+	require.NoError(t, r.TouchSession(s))
+	require.WithinDuration(t, time.Now().Add(10*time.Minute), s.Expiry, time.Second)
+	stored, err := r.GetSessionDB(s.Token)
+	require.NoError(t, err)
+	require.WithinDuration(t, s.Expiry, stored.Expiry, time.Millisecond)
+
+	// Но не дальше максимального срока жизни от создания.
+	created := now.Add(-24*time.Hour + 5*time.Minute)
+	s = addTestSession(t, r, u.ID, created, now.Add(-7*time.Minute), now.Add(3*time.Minute))
+	require.NoError(t, r.TouchSession(s))
+	require.WithinDuration(t, created.Add(24*time.Hour), s.Expiry, time.Millisecond)
+	stored, err = r.GetSessionDB(s.Token)
+	require.NoError(t, err)
+	require.WithinDuration(t, created.Add(24*time.Hour), stored.Expiry, time.Millisecond)
+
+	// Недавно использованная сессия в БД не обновляется.
+	lastUsed := now.Add(-time.Second)
+	s = addTestSession(t, r, u.ID, now.Add(-time.Hour), lastUsed, now.Add(5*time.Minute))
+	require.NoError(t, r.TouchSession(s))
+	require.Equal(t, lastUsed, s.LastUsedAt)
+}
+
+func TestSweepSessions(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	u := addTestUser(t, r, "session")
+	now := time.Now()
+	expired := addTestSession(t, r, u.ID, now.Add(-time.Hour), now.Add(-time.Hour), now.Add(-time.Minute))
+	live := addTestSession(t, r, u.ID, now, now, now.Add(time.Hour))
+
+	n, err := r.SweepSessions()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, n, int64(1))
+	_, err = r.GetSessionDB(expired.Token)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+	_, err = r.GetSessionDB(live.Token)
+	require.NoError(t, err)
+}
+
+func TestDropRawSessionTokens(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	u := addTestUser(t, r, "session")
+	now := time.Now()
+	hashed := addTestSession(t, r, u.ID, now, now, now.Add(time.Hour))
+	raw := uuid.NewString()
+	_, err := r.db.ExecContext(r.ctx, "INSERT INTO sessions (user_id, token, expiry) VALUES ($1, $2, $3)", u.ID, raw, now.Add(time.Hour))
+	require.NoError(t, err)
+
+	require.NoError(t, r.dropRawSessionTokens(r.ctx))
+	var count int
+	require.NoError(t, r.db.QueryRowContext(r.ctx, "SELECT count(*) FROM sessions WHERE token = $1", raw).Scan(&count))
+	require.Zero(t, count)
+	_, err = r.GetSessionDB(hashed.Token)
+	require.NoError(t, err)
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+type Sweeper struct {
+	storage  entity.Storager
+	interval time.Duration
+}
+
+// NewSweeper - конструктор фоновой очистки истекших сессий.
+func NewSweeper(st entity.Storager, interval time.Duration) *Sweeper {
+	return &Sweeper{
+		storage:  st,
+		interval: interval,
+	}
+}
+
+// Start - запуск периодической очистки до отмены контекста.
+func (s *Sweeper) Start(ctx context.Context) {
+	if s.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
+
+// sweep - метод, выполняющий один проход очистки.
+func (s *Sweeper) sweep() {
+	n, err := s.storage.SweepSessions()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to sweep expired sessions")
+		return
+	}
+	if n > 0 {
+		log.Debug().Int64("count", n).Msg("expired sessions deleted")
+	}
+}
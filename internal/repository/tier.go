@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/tier"
+)
+
+// initTiers - метод, создающий таблицы уровней пользователей и истории их смены, если их нет.
+// Подготавливает стейтменты для базы данных.
+func (r *Repository) initTiers(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS user_tiers (
+				user_id bigint PRIMARY KEY,
+				tier varchar NOT NULL,
+				qualifying bigint NOT NULL,
+				updated_at timestamptz NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS tier_history (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				from_tier varchar NOT NULL,
+				to_tier varchar NOT NULL,
+				qualifying bigint NOT NULL,
+				changed_at timestamptz NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS tier_history_user_id_idx ON tier_history (user_id)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table user_tiers created")
+	err = r.initTiersStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initTiersStatements - метод, подготавливающий стейтменты БД для работы с уровнями пользователей.
+func (r *Repository) initTiersStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"SELECT tier FROM user_tiers WHERE user_id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["tiersGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO user_tiers (user_id, tier, qualifying, updated_at) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id) DO UPDATE SET tier = $2, qualifying = $3, updated_at = $4`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["tiersSet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT COALESCE(SUM(accrual), 0) FROM orders WHERE user_id=$1 AND status='PROCESSED'",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["tiersLifetimeAccrual"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM point_lots WHERE user_id=$1 AND source=$2 AND created_at >= $3",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["tiersRollingAccrual"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO tier_history (user_id, from_tier, to_tier, qualifying, changed_at) VALUES ($1, $2, $3, $4, $5)",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["tierHistoryInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT id, user_id, from_tier, to_tier, qualifying, changed_at FROM tier_history WHERE user_id=$1 ORDER BY changed_at DESC",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["tierHistoryGetForUser"] = stmt
+	return nil
+}
+
+// userTier - метод, возвращающий название сохраненного уровня пользователя или пустую строку, если уровень еще не присваивался.
+func (r *Repository) userTier(tx *sql.Tx, userID uint64) (string, error) {
+	var name string
+	err := r.txStmt(tx, "tiersGet").QueryRowContext(r.ctx, userID).Scan(&name)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to get user tier - %s", err.Error())
+	}
+	return name, nil
+}
+
+// qualifyingPoints - метод, возвращающий баллы, начисленные пользователю по заказам за все время
+// или, если задан LOYALTY_TIER_WINDOW, за скользящее окно до now.
+func (r *Repository) qualifyingPoints(tx *sql.Tx, userID uint64, now time.Time) (uint64, error) {
+	var sum uint64
+	var err error
+	if r.conf.TierWindow > 0 {
+		err = r.txStmt(tx, "tiersRollingAccrual").QueryRowContext(r.ctx, userID, lotAccrual, now.Add(-r.conf.TierWindow)).Scan(&sum)
+	} else {
+		err = r.txStmt(tx, "tiersLifetimeAccrual").QueryRowContext(r.ctx, userID).Scan(&sum)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get qualifying points - %s", err.Error())
+	}
+	return sum, nil
+}
+
+// accrualTier - метод, возвращающий уровень, по множителю которого начисляются баллы за заказ.
+// Это уровень, пересчитанный при прошлом начислении, а если его нет - уровень по уже накопленным баллам.
+// Со скользящим окном LOYALTY_TIER_WINDOW сохраненный уровень мог устареть, пока начислений не было,
+// поэтому уровень всегда считается по баллам за окно до now.
+func (r *Repository) accrualTier(tx *sql.Tx, userID uint64, now time.Time) (tier.Tier, error) {
+	if r.conf.TierWindow <= 0 {
+		name, err := r.userTier(tx, userID)
+		if err != nil {
+			return tier.Tier{}, err
+		}
+		if t, ok := r.tiers.Get(name); ok {
+			return t, nil
+		}
+	}
+	qualifying, err := r.qualifyingPoints(tx, userID, now)
+	if err != nil {
+		return tier.Tier{}, err
+	}
+	t, _ := r.tiers.For(qualifying)
+	return t, nil
+}
+
+// recalcTier - метод, пересчитывающий уровень пользователя после начисления в транзакции tx.
+// Смена уровня пишется в историю и журнал аудита.
+func (r *Repository) recalcTier(tx *sql.Tx, userID uint64, now time.Time) error {
+	if len(r.tiers) == 0 {
+		return nil
+	}
+	prev, err := r.userTier(tx, userID)
+	if err != nil {
+		return err
+	}
+	qualifying, err := r.qualifyingPoints(tx, userID, now)
+	if err != nil {
+		return err
+	}
+	t, _ := r.tiers.For(qualifying)
+	_, err = tx.StmtContext(r.ctx, r.stmts["tiersSet"]).ExecContext(r.ctx, userID, t.Name, qualifying, now)
+	if err != nil {
+		return fmt.Errorf("failed to set user tier - %s", err.Error())
+	}
+	if t.Name == prev {
+		return nil
+	}
+	_, err = tx.StmtContext(r.ctx, r.stmts["tierHistoryInsert"]).ExecContext(r.ctx, userID, prev, t.Name, qualifying, now)
+	if err != nil {
+		return fmt.Errorf("failed to add tier history - %s", err.Error())
+	}
+	e := auditEvent(nil, entity.AuditTierChange, entity.AuditTargetUser, userID)
+	e.Before = auditState(map[string]string{"tier": prev})
+	e.After = auditState(map[string]interface{}{"tier": t.Name, "qualifying": float64(qualifying) / 100})
+	err = r.appendAudit(tx, e)
+	if err != nil {
+		return err
+	}
+	log.Info().Uint64("user", userID).Str("from", prev).Str("to", t.Name).Msg("user tier changed")
+	return nil
+}
+
+// GetUserTierDB - метод, возвращающий сохраненный уровень пользователя или пустую строку.
+func (r *Repository) GetUserTierDB(userID uint64) (string, error) {
+	return r.userTier(nil, userID)
+}
+
+// GetQualifyingPointsDB - метод, возвращающий баллы, по которым определяется уровень пользователя на момент now.
+func (r *Repository) GetQualifyingPointsDB(userID uint64, now time.Time) (uint64, error) {
+	return r.qualifyingPoints(nil, userID, now)
+}
+
+// GetTierHistoryDB - метод, возвращающий историю смены уровня пользователя, начиная с новых.
+func (r *Repository) GetTierHistoryDB(userID uint64) ([]entity.TierChange, error) {
+	rows, err := r.stmts["tierHistoryGetForUser"].QueryContext(r.ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]entity.TierChange, 0)
+	for rows.Next() {
+		var c entity.TierChange
+		err = rows.Scan(&c.ID, &c.UserID, &c.From, &c.To, &c.Qualifying, &c.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
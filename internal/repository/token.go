@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tokenHashLen - длина hex-представления HMAC-SHA256, по ней отличаются хэши от старых сырых токенов в БД.
+const tokenHashLen = sha256.Size * 2
+
+// tokenHasher - хэширует токены ключом HMAC. Первый ключ текущий, остальные - предыдущие, оставленные на время ротации.
+type tokenHasher struct {
+	keys [][]byte
+}
+
+// newTokenHasher - конструктор хэшера из списка ключей через запятую.
+// Без ключей генерирует случайный, и сессии не переживут перезапуск сервиса.
+func newTokenHasher(keys string) *tokenHasher {
+	h := &tokenHasher{}
+	for _, k := range strings.Split(keys, ",") {
+		k = strings.TrimSpace(k)
+		if k != "" {
+			h.keys = append(h.keys, []byte(k))
+		}
+	}
+	if len(h.keys) == 0 {
+		h.keys = append(h.keys, randomKey("SESSION_TOKEN_KEYS"))
+	}
+	return h
+}
+
+// randomKey - функция, генерирующая случайный ключ для ненастроенного параметра конфига с предупреждением в лог.
+func randomKey(name string) []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatal().Err(err).Msgf("failed to generate random %s", name)
+	}
+	log.Warn().Msgf("%s is not set, using random key - sessions and tokens will be lost on restart", name)
+	return key
+}
+
+// Hash - метод, возвращающий хэш токена текущим ключом.
+func (h *tokenHasher) Hash(token string) string {
+	return hashToken(h.keys[0], token)
+}
+
+// Candidates - метод, возвращающий хэши токена всеми ключами, начиная с текущего.
+func (h *tokenHasher) Candidates(token string) []string {
+	hashes := make([]string, 0, len(h.keys))
+	for _, k := range h.keys {
+		hashes = append(hashes, hashToken(k, token))
+	}
+	return hashes
+}
+
+// hashToken - функция, вычисляющая HMAC-SHA256 токена в hex.
+func hashToken(key []byte, token string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
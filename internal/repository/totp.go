@@ -0,0 +1,282 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// initTOTP - метод, создающий таблицы TOTP и кодов восстановления, если их нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initTOTP(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS user_totp (
+				user_id bigint PRIMARY KEY,
+				secret varchar NOT NULL,
+				confirmed_at timestamptz,
+				last_step bigint NOT NULL DEFAULT 0,
+				created_at timestamptz NOT NULL DEFAULT now())`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				code varchar NOT NULL,
+				used_at timestamptz,
+				created_at timestamptz NOT NULL DEFAULT now())`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS recovery_codes_user_code_idx ON recovery_codes (user_id, code)`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("tables user_totp and recovery_codes created")
+	err = r.initTOTPStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initTOTPStatements - метод, подготавливающий стейтменты БД для работы с TOTP и кодами восстановления.
+func (r *Repository) initTOTPStatements() error {
+	// Неподтвержденный секрет заменяется при повторном подключении, подтвержденный - нет.
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO user_totp (user_id, secret, created_at) VALUES ($1, $2, $3)
+			ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at, last_step = 0
+			WHERE user_totp.confirmed_at IS NULL`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["totpUpsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT user_id, secret, confirmed_at, last_step, created_at FROM user_totp WHERE user_id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["totpGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE user_totp SET confirmed_at = $2, last_step = $3 WHERE user_id = $1 AND confirmed_at IS NULL AND last_step < $3",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["totpConfirm"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["totpUseStep"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM user_totp WHERE user_id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["totpDelete"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO recovery_codes (user_id, code, created_at) VALUES ($1, $2, $3)",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["recoveryCodesInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"DELETE FROM recovery_codes WHERE user_id = $1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["recoveryCodesDeleteForUser"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE recovery_codes SET used_at = $3 WHERE user_id = $1 AND code = $2 AND used_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["recoveryCodesUse"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT count(*) FROM recovery_codes WHERE user_id = $1 AND used_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["recoveryCodesCount"] = stmt
+	return nil
+}
+
+// AddTOTPDB - метод, сохраняющий новый неподтвержденный секрет TOTP пользователя в зашифрованном виде.
+// Возвращает false, если у пользователя уже подключен TOTP.
+func (r *Repository) AddTOTPDB(t *entity.TOTP) (bool, error) {
+	sealed, err := r.secrets.Seal(t.Secret)
+	if err != nil {
+		return false, err
+	}
+	res, err := r.stmts["totpUpsert"].ExecContext(r.ctx, t.UserID, sealed, t.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// GetTOTPDB - метод, возвращающий TOTP пользователя с расшифрованным секретом.
+func (r *Repository) GetTOTPDB(userID uint64) (entity.TOTP, error) {
+	t := entity.TOTP{}
+	row := r.stmts["totpGet"].QueryRowContext(r.ctx, userID)
+	err := row.Scan(&t.UserID, &t.Secret, &t.ConfirmedAt, &t.LastStep, &t.CreatedAt)
+	if err == sql.ErrNoRows {
+		return t, ErrTOTPNotEnabled
+	}
+	if err != nil {
+		return t, fmt.Errorf("failed to get TOTP - %s", err.Error())
+	}
+	t.Secret, err = r.secrets.Open(t.Secret)
+	if err != nil {
+		return t, err
+	}
+	return t, nil
+}
+
+// ConfirmTOTPDB - метод, в одной транзакции подтверждающий TOTP кодом шага step и заменяющий коды восстановления пользователя.
+// Возвращает false, если TOTP уже подтвержден или шаг уже использован.
+func (r *Repository) ConfirmTOTPDB(userID uint64, step int64, codes []string, at time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	txConfirm := tx.StmtContext(r.ctx, r.stmts["totpConfirm"])
+	res, err := txConfirm.ExecContext(r.ctx, userID, at, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rows == 0 {
+		return false, nil
+	}
+	err = r.replaceRecoveryCodes(tx, userID, codes, at)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("confirm TOTP transaction failed - %s", err.Error())
+	}
+	return true, nil
+}
+
+// UseTOTPStepDB - метод, отмечающий шаг времени TOTP использованным. Возвращает false, если код этого или более позднего шага уже принимался.
+func (r *Repository) UseTOTPStepDB(userID uint64, step int64) (bool, error) {
+	res, err := r.stmts["totpUseStep"].ExecContext(r.ctx, userID, step)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// DeleteTOTPDB - метод, в одной транзакции отключающий TOTP и удаляющий коды восстановления пользователя.
+func (r *Repository) DeleteTOTPDB(userID uint64) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.StmtContext(r.ctx, r.stmts["totpDelete"]).ExecContext(r.ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.StmtContext(r.ctx, r.stmts["recoveryCodesDeleteForUser"]).ExecContext(r.ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("delete TOTP transaction failed - %s", err.Error())
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodesDB - метод, заменяющий коды восстановления пользователя новыми. В БД хранятся только их хэши.
+func (r *Repository) ReplaceRecoveryCodesDB(userID uint64, codes []string, at time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = r.replaceRecoveryCodes(tx, userID, codes, at)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("replace recovery codes transaction failed - %s", err.Error())
+	}
+	return nil
+}
+
+// replaceRecoveryCodes - метод, заменяющий коды восстановления пользователя в транзакции tx.
+func (r *Repository) replaceRecoveryCodes(tx *sql.Tx, userID uint64, codes []string, at time.Time) error {
+	_, err := tx.StmtContext(r.ctx, r.stmts["recoveryCodesDeleteForUser"]).ExecContext(r.ctx, userID)
+	if err != nil {
+		return err
+	}
+	txInsert := tx.StmtContext(r.ctx, r.stmts["recoveryCodesInsert"])
+	for _, code := range codes {
+		_, err = txInsert.ExecContext(r.ctx, userID, r.secrets.Hash(code), at)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// UseRecoveryCodeDB - метод, гасящий код восстановления пользователя. Возвращает false, если кода нет или он уже использован.
+func (r *Repository) UseRecoveryCodeDB(userID uint64, code string, at time.Time) (bool, error) {
+	res, err := r.stmts["recoveryCodesUse"].ExecContext(r.ctx, userID, r.secrets.Hash(code), at)
+	if err != nil {
+		return false, err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+// CountRecoveryCodesDB - метод, возвращающий число неиспользованных кодов восстановления пользователя.
+func (r *Repository) CountRecoveryCodesDB(userID uint64) (int, error) {
+	var n int
+	err := r.stmts["recoveryCodesCount"].QueryRowContext(r.ctx, userID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,284 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+// lotTransfer - источник партии баллов, полученной переводом от другого пользователя.
+const lotTransfer = "transfer"
+
+// transfersColumns - порядок колонок таблицы переводов, в котором их читает scanTransfer.
+const transfersColumns = "t.id, t.from_user_id, t.to_user_id, t.amount, t.comment, t.idempotency_key, t.created_at"
+
+// initTransfers - метод, создающий таблицу переводов баллов между пользователями, если ее нет. Подготавливает стейтменты для базы данных.
+func (r *Repository) initTransfers(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS transfers (
+				id bigserial PRIMARY KEY,
+				from_user_id bigint NOT NULL,
+				to_user_id bigint NOT NULL,
+				amount bigint NOT NULL,
+				comment varchar NOT NULL,
+				idempotency_key varchar NOT NULL,
+				created_at timestamptz NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS transfers_from_user_id_idx ON transfers (from_user_id, created_at)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS transfers_to_user_id_idx ON transfers (to_user_id)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			CREATE UNIQUE INDEX IF NOT EXISTS transfers_idempotency_key_idx ON transfers (from_user_id, idempotency_key)
+				WHERE idempotency_key <> ''`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table transfers created")
+	err = r.initTransfersStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initTransfersStatements - метод, подготавливающий стейтменты БД для работы с переводами баллов.
+func (r *Repository) initTransfersStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO transfers (from_user_id, to_user_id, amount, comment, idempotency_key, created_at)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["transfersInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT "+transfersColumns+" FROM transfers t WHERE t.from_user_id=$1 AND t.idempotency_key=$2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["transfersGetByKey"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT COALESCE(SUM(amount), 0) FROM transfers WHERE from_user_id=$1 AND created_at >= $2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["transfersSumSince"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`SELECT `+transfersColumns+`, f.login, u.login FROM transfers t
+			JOIN users f ON f.id = t.from_user_id
+			JOIN users u ON u.id = t.to_user_id
+			WHERE t.from_user_id=$1 OR t.to_user_id=$1 ORDER BY t.created_at DESC, t.id DESC`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["transfersGetForUser"] = stmt
+	return nil
+}
+
+// scanTransfer - функция, читающая перевод из строки результата в порядке transfersColumns.
+func scanTransfer(row scanner, t *entity.Transfer, dest ...interface{}) error {
+	return row.Scan(append([]interface{}{&t.ID, &t.FromUserID, &t.ToUserID, &t.Amount, &t.Comment,
+		&t.IdempotencyKey, &t.CreatedAt}, dest...)...)
+}
+
+// AddTransferDB - метод, переводящий t.Amount баллов от t.FromUserID к t.ToUserID в одной транзакции и записывающий
+// событие e в журнал аудита. Полученные баллы сохраняют сроки сгорания партий отправителя.
+// Если у отправителя уже есть перевод с тем же ключом идемпотентности, t заполняется им и возвращается true;
+// перевод с тем же ключом, но другими получателем, суммой или комментарием - ErrIdempotencyKeyReused.
+func (r *Repository) AddTransferDB(t *entity.Transfer, e *entity.AuditEvent) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	// Балансы нескольких пользователей блокируются по убыванию ID, как и при бонусах за приглашение,
+	// поэтому встречные переводы не взаимоблокируются.
+	ids := []uint64{t.FromUserID, t.ToUserID}
+	if ids[0] < ids[1] {
+		ids[0], ids[1] = ids[1], ids[0]
+	}
+	txGetBalance := tx.StmtContext(r.ctx, r.stmts["balanceGetForUpdate"])
+	balances := make(map[uint64]entity.Balance, len(ids))
+	for _, id := range ids {
+		var b entity.Balance
+		err = txGetBalance.QueryRowContext(r.ctx, id).Scan(&b.UserID, &b.Current, &b.Withdrawn)
+		if err != nil {
+			return false, fmt.Errorf("failed to get user balance - %s", err.Error())
+		}
+		balances[id] = b
+	}
+	// Повтор с тем же ключом ждет блокировку баланса отправителя, поэтому видит уже зафиксированный первый перевод.
+	if t.IdempotencyKey != "" {
+		prev, err := r.transferByKey(tx, t.FromUserID, t.IdempotencyKey)
+		if err != nil {
+			return false, err
+		}
+		if prev != nil {
+			if !sameTransfer(prev, t) {
+				return false, ErrIdempotencyKeyReused
+			}
+			*t = *prev
+			return true, nil
+		}
+	}
+	from, to := balances[t.FromUserID], balances[t.ToUserID]
+	_, err = r.expireDueLots(tx, &from, t.CreatedAt)
+	if err != nil {
+		return false, err
+	}
+	if from.Current < t.Amount {
+		return false, ErrNotEnoughFunds
+	}
+	err = r.checkTransferLimit(tx, t)
+	if err != nil {
+		return false, err
+	}
+	err = tx.StmtContext(r.ctx, r.stmts["transfersInsert"]).QueryRowContext(r.ctx,
+		t.FromUserID, t.ToUserID, t.Amount, t.Comment, t.IdempotencyKey, t.CreatedAt).Scan(&t.ID)
+	if err != nil {
+		return false, fmt.Errorf("failed to add transfer - %s", err.Error())
+	}
+	txAdjust := tx.StmtContext(r.ctx, r.stmts["balanceAdjust"])
+	_, err = txAdjust.ExecContext(r.ctx, t.FromUserID, -int64(t.Amount))
+	if err != nil {
+		return false, fmt.Errorf("failed to update user balance - %s", err.Error())
+	}
+	_, err = txAdjust.ExecContext(r.ctx, t.ToUserID, int64(t.Amount))
+	if err != nil {
+		return false, fmt.Errorf("failed to update user balance - %s", err.Error())
+	}
+	err = r.movePointLots(tx, t)
+	if err != nil {
+		return false, err
+	}
+	e.TargetID = strconv.FormatUint(t.ID, 10)
+	e.Before = transferState(from, to)
+	from.Current -= t.Amount
+	to.Current += t.Amount
+	e.After = transferState(from, to)
+	err = r.appendAudit(tx, e)
+	if err != nil {
+		return false, err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("add transfer transaction failed - %s", err.Error())
+	}
+	return false, nil
+}
+
+// transferByKey - метод, возвращающий перевод отправителя fromUserID с ключом идемпотентности key или nil.
+func (r *Repository) transferByKey(tx *sql.Tx, fromUserID uint64, key string) (*entity.Transfer, error) {
+	t := &entity.Transfer{}
+	err := scanTransfer(r.txStmt(tx, "transfersGetByKey").QueryRowContext(r.ctx, fromUserID, key), t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transfer - %s", err.Error())
+	}
+	return t, nil
+}
+
+// sameTransfer - функция, проверяющая, что повтор запроса совпадает с исходным переводом.
+func sameTransfer(prev, t *entity.Transfer) bool {
+	return prev.ToUserID == t.ToUserID && prev.Amount == t.Amount && prev.Comment == t.Comment
+}
+
+// checkTransferLimit - метод, проверяющий, что перевод t не превышает TRANSFER_DAILY_LIMIT отправителя за сутки UTC.
+func (r *Repository) checkTransferLimit(tx *sql.Tx, t *entity.Transfer) error {
+	limit := uint64(math.Round(r.conf.TransferDailyLimit * 100))
+	if limit == 0 {
+		return nil
+	}
+	y, m, d := t.CreatedAt.UTC().Date()
+	var sent uint64
+	err := tx.StmtContext(r.ctx, r.stmts["transfersSumSince"]).QueryRowContext(r.ctx,
+		t.FromUserID, time.Date(y, m, d, 0, 0, 0, 0, time.UTC)).Scan(&sent)
+	if err != nil {
+		return fmt.Errorf("failed to get transfers sum - %s", err.Error())
+	}
+	if sent+t.Amount > limit {
+		return ErrTransferLimitExceeded
+	}
+	return nil
+}
+
+// movePointLots - метод, списывающий переведенные баллы из партий отправителя и начисляющий их получателю
+// партиями с теми же сроками сгорания, чтобы перевод не продлевал жизнь баллов.
+func (r *Repository) movePointLots(tx *sql.Tx, t *entity.Transfer) error {
+	consumed, err := r.consumePointLots(tx, t.FromUserID, t.Amount, t.CreatedAt)
+	if err != nil {
+		return err
+	}
+	sourceID := strconv.FormatUint(t.ID, 10)
+	var moved uint64
+	for _, l := range consumed {
+		err = r.insertPointLot(tx, t.ToUserID, lotTransfer, sourceID, l.Amount, t.CreatedAt, l.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		moved += l.Amount
+	}
+	// Баллы сверх партий отправителя получатель получает обычной новой партией.
+	return r.addPointLot(tx, t.ToUserID, lotTransfer, sourceID, t.Amount-moved, t.CreatedAt)
+}
+
+// transferState - функция, возвращающая для журнала аудита балансы отправителя и получателя перевода.
+func transferState(from, to entity.Balance) string {
+	return auditState(struct {
+		From entity.BalanceX `json:"from"`
+		To   entity.BalanceX `json:"to"`
+	}{
+		entity.BalanceX{Current: float64(from.Current) / 100, Withdrawn: float64(from.Withdrawn) / 100},
+		entity.BalanceX{Current: float64(to.Current) / 100, Withdrawn: float64(to.Withdrawn) / 100},
+	})
+}
+
+// GetTransferByKeyDB - метод, возвращающий перевод отправителя с ключом идемпотентности key или nil, если его нет.
+func (r *Repository) GetTransferByKeyDB(fromUserID uint64, key string) (*entity.Transfer, error) {
+	return r.transferByKey(nil, fromUserID, key)
+}
+
+// GetTransfersDB - метод, возвращающий исходящие и входящие переводы пользователя, начиная с новых.
+func (r *Repository) GetTransfersDB(userID uint64) ([]entity.Transfer, error) {
+	rows, err := r.stmts["transfersGetForUser"].QueryContext(r.ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	list := make([]entity.Transfer, 0)
+	for rows.Next() {
+		var t entity.Transfer
+		err = scanTransfer(rows, &t, &t.FromLogin, &t.ToLogin)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
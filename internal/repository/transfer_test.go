@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+// requireFieldError - функция, проверяющая, что err - ошибка валидации поля field с кодом code.
+func requireFieldError(t *testing.T, err error, field, code string) {
+	t.Helper()
+	var verr validate.Errors
+	require.True(t, errors.As(err, &verr), err)
+	require.Len(t, verr, 1)
+	require.Equal(t, field, verr[0].Field)
+	require.Equal(t, code, verr[0].Code)
+}
+
+func TestTransferIdempotency(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	from := addTestUser(t, r, "sender")
+	to := addTestUser(t, r, "recipient")
+	creditTestUser(t, r, from.ID, 10000, nil)
+	actor := &entity.Actor{UserID: from.ID}
+	req := &entity.TransferRequest{To: to.Login, Amount: 10, Comment: "lunch"}
+
+	first, replayed, err := r.Transfer(from.ID, actor, req, "key-1", "")
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, entity.TransferOut, first.Direction)
+
+	again, replayed, err := r.Transfer(from.ID, actor, req, "key-1", "")
+	require.NoError(t, err)
+	require.True(t, replayed)
+	require.Equal(t, first.ID, again.ID)
+	require.Equal(t, uint64(9000), testBalance(t, r, from.ID))
+	require.Equal(t, uint64(1000), testBalance(t, r, to.ID))
+
+	_, _, err = r.Transfer(from.ID, actor, &entity.TransferRequest{To: to.Login, Amount: 20, Comment: "lunch"}, "key-1", "")
+	require.ErrorIs(t, err, ErrIdempotencyKeyReused)
+
+	// Без ключа каждый запрос - новый перевод.
+	_, replayed, err = r.Transfer(from.ID, actor, req, "", "")
+	require.NoError(t, err)
+	require.False(t, replayed)
+	require.Equal(t, uint64(8000), testBalance(t, r, from.ID))
+}
+
+func TestTransferDailyLimit(t *testing.T) {
+	conf := testConfig()
+	conf.TransferDailyLimit = 50
+	r := newTestRepository(t, conf)
+	from := addTestUser(t, r, "sender")
+	to := addTestUser(t, r, "recipient")
+	creditTestUser(t, r, from.ID, 10000, nil)
+	actor := &entity.Actor{UserID: from.ID}
+
+	_, _, err := r.Transfer(from.ID, actor, &entity.TransferRequest{To: to.Login, Amount: 40}, "", "")
+	require.NoError(t, err)
+	_, _, err = r.Transfer(from.ID, actor, &entity.TransferRequest{To: to.Login, Amount: 20}, "", "")
+	require.ErrorIs(t, err, ErrTransferLimitExceeded)
+	_, _, err = r.Transfer(from.ID, actor, &entity.TransferRequest{To: to.Login, Amount: 10}, "", "")
+	require.NoError(t, err)
+	require.Equal(t, uint64(5000), testBalance(t, r, from.ID))
+}
+
+func TestTransferNotEnoughFunds(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	from := addTestUser(t, r, "sender")
+	to := addTestUser(t, r, "recipient")
+	creditTestUser(t, r, from.ID, 500, nil)
+
+	_, _, err := r.Transfer(from.ID, &entity.Actor{UserID: from.ID}, &entity.TransferRequest{To: to.Login, Amount: 10}, "", "")
+	require.ErrorIs(t, err, ErrNotEnoughFunds)
+	require.Equal(t, uint64(500), testBalance(t, r, from.ID))
+	require.Equal(t, uint64(0), testBalance(t, r, to.ID))
+}
+
+func TestTransferRecipient(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	from := addTestUser(t, r, "sender")
+	creditTestUser(t, r, from.ID, 10000, nil)
+	actor := &entity.Actor{UserID: from.ID}
+
+	_, _, err := r.Transfer(from.ID, actor, &entity.TransferRequest{To: from.Login, Amount: 10}, "", "")
+	requireFieldError(t, err, "to", "invalid_value")
+
+	_, _, err = r.Transfer(from.ID, actor, &entity.TransferRequest{To: testLogin("nobody"), Amount: 10}, "", "")
+	requireFieldError(t, err, "to", "not_found")
+	require.Equal(t, uint64(10000), testBalance(t, r, from.ID))
+}
@@ -0,0 +1,311 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/upload"
+)
+
+// orderUploadLease - время, после которого задание загрузки без отметки обработчика снова можно взять в работу.
+const orderUploadLease = time.Minute
+
+// initOrderUploads - метод, создающий таблицы заданий пакетной загрузки заказов и их результатов, если их нет.
+// Подготавливает стейтменты для базы данных.
+func (r *Repository) initOrderUploads(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS order_uploads (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL,
+				status varchar NOT NULL,
+				lines jsonb NOT NULL,
+				total integer NOT NULL,
+				processed integer NOT NULL DEFAULT 0,
+				created_at timestamptz NOT NULL,
+				heartbeat_at timestamptz,
+				finished_at timestamptz)`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE INDEX IF NOT EXISTS order_uploads_status_idx ON order_uploads (id) WHERE status <> 'done'`)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `
+			CREATE TABLE IF NOT EXISTS order_upload_results (
+				upload_id bigint NOT NULL REFERENCES order_uploads (id) ON DELETE CASCADE,
+				line_number integer NOT NULL,
+				order_number varchar NOT NULL,
+				result varchar NOT NULL,
+				PRIMARY KEY (upload_id, line_number))`)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msg("table order_uploads created")
+	err = r.initOrderUploadsStatements()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// initOrderUploadsStatements - метод, подготавливающий стейтменты БД для пакетной загрузки заказов.
+func (r *Repository) initOrderUploadsStatements() error {
+	stmt, err := r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO orders (id, user_id, status, uploaded_at) VALUES ($1, $2, $3, $4) ON CONFLICT (id) DO NOTHING",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["ordersInsertIfAbsent"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT user_id FROM orders WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["ordersGetOwner"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`INSERT INTO order_uploads (user_id, status, lines, total, created_at)
+			VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["orderUploadsInsert"] = stmt
+	// Задание, обработчик которого дольше orderUploadLease не отмечался, считается брошенным и берется заново.
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		`UPDATE order_uploads SET status='running', heartbeat_at=$1
+			WHERE id = (SELECT id FROM order_uploads
+				WHERE status='pending' OR status='running' AND heartbeat_at < $2
+				ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED)
+			RETURNING id, user_id, status, lines, total, processed, created_at`,
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["orderUploadsClaim"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT processed FROM order_uploads WHERE id=$1 FOR UPDATE",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["orderUploadsGetProcessedForUpdate"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE order_uploads SET processed=$2, heartbeat_at=$3, status=$4, finished_at=$5 WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["orderUploadsProgress"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"INSERT INTO order_upload_results (upload_id, line_number, order_number, result) VALUES ($1, $2, $3, $4)",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["orderUploadResultsInsert"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT id, user_id, status, total, processed, created_at, finished_at FROM order_uploads WHERE id=$1 AND user_id=$2",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["orderUploadsGet"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"SELECT line_number, order_number, result FROM order_upload_results WHERE upload_id=$1 ORDER BY line_number",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["orderUploadResultsGet"] = stmt
+	return nil
+}
+
+// addOrdersBatch - метод, добавляющий в транзакции tx заказы пользователя из строк lines и записывающий в каждую строку результат.
+// Номер, уже загруженный в этой же пачке, становится повтором своего заказа.
+func (r *Repository) addOrdersBatch(tx *sql.Tx, userID uint64, lines []entity.OrderUploadLine, now time.Time) error {
+	txInsert := r.txStmt(tx, "ordersInsertIfAbsent")
+	txGetOwner := r.txStmt(tx, "ordersGetOwner")
+	for i := range lines {
+		orderID, ok := upload.Number(lines[i].Order)
+		if !ok {
+			lines[i].Result = entity.UploadInvalid
+			continue
+		}
+		res, err := txInsert.ExecContext(r.ctx, orderID, userID, "NEW", now)
+		if err != nil {
+			return fmt.Errorf("failed to insert order - %s", err.Error())
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("failed to insert order - %s", err.Error())
+		}
+		if n == 1 {
+			lines[i].Result = entity.UploadAccepted
+			continue
+		}
+		var ownerID uint64
+		err = txGetOwner.QueryRowContext(r.ctx, orderID).Scan(&ownerID)
+		if err != nil {
+			return fmt.Errorf("failed to get order owner - %s", err.Error())
+		}
+		if ownerID == userID {
+			lines[i].Result = entity.UploadDuplicateOwn
+		} else {
+			lines[i].Result = entity.UploadDuplicateOther
+		}
+	}
+	return nil
+}
+
+// AddOrdersBatchDB - метод, в одной транзакции добавляющий заказы пользователя из строк lines и записывающий в каждую строку результат.
+func (r *Repository) AddOrdersBatchDB(userID uint64, lines []entity.OrderUploadLine, now time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = r.addOrdersBatch(tx, userID, lines, now)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("add orders batch transaction failed - %s", err.Error())
+	}
+	return nil
+}
+
+// AddOrderUploadDB - метод, сохраняющий новое задание пакетной загрузки заказов. Заполняет ID задания.
+func (r *Repository) AddOrderUploadDB(u *entity.OrderUpload) error {
+	lines, err := json.Marshal(u.Lines)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload lines - %s", err.Error())
+	}
+	err = r.stmts["orderUploadsInsert"].QueryRowContext(r.ctx, u.UserID, u.Status, string(lines), u.Total, u.CreatedAt).Scan(&u.ID)
+	if err != nil {
+		return fmt.Errorf("failed to add order upload - %s", err.Error())
+	}
+	return nil
+}
+
+// ClaimOrderUploadDB - метод, берущий в работу самое старое ожидающее или брошенное задание загрузки заказов.
+// Если таких заданий нет, возвращает nil.
+func (r *Repository) ClaimOrderUploadDB(now time.Time) (*entity.OrderUpload, error) {
+	u := &entity.OrderUpload{}
+	var lines string
+	err := r.stmts["orderUploadsClaim"].QueryRowContext(r.ctx, now, now.Add(-orderUploadLease)).
+		Scan(&u.ID, &u.UserID, &u.Status, &lines, &u.Total, &u.Processed, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim order upload - %s", err.Error())
+	}
+	err = json.Unmarshal([]byte(lines), &u.Lines)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal upload lines - %s", err.Error())
+	}
+	return u, nil
+}
+
+// AddOrderUploadBatchDB - метод, в одной транзакции добавляющий заказы из очередной пачки строк задания u, начиная
+// со строки u.Processed, и сохраняющий их результаты. Последняя пачка завершает задание. Если задание уже продвинул
+// другой обработчик, ничего не меняет и возвращает false.
+func (r *Repository) AddOrderUploadBatchDB(u *entity.OrderUpload, lines []entity.OrderUploadLine, now time.Time) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var processed int
+	err = r.txStmt(tx, "orderUploadsGetProcessedForUpdate").QueryRowContext(r.ctx, u.ID).Scan(&processed)
+	if err != nil {
+		return false, fmt.Errorf("failed to get order upload - %s", err.Error())
+	}
+	if processed != u.Processed {
+		return false, nil
+	}
+	err = r.addOrdersBatch(tx, u.UserID, lines, now)
+	if err != nil {
+		return false, err
+	}
+	txInsertResult := r.txStmt(tx, "orderUploadResultsInsert")
+	for _, l := range lines {
+		_, err = txInsertResult.ExecContext(r.ctx, u.ID, l.Line, l.Order, l.Result)
+		if err != nil {
+			return false, fmt.Errorf("failed to insert upload result - %s", err.Error())
+		}
+	}
+	processed += len(lines)
+	status := entity.UploadRunning
+	var finishedAt *time.Time
+	if processed >= u.Total {
+		status = entity.UploadDone
+		finishedAt = &now
+	}
+	_, err = r.txStmt(tx, "orderUploadsProgress").ExecContext(r.ctx, u.ID, processed, now, status, finishedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to update order upload - %s", err.Error())
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, fmt.Errorf("order upload batch transaction failed - %s", err.Error())
+	}
+	u.Processed = processed
+	u.Status = status
+	u.FinishedAt = finishedAt
+	return true, nil
+}
+
+// GetOrderUploadDB - метод, возвращающий задание загрузки заказов пользователя с результатами уже обработанных строк в Lines.
+func (r *Repository) GetOrderUploadDB(userID, id uint64) (*entity.OrderUpload, error) {
+	u := &entity.OrderUpload{}
+	finishedAt := new(sql.NullTime)
+	err := r.stmts["orderUploadsGet"].QueryRowContext(r.ctx, id, userID).
+		Scan(&u.ID, &u.UserID, &u.Status, &u.Total, &u.Processed, &u.CreatedAt, finishedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrOrderUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order upload - %s", err.Error())
+	}
+	if finishedAt.Valid {
+		u.FinishedAt = &finishedAt.Time
+	}
+	rows, err := r.stmts["orderUploadResultsGet"].QueryContext(r.ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get upload results - %s", err.Error())
+	}
+	defer rows.Close()
+	u.Lines = make([]entity.OrderUploadLine, 0, u.Processed)
+	for rows.Next() {
+		var l entity.OrderUploadLine
+		err = rows.Scan(&l.Line, &l.Order, &l.Result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan upload result - %s", err.Error())
+		}
+		u.Lines = append(u.Lines, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get upload results - %s", err.Error())
+	}
+	return u, nil
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+type Uploader struct {
+	storage  entity.Storager
+	interval time.Duration
+}
+
+// NewUploader - конструктор фоновой обработки заданий пакетной загрузки заказов.
+func NewUploader(st entity.Storager, interval time.Duration) *Uploader {
+	return &Uploader{
+		storage:  st,
+		interval: interval,
+	}
+}
+
+// Start - запуск периодической обработки заданий загрузки до отмены контекста. Первый проход выполняется сразу.
+func (u *Uploader) Start(ctx context.Context) {
+	if u.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(u.interval)
+	defer ticker.Stop()
+	for {
+		u.process()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// process - метод, выполняющий один проход обработки заданий.
+func (u *Uploader) process() {
+	n, err := u.storage.ProcessOrderUploads()
+	if err != nil {
+		log.Error().Err(err).Msg("failed to process order uploads")
+		return
+	}
+	if n > 0 {
+		log.Debug().Int64("uploads", n).Msg("order uploads processed")
+	}
+}
@@ -1,60 +1,120 @@
 package repository
 
 import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
-	"golang.org/x/crypto/bcrypt"
 
+	"github.com/gtgaleevtimur/gofermart/internal/audit"
 	"github.com/gtgaleevtimur/gofermart/internal/entity"
 	"github.com/gtgaleevtimur/gofermart/internal/loon"
+	"github.com/gtgaleevtimur/gofermart/internal/notify"
+	"github.com/gtgaleevtimur/gofermart/internal/token"
+	"github.com/gtgaleevtimur/gofermart/internal/totp"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+const (
+	// totpSkew - допуск в шагах TOTP на расхождение часов клиента и сервера.
+	totpSkew = 1
+	// maxChallengeAttempts - число попыток ввести код второго фактора на один вход.
+	maxChallengeAttempts = 5
+	// recoveryCodesCount - число кодов восстановления, выдаваемых при подключении TOTP.
+	recoveryCodesCount = 10
+	// apiKeyPrefix - начало каждого API-ключа, по нему ключ отличается от других токенов.
+	apiKeyPrefix = "gmk_"
+	// apiKeyTouchInterval - как часто записывать в БД время последнего использования API-ключа.
+	apiKeyTouchInterval = time.Minute
+	// auditDefaultLimit и auditMaxLimit - размер страницы журнала аудита по умолчанию и наибольший.
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+	// expireBatchSize - сколько пользователей с просроченными баллами обрабатывается за один запрос к БД.
+	expireBatchSize = 100
+	// idempotencyKeyMaxLength - предельная длина ключа идемпотентности перевода.
+	idempotencyKeyMaxLength = 255
 )
 
 // Register - общий метод ля регистрации пользователя.
 func (r *Repository) Register(accInfo *entity.AccountInfo) (*entity.Session, error) {
+	err := r.policy.CheckCredentials(accInfo.Login, accInfo.Password)
+	if err != nil {
+		return nil, err
+	}
+	err = r.policy.CheckEmail(accInfo.Email)
+	if err != nil {
+		return nil, err
+	}
+	// Занятым считается и логин, отличающийся только регистром или формой записи Unicode.
 	r.userMemory.RLock()
-	_, ok := r.userMemory.ByLogin[accInfo.Login]
+	_, ok := r.userMemory.ByLogin[validate.CanonicalLogin(accInfo.Login)]
 	r.userMemory.RUnlock()
 	if ok {
 		return nil, ErrLoginAlreadyTaken
 	}
-	hashedPassword, err := HashPass(accInfo.Password)
+	ref, err := r.referral(accInfo)
+	if err != nil {
+		return nil, err
+	}
+	hashedPassword, err := r.passwords.Hash(accInfo.Password)
 	if err != nil {
 		return nil, err
 	}
 	u := &entity.User{
 		Login:    accInfo.Login,
 		Password: hashedPassword,
+		Email:    accInfo.Email,
 	}
-	id, err := r.AddUserDB(u)
+	e := auditEvent(&entity.Actor{IP: accInfo.IP, RequestID: accInfo.RequestID}, entity.AuditRegister, entity.AuditTargetUser, 0)
+	id, err := r.AddUserDB(u, ref, e)
 	if err != nil {
 		return nil, err
 	}
 	u.ID = id
-	r.userMemory.Lock()
-	r.userMemory.ByLogin[u.Login] = *u
-	r.userMemory.ByID[u.ID] = *u
-	r.userMemory.Unlock()
-	session, err := r.Login(accInfo, "")
+	r.cacheUser(*u)
+	session, err := r.startSession(u.ID, accInfo)
 	if err != nil {
 		return nil, err
 	}
 	return session, nil
 }
 
-// Login - метод, обновляющий сессию при авторизации пользователя и добавляющий при регистрации.
-func (r *Repository) Login(accInfo *entity.AccountInfo, oldToken string) (*entity.Session, error) {
-	user, err := r.GetUser(accInfo.Login)
+// referral - метод, возвращающий приглашение по реферальному коду из данных регистрации или nil, если кода нет.
+// Неизвестный код или код отключенного пользователя - ошибка валидации.
+func (r *Repository) referral(accInfo *entity.AccountInfo) (*entity.Referral, error) {
+	code := normalizeReferralCode(accInfo.ReferralCode)
+	if code == "" {
+		return nil, nil
+	}
+	referrerID, err := r.GetReferrerDB(code)
+	if errors.Is(err, ErrReferralCodeNotFound) {
+		return nil, validate.Errors{{Field: "referral_code", Code: "not_found", Message: "unknown referral code"}}
+	}
 	if err != nil {
 		return nil, err
 	}
-	check := user.CheckPassword(accInfo.Password)
-	if !check {
-		return nil, ErrInvalidPair
+	return &entity.Referral{
+		ReferrerID: referrerID,
+		Status:     entity.ReferralPending,
+		IP:         accInfo.IP,
+		CreatedAt:  time.Now(),
+	}, nil
+}
+
+// Login - метод, обновляющий сессию при авторизации пользователя.
+func (r *Repository) Login(accInfo *entity.AccountInfo, oldToken string) (*entity.Session, error) {
+	user, err := r.authenticate(accInfo)
+	if err != nil {
+		return nil, err
 	}
 	if oldToken != "" {
 		err = r.DeleteSession(oldToken)
@@ -62,67 +122,1503 @@ func (r *Repository) Login(accInfo *entity.AccountInfo, oldToken string) (*entit
 			log.Error().Err(err)
 		}
 	}
+	return r.startSession(user.ID, accInfo)
+}
+
+// startSession - метод, открывающий новую сессию пользователя.
+func (r *Repository) startSession(userID uint64, accInfo *entity.AccountInfo) (*entity.Session, error) {
 	newToken := uuid.NewString()
-	expiresAt := time.Now().Add(600 * time.Second)
+	now := time.Now()
 
 	s := &entity.Session{
-		UserID: user.ID,
-		Token:  newToken,
-		Expiry: expiresAt,
+		UserID:     userID,
+		Token:      newToken,
+		Expiry:     r.sessionExpiry(now, now),
+		CreatedAt:  now,
+		LastUsedAt: now,
+		IP:         accInfo.IP,
+		UserAgent:  accInfo.UserAgent,
+	}
+	err := r.AddSession(s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// authenticate - метод, проверяющий пару логин/пароль и второй фактор, если он подключен, и возвращающий пользователя.
+// Ограничивает частоту попыток по логину и IP, блокирует вход после серии неудачных попыток и пишет их в журнал.
+func (r *Repository) authenticate(accInfo *entity.AccountInfo) (*entity.User, error) {
+	if err := r.limitLogins(accInfo); err != nil {
+		log.Warn().Str("login", accInfo.Login).Str("ip", accInfo.IP).Msg("login attempts rate limited")
+		return nil, err
+	}
+	attempt := &entity.LoginAttempt{
+		Login:     accInfo.Login,
+		IP:        accInfo.IP,
+		UserAgent: accInfo.UserAgent,
+		CreatedAt: time.Now(),
+	}
+	defer r.logLoginAttempt(attempt, accInfo.RequestID)
+	user, err := r.GetUser(accInfo.Login)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			attempt.Reason = attemptUnknownLogin
+		}
+		return nil, err
+	}
+	attempt.UserID = user.ID
+	if user.IsLocked() {
+		attempt.Reason = attemptLocked
+		return nil, &RetryAfterError{Err: ErrAccountLocked, RetryAfter: time.Until(*user.LockedUntil)}
+	}
+	check, err := r.passwords.Verify(user.Password, accInfo.Password)
+	if err != nil {
+		log.Error().Err(err).Uint64("user", user.ID).Msg("failed to verify password hash")
+	}
+	if !check {
+		attempt.Reason = attemptInvalidPassword
+		err = r.addFailedLogin(user)
+		if err != nil {
+			log.Error().Err(err).Uint64("user", user.ID).Msg("failed to register failed login")
+		}
+		return nil, ErrInvalidPair
+	}
+	if user.IsDisabled() {
+		// Проверяется после пароля, чтобы не раскрывать статус аккаунта по одному логину.
+		attempt.Reason = attemptDisabled
+		return nil, ErrAccountDisabled
+	}
+	if r.passwords.NeedsRehash(user.Password) {
+		r.upgradePassword(user, accInfo.Password)
+	}
+	err = r.secondFactor(user, accInfo)
+	if err != nil {
+		if errors.Is(err, ErrMFARequired) {
+			attempt.Reason = attemptMFARequired
+		} else if errors.Is(err, ErrInvalidMFACode) {
+			attempt.Reason = attemptInvalidMFACode
+		}
+		return nil, err
+	}
+	attempt.Success = true
+	attempt.Reason = attemptSuccess
+	r.loginSucceeded(user)
+	return user, nil
+}
+
+// loginSucceeded - метод, сбрасывающий счетчик неудачных входов пользователя после успешного входа.
+func (r *Repository) loginSucceeded(user *entity.User) {
+	if user.FailedLogins == 0 {
+		return
+	}
+	err := r.ResetFailedLoginsDB(user.ID)
+	if err != nil {
+		log.Error().Err(err).Uint64("user", user.ID).Msg("failed to reset failed logins")
+		return
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	r.cacheUser(*user)
+}
+
+// secondFactor - метод, проверяющий второй фактор пользователя с подключенным TOTP.
+// Код из запроса проверяется сразу, без кода возвращается MFARequiredError с новым challenge.
+func (r *Repository) secondFactor(user *entity.User, accInfo *entity.AccountInfo) error {
+	t, err := r.GetTOTPDB(user.ID)
+	if errors.Is(err, ErrTOTPNotEnabled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !t.IsEnabled() {
+		return nil
+	}
+	if accInfo.OTP != "" {
+		return r.verifySecondFactor(user, &t, accInfo.OTP, true)
+	}
+	return &MFARequiredError{Challenge: r.newChallenge(user.ID)}
+}
+
+// newChallenge - метод, открывающий незавершенный вход, который завершается кодом второго фактора.
+func (r *Repository) newChallenge(userID uint64) *entity.MFAChallenge {
+	mfaToken := uuid.NewString()
+	r.challenges.Lock()
+	r.challenges.ByToken[r.tokens.Hash(mfaToken)] = entity.Challenge{
+		UserID: userID,
+		Expiry: time.Now().Add(r.conf.MFAChallengeTTL),
+	}
+	r.challenges.Unlock()
+	return &entity.MFAChallenge{
+		Token:     mfaToken,
+		ExpiresIn: int64(r.conf.MFAChallengeTTL.Seconds()),
+		Methods:   []string{"totp", "recovery_code"},
+	}
+}
+
+// passChallenge - метод, завершающий вход со вторым фактором по токену challenge и коду.
+// На один challenge дается maxChallengeAttempts попыток, неверные коды учитываются как неудачные входы.
+func (r *Repository) passChallenge(mfaToken, code string, accInfo *entity.AccountInfo) (*entity.User, error) {
+	key := r.tokens.Hash(mfaToken)
+	r.challenges.Lock()
+	ch, ok := r.challenges.ByToken[key]
+	if ok {
+		ch.Attempts++
+		if ch.Attempts >= maxChallengeAttempts {
+			delete(r.challenges.ByToken, key)
+		} else {
+			r.challenges.ByToken[key] = ch
+		}
+	}
+	r.challenges.Unlock()
+	if !ok || ch.Expiry.Before(time.Now()) {
+		return nil, ErrMFAChallengeInvalid
+	}
+	user, err := r.GetUser(ch.UserID)
+	if err != nil {
+		return nil, err
+	}
+	attempt := &entity.LoginAttempt{
+		Login:     user.Login,
+		UserID:    user.ID,
+		IP:        accInfo.IP,
+		UserAgent: accInfo.UserAgent,
+		CreatedAt: time.Now(),
+	}
+	defer r.logLoginAttempt(attempt, accInfo.RequestID)
+	if user.IsLocked() {
+		attempt.Reason = attemptLocked
+		return nil, &RetryAfterError{Err: ErrAccountLocked, RetryAfter: time.Until(*user.LockedUntil)}
+	}
+	if user.IsDisabled() {
+		attempt.Reason = attemptDisabled
+		return nil, ErrAccountDisabled
+	}
+	t, err := r.GetTOTPDB(user.ID)
+	if errors.Is(err, ErrTOTPNotEnabled) || (err == nil && !t.IsEnabled()) {
+		return nil, ErrMFAChallengeInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = r.verifySecondFactor(user, &t, code, true)
+	if err != nil {
+		if errors.Is(err, ErrInvalidMFACode) {
+			attempt.Reason = attemptInvalidMFACode
+		}
+		return nil, err
+	}
+	r.challenges.Lock()
+	delete(r.challenges.ByToken, key)
+	r.challenges.Unlock()
+	attempt.Success = true
+	attempt.Reason = attemptSuccess
+	r.loginSucceeded(user)
+	return user, nil
+}
+
+// verifySecondFactor - метод, проверяющий код TOTP или, если allowRecovery, код восстановления.
+// Неверный код учитывается как неудачный вход и может заблокировать вход.
+func (r *Repository) verifySecondFactor(user *entity.User, t *entity.TOTP, code string, allowRecovery bool) error {
+	code = strings.TrimSpace(code)
+	var err error
+	switch {
+	case totp.IsCode(code):
+		err = r.useTOTP(t, code)
+	case allowRecovery:
+		err = r.useRecoveryCode(user.ID, code)
+	default:
+		err = ErrInvalidMFACode
+	}
+	if errors.Is(err, ErrInvalidMFACode) {
+		errFailed := r.addFailedLogin(user)
+		if errFailed != nil {
+			log.Error().Err(errFailed).Uint64("user", user.ID).Msg("failed to register failed login")
+		}
+	}
+	return err
+}
+
+// useTOTP - метод, проверяющий код TOTP. Код принимается один раз: шаг времени запоминается в БД.
+func (r *Repository) useTOTP(t *entity.TOTP, code string) error {
+	step, ok := totp.Validate(t.Secret, code, time.Now(), totpSkew)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	ok, err := r.UseTOTPStepDB(t.UserID, step)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// useRecoveryCode - метод, гасящий код восстановления пользователя.
+func (r *Repository) useRecoveryCode(userID uint64, code string) error {
+	ok, err := r.UseRecoveryCodeDB(userID, totp.NormalizeRecoveryCode(code), time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+	log.Info().Uint64("user", userID).Msg("recovery code used")
+	return nil
+}
+
+// upgradePassword - метод, перехэширующий пароль текущим алгоритмом после успешного входа, пока пароль известен в открытом виде.
+// Ошибка не мешает входу - попытка повторится при следующем.
+func (r *Repository) upgradePassword(user *entity.User, password string) {
+	hash, err := r.passwords.Hash(password)
+	if err != nil {
+		log.Error().Err(err).Uint64("user", user.ID).Msg("failed to rehash password")
+		return
+	}
+	err = r.UpdatePasswordDB(user.ID, hash)
+	if err != nil {
+		log.Error().Err(err).Uint64("user", user.ID).Msg("failed to save rehashed password")
+		return
+	}
+	r.setPassword(user, hash)
+	log.Info().Uint64("user", user.ID).Str("algorithm", r.passwords.Current().Name()).Msg("password hash upgraded")
+}
+
+// setPassword - метод, отражающий сохраненный в БД новый хэш пароля в хэш-таблице и метрике legacy_password_hashes.
+func (r *Repository) setPassword(user *entity.User, hash []byte) {
+	if !r.passwords.Current().Match(user.Password) {
+		legacyPasswordHashes.Add(-1)
+	}
+	user.Password = hash
+	r.cacheUser(*user)
+}
+
+// checkPassword - метод, сверяющий пароль уже вошедшего пользователя перед изменением его учетных данных.
+func (r *Repository) checkPassword(user *entity.User, password string) error {
+	if ok, retryAfter := r.loginsByLogin.Allow(user.Login); !ok {
+		return &RetryAfterError{Err: ErrTooManyLoginAttempts, RetryAfter: retryAfter}
+	}
+	check, err := r.passwords.Verify(user.Password, password)
+	if err != nil {
+		log.Error().Err(err).Uint64("user", user.ID).Msg("failed to verify password hash")
+	}
+	if !check {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+// limitLogins - метод, учитывающий попытку входа в ограничителях по IP и логину.
+func (r *Repository) limitLogins(accInfo *entity.AccountInfo) error {
+	if accInfo.IP != "" {
+		if ok, retryAfter := r.loginsByIP.Allow(accInfo.IP); !ok {
+			return &RetryAfterError{Err: ErrTooManyLoginAttempts, RetryAfter: retryAfter}
+		}
+	}
+	if ok, retryAfter := r.loginsByLogin.Allow(accInfo.Login); !ok {
+		return &RetryAfterError{Err: ErrTooManyLoginAttempts, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// addFailedLogin - метод, учитывающий неудачный вход пользователя и блокирующий вход, когда их набралось LockoutThreshold.
+// Каждая следующая неудача удваивает срок блокировки, но не больше LockoutMax.
+func (r *Repository) addFailedLogin(user *entity.User) error {
+	failed, err := r.AddFailedLoginDB(user.ID)
+	if err != nil {
+		return err
+	}
+	user.FailedLogins = failed
+	if r.conf.LockoutThreshold > 0 && failed >= r.conf.LockoutThreshold {
+		until := time.Now().Add(lockoutDuration(failed-r.conf.LockoutThreshold, r.conf.LockoutBase, r.conf.LockoutMax))
+		err = r.LockUserDB(user.ID, until)
+		if err != nil {
+			return err
+		}
+		user.LockedUntil = &until
+		log.Warn().Uint64("user", user.ID).Int("failed", failed).Time("until", until).Msg("user locked after failed logins")
+	}
+	r.cacheUser(*user)
+	return nil
+}
+
+// lockoutDuration - функция, вычисляющая срок блокировки: base, удвоенный step раз, но не больше limit (если он задан).
+func lockoutDuration(step int, base, limit time.Duration) time.Duration {
+	if step > 30 {
+		step = 30
+	}
+	d := base << step
+	if limit > 0 && (d > limit || d < base) {
+		d = limit
+	}
+	return d
+}
+
+// logLoginAttempt - метод, записывающий попытку входа в журнал, а ее итог - в цепочку попыток входа журнала аудита.
+// Ошибка записи не мешает входу. Запрос второго фактора - промежуточный шаг, в журнал аудита попадает только его результат.
+// Логин в аудит не пишется: попытку с известным логином связывает с пользователем target_id.
+func (r *Repository) logLoginAttempt(attempt *entity.LoginAttempt, requestID string) {
+	if attempt.Reason == "" {
+		return
+	}
+	var e *entity.AuditEvent
+	if attempt.Reason != attemptMFARequired {
+		action := entity.AuditLoginFailed
+		if attempt.Success {
+			action = entity.AuditLogin
+		}
+		e = auditEvent(&entity.Actor{UserID: attempt.UserID, IP: attempt.IP, RequestID: requestID},
+			action, entity.AuditTargetUser, attempt.UserID)
+		if attempt.UserID == 0 {
+			e.TargetID = ""
+		}
+		e.Chain = entity.AuditChainAuth
+		e.After = auditState(map[string]string{"reason": attempt.Reason})
+	}
+	err := r.AddLoginAttemptDB(attempt, e)
+	if err != nil {
+		log.Error().Err(err).Str("login", attempt.Login).Msg("failed to log login attempt")
+	}
+}
+
+// AddSession - метод добавляющий пользователя в хэш-таблицу и БД.
+func (r *Repository) AddSession(session *entity.Session) error {
+	key, cached := r.sessionCacheEntry(*session)
+	r.sessionMemory.RLock()
+	_, ok := r.sessionMemory.BySessionToken[key]
+	r.sessionMemory.RUnlock()
+	if ok {
+		return fmt.Errorf("session already exists")
+	}
+	err := r.AddSessionDB(session)
+	if err != nil {
+		return err
+	}
+	cached.ID = session.ID
+	r.sessionMemory.Lock()
+	r.sessionMemory.BySessionToken[key] = cached
+	r.sessionMemory.Unlock()
+	return nil
+}
+
+// GetSession - метод, возвращающий сессию пользователя из хэш-памяти или БД.
+func (r *Repository) GetSession(token string) (*entity.Session, error) {
+	var err error
+	key := r.tokens.Hash(token)
+	r.sessionMemory.RLock()
+	session, ok := r.sessionMemory.BySessionToken[key]
+	r.sessionMemory.RUnlock()
+	if !ok {
+		session, err = r.GetSessionDB(token)
+		if err != nil {
+			return nil, fmt.Errorf("token session not found - %s", err.Error())
+		}
+		_, cached := r.sessionCacheEntry(session)
+		r.sessionMemory.Lock()
+		r.sessionMemory.BySessionToken[key] = cached
+		r.sessionMemory.Unlock()
+	}
+	session.Token = token
+	return &session, nil
+}
+
+// DeleteSession - метод, удаляющий сессию пользователя из хэш-таблицы и БД.
+func (r *Repository) DeleteSession(token string) error {
+	r.sessionMemory.Lock()
+	delete(r.sessionMemory.BySessionToken, r.tokens.Hash(token))
+	r.sessionMemory.Unlock()
+	err := r.DeleteSessionDB(token)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// sessionCacheEntry - метод, возвращающий ключ и копию сессии для хэш-таблицы: как и в БД, вместо токена там хранится его хэш.
+func (r *Repository) sessionCacheEntry(session entity.Session) (string, entity.Session) {
+	key := r.tokens.Hash(session.Token)
+	session.Token = key
+	return key, session
+}
+
+// TouchSession - метод, отмечающий использование сессии и продлевающий ее срок (скользящее истечение).
+// Запись в БД происходит не чаще, чем раз в sessionTouchInterval.
+func (r *Repository) TouchSession(session *entity.Session) error {
+	now := time.Now()
+	if now.Sub(session.LastUsedAt) < r.sessionTouchInterval() {
+		return nil
+	}
+	expiry := r.sessionExpiry(session.CreatedAt, now)
+	if expiry.Before(session.Expiry) {
+		expiry = session.Expiry
+	}
+	err := r.TouchSessionDB(session.ID, now, expiry)
+	if err != nil {
+		return err
+	}
+	session.LastUsedAt = now
+	session.Expiry = expiry
+	key, cached := r.sessionCacheEntry(*session)
+	r.sessionMemory.Lock()
+	if _, ok := r.sessionMemory.BySessionToken[key]; ok {
+		r.sessionMemory.BySessionToken[key] = cached
+	}
+	r.sessionMemory.Unlock()
+	return nil
+}
+
+// sessionExpiry - метод, вычисляющий срок действия сессии: TTL от последнего использования, но не дольше максимального срока жизни.
+func (r *Repository) sessionExpiry(createdAt, lastUsedAt time.Time) time.Time {
+	expiry := lastUsedAt.Add(r.conf.SessionTTL)
+	if r.conf.SessionMaxLifetime > 0 {
+		limit := createdAt.Add(r.conf.SessionMaxLifetime)
+		if expiry.After(limit) {
+			expiry = limit
+		}
+	}
+	return expiry
+}
+
+// sessionTouchInterval - метод, возвращающий минимальный интервал между обновлениями сессии в БД.
+func (r *Repository) sessionTouchInterval() time.Duration {
+	interval := r.conf.SessionTTL / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	return interval
+}
+
+// SweepSessions - метод, удаляющий истекшие сессии из БД и хэш-таблицы, а также истекшие refresh-токены, токены восстановления пароля и API-ключи.
+func (r *Repository) SweepSessions() (int64, error) {
+	now := time.Now()
+	n, err := r.DeleteExpiredSessionsDB(now)
+	if err != nil {
+		return 0, err
+	}
+	r.forgetSessions(func(s entity.Session) bool {
+		return s.Expiry.Before(now)
+	})
+	r.challenges.Lock()
+	for key, ch := range r.challenges.ByToken {
+		if ch.Expiry.Before(now) {
+			delete(r.challenges.ByToken, key)
+		}
+	}
+	r.challenges.Unlock()
+	tokens, err := r.DeleteExpiredRefreshTokensDB(now)
+	if err != nil {
+		return n, err
+	}
+	resets, err := r.DeleteExpiredPasswordResetsDB(now)
+	if err != nil {
+		return n + tokens, err
+	}
+	keys, err := r.DeleteExpiredAPIKeysDB(now)
+	if err != nil {
+		return n + tokens + resets, err
+	}
+	return n + tokens + resets + keys, nil
+}
+
+// GetSessions - метод, возвращающий активные сессии пользователя из БД.
+func (r *Repository) GetSessions(userID uint64) ([]entity.SessionX, error) {
+	sessions, err := r.GetSessionsDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	sx := make([]entity.SessionX, 0, len(sessions))
+	for _, s := range sessions {
+		if s.IsExpired() {
+			continue
+		}
+		sx = append(sx, entity.SessionX{
+			ID:         s.ID,
+			CreatedAt:  s.CreatedAt.Format(time.RFC3339),
+			LastUsedAt: s.LastUsedAt.Format(time.RFC3339),
+			ExpiresAt:  s.Expiry.Format(time.RFC3339),
+			IP:         s.IP,
+			UserAgent:  s.UserAgent,
+		})
+	}
+	return sx, nil
+}
+
+// DeleteUserSession - метод, завершающий сессию пользователя по ее ID в хэш-таблице и БД.
+func (r *Repository) DeleteUserSession(userID, sessionID uint64) error {
+	err := r.DeleteUserSessionDB(userID, sessionID)
+	if err != nil {
+		return err
+	}
+	r.forgetSessions(func(s entity.Session) bool {
+		return s.ID == sessionID
+	})
+	return nil
+}
+
+// DeleteUserSessions - метод, завершающий все сессии пользователя в хэш-таблице и БД и отзывающий его refresh- и access-токены.
+func (r *Repository) DeleteUserSessions(userID uint64) error {
+	err := r.DeleteUserSessionsDB(userID)
+	if err != nil {
+		return err
+	}
+	err = r.RevokeUserRefreshTokensDB(userID, time.Now())
+	if err != nil {
+		return err
+	}
+	r.forgetSessions(func(s entity.Session) bool {
+		return s.UserID == userID
+	})
+	return r.revokeAccessTokens(userID)
+}
+
+// revokeAccessTokens - метод, отзывающий все выданные пользователю access-токены увеличением версии токенов.
+func (r *Repository) revokeAccessTokens(userID uint64) error {
+	version, err := r.BumpTokenVersionDB(userID)
+	if err != nil {
+		return err
+	}
+	r.userMemory.Lock()
+	if u, ok := r.userMemory.ByID[userID]; ok {
+		u.TokenVersion = version
+		r.userMemory.ByID[userID] = u
+		r.userMemory.ByLogin[validate.CanonicalLogin(u.Login)] = u
+	}
+	r.userMemory.Unlock()
+	return nil
+}
+
+// CompleteLogin - метод, завершающий вход со вторым фактором и открывающий сессию.
+func (r *Repository) CompleteLogin(mfaToken, code string, accInfo *entity.AccountInfo, oldToken string) (*entity.Session, error) {
+	user, err := r.passChallenge(mfaToken, code, accInfo)
+	if err != nil {
+		return nil, err
+	}
+	if oldToken != "" {
+		err = r.DeleteSession(oldToken)
+		if err != nil {
+			log.Error().Err(err)
+		}
+	}
+	return r.startSession(user.ID, accInfo)
+}
+
+// CompleteTokens - метод, завершающий вход со вторым фактором и выпускающий пару токенов.
+func (r *Repository) CompleteTokens(mfaToken, code string, accInfo *entity.AccountInfo) (*entity.TokenPair, error) {
+	user, err := r.passChallenge(mfaToken, code, accInfo)
+	if err != nil {
+		return nil, err
+	}
+	return r.issueTokens(user.ID, accInfo)
+}
+
+// GetMFAStatus - метод, возвращающий состояние второго фактора пользователя.
+func (r *Repository) GetMFAStatus(userID uint64) (*entity.MFAStatus, error) {
+	status := &entity.MFAStatus{}
+	t, err := r.GetTOTPDB(userID)
+	if errors.Is(err, ErrTOTPNotEnabled) {
+		return status, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	status.Enabled = t.IsEnabled()
+	if status.Enabled {
+		status.RecoveryCodesLeft, err = r.CountRecoveryCodesDB(userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// EnrollTOTP - метод, создающий новый секрет TOTP пользователя. Второй фактор включается только после ConfirmTOTP.
+func (r *Repository) EnrollTOTP(userID uint64) (*entity.TOTPEnrollment, error) {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	ok, err := r.AddTOTPDB(&entity.TOTP{
+		UserID:    user.ID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	return &entity.TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(r.conf.TOTPIssuer, user.Login, secret),
+	}, nil
+}
+
+// ConfirmTOTP - метод, включающий TOTP после проверки первого кода из приложения и выдающий коды восстановления.
+// Коды показываются один раз, в БД хранятся только их хэши.
+func (r *Repository) ConfirmTOTP(userID uint64, code string) ([]string, error) {
+	t, err := r.GetTOTPDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	if t.IsEnabled() {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	step, ok := totp.Validate(t.Secret, strings.TrimSpace(code), time.Now(), totpSkew)
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	codes, err := totp.RecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, err
+	}
+	ok, err = r.ConfirmTOTPDB(userID, step, normalizeRecoveryCodes(codes), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+	log.Info().Uint64("user", userID).Msg("TOTP enabled")
+	return codes, nil
+}
+
+// DisableTOTP - метод, отключающий второй фактор после проверки пароля и кода TOTP или кода восстановления.
+func (r *Repository) DisableTOTP(userID uint64, password, code string) error {
+	user, t, err := r.enabledTOTP(userID)
+	if err != nil {
+		return err
+	}
+	err = r.checkPassword(user, password)
+	if err != nil {
+		return err
+	}
+	err = r.verifySecondFactor(user, t, code, true)
+	if err != nil {
+		return err
+	}
+	err = r.DeleteTOTPDB(userID)
+	if err != nil {
+		return err
+	}
+	log.Info().Uint64("user", userID).Msg("TOTP disabled")
+	return nil
+}
+
+// RegenerateRecoveryCodes - метод, заменяющий коды восстановления новыми после проверки кода TOTP.
+func (r *Repository) RegenerateRecoveryCodes(userID uint64, code string) ([]string, error) {
+	user, t, err := r.enabledTOTP(userID)
+	if err != nil {
+		return nil, err
+	}
+	err = r.verifySecondFactor(user, t, code, false)
+	if err != nil {
+		return nil, err
+	}
+	codes, err := totp.RecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, err
+	}
+	err = r.ReplaceRecoveryCodesDB(userID, normalizeRecoveryCodes(codes), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// enabledTOTP - метод, возвращающий пользователя и его подключенный TOTP.
+func (r *Repository) enabledTOTP(userID uint64) (*entity.User, *entity.TOTP, error) {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := r.GetTOTPDB(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !t.IsEnabled() {
+		return nil, nil, ErrTOTPNotEnabled
+	}
+	return user, &t, nil
+}
+
+// normalizeRecoveryCodes - функция, приводящая коды восстановления к виду, в котором они хэшируются.
+func normalizeRecoveryCodes(codes []string) []string {
+	normalized := make([]string, 0, len(codes))
+	for _, c := range codes {
+		normalized = append(normalized, totp.NormalizeRecoveryCode(c))
+	}
+	return normalized
+}
+
+// stepUpWithdraw - метод, требующий свежий код TOTP для списания больше TOTPWithdrawThreshold у пользователя с подключенным TOTP.
+// Коды восстановления здесь не принимаются.
+func (r *Repository) stepUpWithdraw(userID uint64, sum float64, code string) error {
+	if sum <= r.conf.TOTPWithdrawThreshold {
+		return nil
+	}
+	user, t, err := r.enabledTOTP(userID)
+	if errors.Is(err, ErrTOTPNotEnabled) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if code == "" {
+		return ErrMFARequired
+	}
+	if user.IsLocked() {
+		return &RetryAfterError{Err: ErrAccountLocked, RetryAfter: time.Until(*user.LockedUntil)}
+	}
+	return r.verifySecondFactor(user, t, code, false)
+}
+
+// ChangePassword - метод, меняющий пароль вошедшего пользователя после проверки текущего.
+// Остальные сессии и семейства refresh-токенов пользователя завершаются, текущий вход сохраняется.
+func (r *Repository) ChangePassword(current *entity.Session, oldPassword, newPassword string) error {
+	user, err := r.GetUser(current.UserID)
+	if err != nil {
+		return err
+	}
+	err = r.checkPassword(user, oldPassword)
+	if err != nil {
+		return err
+	}
+	err = r.policy.CheckPassword(user.Login, newPassword)
+	if err != nil {
+		return err
+	}
+	hash, err := r.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	err = r.UpdatePasswordDB(user.ID, hash)
+	if err != nil {
+		return err
+	}
+	r.setPassword(user, hash)
+	return r.endOtherSessions(current)
+}
+
+// endOtherSessions - метод, завершающий все сессии и семейства refresh-токенов пользователя, кроме текущего входа.
+// Для входа по access-токену ID сессии нулевой, и завершаются все сессии. Access-токены отзываются все, включая текущий:
+// клиент текущего входа получает новый обменом своего refresh-токена.
+func (r *Repository) endOtherSessions(current *entity.Session) error {
+	err := r.DeleteOtherSessionsDB(current.UserID, current.ID)
+	if err != nil {
+		return err
+	}
+	err = r.RevokeOtherRefreshTokensDB(current.UserID, current.TokenFamily, time.Now())
+	if err != nil {
+		return err
+	}
+	r.forgetSessions(func(s entity.Session) bool {
+		return s.UserID == current.UserID && s.ID != current.ID
+	})
+	return r.revokeAccessTokens(current.UserID)
+}
+
+// ChangeEmail - метод, меняющий адрес почты для восстановления пароля после проверки пароля. Пустой адрес удаляет его.
+func (r *Repository) ChangeEmail(userID uint64, password, email string) error {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	err = r.checkPassword(user, password)
+	if err != nil {
+		return err
+	}
+	err = r.policy.CheckEmail(email)
+	if err != nil {
+		return err
+	}
+	err = r.UpdateEmailDB(user.ID, email)
+	if err != nil {
+		return err
+	}
+	user.Email = email
+	r.cacheUser(*user)
+	return nil
+}
+
+// ExportUser - метод, собирающий все данные пользователя для выгрузки: профиль, баланс, заказы, списания, сессии и API-ключи.
+func (r *Repository) ExportUser(userID uint64) (*entity.UserExport, error) {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	status, err := r.GetMFAStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+	balance, err := r.GetBalance(userID)
+	if err != nil {
+		return nil, err
+	}
+	orders, err := r.GetOrders(userID)
+	if err != nil {
+		return nil, err
+	}
+	withdrawals, err := r.GetWithdrawals(userID)
+	if err != nil && !errors.Is(err, ErrNoContent) {
+		return nil, err
+	}
+	if withdrawals == nil {
+		withdrawals = make([]entity.WithdrawX, 0)
+	}
+	adjustments, err := r.GetUserAdjustments(userID)
+	if err != nil {
+		return nil, err
+	}
+	expirations, err := r.GetPointExpirations(userID)
+	if err != nil {
+		return nil, err
+	}
+	transfers, err := r.GetTransfers(userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := r.GetSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := r.GetAPIKeys(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.UserExport{
+		ExportedAt: time.Now().Format(time.RFC3339),
+		Profile: entity.UserProfile{
+			ID:         user.ID,
+			Login:      user.Login,
+			Email:      user.Email,
+			MFAEnabled: status.Enabled,
+		},
+		Balance:     *balance,
+		Orders:      orders,
+		Withdrawals: withdrawals,
+		Adjustments: adjustments,
+		Expirations: expirations,
+		Transfers:   transfers,
+		Sessions:    sessions,
+		APIKeys:     keys,
+	}, nil
+}
+
+// DeleteAccount - метод, удаляющий аккаунт пользователя после проверки пароля и, если подключен TOTP, кода второго фактора.
+// Персональные данные обезличиваются, а финансовые записи остаются для учета.
+func (r *Repository) DeleteAccount(userID uint64, password, code string) error {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	err = r.checkPassword(user, password)
+	if err != nil {
+		return err
+	}
+	t, err := r.GetTOTPDB(userID)
+	if err != nil && !errors.Is(err, ErrTOTPNotEnabled) {
+		return err
+	}
+	if err == nil && t.IsEnabled() {
+		if code == "" {
+			return ErrMFARequired
+		}
+		err = r.verifySecondFactor(user, &t, code, true)
+		if err != nil {
+			return err
+		}
+	}
+	err = r.DeleteUserDB(userID, user.Login, anonymousLogin(userID), time.Now())
+	if err != nil {
+		return err
+	}
+	r.userMemory.Lock()
+	delete(r.userMemory.ByLogin, validate.CanonicalLogin(user.Login))
+	delete(r.userMemory.ByID, userID)
+	r.userMemory.Unlock()
+	r.forgetSessions(func(s entity.Session) bool {
+		return s.UserID == userID
+	})
+	r.challenges.Lock()
+	for key, ch := range r.challenges.ByToken {
+		if ch.UserID == userID {
+			delete(r.challenges.ByToken, key)
+		}
+	}
+	r.challenges.Unlock()
+	r.loginsByLogin.Reset(user.Login)
+	log.Info().Uint64("user", userID).Msg("account deleted")
+	return nil
+}
+
+// GetUserInfo - метод, возвращающий сведения о пользователе по логину или ID для сотрудников поддержки.
+func (r *Repository) GetUserInfo(byKey interface{}) (*entity.UserX, error) {
+	user, err := r.GetUser(byKey)
+	if err != nil {
+		return nil, err
+	}
+	status, err := r.GetMFAStatus(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	ux := &entity.UserX{
+		ID:           user.ID,
+		Login:        user.Login,
+		Email:        user.Email,
+		Role:         user.Role,
+		MFAEnabled:   status.Enabled,
+		FailedLogins: user.FailedLogins,
+	}
+	if user.IsLocked() {
+		ux.LockedUntil = user.LockedUntil.Format(time.RFC3339)
+	}
+	if user.IsDisabled() {
+		ux.DisabledAt = user.DisabledAt.Format(time.RFC3339)
+	}
+	return ux, nil
+}
+
+// SetUserRole - метод, назначающий пользователю роль от имени сотрудника actor.
+func (r *Repository) SetUserRole(userID uint64, role string, actor *entity.Actor) error {
+	if !entity.IsRole(role) {
+		return validate.Errors{{Field: "role", Code: "invalid_value", Message: "unknown role"}}
+	}
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	e := auditEvent(actor, entity.AuditSetRole, entity.AuditTargetUser, userID)
+	e.Before = auditState(map[string]string{"role": user.Role})
+	e.After = auditState(map[string]string{"role": role})
+	err = r.SetUserRoleDB(userID, role, e)
+	if err != nil {
+		return err
+	}
+	user.Role = role
+	r.cacheUser(*user)
+	log.Info().Uint64("user", userID).Str("role", role).Msg("user role changed")
+	return nil
+}
+
+// DisableUser - метод, отключающий аккаунт пользователя от имени сотрудника actor и завершающий все его сессии и токены.
+func (r *Repository) DisableUser(userID uint64, actor *entity.Actor) error {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.IsDisabled() {
+		now := time.Now()
+		e := auditEvent(actor, entity.AuditDisableUser, entity.AuditTargetUser, userID)
+		e.Before = disabledState(nil)
+		e.After = disabledState(&now)
+		err = r.SetUserDisabledDB(userID, &now, e)
+		if err != nil {
+			return err
+		}
+		user.DisabledAt = &now
+		r.cacheUser(*user)
+	}
+	err = r.DeleteUserSessions(userID)
+	if err != nil {
+		return err
+	}
+	log.Info().Uint64("user", userID).Msg("account disabled")
+	return nil
+}
+
+// EnableUser - метод, включающий ранее отключенный аккаунт от имени сотрудника actor.
+func (r *Repository) EnableUser(userID uint64, actor *entity.Actor) error {
+	user, err := r.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	if !user.IsDisabled() {
+		return nil
+	}
+	e := auditEvent(actor, entity.AuditEnableUser, entity.AuditTargetUser, userID)
+	e.Before = disabledState(user.DisabledAt)
+	e.After = disabledState(nil)
+	err = r.SetUserDisabledDB(userID, nil, e)
+	if err != nil {
+		return err
+	}
+	user.DisabledAt = nil
+	r.cacheUser(*user)
+	log.Info().Uint64("user", userID).Msg("account enabled")
+	return nil
+}
+
+// disabledState - функция, возвращающая для журнала аудита момент отключения аккаунта.
+func disabledState(at *time.Time) string {
+	state := map[string]interface{}{"disabled_at": nil}
+	if at != nil {
+		state["disabled_at"] = at.UTC().Format(time.RFC3339)
+	}
+	return auditState(state)
+}
+
+// LogoutUser - метод, завершающий все сессии пользователя по решению сотрудника actor.
+func (r *Repository) LogoutUser(userID uint64, actor *entity.Actor) error {
+	_, err := r.GetUser(userID)
+	if err != nil {
+		return err
+	}
+	err = r.DeleteUserSessions(userID)
+	if err != nil {
+		return err
+	}
+	return r.AddAuditEventDB(auditEvent(actor, entity.AuditLogoutUser, entity.AuditTargetUser, userID))
+}
+
+// anonymousLogin - функция, возвращающая логин удаленного пользователя. Символ # недопустим в логине при регистрации.
+func anonymousLogin(userID uint64) string {
+	return "deleted#" + strconv.FormatUint(userID, 10)
+}
+
+// RequestPasswordReset - метод, выпускающий одноразовый токен восстановления пароля и отправляющий его пользователю.
+// Для неизвестного логина или пользователя без почты ничего не делает, чтобы ответ не выдавал существование логина.
+func (r *Repository) RequestPasswordReset(accInfo *entity.AccountInfo) error {
+	err := r.limitResets(accInfo)
+	if err != nil {
+		return err
+	}
+	user, err := r.GetUser(accInfo.Login)
+	if errors.Is(err, ErrUserNotFound) {
+		log.Info().Str("login", accInfo.Login).Msg("password reset requested for unknown login")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	to := r.resetRecipient(user)
+	if to == "" {
+		log.Warn().Uint64("user", user.ID).Msg("password reset requested, but user has no email")
+		return nil
+	}
+	now := time.Now()
+	reset := &entity.PasswordReset{
+		UserID:    user.ID,
+		Token:     uuid.NewString(),
+		Expiry:    now.Add(r.conf.PasswordResetTTL),
+		CreatedAt: now,
+		IP:        accInfo.IP,
+	}
+	err = r.AddPasswordResetDB(reset)
+	if err != nil {
+		return err
+	}
+	// Отправка может быть долгой, а время ответа не должно выдавать существование логина.
+	go r.sendPasswordReset(to, reset)
+	return nil
+}
+
+// limitResets - метод, ограничивающий частоту запросов восстановления пароля с одного IP и на один логин.
+// Лимиты отдельны от лимитов входа, чтобы запросами восстановления нельзя было заблокировать вход чужого пользователя.
+func (r *Repository) limitResets(accInfo *entity.AccountInfo) error {
+	if accInfo.IP != "" {
+		if ok, retryAfter := r.resetsByIP.Allow(accInfo.IP); !ok {
+			return &RetryAfterError{Err: ErrTooManyRequests, RetryAfter: retryAfter}
+		}
+	}
+	if ok, retryAfter := r.resetsByLogin.Allow(validate.CanonicalLogin(accInfo.Login)); !ok {
+		return &RetryAfterError{Err: ErrTooManyRequests, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// resetRecipient - метод, возвращающий адрес для восстановления пароля: почту пользователя или логин, если он сам является адресом.
+func (r *Repository) resetRecipient(user *entity.User) string {
+	if user.Email != "" {
+		return user.Email
+	}
+	if strings.Contains(user.Login, "@") && r.policy.CheckEmail(user.Login) == nil {
+		return user.Login
+	}
+	return ""
+}
+
+// sendPasswordReset - метод, отправляющий токен восстановления пароля. Ошибка только пишется в лог.
+func (r *Repository) sendPasswordReset(to string, reset *entity.PasswordReset) {
+	msg := notify.Message{
+		To:      to,
+		Subject: "Gophermart password reset",
+		Body: fmt.Sprintf("Someone requested a password reset for your Gophermart account.\n\n"+
+			"To set a new password, use %s\n\nIt is valid until %s and can be used once. "+
+			"If you did not request a reset, ignore this message.",
+			resetLink(r.conf.PasswordResetURL, reset.Token), reset.Expiry.UTC().Format(time.RFC1123)),
+	}
+	err := r.notifier.Notify(r.ctx, msg)
+	if err != nil {
+		log.Error().Err(err).Uint64("user", reset.UserID).Msg("failed to send password reset")
+		return
+	}
+	log.Info().Uint64("user", reset.UserID).Msg("password reset sent")
+}
+
+// resetLink - функция, подставляющая токен в параметр token ссылки на страницу восстановления. Без ссылки возвращает сам токен.
+func resetLink(base, resetToken string) string {
+	if base == "" {
+		return "the token " + resetToken
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "the token " + resetToken
+	}
+	q := u.Query()
+	q.Set("token", resetToken)
+	u.RawQuery = q.Encode()
+	return "the link " + u.String()
+}
+
+// ResetPassword - метод, устанавливающий новый пароль по токену восстановления.
+// Токен одноразовый; после смены пароля снимается блокировка входа и завершаются все сессии пользователя.
+func (r *Repository) ResetPassword(resetToken, newPassword string) error {
+	reset, err := r.GetPasswordResetDB(resetToken)
+	if err != nil {
+		return err
+	}
+	if reset.UsedAt != nil {
+		return ErrResetTokenInvalid
+	}
+	if reset.IsExpired() {
+		return ErrResetTokenExpired
+	}
+	user, err := r.GetUser(reset.UserID)
+	if err != nil {
+		return err
+	}
+	err = r.policy.CheckPassword(user.Login, newPassword)
+	if err != nil {
+		return err
+	}
+	hash, err := r.passwords.Hash(newPassword)
+	if err != nil {
+		return err
+	}
+	ok, err := r.ResetPasswordDB(reset.ID, user.ID, hash, time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrResetTokenInvalid
+	}
+	user.FailedLogins = 0
+	user.LockedUntil = nil
+	r.setPassword(user, hash)
+	r.loginsByLogin.Reset(user.Login)
+	log.Info().Uint64("user", user.ID).Msg("password reset")
+	// Восстановление пароля означает, что прежние учетные данные могли попасть к чужому, поэтому отзываются и API-ключи.
+	keys, err := r.RevokeUserAPIKeysDB(user.ID, time.Now())
+	if err != nil {
+		return err
+	}
+	if keys > 0 {
+		log.Info().Uint64("user", user.ID).Int64("api_keys", keys).Msg("API keys revoked after password reset")
+	}
+	return r.DeleteUserSessions(user.ID)
+}
+
+// IssueTokens - метод, проверяющий пару логин/пароль и выпускающий access-токен с новым семейством refresh-токенов.
+func (r *Repository) IssueTokens(accInfo *entity.AccountInfo) (*entity.TokenPair, error) {
+	user, err := r.authenticate(accInfo)
+	if err != nil {
+		return nil, err
+	}
+	return r.issueTokens(user.ID, accInfo)
+}
+
+// issueTokens - метод, выпускающий access-токен с новым семейством refresh-токенов для вошедшего пользователя.
+func (r *Repository) issueTokens(userID uint64, accInfo *entity.AccountInfo) (*entity.TokenPair, error) {
+	refresh := r.newRefreshToken(userID, uuid.NewString(), accInfo)
+	err := r.AddRefreshTokenDB(refresh)
+	if err != nil {
+		return nil, err
+	}
+	return r.tokenPair(refresh)
+}
+
+// RefreshTokens - метод, обменивающий refresh-токен на новую пару токенов того же семейства (ротация).
+// Повторное предъявление уже использованного токена означает его кражу - семейство отзывается целиком.
+func (r *Repository) RefreshTokens(refreshToken string, accInfo *entity.AccountInfo) (*entity.TokenPair, error) {
+	used, err := r.GetRefreshTokenDB(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if used.RevokedAt != nil {
+		return nil, ErrRefreshTokenInvalid
+	}
+	if used.UsedAt != nil {
+		return nil, r.revokeReusedFamily(&used)
+	}
+	if used.IsExpired() {
+		return nil, ErrRefreshTokenExpired
+	}
+	next := r.newRefreshToken(used.UserID, used.Family, accInfo)
+	ok, err := r.RotateRefreshTokenDB(used.ID, next.CreatedAt, next)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, r.revokeReusedFamily(&used)
+	}
+	return r.tokenPair(next)
+}
+
+// RevokeRefreshToken - метод, отзывающий семейство, к которому относится refresh-токен.
+func (r *Repository) RevokeRefreshToken(refreshToken string) error {
+	t, err := r.GetRefreshTokenDB(refreshToken)
+	if err != nil {
+		return err
+	}
+	return r.RevokeTokenFamily(t.Family)
+}
+
+// RevokeTokenFamily - метод, отзывающий все refresh-токены семейства. Выпущенные access-токены доживают свой короткий срок.
+func (r *Repository) RevokeTokenFamily(family string) error {
+	return r.RevokeRefreshFamilyDB(family, time.Now())
+}
+
+// ParseAccessToken - метод, проверяющий подпись и срок access-токена и текущее состояние его владельца:
+// токен удаленного пользователя или выпущенный до отзыва токенов недействителен, отключенного - ErrAccountDisabled.
+// Пользователь берется из хэш-таблицы, поэтому БД обычно не нужна.
+func (r *Repository) ParseAccessToken(accessToken string) (*entity.AccessClaims, error) {
+	claims, err := r.access.Parse(accessToken)
+	if err != nil {
+		if errors.Is(err, token.ErrTokenExpired) {
+			return nil, ErrAccessTokenExpired
+		}
+		return nil, fmt.Errorf("%w - %s", ErrAccessTokenInvalid, err.Error())
+	}
+	userID, err := claims.UserID()
+	if err != nil {
+		return nil, fmt.Errorf("%w - %s", ErrAccessTokenInvalid, err.Error())
+	}
+	user, err := r.GetUser(userID)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil, fmt.Errorf("%w - user not found", ErrAccessTokenInvalid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.IsDisabled() {
+		return nil, ErrAccountDisabled
+	}
+	if claims.Version != user.TokenVersion {
+		return nil, fmt.Errorf("%w - token revoked", ErrAccessTokenInvalid)
+	}
+	return &entity.AccessClaims{
+		UserID:  userID,
+		Family:  claims.Family,
+		Version: claims.Version,
+		Expiry:  claims.ExpiresAt.Time,
+	}, nil
+}
+
+// newRefreshToken - метод, создающий новый refresh-токен семейства.
+func (r *Repository) newRefreshToken(userID uint64, family string, accInfo *entity.AccountInfo) *entity.RefreshToken {
+	now := time.Now()
+	return &entity.RefreshToken{
+		UserID:    userID,
+		Family:    family,
+		Token:     uuid.NewString(),
+		Expiry:    now.Add(r.conf.RefreshTokenTTL),
+		CreatedAt: now,
+		IP:        accInfo.IP,
+		UserAgent: accInfo.UserAgent,
+	}
+}
+
+// tokenPair - метод, выпускающий access-токен к refresh-токену.
+func (r *Repository) tokenPair(refresh *entity.RefreshToken) (*entity.TokenPair, error) {
+	user, err := r.GetUser(refresh.UserID)
+	if err != nil {
+		return nil, err
+	}
+	access, expiry, err := r.access.Issue(refresh.UserID, refresh.Family, user.TokenVersion)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.TokenPair{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(expiry.Sub(refresh.CreatedAt).Seconds()),
+		RefreshToken: refresh.Token,
+	}, nil
+}
+
+// CreateAPIKey - метод, выпускающий пользователю API-ключ с областями действия req.Scopes.
+// Ключ возвращается один раз, в БД хранится только его хэш. Без срока действия ключ живет APIKeyTTL.
+func (r *Repository) CreateAPIKey(userID uint64, req *entity.APIKeyRequest) (*entity.APIKeyX, error) {
+	scopes := uniqueStrings(req.Scopes)
+	var errs validate.Errors
+	err := validate.CheckAPIKey(req.Name, scopes, entity.Scopes)
+	if err != nil && !errors.As(err, &errs) {
+		return nil, err
+	}
+	now := time.Now()
+	expiry := now.Add(r.conf.APIKeyTTL)
+	if req.ExpiresAt != nil {
+		expiry = *req.ExpiresAt
+	}
+	if !expiry.After(now) {
+		errs = append(errs, validate.FieldError{Field: "expires_at", Code: "invalid_value", Message: "expires_at must be in the future"})
+	} else if expiry.After(now.Add(r.conf.APIKeyMaxTTL)) {
+		errs = append(errs, validate.FieldError{Field: "expires_at", Code: "too_late",
+			Message: fmt.Sprintf("expires_at must be within %s", r.conf.APIKeyMaxTTL)})
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	key, err := newAPIKey()
+	if err != nil {
+		return nil, err
+	}
+	k := &entity.APIKey{
+		UserID:    userID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    key[:len(apiKeyPrefix)+8],
+		Key:       key,
+		Scopes:    scopes,
+		Expiry:    expiry,
+		CreatedAt: now,
+	}
+	err = r.AddAPIKeyDB(k)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add API key - %s", err.Error())
+	}
+	kx := apiKeyX(k)
+	kx.Key = key
+	return &kx, nil
+}
+
+// GetAPIKeys - метод, возвращающий действующие API-ключи пользователя без самих ключей.
+func (r *Repository) GetAPIKeys(userID uint64) ([]entity.APIKeyX, error) {
+	keys, err := r.GetAPIKeysDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	kx := make([]entity.APIKeyX, 0, len(keys))
+	for i := range keys {
+		if keys[i].IsExpired() {
+			continue
+		}
+		kx = append(kx, apiKeyX(&keys[i]))
+	}
+	return kx, nil
+}
+
+// RevokeAPIKey - метод, отзывающий API-ключ пользователя по его ID.
+func (r *Repository) RevokeAPIKey(userID, id uint64) error {
+	return r.RevokeAPIKeyDB(userID, id, time.Now())
+}
+
+// AuthAPIKey - метод, проверяющий API-ключ и отмечающий его использование.
+// Запись в БД происходит не чаще, чем раз в apiKeyTouchInterval.
+func (r *Repository) AuthAPIKey(key string) (*entity.APIKey, error) {
+	if !IsAPIKey(key) {
+		return nil, ErrAPIKeyInvalid
+	}
+	k, err := r.GetAPIKeyDB(key)
+	if err != nil {
+		return nil, err
+	}
+	if k.RevokedAt != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if k.IsExpired() {
+		return nil, ErrAPIKeyExpired
+	}
+	owner, err := r.GetUser(k.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrAPIKeyInvalid
+		}
+		return nil, err
+	}
+	if owner.IsDisabled() {
+		return nil, ErrAccountDisabled
+	}
+	now := time.Now()
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchInterval {
+		err = r.TouchAPIKeyDB(k.ID, now)
+		if err != nil {
+			log.Error().Err(err).Uint64("api_key", k.ID).Msg("failed to update API key last use")
+		}
+		k.LastUsedAt = &now
 	}
-	err = r.AddSession(s)
-	if err != nil {
-		return nil, err
+	return &k, nil
+}
+
+// IsAPIKey - функция, отличающая API-ключ по префиксу от токена сессии и access-токена.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// newAPIKey - функция, создающая случайный API-ключ с префиксом apiKeyPrefix (256 бит).
+func newAPIKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate API key - %s", err.Error())
 	}
-	return s, nil
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-// AddSession - метод добавляющий пользователя в хэш-таблицу и БД.
-func (r *Repository) AddSession(session *entity.Session) error {
-	r.sessionMemory.RLock()
-	_, ok := r.sessionMemory.BySessionToken[session.Token]
-	r.sessionMemory.RUnlock()
-	if ok {
-		return fmt.Errorf("session already exists")
+// apiKeyX - функция, преобразующая API-ключ в представление для ответа.
+func apiKeyX(k *entity.APIKey) entity.APIKeyX {
+	kx := entity.APIKeyX{
+		ID:        k.ID,
+		Name:      k.Name,
+		Prefix:    k.Prefix,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt.Format(time.RFC3339),
+		ExpiresAt: k.Expiry.Format(time.RFC3339),
 	}
-	err := r.AddSessionDB(session)
-	if err != nil {
-		return err
+	if k.LastUsedAt != nil {
+		kx.LastUsedAt = k.LastUsedAt.Format(time.RFC3339)
 	}
-	r.sessionMemory.Lock()
-	r.sessionMemory.BySessionToken[session.Token] = *session
-	r.sessionMemory.Unlock()
-	return nil
+	return kx
 }
 
-// GetSession - метод, возвращающий сессию пользователя из хэш-памяти или БД.
-func (r *Repository) GetSession(token string) (*entity.Session, error) {
-	var err error
-	r.sessionMemory.RLock()
-	session, ok := r.sessionMemory.BySessionToken[token]
-	r.sessionMemory.RUnlock()
-	if !ok {
-		session, err = r.GetSessionDB(token)
-		if err != nil {
-			return nil, fmt.Errorf("token session not found - %s", err.Error())
+// uniqueStrings - функция, убирающая повторы из списка с сохранением порядка.
+func uniqueStrings(list []string) []string {
+	seen := make(map[string]struct{}, len(list))
+	out := make([]string, 0, len(list))
+	for _, s := range list {
+		if _, ok := seen[s]; ok {
+			continue
 		}
-		r.sessionMemory.Lock()
-		r.sessionMemory.BySessionToken[session.Token] = session
-		r.sessionMemory.Unlock()
+		seen[s] = struct{}{}
+		out = append(out, s)
 	}
-	return &session, nil
+	return out
 }
 
-// DeleteSession - метод, удаляющий сессию пользователя из хэш-таблицы и БД.
-func (r *Repository) DeleteSession(token string) error {
-	r.sessionMemory.Lock()
-	delete(r.sessionMemory.BySessionToken, token)
-	r.sessionMemory.Unlock()
-	err := r.DeleteSessionDB(token)
+// revokeReusedFamily - метод, отзывающий семейство повторно предъявленного refresh-токена.
+func (r *Repository) revokeReusedFamily(used *entity.RefreshToken) error {
+	log.Warn().Uint64("user", used.UserID).Str("family", used.Family).Msg("refresh token reuse detected, revoking family")
+	err := r.RevokeTokenFamily(used.Family)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to revoke token family - %s", err.Error())
 	}
-	return nil
+	return ErrRefreshTokenReused
+}
+
+// forgetSessions - метод, удаляющий из хэш-таблицы сессии, подходящие под условие.
+func (r *Repository) forgetSessions(match func(s entity.Session) bool) {
+	r.sessionMemory.Lock()
+	for token, s := range r.sessionMemory.BySessionToken {
+		if match(s) {
+			delete(r.sessionMemory.BySessionToken, token)
+		}
+	}
+	r.sessionMemory.Unlock()
 }
 
 // GetUser - метод, возвращающий информацию о пользователе из хэш-таблицы или БД.
@@ -133,7 +1629,9 @@ func (r *Repository) GetUser(byKey interface{}) (*entity.User, error) {
 	r.userMemory.RLock()
 	switch key := byKey.(type) {
 	case string:
-		u, ok = r.userMemory.ByLogin[key]
+		// Хэш-таблица хранит пользователей по каноническому логину, а вход возможен только по точному.
+		u, ok = r.userMemory.ByLogin[validate.CanonicalLogin(key)]
+		ok = ok && u.Login == key
 	case uint64:
 		u, ok = r.userMemory.ByID[key]
 	default:
@@ -147,14 +1645,19 @@ func (r *Repository) GetUser(byKey interface{}) (*entity.User, error) {
 			return nil, err
 		}
 		// закэшируем полученного пользователя
-		r.userMemory.Lock()
-		r.userMemory.ByLogin[u.Login] = u
-		r.userMemory.ByID[u.ID] = u
-		r.userMemory.Unlock()
+		r.cacheUser(u)
 	}
 	return &u, nil
 }
 
+// cacheUser - метод, сохраняющий пользователя в хэш-таблице по ID и каноническому логину.
+func (r *Repository) cacheUser(u entity.User) {
+	r.userMemory.Lock()
+	r.userMemory.ByLogin[validate.CanonicalLogin(u.Login)] = u
+	r.userMemory.ByID[u.ID] = u
+	r.userMemory.Unlock()
+}
+
 // PostOrders - метод, регистрирующий заказ пользователя в хэш-таблице или БД.
 func (r *Repository) PostOrders(orderID, userID uint64) error {
 	err := r.AddOrders(orderID, userID)
@@ -251,9 +1754,75 @@ func (r *Repository) GetBalance(userID uint64) (*entity.BalanceX, error) {
 		Current:   float64(b.Current) / 100,
 		Withdrawn: float64(b.Withdrawn) / 100,
 	}
+	if r.conf.PointsExpiringSoon > 0 {
+		now := time.Now()
+		lots, err := r.GetExpiringPointsDB(userID, now, now.Add(r.conf.PointsExpiringSoon))
+		if err != nil {
+			return nil, err
+		}
+		blx.ExpiringSoon = expiringPoints(lots)
+	}
 	return blx, nil
 }
 
+// expiringPoints - функция, суммирующая остатки партий, отсортированных по сроку, по дням сгорания (UTC).
+func expiringPoints(lots []entity.PointLot) []entity.ExpiringPointsX {
+	var days []entity.ExpiringPointsX
+	var sum uint64
+	for i, l := range lots {
+		sum += l.Remaining
+		date := l.ExpiresAt.UTC().Format("2006-01-02")
+		if i+1 < len(lots) && lots[i+1].ExpiresAt.UTC().Format("2006-01-02") == date {
+			continue
+		}
+		days = append(days, entity.ExpiringPointsX{Amount: float64(sum) / 100, Date: date})
+		sum = 0
+	}
+	return days
+}
+
+// GetPointExpirations - метод, возвращающий сгорания баллов для истории пользователя.
+func (r *Repository) GetPointExpirations(userID uint64) ([]entity.PointExpirationX, error) {
+	list, err := r.GetPointExpirationsDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	px := make([]entity.PointExpirationX, 0, len(list))
+	for _, p := range list {
+		px = append(px, entity.PointExpirationX{
+			Amount:    float64(p.Amount) / 100,
+			ExpiredAt: p.ExpiredAt.Format(time.RFC3339),
+		})
+	}
+	return px, nil
+}
+
+// ExpirePoints - метод, сжигающий остатки всех просроченных партий баллов. Возвращает число пользователей, у которых сгорели баллы.
+func (r *Repository) ExpirePoints() (int64, error) {
+	now := time.Now()
+	var n int64
+	for {
+		users, err := r.GetExpiredUsersDB(now, expireBatchSize)
+		if err != nil {
+			return n, err
+		}
+		if len(users) == 0 {
+			return n, nil
+		}
+		for _, userID := range users {
+			amount, err := r.ExpirePointsDB(userID, now)
+			if err != nil {
+				return n, err
+			}
+			r.forgetBalance(userID)
+			if amount > 0 {
+				n++
+				log.Info().Uint64("user", userID).Float64("amount", float64(amount)/100).Msg("points expired")
+			}
+		}
+	}
+}
+
 // PostWithdraw - метод, регистрирующий новое списание из системы лояльности пользователем.
 func (r *Repository) PostWithdraw(wd *entity.WithdrawX) error {
 	orderID, err := strconv.Atoi(wd.Order)
@@ -269,13 +1838,17 @@ func (r *Repository) PostWithdraw(wd *entity.WithdrawX) error {
 	if !loon.IsValid(strOrderID) {
 		return ErrOrderInvalidFormat
 	}
-	err = r.AddWithdrawDB(withdraw)
+	err = r.stepUpWithdraw(withdraw.UserID, wd.Sum, wd.OTP)
 	if err != nil {
 		return err
 	}
-	r.balanceMemory.Lock()
-	delete(r.balanceMemory.ByUserID, withdraw.UserID)
-	r.balanceMemory.Unlock()
+	e := auditEvent(&entity.Actor{UserID: wd.UserID, IP: wd.IP, RequestID: wd.RequestID},
+		entity.AuditWithdraw, entity.AuditTargetOrder, withdraw.OrderID)
+	err = r.AddWithdrawDB(withdraw, e)
+	if err != nil {
+		return err
+	}
+	r.forgetBalance(withdraw.UserID)
 	return nil
 }
 
@@ -303,11 +1876,545 @@ func (r *Repository) GetWithdrawals(userID uint64) ([]entity.WithdrawX, error) {
 	return wdx, nil
 }
 
-// HashPass - функция, хэширующая пароль пользователя.
-func HashPass(password string) ([]byte, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 8)
+// AdjustBalance - метод, вручную начисляющий (amount > 0) или списывающий баллы пользователя от имени сотрудника actor.
+// Корректировка по модулю больше AdjustmentThreshold или сверх суточного AdjustmentDailyLimit автора не применяется сразу,
+// а ждет одобрения другим администратором. Свой баланс сотрудник корректировать не может.
+func (r *Repository) AdjustBalance(userID uint64, actor *entity.Actor, req *entity.AdjustmentRequest) (*entity.AdjustmentX, error) {
+	err := validate.CheckAdjustment(req.Amount, req.Reason, req.Comment, entity.AdjustmentReasons)
+	if err != nil {
+		return nil, err
+	}
+	staffID := actor.UserID
+	if userID == staffID {
+		return nil, ErrSelfAdjustment
+	}
+	_, err = r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	a := &entity.Adjustment{
+		UserID:    userID,
+		Amount:    int64(math.Round(req.Amount * 100)),
+		Reason:    req.Reason,
+		Comment:   strings.TrimSpace(req.Comment),
+		Status:    entity.AdjustmentApplied,
+		CreatedBy: staffID,
+		CreatedAt: now,
+	}
+	if math.Abs(req.Amount) > r.conf.AdjustmentThreshold {
+		a.Status = entity.AdjustmentPending
+	} else {
+		a.DecidedBy = &staffID
+		a.DecidedAt = &now
+	}
+	err = r.AddAdjustmentDB(a, auditEvent(actor, entity.AuditAdjust, entity.AuditTargetAdjustment, 0))
+	if err != nil {
+		return nil, err
+	}
+	if a.Status == entity.AdjustmentApplied {
+		r.forgetBalance(userID)
+	}
+	log.Info().Uint64("user", userID).Uint64("staff", staffID).Int64("amount", a.Amount).
+		Str("status", a.Status).Msg("balance adjustment created")
+	ax := adjustmentX(a)
+	return &ax, nil
+}
+
+// GetAdjustments - метод, возвращающий все корректировки баланса пользователя для сотрудников.
+func (r *Repository) GetAdjustments(userID uint64) ([]entity.AdjustmentX, error) {
+	_, err := r.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	list, err := r.GetAdjustmentsDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	return adjustmentsX(list), nil
+}
+
+// GetPendingAdjustments - метод, возвращающий корректировки баланса, ожидающие одобрения.
+func (r *Repository) GetPendingAdjustments() ([]entity.AdjustmentX, error) {
+	list, err := r.GetPendingAdjustmentsDB()
+	if err != nil {
+		return nil, err
+	}
+	return adjustmentsX(list), nil
+}
+
+// ApproveAdjustment - метод, одобряющий и применяющий корректировку баланса. Ни автор корректировки, ни пользователь,
+// чей баланс она меняет, одобрить ее не могут.
+func (r *Repository) ApproveAdjustment(id uint64, actor *entity.Actor) (*entity.AdjustmentX, error) {
+	return r.decideAdjustment(id, actor, entity.AdjustmentApplied)
+}
+
+// RejectAdjustment - метод, отклоняющий корректировку баланса. Отклонить можно и свою корректировку.
+func (r *Repository) RejectAdjustment(id uint64, actor *entity.Actor) (*entity.AdjustmentX, error) {
+	return r.decideAdjustment(id, actor, entity.AdjustmentRejected)
+}
+
+// decideAdjustment - метод, записывающий решение status по ожидающей корректировке баланса.
+func (r *Repository) decideAdjustment(id uint64, actor *entity.Actor, status string) (*entity.AdjustmentX, error) {
+	staffID := actor.UserID
+	a, err := r.GetAdjustmentDB(id)
+	if err != nil {
+		return nil, err
+	}
+	if a.Status != entity.AdjustmentPending {
+		return nil, ErrAdjustmentNotPending
+	}
+	if status == entity.AdjustmentApplied && a.CreatedBy == staffID {
+		return nil, ErrSelfApproval
+	}
+	if status == entity.AdjustmentApplied && a.UserID == staffID {
+		return nil, ErrSelfAdjustment
+	}
+	now := time.Now()
+	a.Status = status
+	a.DecidedBy = &staffID
+	a.DecidedAt = &now
+	action := entity.AuditAdjustReject
+	if status == entity.AdjustmentApplied {
+		action = entity.AuditAdjustApprove
+	}
+	err = r.DecideAdjustmentDB(&a, auditEvent(actor, action, entity.AuditTargetAdjustment, id))
+	if err != nil {
+		return nil, err
+	}
+	if status == entity.AdjustmentApplied {
+		r.forgetBalance(a.UserID)
+	}
+	log.Info().Uint64("adjustment", id).Uint64("staff", staffID).Str("status", status).Msg("balance adjustment decided")
+	ax := adjustmentX(&a)
+	return &ax, nil
+}
+
+// GetUserAdjustments - метод, возвращающий примененные корректировки баланса для истории пользователя.
+func (r *Repository) GetUserAdjustments(userID uint64) ([]entity.UserAdjustmentX, error) {
+	list, err := r.GetAdjustmentsDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	ux := make([]entity.UserAdjustmentX, 0, len(list))
+	for _, a := range list {
+		if a.Status != entity.AdjustmentApplied || a.DecidedAt == nil {
+			continue
+		}
+		ux = append(ux, entity.UserAdjustmentX{
+			Amount:      float64(a.Amount) / 100,
+			Reason:      a.Reason,
+			ProcessedAt: a.DecidedAt.Format(time.RFC3339),
+		})
+	}
+	return ux, nil
+}
+
+// forgetBalance - метод, удаляющий баланс пользователя из хэш-таблицы после его изменения в БД.
+func (r *Repository) forgetBalance(userID uint64) {
+	r.balanceMemory.Lock()
+	delete(r.balanceMemory.ByUserID, userID)
+	r.balanceMemory.Unlock()
+}
+
+// adjustmentX - функция, переводящая корректировку баланса в вид для ответа API.
+func adjustmentX(a *entity.Adjustment) entity.AdjustmentX {
+	ax := entity.AdjustmentX{
+		ID:        a.ID,
+		UserID:    a.UserID,
+		Amount:    float64(a.Amount) / 100,
+		Reason:    a.Reason,
+		Comment:   a.Comment,
+		Status:    a.Status,
+		CreatedBy: a.CreatedBy,
+		CreatedAt: a.CreatedAt.Format(time.RFC3339),
+	}
+	if a.DecidedBy != nil {
+		ax.DecidedBy = *a.DecidedBy
+	}
+	if a.DecidedAt != nil {
+		ax.DecidedAt = a.DecidedAt.Format(time.RFC3339)
+	}
+	return ax
+}
+
+// adjustmentsX - функция, переводящая список корректировок баланса в вид для ответа API.
+func adjustmentsX(list []entity.Adjustment) []entity.AdjustmentX {
+	ax := make([]entity.AdjustmentX, 0, len(list))
+	for i := range list {
+		ax = append(ax, adjustmentX(&list[i]))
+	}
+	return ax
+}
+
+// GetAuditEvents - метод, возвращающий страницу журнала аудита по фильтру. Следующая страница запрашивается
+// с AfterID, равным ID последнего события.
+func (r *Repository) GetAuditEvents(f *entity.AuditFilter) ([]entity.AuditEventX, error) {
+	if f.Limit <= 0 {
+		f.Limit = auditDefaultLimit
+	}
+	if f.Limit > auditMaxLimit {
+		f.Limit = auditMaxLimit
+	}
+	events, err := r.GetAuditEventsDB(f)
+	if err != nil {
+		return nil, err
+	}
+	ex := make([]entity.AuditEventX, 0, len(events))
+	for _, e := range events {
+		x := entity.AuditEventX{
+			ID:         e.ID,
+			Chain:      e.Chain,
+			CreatedAt:  e.CreatedAt.UTC().Format(time.RFC3339Nano),
+			ActorID:    e.ActorID,
+			Action:     e.Action,
+			TargetType: e.TargetType,
+			TargetID:   e.TargetID,
+			RequestID:  e.RequestID,
+			IP:         e.IP,
+			PrevHash:   e.PrevHash,
+			Hash:       e.Hash,
+		}
+		if e.Before != "" {
+			x.Before = json.RawMessage(e.Before)
+		}
+		if e.After != "" {
+			x.After = json.RawMessage(e.After)
+		}
+		ex = append(ex, x)
+	}
+	return ex, nil
+}
+
+// VerifyAudit - метод, проверяющий все цепочки хэшей журнала аудита от начала и возвращающий первое событие,
+// на котором одна из них разорвана.
+func (r *Repository) VerifyAudit() (*entity.AuditVerification, error) {
+	v := &entity.AuditVerification{Valid: true}
+	f := &entity.AuditFilter{Limit: auditMaxLimit}
+	last := make(map[string]string)
+	for {
+		events, err := r.GetAuditEventsDB(f)
+		if err != nil {
+			return nil, err
+		}
+		if len(events) == 0 {
+			return v, nil
+		}
+		i := audit.Verify(last, events)
+		v.LastHash = last[entity.AuditChainMain]
+		v.AuthLastHash = last[entity.AuditChainAuth]
+		if i >= 0 {
+			v.Valid = false
+			v.Checked += int64(i) + 1
+			v.BrokenID = events[i].ID
+			log.Error().Uint64("event", v.BrokenID).Msg("audit log hash chain is broken")
+			return v, nil
+		}
+		v.Checked += int64(len(events))
+		f.AfterID = events[len(events)-1].ID
+	}
+}
+
+// GetTier - метод, возвращающий уровень пользователя, прогресс до следующего уровня и историю смены уровней.
+// Уровень пересчитывается при каждом начислении, а накопленные баллы считаются на текущий момент.
+// Со скользящим окном уровень тоже считается на текущий момент: баллы, вышедшие из окна, понижают его и без начислений.
+func (r *Repository) GetTier(userID uint64) (*entity.TierX, error) {
+	if len(r.tiers) == 0 {
+		return nil, ErrTiersDisabled
+	}
+	qualifying, err := r.GetQualifyingPointsDB(userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	cur, next := r.tiers.For(qualifying)
+	if r.conf.TierWindow <= 0 {
+		name, err := r.GetUserTierDB(userID)
+		if err != nil {
+			return nil, err
+		}
+		if t, ok := r.tiers.Get(name); ok {
+			cur, next = t, r.tiers.Next(name)
+		}
+	}
+	t := &entity.TierX{
+		Tier:       cur.Name,
+		Multiplier: cur.Multiplier,
+		Qualifying: float64(qualifying) / 100,
+		WindowDays: int(r.conf.TierWindow / (24 * time.Hour)),
+	}
+	if next != nil {
+		t.NextTier = next.Name
+		t.NextThreshold = float64(next.Threshold) / 100
+		if next.Threshold > qualifying {
+			t.ToNextTier = float64(next.Threshold-qualifying) / 100
+		}
+	}
+	history, err := r.GetTierHistoryDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	t.History = make([]entity.TierChangeX, 0, len(history))
+	for _, c := range history {
+		t.History = append(t.History, entity.TierChangeX{
+			From:       c.From,
+			To:         c.To,
+			Qualifying: float64(c.Qualifying) / 100,
+			ChangedAt:  c.ChangedAt.Format(time.RFC3339),
+		})
+	}
+	return t, nil
+}
+
+// GetReferrals - метод, возвращающий реферальный код пользователя, приглашенных им пользователей и заработанные бонусы.
+func (r *Repository) GetReferrals(userID uint64) (*entity.ReferralsX, error) {
+	code, err := r.GetReferralCodeDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	list, err := r.GetReferralsDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	earned, err := r.GetRefereeBonusDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	rx := &entity.ReferralsX{
+		Code:      code,
+		Referrals: make([]entity.ReferralX, 0, len(list)),
+	}
+	for _, ref := range list {
+		x := entity.ReferralX{
+			Login:    ref.RefereeLogin,
+			Status:   ref.Status,
+			Reason:   ref.Reason,
+			Bonus:    float64(ref.ReferrerBonus) / 100,
+			JoinedAt: ref.CreatedAt.Format(time.RFC3339),
+		}
+		if ref.RewardedAt != nil {
+			x.RewardedAt = ref.RewardedAt.Format(time.RFC3339)
+		}
+		earned += ref.ReferrerBonus
+		rx.Referrals = append(rx.Referrals, x)
+	}
+	rx.Earned = float64(earned) / 100
+	return rx, nil
+}
+
+// Transfer - метод, переводящий баллы пользователя другому пользователю по логину от имени actor.
+// Непустой key - ключ идемпотентности: повтор запроса с тем же ключом возвращает исходный перевод и true.
+// Перевод больше TOTPWithdrawThreshold, как и списание, требует код второго фактора otp.
+func (r *Repository) Transfer(userID uint64, actor *entity.Actor, req *entity.TransferRequest, key, otp string) (*entity.TransferX, bool, error) {
+	err := validate.CheckTransfer(req.To, req.Amount, req.Comment)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(key) > idempotencyKeyMaxLength {
+		return nil, false, validate.Errors{{Field: "Idempotency-Key", Code: "too_long",
+			Message: fmt.Sprintf("idempotency key must be at most %d characters", idempotencyKeyMaxLength)}}
+	}
+	to, err := r.GetUser(strings.TrimSpace(req.To))
+	if errors.Is(err, ErrUserNotFound) || err == nil && to.IsDisabled() {
+		return nil, false, validate.Errors{{Field: "to", Code: "not_found", Message: "recipient not found"}}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if to.ID == userID {
+		return nil, false, validate.Errors{{Field: "to", Code: "invalid_value", Message: "cannot transfer points to yourself"}}
+	}
+	t := &entity.Transfer{
+		FromUserID:     userID,
+		ToUserID:       to.ID,
+		Amount:         uint64(math.Round(req.Amount * 100)),
+		Comment:        strings.TrimSpace(req.Comment),
+		IdempotencyKey: key,
+		CreatedAt:      time.Now(),
+	}
+	// Повтор отвечает без второго фактора: код уже проверен при исходном переводе.
+	if key != "" {
+		prev, err := r.GetTransferByKeyDB(userID, key)
+		if err != nil {
+			return nil, false, err
+		}
+		if prev != nil {
+			if !sameTransfer(prev, t) {
+				return nil, false, ErrIdempotencyKeyReused
+			}
+			x := transferX(prev, userID, to.Login)
+			return &x, true, nil
+		}
+	}
+	err = r.stepUpWithdraw(userID, req.Amount, otp)
+	if err != nil {
+		return nil, false, err
+	}
+	replayed, err := r.AddTransferDB(t, auditEvent(actor, entity.AuditTransfer, entity.AuditTargetTransfer, 0))
+	if err != nil {
+		return nil, false, err
+	}
+	if !replayed {
+		r.forgetBalance(t.FromUserID)
+		r.forgetBalance(t.ToUserID)
+		log.Info().Uint64("from", t.FromUserID).Uint64("to", t.ToUserID).Uint64("amount", t.Amount).Msg("points transferred")
+	}
+	x := transferX(t, userID, to.Login)
+	return &x, replayed, nil
+}
+
+// GetTransfers - метод, возвращающий исходящие и входящие переводы пользователя, начиная с новых.
+func (r *Repository) GetTransfers(userID uint64) ([]entity.TransferX, error) {
+	list, err := r.GetTransfersDB(userID)
+	if err != nil {
+		return nil, err
+	}
+	transfers := make([]entity.TransferX, 0, len(list))
+	for i := range list {
+		t := &list[i]
+		counterparty := t.ToLogin
+		if t.ToUserID == userID {
+			counterparty = t.FromLogin
+		}
+		transfers = append(transfers, transferX(t, userID, counterparty))
+	}
+	return transfers, nil
+}
+
+// transferX - функция, преобразующая перевод в представление для ответа пользователю userID.
+func transferX(t *entity.Transfer, userID uint64, counterparty string) entity.TransferX {
+	direction := entity.TransferOut
+	if t.ToUserID == userID {
+		direction = entity.TransferIn
+	}
+	return entity.TransferX{
+		ID:           t.ID,
+		Direction:    direction,
+		Counterparty: counterparty,
+		Amount:       float64(t.Amount) / 100,
+		Comment:      t.Comment,
+		CreatedAt:    t.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// UploadOrders - метод пакетной загрузки заказов пользователя. Загрузка не больше UploadSyncLimit строк обрабатывается сразу
+// пачками по UploadBatchSize, более крупная сохраняется заданием, которое выполняет фоновый обработчик.
+func (r *Repository) UploadOrders(userID uint64, lines []entity.OrderUploadLine) (*entity.OrderUploadX, error) {
+	if len(lines) == 0 {
+		return nil, validate.Errors{{Field: "orders", Code: "required", Message: "upload contains no order numbers"}}
+	}
+	if r.conf.UploadMaxLines > 0 && len(lines) > r.conf.UploadMaxLines {
+		return nil, fmt.Errorf("%w - %d lines, at most %d allowed", ErrOrderUploadTooLarge, len(lines), r.conf.UploadMaxLines)
+	}
+	now := time.Now()
+	if len(lines) > r.conf.UploadSyncLimit {
+		u := &entity.OrderUpload{
+			UserID:    userID,
+			Status:    entity.UploadPending,
+			Lines:     lines,
+			Total:     len(lines),
+			CreatedAt: now,
+		}
+		err := r.AddOrderUploadDB(u)
+		if err != nil {
+			return nil, err
+		}
+		u.Lines = nil
+		return orderUploadX(u), nil
+	}
+	for from := 0; from < len(lines); from += r.uploadBatchSize() {
+		to := from + r.uploadBatchSize()
+		if to > len(lines) {
+			to = len(lines)
+		}
+		err := r.AddOrdersBatchDB(userID, lines[from:to], now)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return orderUploadX(&entity.OrderUpload{
+		Status:    entity.UploadDone,
+		Lines:     lines,
+		Total:     len(lines),
+		Processed: len(lines),
+	}), nil
+}
+
+// GetOrderUpload - метод, возвращающий состояние задания загрузки заказов пользователя и результаты обработанных строк.
+func (r *Repository) GetOrderUpload(userID, id uint64) (*entity.OrderUploadX, error) {
+	u, err := r.GetOrderUploadDB(userID, id)
 	if err != nil {
 		return nil, err
 	}
-	return hashedPassword, nil
+	return orderUploadX(u), nil
+}
+
+// ProcessOrderUploads - метод, выполняющий ожидающие и брошенные задания загрузки заказов. Возвращает число завершенных заданий.
+func (r *Repository) ProcessOrderUploads() (int64, error) {
+	var n int64
+	for {
+		u, err := r.ClaimOrderUploadDB(time.Now())
+		if err != nil {
+			return n, err
+		}
+		if u == nil {
+			return n, nil
+		}
+		for u.Processed < u.Total {
+			to := u.Processed + r.uploadBatchSize()
+			if to > u.Total {
+				to = u.Total
+			}
+			ok, err := r.AddOrderUploadBatchDB(u, u.Lines[u.Processed:to], time.Now())
+			if err != nil {
+				return n, err
+			}
+			// Задание продвинул другой обработчик, взявший его после истечения аренды.
+			if !ok {
+				break
+			}
+		}
+		if u.Status == entity.UploadDone {
+			n++
+			log.Info().Uint64("upload", u.ID).Uint64("user", u.UserID).Int("lines", u.Total).Msg("order upload processed")
+		}
+	}
+}
+
+// uploadBatchSize - метод, возвращающий число строк загрузки, добавляемых в одной транзакции.
+func (r *Repository) uploadBatchSize() int {
+	if r.conf.UploadBatchSize <= 0 {
+		return 1
+	}
+	return r.conf.UploadBatchSize
+}
+
+// orderUploadX - функция, собирающая загрузку заказов для ответа API со сводкой по результатам строк.
+func orderUploadX(u *entity.OrderUpload) *entity.OrderUploadX {
+	ux := &entity.OrderUploadX{
+		ID:        u.ID,
+		Status:    u.Status,
+		Total:     u.Total,
+		Processed: u.Processed,
+		Results:   make([]entity.OrderUploadLine, 0, len(u.Lines)),
+	}
+	if !u.CreatedAt.IsZero() {
+		ux.CreatedAt = u.CreatedAt.Format(time.RFC3339)
+	}
+	if u.FinishedAt != nil {
+		ux.FinishedAt = u.FinishedAt.Format(time.RFC3339)
+	}
+	for _, l := range u.Lines {
+		switch l.Result {
+		case entity.UploadAccepted:
+			ux.Summary.Accepted++
+		case entity.UploadDuplicateOwn:
+			ux.Summary.DuplicateOwn++
+		case entity.UploadDuplicateOther:
+			ux.Summary.DuplicateOther++
+		case entity.UploadInvalid:
+			ux.Summary.Invalid++
+		}
+		ux.Results = append(ux.Results, l)
+	}
+	return ux
 }
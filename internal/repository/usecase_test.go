@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
+)
+
+func TestExpiringPoints(t *testing.T) {
+	msk := time.FixedZone("MSK", 3*60*60)
+	lot := func(remaining uint64, expiresAt time.Time) entity.PointLot {
+		return entity.PointLot{Remaining: remaining, ExpiresAt: &expiresAt}
+	}
+	tests := []struct {
+		name string
+		lots []entity.PointLot
+		want []entity.ExpiringPointsX
+	}{
+		{
+			name: "No lots",
+		},
+		{
+			name: "One lot",
+			lots: []entity.PointLot{lot(15050, time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC))},
+			want: []entity.ExpiringPointsX{{Amount: 150.5, Date: "2024-06-01"}},
+		},
+		{
+			name: "Same day",
+			lots: []entity.PointLot{
+				lot(10000, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)),
+				lot(2500, time.Date(2024, 6, 1, 23, 59, 0, 0, time.UTC)),
+				lot(100, time.Date(2024, 6, 2, 0, 0, 0, 0, time.UTC)),
+			},
+			want: []entity.ExpiringPointsX{{Amount: 125, Date: "2024-06-01"}, {Amount: 1, Date: "2024-06-02"}},
+		},
+		{
+			name: "Days are in UTC",
+			lots: []entity.PointLot{
+				// 2 июня по Москве, но еще 1 июня по UTC.
+				lot(500, time.Date(2024, 6, 2, 1, 0, 0, 0, msk)),
+				lot(700, time.Date(2024, 6, 2, 4, 0, 0, 0, msk)),
+			},
+			want: []entity.ExpiringPointsX{{Amount: 5, Date: "2024-06-01"}, {Amount: 7, Date: "2024-06-02"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, expiringPoints(tt.lots))
+		})
+	}
+}
+
+func TestRegisterLoginTaken(t *testing.T) {
+	policy, err := validate.NewPolicy(3, 64, "", 8, 72, "")
+	require.NoError(t, err)
+	r := &Repository{policy: policy, userMemory: entity.NewUsers()}
+	r.cacheUser(entity.User{ID: 1, Login: "Alice"})
+
+	for _, login := range []string{"Alice", "alice", "ALICE"} {
+		_, err = r.Register(&entity.AccountInfo{Login: login, Password: "correct horse battery"})
+		require.ErrorIs(t, err, ErrLoginAlreadyTaken, login)
+	}
+
+	u, err := r.GetUser("Alice")
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), u.ID)
+}
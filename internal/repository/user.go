@@ -3,13 +3,26 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgconn"
 
 	"github.com/rs/zerolog/log"
 
 	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/validate"
 )
 
+// pgUniqueViolation - код ошибки PostgreSQL о нарушении уникального индекса.
+const pgUniqueViolation = "23505"
+
+// usersColumns - порядок колонок таблицы пользователей, в котором их читает scanUser.
+const usersColumns = "id, login, password, email, failed_logins, locked_until, role, disabled_at, token_version"
+
 // initUsers - метод, создающий таблицу пользователей, если ее нет. Подготавливает стейтменты для базы данных.
 func (r *Repository) initUsers(ctx context.Context) error {
 	_, err := r.db.ExecContext(ctx, `
@@ -20,19 +33,182 @@ func (r *Repository) initUsers(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	_, err = r.db.ExecContext(ctx, `
+			ALTER TABLE users
+				ADD COLUMN IF NOT EXISTS failed_logins integer NOT NULL DEFAULT 0,
+				ADD COLUMN IF NOT EXISTS locked_until timestamptz,
+				ADD COLUMN IF NOT EXISTS login_canonical varchar,
+				ADD COLUMN IF NOT EXISTS email varchar NOT NULL DEFAULT '',
+				ADD COLUMN IF NOT EXISTS deleted_at timestamptz,
+				ADD COLUMN IF NOT EXISTS role varchar NOT NULL DEFAULT 'user',
+				ADD COLUMN IF NOT EXISTS disabled_at timestamptz,
+				ADD COLUMN IF NOT EXISTS token_version integer NOT NULL DEFAULT 0`)
+	if err != nil {
+		return err
+	}
+	err = r.backfillCanonicalLogins(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS users_login_canonical_idx ON users (login_canonical)`)
+	if err != nil {
+		return err
+	}
 	log.Debug().Msg("table users created")
 	err = r.initUsersStatements()
 	if err != nil {
 		return err
 	}
+	err = r.countLegacyPasswords(ctx)
+	if err != nil {
+		return err
+	}
+	err = r.grantAdmins(ctx)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// grantAdmins - метод, выдающий роль администратора пользователям из ADMIN_LOGINS, чтобы было кому назначать роли через API.
+// Роль выдается, только пока в системе нет ни одного администратора, поэтому перезапуск не отменяет изменений ролей через API.
+// Логин из списка должен быть уже зарегистрирован, иначе запуск прерывается: ждать его регистрации нельзя, ведь его может занять кто угодно.
+func (r *Repository) grantAdmins(ctx context.Context) error {
+	logins := make([]string, 0)
+	for _, login := range strings.Split(r.conf.AdminLogins, ",") {
+		login = strings.TrimSpace(login)
+		if login != "" {
+			logins = append(logins, login)
+		}
+	}
+	if len(logins) == 0 {
+		return nil
+	}
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var admins int
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM users WHERE role = $1 AND deleted_at IS NULL`, entity.RoleAdmin).Scan(&admins)
+	if err != nil {
+		return err
+	}
+	if admins > 0 {
+		log.Info().Int("admins", admins).Msg("admins already exist, ADMIN_LOGINS ignored")
+		return nil
+	}
+	for _, login := range logins {
+		res, err := tx.ExecContext(ctx, `UPDATE users SET role = $2 WHERE login_canonical = $1 AND deleted_at IS NULL`,
+			validate.CanonicalLogin(login), entity.RoleAdmin)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("admin login `%s` from ADMIN_LOGINS is not registered", login)
+		}
+		log.Info().Str("login", login).Msg("admin role granted from ADMIN_LOGINS")
+	}
+	return tx.Commit()
+}
+
+// countLegacyPasswords - метод, считающий пользователей с хэшем пароля не текущего алгоритма для метрики legacy_password_hashes.
+// Хватает начала хэша, в нем записан алгоритм.
+func (r *Repository) countLegacyPasswords(ctx context.Context) error {
+	rows, err := r.db.QueryContext(ctx, `SELECT substring(password from 1 for 16) FROM users`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var legacy int64
+	for rows.Next() {
+		var prefix []byte
+		if err = rows.Scan(&prefix); err != nil {
+			return err
+		}
+		if !r.passwords.Current().Match(prefix) {
+			legacy++
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	legacyPasswordHashes.Set(legacy)
+	if legacy > 0 {
+		log.Info().Int64("count", legacy).Str("algorithm", r.passwords.Current().Name()).Msg("users with legacy password hashes")
+	}
 	return nil
 }
 
+// backfillCanonicalLogins - метод, заполняющий канонический логин у пользователей, зарегистрированных до его появления.
+// Если канонический логин уже занят, к нему добавляется ID пользователя, чтобы уникальный индекс мог быть создан.
+func (r *Repository) backfillCanonicalLogins(ctx context.Context) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	taken := make(map[string]bool)
+	rows, err := tx.QueryContext(ctx, `SELECT login_canonical FROM users WHERE login_canonical IS NOT NULL`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var canonical string
+		if err = rows.Scan(&canonical); err != nil {
+			rows.Close()
+			return err
+		}
+		taken[canonical] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	type pending struct {
+		id    uint64
+		login string
+	}
+	users := make([]pending, 0)
+	rows, err = tx.QueryContext(ctx, `SELECT id, login FROM users WHERE login_canonical IS NULL ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var u pending
+		if err = rows.Scan(&u.id, &u.login); err != nil {
+			rows.Close()
+			return err
+		}
+		users = append(users, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, u := range users {
+		canonical := validate.CanonicalLogin(u.login)
+		if taken[canonical] {
+			log.Warn().Uint64("user", u.id).Str("login", u.login).Msg("canonical login collides with another user")
+			canonical += "#" + strconv.FormatUint(u.id, 10)
+		}
+		taken[canonical] = true
+		_, err = tx.ExecContext(ctx, `UPDATE users SET login_canonical = $2 WHERE id = $1`, u.id, canonical)
+		if err != nil {
+			return err
+		}
+	}
+	if len(users) > 0 {
+		log.Info().Int("count", len(users)).Msg("canonical logins backfilled")
+	}
+	return tx.Commit()
+}
+
 // initUsersStatements - метод, добавляющий стейтменты БД для работы с таблицей пользователей.
 func (r *Repository) initUsersStatements() error {
 	stmt, err := r.db.PrepareContext(
 		r.ctx,
-		"INSERT INTO users (login, password) VALUES ($1, $2)",
+		"INSERT INTO users (login, password, login_canonical, email) VALUES ($1, $2, $3, $4)",
 	)
 	if err != nil {
 		return err
@@ -40,7 +216,7 @@ func (r *Repository) initUsersStatements() error {
 	r.stmts["usersInsert"] = stmt
 	stmt, err = r.db.PrepareContext(
 		r.ctx,
-		"SELECT * FROM users WHERE login=$1",
+		"SELECT "+usersColumns+" FROM users WHERE login=$1 AND deleted_at IS NULL",
 	)
 	if err != nil {
 		return err
@@ -48,25 +224,97 @@ func (r *Repository) initUsersStatements() error {
 	r.stmts["usersGetByLogin"] = stmt
 	stmt, err = r.db.PrepareContext(
 		r.ctx,
-		"SELECT * FROM users WHERE id=$1",
+		"SELECT "+usersColumns+" FROM users WHERE id=$1 AND deleted_at IS NULL",
 	)
 	if err != nil {
 		return err
 	}
 	r.stmts["usersGetByID"] = stmt
+	// Пользователь не удаляется, а обезличивается: его заказы, списания и баланс нужны для учета.
 	stmt, err = r.db.PrepareContext(
 		r.ctx,
-		"DELETE FROM users WHERE login=$1",
+		`UPDATE users SET login = $2, login_canonical = $2, password = '', email = '', failed_logins = 0, locked_until = NULL, deleted_at = $3
+			WHERE id = $1 AND deleted_at IS NULL`,
 	)
 	if err != nil {
 		return err
 	}
 	r.stmts["usersDelete"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET failed_logins = failed_logins + 1 WHERE id=$1 RETURNING failed_logins",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersAddFailedLogin"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET locked_until = $2 WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersLock"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersResetFailedLogins"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET password = $2 WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersUpdatePassword"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET email = $2 WHERE id=$1",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersUpdateEmail"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET role = $2 WHERE id=$1 AND deleted_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersSetRole"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET disabled_at = $2 WHERE id=$1 AND deleted_at IS NULL",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersSetDisabled"] = stmt
+	stmt, err = r.db.PrepareContext(
+		r.ctx,
+		"UPDATE users SET token_version = token_version + 1 WHERE id=$1 RETURNING token_version",
+	)
+	if err != nil {
+		return err
+	}
+	r.stmts["usersBumpTokenVersion"] = stmt
 	return nil
 }
 
-// AddUserDB - метод, добавляющий пользователя в БД.
-func (r *Repository) AddUserDB(u *entity.User) (uint64, error) {
+// scanUser - функция, читающая пользователя из строки результата в порядке usersColumns.
+func scanUser(row scanner, u *entity.User) error {
+	return row.Scan(&u.ID, &u.Login, &u.Password, &u.Email, &u.FailedLogins, &u.LockedUntil, &u.Role, &u.DisabledAt, &u.TokenVersion)
+}
+
+// AddUserDB - метод, добавляющий пользователя в БД вместе с его реферальным кодом и, если ref не nil,
+// приглашением, по которому он зарегистрировался.
+func (r *Repository) AddUserDB(u *entity.User, ref *entity.Referral, e *entity.AuditEvent) (uint64, error) {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return 0, err
@@ -77,14 +325,18 @@ func (r *Repository) AddUserDB(u *entity.User) (uint64, error) {
 	txInsertBalance := tx.StmtContext(r.ctx, r.stmts["balanceInsert"])
 	row := txGet.QueryRowContext(r.ctx, u.Login)
 	blankUser := entity.User{}
-	err = row.Scan(&blankUser.ID, &blankUser.Login, &blankUser.Password)
+	err = scanUser(row, &blankUser)
 	if err == sql.ErrNoRows {
-		_, err = txInsert.ExecContext(r.ctx, u.Login, u.Password)
+		_, err = txInsert.ExecContext(r.ctx, u.Login, u.Password, validate.CanonicalLogin(u.Login), u.Email)
+		if isUniqueViolation(err) {
+			// Логин отличается от занятого только регистром или формой записи Unicode.
+			return 0, ErrLoginAlreadyTaken
+		}
 		if err != nil {
 			return 0, err
 		}
 		row = txGet.QueryRowContext(r.ctx, u.Login)
-		err = row.Scan(&u.ID, &u.Login, &u.Password)
+		err = scanUser(row, u)
 		if err != nil {
 			return 0, err
 		}
@@ -92,6 +344,23 @@ func (r *Repository) AddUserDB(u *entity.User) (uint64, error) {
 		if err != nil {
 			return 0, err
 		}
+		_, err = r.referralCode(tx, u.ID)
+		if err != nil {
+			return 0, err
+		}
+		if ref != nil {
+			ref.RefereeID = u.ID
+			err = r.addReferral(tx, ref)
+			if err != nil {
+				return 0, err
+			}
+		}
+		e.ActorID = u.ID
+		e.TargetID = strconv.FormatUint(u.ID, 10)
+		err = r.appendAudit(tx, e)
+		if err != nil {
+			return 0, err
+		}
 	} else if err != nil {
 		return 0, err
 	} else {
@@ -123,7 +392,7 @@ func (r *Repository) GetUserDB(byKey interface{}) (entity.User, error) {
 	default:
 		return u, fmt.Errorf("given type not implemented")
 	}
-	err = row.Scan(&u.ID, &u.Login, &u.Password)
+	err = scanUser(row, &u)
 	if err == sql.ErrNoRows {
 		return u, ErrUserNotFound
 	}
@@ -137,3 +406,132 @@ func (r *Repository) GetUserDB(byKey interface{}) (entity.User, error) {
 
 	return u, nil
 }
+
+// AddFailedLoginDB - метод, увеличивающий счетчик неудачных входов пользователя и возвращающий новое значение.
+func (r *Repository) AddFailedLoginDB(userID uint64) (int, error) {
+	var failed int
+	row := r.stmts["usersAddFailedLogin"].QueryRowContext(r.ctx, userID)
+	err := row.Scan(&failed)
+	if err != nil {
+		return 0, err
+	}
+	return failed, nil
+}
+
+// LockUserDB - метод, блокирующий вход пользователя до указанного времени.
+func (r *Repository) LockUserDB(userID uint64, until time.Time) error {
+	_, err := r.stmts["usersLock"].ExecContext(r.ctx, userID, until)
+	return err
+}
+
+// ResetFailedLoginsDB - метод, сбрасывающий счетчик неудачных входов и блокировку пользователя.
+func (r *Repository) ResetFailedLoginsDB(userID uint64) error {
+	_, err := r.stmts["usersResetFailedLogins"].ExecContext(r.ctx, userID)
+	return err
+}
+
+// isUniqueViolation - функция, проверяющая, что запрос нарушил уникальный индекс.
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation
+}
+
+// UpdatePasswordDB - метод, заменяющий хэш пароля пользователя.
+func (r *Repository) UpdatePasswordDB(userID uint64, hash []byte) error {
+	_, err := r.stmts["usersUpdatePassword"].ExecContext(r.ctx, userID, hash)
+	return err
+}
+
+// UpdateEmailDB - метод, заменяющий адрес почты пользователя.
+func (r *Repository) UpdateEmailDB(userID uint64, email string) error {
+	_, err := r.stmts["usersUpdateEmail"].ExecContext(r.ctx, userID, email)
+	return err
+}
+
+// BumpTokenVersionDB - метод, увеличивающий версию access-токенов пользователя. Возвращает новую версию.
+func (r *Repository) BumpTokenVersionDB(userID uint64) (int, error) {
+	var version int
+	err := r.stmts["usersBumpTokenVersion"].QueryRowContext(r.ctx, userID).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to bump token version - %s", err.Error())
+	}
+	return version, nil
+}
+
+// SetUserRoleDB - метод, назначающий пользователю роль и записывающий событие e в журнал аудита.
+func (r *Repository) SetUserRoleDB(userID uint64, role string, e *entity.AuditEvent) error {
+	return r.updateUser("usersSetRole", userID, role, e)
+}
+
+// SetUserDisabledDB - метод, отключающий аккаунт с момента at или, если at пустой, включающий его обратно.
+// Событие e записывается в журнал аудита.
+func (r *Repository) SetUserDisabledDB(userID uint64, at *time.Time, e *entity.AuditEvent) error {
+	return r.updateUser("usersSetDisabled", userID, at, e)
+}
+
+// updateUser - метод, выполняющий стейтмент изменения пользователя вместе с записью события e в журнал аудита.
+// Возвращает ErrUserNotFound, если пользователя нет.
+func (r *Repository) updateUser(stmt string, userID uint64, value interface{}, e *entity.AuditEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.StmtContext(r.ctx, r.stmts[stmt]).ExecContext(r.ctx, userID, value)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	err = r.appendAudit(tx, e)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUserDB - метод, в одной транзакции обезличивающий пользователя: логин заменяется на anonLogin, пароль и почта стираются.
+// Удаляются сессии, токены восстановления пароля, TOTP и API-ключи, refresh-токены отзываются, из журнала входов убираются IP и user agent.
+// Заказы, списания и баланс остаются.
+func (r *Repository) DeleteUserDB(userID uint64, login, anonLogin string, at time.Time) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.StmtContext(r.ctx, r.stmts["usersDelete"]).ExecContext(r.ctx, userID, anonLogin, at)
+	if err != nil {
+		return err
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUserNotFound
+	}
+	for _, name := range []string{"sessionsDeleteForUser", "passwordResetsDeleteForUser", "totpDelete", "recoveryCodesDeleteForUser", "apiKeysDeleteForUser"} {
+		_, err = tx.StmtContext(r.ctx, r.stmts[name]).ExecContext(r.ctx, userID)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.StmtContext(r.ctx, r.stmts["refreshTokensRevokeForUser"]).ExecContext(r.ctx, userID, at)
+	if err != nil {
+		return err
+	}
+	_, err = tx.StmtContext(r.ctx, r.stmts["loginAttemptsAnonymize"]).ExecContext(r.ctx, userID, login, anonLogin)
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("delete user transaction failed - %s", err.Error())
+	}
+	return nil
+}
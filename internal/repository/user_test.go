@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func TestDeleteAccount(t *testing.T) {
+	r := newTestRepository(t, testConfig())
+	u := addTestUser(t, r, "deleted")
+	accInfo := &entity.AccountInfo{Login: u.Login, Password: "correct horse battery"}
+	session, err := r.Login(accInfo, "")
+	require.NoError(t, err)
+	tokens, err := r.IssueTokens(accInfo)
+	require.NoError(t, err)
+	key, err := r.CreateAPIKey(u.ID, &entity.APIKeyRequest{Name: "shop", Scopes: []string{entity.ScopeBalanceRead}})
+	require.NoError(t, err)
+	require.NoError(t, r.PostOrders(testOrderNumber(), u.ID))
+	creditTestUser(t, r, u.ID, 1000, nil)
+	orderID := testOrderNumber()
+	require.NoError(t, r.AddWithdrawDB(&entity.Withdraw{OrderID: orderID, UserID: u.ID, Sum: 500},
+		auditEvent(&entity.Actor{UserID: u.ID}, entity.AuditWithdraw, entity.AuditTargetOrder, orderID)))
+
+	require.ErrorIs(t, r.DeleteAccount(u.ID, "wrong password", ""), ErrInvalidPassword)
+	require.NoError(t, r.DeleteAccount(u.ID, accInfo.Password, ""))
+
+	// Персональные данные обезличены.
+	var login, password, email string
+	var deleted bool
+	err = r.db.QueryRowContext(r.ctx, "SELECT login, password, email, deleted_at IS NOT NULL FROM users WHERE id = $1", u.ID).
+		Scan(&login, &password, &email, &deleted)
+	require.NoError(t, err)
+	require.Equal(t, anonymousLogin(u.ID), login)
+	require.Empty(t, password)
+	require.Empty(t, email)
+	require.True(t, deleted)
+	_, err = r.GetUser(u.Login)
+	require.ErrorIs(t, err, ErrUserNotFound)
+	_, err = r.GetUser(u.ID)
+	require.ErrorIs(t, err, ErrUserNotFound)
+
+	// Сессии, токены и API-ключи отозваны.
+	_, err = r.GetSession(session.Token)
+	require.Error(t, err)
+	_, err = r.ParseAccessToken(tokens.AccessToken)
+	require.Error(t, err)
+	_, err = r.RefreshTokens(tokens.RefreshToken, &entity.AccountInfo{})
+	require.Error(t, err)
+	_, err = r.AuthAPIKey(key.Key)
+	require.Error(t, err)
+	_, err = r.Login(accInfo, "")
+	require.Error(t, err)
+
+	// Заказы и списания остаются для учета.
+	orders, err := r.GetOrdersDB(u.ID)
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	withdrawals, err := r.GetWithdrawalsDB(u.ID)
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	require.Equal(t, uint64(500), testBalance(t, r, u.ID))
+
+	// Логин освобождается.
+	_, err = r.Register(&entity.AccountInfo{Login: u.Login, Password: accInfo.Password})
+	require.NoError(t, err)
+}
@@ -59,8 +59,9 @@ func (r *Repository) initWithdrawalsStatements() error {
 	return nil
 }
 
-// AddWithdrawDB - метод, добавляющий списание баллов лояльности пользователя в БД.
-func (r *Repository) AddWithdrawDB(withdraw *entity.Withdraw) error {
+// AddWithdrawDB - метод, добавляющий списание баллов лояльности пользователя в БД и событие e в журнал аудита.
+// Сначала сгорают просроченные партии, затем списанные баллы расходуются из партий, начиная с тех, что сгорают раньше.
+func (r *Repository) AddWithdrawDB(withdraw *entity.Withdraw, e *entity.AuditEvent) error {
 	tx, err := r.db.Begin()
 	if err != nil {
 		return err
@@ -68,7 +69,7 @@ func (r *Repository) AddWithdrawDB(withdraw *entity.Withdraw) error {
 	defer tx.Rollback()
 	txGetByID := tx.StmtContext(r.ctx, r.stmts["withdrawalsGetByID"])
 	txInsertWithdrawal := tx.StmtContext(r.ctx, r.stmts["withdrawalsInsert"])
-	txGetBalance := tx.StmtContext(r.ctx, r.stmts["balanceGet"])
+	txGetBalance := tx.StmtContext(r.ctx, r.stmts["balanceGetForUpdate"])
 	txUpdateBalance := tx.StmtContext(r.ctx, r.stmts["balanceUpdate"])
 	var balance entity.Balance
 	row := txGetBalance.QueryRowContext(r.ctx, withdraw.UserID)
@@ -79,6 +80,11 @@ func (r *Repository) AddWithdrawDB(withdraw *entity.Withdraw) error {
 	if err != nil {
 		return fmt.Errorf("failed to get user balance - %s", err.Error())
 	}
+	now := time.Now()
+	_, err = r.expireDueLots(tx, &balance, now)
+	if err != nil {
+		return err
+	}
 	if balance.Current < withdraw.Sum {
 		return ErrNotEnoughFunds
 	}
@@ -95,7 +101,17 @@ func (r *Repository) AddWithdrawDB(withdraw *entity.Withdraw) error {
 	err = row.Scan(&bw.OrderID, &bw.UserID, &bw.Sum, date)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			_, err = txInsertWithdrawal.ExecContext(r.ctx, withdraw.OrderID, withdraw.UserID, withdraw.Sum, time.Now())
+			_, err = txInsertWithdrawal.ExecContext(r.ctx, withdraw.OrderID, withdraw.UserID, withdraw.Sum, now)
+			if err != nil {
+				return err
+			}
+			_, err = r.consumePointLots(tx, withdraw.UserID, withdraw.Sum, now)
+			if err != nil {
+				return err
+			}
+			e.Before = balanceState(balance)
+			e.After = balanceState(entity.Balance{Current: current, Withdrawn: withdrawn})
+			err = r.appendAudit(tx, e)
 			if err != nil {
 				return err
 			}
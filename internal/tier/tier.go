@@ -0,0 +1,101 @@
+package tier
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Tier - уровень программы лояльности: с какого числа накопленных баллов (в копейках) он действует
+// и во сколько раз увеличивает начисления.
+type Tier struct {
+	Name       string
+	Threshold  uint64
+	Multiplier float64
+}
+
+// Apply - метод, возвращающий начисление accrual (в копейках) с учетом множителя уровня.
+func (t Tier) Apply(accrual uint64) uint64 {
+	return uint64(math.Round(float64(accrual) * t.Multiplier))
+}
+
+// Ladder - уровни в порядке возрастания порога. Порог первого уровня всегда 0.
+type Ladder []Tier
+
+// Parse - функция, читающая уровни из строки вида `bronze:0:1,silver:1000:1.05,gold:5000:1.1`
+// (название, порог в баллах, множитель). Пустая строка отключает уровни.
+func Parse(spec string) (Ladder, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	var l Ladder
+	for _, item := range strings.Split(spec, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid tier `%s` - want name:threshold:multiplier", item)
+		}
+		threshold, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid threshold of tier `%s`", parts[0])
+		}
+		multiplier, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || multiplier <= 0 {
+			return nil, fmt.Errorf("invalid multiplier of tier `%s`", parts[0])
+		}
+		if _, ok := l.Get(parts[0]); ok {
+			return nil, fmt.Errorf("duplicate tier `%s`", parts[0])
+		}
+		t := Tier{Name: parts[0], Threshold: uint64(math.Round(threshold * 100)), Multiplier: multiplier}
+		if len(l) == 0 && t.Threshold != 0 {
+			return nil, fmt.Errorf("first tier `%s` must have zero threshold", t.Name)
+		}
+		if len(l) > 0 && t.Threshold <= l[len(l)-1].Threshold {
+			return nil, fmt.Errorf("tier `%s` threshold must be greater than the previous one", t.Name)
+		}
+		l = append(l, t)
+	}
+	return l, nil
+}
+
+// For - метод, возвращающий уровень для qualifying накопленных баллов и следующий уровень, если он есть.
+func (l Ladder) For(qualifying uint64) (Tier, *Tier) {
+	if len(l) == 0 {
+		return Tier{Multiplier: 1}, nil
+	}
+	i := 0
+	for i+1 < len(l) && l[i+1].Threshold <= qualifying {
+		i++
+	}
+	return l[i], l.next(i)
+}
+
+// Get - метод, возвращающий уровень по названию.
+func (l Ladder) Get(name string) (Tier, bool) {
+	for _, t := range l {
+		if t.Name == name {
+			return t, true
+		}
+	}
+	return Tier{}, false
+}
+
+// Next - метод, возвращающий уровень, следующий за уровнем name, или nil, если name - высший уровень или его нет.
+func (l Ladder) Next(name string) *Tier {
+	for i, t := range l {
+		if t.Name == name {
+			return l.next(i)
+		}
+	}
+	return nil
+}
+
+// next - метод, возвращающий уровень после i-го.
+func (l Ladder) next(i int) *Tier {
+	if i+1 >= len(l) {
+		return nil
+	}
+	t := l[i+1]
+	return &t
+}
@@ -0,0 +1,104 @@
+package tier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    Ladder
+		wantErr bool
+	}{
+		{
+			name: "Ladder",
+			spec: "bronze:0:1, silver:1000:1.05,gold:5000.5:1.1",
+			want: Ladder{
+				{Name: "bronze", Threshold: 0, Multiplier: 1},
+				{Name: "silver", Threshold: 100000, Multiplier: 1.05},
+				{Name: "gold", Threshold: 500050, Multiplier: 1.1},
+			},
+		},
+		{
+			name: "Disabled",
+			spec: " ",
+		},
+		{
+			name:    "Missing part",
+			spec:    "bronze:0",
+			wantErr: true,
+		},
+		{
+			name:    "Nonzero first threshold",
+			spec:    "silver:1000:1.05",
+			wantErr: true,
+		},
+		{
+			name:    "Thresholds not ascending",
+			spec:    "bronze:0:1,gold:5000:1.1,silver:1000:1.05",
+			wantErr: true,
+		},
+		{
+			name:    "Duplicate name",
+			spec:    "bronze:0:1,bronze:1000:1.05",
+			wantErr: true,
+		},
+		{
+			name:    "Zero multiplier",
+			spec:    "bronze:0:0",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := Parse(tt.spec)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, l)
+		})
+	}
+}
+
+func TestLadder(t *testing.T) {
+	l, err := Parse("bronze:0:1,silver:1000:1.05,gold:5000:1.1")
+	require.NoError(t, err)
+	tests := []struct {
+		name       string
+		qualifying uint64
+		tier       string
+		next       string
+	}{
+		{name: "Start", qualifying: 0, tier: "bronze", next: "silver"},
+		{name: "Below threshold", qualifying: 99999, tier: "bronze", next: "silver"},
+		{name: "At threshold", qualifying: 100000, tier: "silver", next: "gold"},
+		{name: "Top tier", qualifying: 1000000, tier: "gold"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cur, next := l.For(tt.qualifying)
+			require.Equal(t, tt.tier, cur.Name)
+			if tt.next == "" {
+				require.Nil(t, next)
+				require.Nil(t, l.Next(cur.Name))
+				return
+			}
+			require.Equal(t, tt.next, next.Name)
+			require.Equal(t, tt.next, l.Next(cur.Name).Name)
+		})
+	}
+
+	silver, ok := l.Get("silver")
+	require.True(t, ok)
+	require.Equal(t, uint64(1050), silver.Apply(1000))
+	require.Equal(t, uint64(1), silver.Apply(1))
+
+	cur, next := Ladder(nil).For(100)
+	require.Equal(t, uint64(100), cur.Apply(100))
+	require.Nil(t, next)
+}
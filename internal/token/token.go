@@ -0,0 +1,96 @@
+package token
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+const issuer = "gophermart"
+
+var (
+	ErrInvalidToken = errors.New("invalid access token")
+	ErrTokenExpired = errors.New("access token has expired")
+)
+
+// Issuer - выпускает и проверяет короткоживущие access-токены (JWT, HS256).
+type Issuer struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// Claims - содержимое access-токена. Family связывает токен с семейством refresh-токенов, из которого он выпущен,
+// а Version - с версией токенов пользователя на момент выпуска.
+type Claims struct {
+	jwt.RegisteredClaims
+	Family  string `json:"fam,omitempty"`
+	Version int    `json:"ver,omitempty"`
+}
+
+// NewIssuer - конструктор выпускающего токены с ключом подписи и временем жизни токена.
+func NewIssuer(key []byte, ttl time.Duration) *Issuer {
+	return &Issuer{
+		key: key,
+		ttl: ttl,
+		now: time.Now,
+	}
+}
+
+// Issue - метод, выпускающий подписанный токен пользователя с версией токенов version. Возвращает токен и срок его действия.
+func (i *Issuer) Issue(userID uint64, family string, version int) (string, time.Time, error) {
+	now := i.now()
+	expiry := now.Add(i.ttl)
+	claims := Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    issuer,
+			Subject:   strconv.FormatUint(userID, 10),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiry),
+		},
+		Family:  family,
+		Version: version,
+	}
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(i.key)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to sign access token - %s", err.Error())
+	}
+	return signed, expiry, nil
+}
+
+// Parse - метод, проверяющий подпись и срок действия токена и возвращающий его содержимое.
+func (i *Issuer) Parse(signed string) (*Claims, error) {
+	claims := &Claims{}
+	parser := jwt.Parser{ValidMethods: []string{jwt.SigningMethodHS256.Alg()}}
+	_, err := parser.ParseWithClaims(signed, claims, func(*jwt.Token) (interface{}, error) {
+		return i.key, nil
+	})
+	if err != nil {
+		var vErr *jwt.ValidationError
+		if errors.As(err, &vErr) && vErr.Errors == jwt.ValidationErrorExpired {
+			return nil, ErrTokenExpired
+		}
+		return nil, fmt.Errorf("%w - %s", ErrInvalidToken, err.Error())
+	}
+	if claims.Issuer != issuer || claims.ExpiresAt == nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.ExpiresAt.Time.Before(i.now()) {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// UserID - метод, возвращающий ID пользователя из subject токена.
+func (c *Claims) UserID() (uint64, error) {
+	id, err := strconv.ParseUint(c.Subject, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w - bad subject", ErrInvalidToken)
+	}
+	return id, nil
+}
@@ -0,0 +1,80 @@
+package token
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssuer(t *testing.T) {
+	key := []byte("secret")
+	issuer := NewIssuer(key, time.Minute)
+	signed, _, err := issuer.Issue(42, "family", 3)
+	require.NoError(t, err)
+
+	expired := NewIssuer(key, time.Minute)
+	expired.now = func() time.Time { return time.Now().Add(-time.Hour) }
+	expiredToken, _, err := expired.Issue(42, "family", 3)
+	require.NoError(t, err)
+
+	unsigned, err := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{
+		Issuer:    "gophermart",
+		Subject:   "42",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		issuer *Issuer
+		token  string
+		err    error
+	}{
+		{
+			name:   "Positive",
+			issuer: issuer,
+			token:  signed,
+		},
+		{
+			name:   "Expired",
+			issuer: issuer,
+			token:  expiredToken,
+			err:    ErrTokenExpired,
+		},
+		{
+			name:   "Wrong key",
+			issuer: NewIssuer([]byte("other"), time.Minute),
+			token:  signed,
+			err:    ErrInvalidToken,
+		},
+		{
+			name:   "Unsigned",
+			issuer: issuer,
+			token:  unsigned,
+			err:    ErrInvalidToken,
+		},
+		{
+			name:   "Garbage",
+			issuer: issuer,
+			token:  "not.a.token",
+			err:    ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := tt.issuer.Parse(tt.token)
+			if tt.err != nil {
+				require.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			id, err := claims.UserID()
+			require.NoError(t, err)
+			require.Equal(t, uint64(42), id)
+			require.Equal(t, "family", claims.Family)
+			require.Equal(t, 3, claims.Version)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Параметры кодов: значения по умолчанию RFC 6238, которые поддерживают все приложения-аутентификаторы.
+const (
+	Digits = 6
+	Period = 30
+)
+
+var ErrInvalidSecret = errors.New("invalid TOTP secret")
+
+var (
+	secretEncoding   = base32.StdEncoding.WithPadding(base32.NoPadding)
+	recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+)
+
+// GenerateSecret - функция, создающая случайный секрет в base32 (160 бит, как рекомендует RFC 4226).
+func GenerateSecret() (string, error) {
+	key := make([]byte, 20)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("failed to generate TOTP secret - %s", err.Error())
+	}
+	return secretEncoding.EncodeToString(key), nil
+}
+
+// URI - функция, возвращающая otpauth:// URI для QR-кода, который сканирует приложение-аутентификатор.
+func URI(issuer, account, secret string) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(Period))
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
+
+// Code - функция, вычисляющая код для момента t.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, step(t), Digits), nil
+}
+
+// Validate - функция, проверяющая код с допуском skew шагов в обе стороны на расхождение часов.
+// Возвращает шаг, которому соответствует код: повторное использование шага нужно отклонять.
+func Validate(secret, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+	current := int64(step(t))
+	for i := -skew; i <= skew; i++ {
+		s := current + int64(i)
+		if s < 0 {
+			continue
+		}
+		if hmac.Equal([]byte(hotp(key, uint64(s), Digits)), []byte(code)) {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// IsCode - функция, проверяющая, что строка похожа на код TOTP, а не на код восстановления.
+func IsCode(code string) bool {
+	if len(code) != Digits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// RecoveryCodes - функция, создающая n одноразовых кодов восстановления вида xxxxx-xxxxx (50 бит каждый).
+func RecoveryCodes(n int) ([]string, error) {
+	codes := make([]string, 0, n)
+	buf := make([]byte, 7)
+	for len(codes) < n {
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("failed to generate recovery codes - %s", err.Error())
+		}
+		c := recoveryEncoding.EncodeToString(buf)[:10]
+		codes = append(codes, c[:5]+"-"+c[5:])
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode - функция, приводящая введенный код восстановления к виду для сравнения: без разделителей и в нижнем регистре.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// step - функция, возвращающая номер шага времени для момента t.
+func step(t time.Time) uint64 {
+	return uint64(t.Unix()) / Period
+}
+
+// decodeSecret - функция, разбирающая секрет в base32 без учета регистра, пробелов и выравнивания.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := secretEncoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// hotp - функция, вычисляющая код HOTP (RFC 4226) со счетчиком counter.
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,113 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHOTP(t *testing.T) {
+	// Тестовые векторы RFC 6238, приложение B (SHA1).
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			require.Equal(t, tt.code, hotp(key, step(time.Unix(tt.unix, 0)), 8))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	require.NoError(t, err)
+	now := time.Unix(1_700_000_000, 0)
+	code, err := Code(secret, now)
+	require.NoError(t, err)
+	prev, err := Code(secret, now.Add(-Period*time.Second))
+	require.NoError(t, err)
+	old, err := Code(secret, now.Add(-3*Period*time.Second))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		step   int64
+		ok     bool
+	}{
+		{
+			name:   "Current code",
+			secret: secret,
+			code:   code,
+			step:   now.Unix() / Period,
+			ok:     true,
+		},
+		{
+			name:   "Previous code within skew",
+			secret: secret,
+			code:   prev,
+			step:   now.Unix()/Period - 1,
+			ok:     true,
+		},
+		{
+			name:   "Code outside skew",
+			secret: secret,
+			code:   old,
+		},
+		{
+			name:   "Wrong length",
+			secret: secret,
+			code:   code[:5],
+		},
+		{
+			name:   "Invalid secret",
+			secret: "not base32!",
+			code:   code,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := Validate(tt.secret, tt.code, now, 1)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.step, s)
+		})
+	}
+}
+
+func TestURI(t *testing.T) {
+	u, err := url.Parse(URI("Gophermart", "gopher", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	require.Equal(t, "otpauth", u.Scheme)
+	require.Equal(t, "totp", u.Host)
+	require.Equal(t, "/Gophermart:gopher", u.Path)
+	require.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	require.Equal(t, "Gophermart", u.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := RecoveryCodes(10)
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+	seen := make(map[string]struct{})
+	for _, c := range codes {
+		require.Len(t, c, 11)
+		require.False(t, IsCode(c))
+		seen[NormalizeRecoveryCode(c)] = struct{}{}
+	}
+	require.Len(t, seen, 10)
+	require.Equal(t, NormalizeRecoveryCode(codes[0]), NormalizeRecoveryCode(" "+strings.ToUpper(codes[0])))
+	require.True(t, IsCode("012345"))
+}
@@ -0,0 +1,130 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+	"github.com/gtgaleevtimur/gofermart/internal/loon"
+)
+
+// Форматы пакетной загрузки заказов по типу содержимого.
+const (
+	FormatJSON = "application/json"
+	FormatText = "text/plain"
+	FormatCSV  = "text/csv"
+)
+
+// ErrUnsupportedFormat - тип содержимого загрузки не входит в поддерживаемые форматы.
+var ErrUnsupportedFormat = errors.New("unsupported upload format")
+
+// csvHeaders - названия первой колонки, по которым первая строка CSV считается заголовком.
+var csvHeaders = []string{"order", "number", "order_number", "order_id"}
+
+// Parse - функция, читающая номера заказов из тела загрузки в формате, заданном типом содержимого contentType:
+// JSON-массив строк или чисел, текст с номером в каждой строке или CSV с номером в первой колонке.
+// Пустые строки пропускаются, Line каждого номера - его строка в тексте и CSV или позиция в JSON-массиве.
+func Parse(contentType string, body []byte) ([]entity.OrderUploadLine, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, ErrUnsupportedFormat
+	}
+	switch mediaType {
+	case FormatJSON:
+		return parseJSON(body)
+	case FormatText:
+		return parseText(body), nil
+	case FormatCSV:
+		return parseCSV(body)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+// parseJSON - функция, читающая номера заказов из JSON-массива. Элементы, не являющиеся строкой или числом,
+// сохраняются как есть и не проходят проверку номера.
+func parseJSON(body []byte) ([]entity.OrderUploadLine, error) {
+	var items []json.RawMessage
+	err := json.Unmarshal(body, &items)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON array - %s", err.Error())
+	}
+	lines := make([]entity.OrderUploadLine, 0, len(items))
+	for i, item := range items {
+		order := string(bytes.TrimSpace(item))
+		var s string
+		if json.Unmarshal(item, &s) == nil {
+			order = s
+		}
+		lines = append(lines, entity.OrderUploadLine{Line: i + 1, Order: strings.TrimSpace(order)})
+	}
+	return lines, nil
+}
+
+// parseText - функция, читающая по одному номеру заказа из каждой непустой строки текста.
+func parseText(body []byte) []entity.OrderUploadLine {
+	lines := make([]entity.OrderUploadLine, 0)
+	for i, s := range strings.Split(string(body), "\n") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		lines = append(lines, entity.OrderUploadLine{Line: i + 1, Order: s})
+	}
+	return lines
+}
+
+// parseCSV - функция, читающая номера заказов из первой колонки CSV. Первая строка пропускается,
+// если в первой колонке одно из названий csvHeaders.
+func parseCSV(body []byte) ([]entity.OrderUploadLine, error) {
+	r := csv.NewReader(bytes.NewReader(body))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	lines := make([]entity.OrderUploadLine, 0)
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV - %s", err.Error())
+		}
+		order := strings.TrimSpace(record[0])
+		if first && isCSVHeader(order) {
+			continue
+		}
+		if order == "" {
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		lines = append(lines, entity.OrderUploadLine{Line: line, Order: order})
+	}
+	return lines, nil
+}
+
+// isCSVHeader - функция, проверяющая, что ячейка - название колонки с номерами заказов.
+func isCSVHeader(cell string) bool {
+	cell = strings.ToLower(cell)
+	for _, h := range csvHeaders {
+		if cell == h {
+			return true
+		}
+	}
+	return false
+}
+
+// Number - функция, возвращающая номер заказа, если строка состоит из цифр, помещается в bigint
+// и проходит проверку алгоритмом Луна.
+func Number(order string) (uint64, bool) {
+	id, err := strconv.ParseUint(order, 10, 63)
+	if err != nil || !loon.IsValid(order) {
+		return 0, false
+	}
+	return id, true
+}
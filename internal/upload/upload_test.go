@@ -0,0 +1,123 @@
+package upload
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/gtgaleevtimur/gofermart/internal/entity"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        []entity.OrderUploadLine
+		wantErr     bool
+		errIs       error
+	}{
+		{
+			name:        "JSON strings and numbers",
+			contentType: "application/json; charset=utf-8",
+			body:        `["12345678903", 4561261212345467, " 79927398713 ", true]`,
+			want: []entity.OrderUploadLine{
+				{Line: 1, Order: "12345678903"},
+				{Line: 2, Order: "4561261212345467"},
+				{Line: 3, Order: "79927398713"},
+				{Line: 4, Order: "true"},
+			},
+		},
+		{
+			name:        "Text with blank lines",
+			contentType: "text/plain",
+			body:        "12345678903\r\n\n  79927398713 \nabc\n",
+			want: []entity.OrderUploadLine{
+				{Line: 1, Order: "12345678903"},
+				{Line: 3, Order: "79927398713"},
+				{Line: 4, Order: "abc"},
+			},
+		},
+		{
+			name:        "CSV with header",
+			contentType: "text/csv",
+			body:        "order,comment\n12345678903,first\n\n79927398713\n\"4561261212345467\",\"a, b\"\n",
+			want: []entity.OrderUploadLine{
+				{Line: 2, Order: "12345678903"},
+				{Line: 4, Order: "79927398713"},
+				{Line: 5, Order: "4561261212345467"},
+			},
+		},
+		{
+			name:        "CSV without header",
+			contentType: "text/csv",
+			body:        "12345678903\n79927398713",
+			want: []entity.OrderUploadLine{
+				{Line: 1, Order: "12345678903"},
+				{Line: 2, Order: "79927398713"},
+			},
+		},
+		{
+			name:        "Empty JSON array",
+			contentType: "application/json",
+			body:        `[]`,
+			want:        []entity.OrderUploadLine{},
+		},
+		{
+			name:        "JSON object",
+			contentType: "application/json",
+			body:        `{"orders": []}`,
+			wantErr:     true,
+		},
+		{
+			name:        "Broken CSV quote",
+			contentType: "text/csv",
+			body:        "\"12345678903\n",
+			wantErr:     true,
+		},
+		{
+			name:        "Unsupported format",
+			contentType: "application/xml",
+			body:        "<orders/>",
+			wantErr:     true,
+			errIs:       ErrUnsupportedFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.contentType, []byte(tt.body))
+			if tt.wantErr {
+				require.Error(t, err)
+				if tt.errIs != nil {
+					require.ErrorIs(t, err, tt.errIs)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNumber(t *testing.T) {
+	tests := []struct {
+		order string
+		want  uint64
+		ok    bool
+	}{
+		{"12345678903", 12345678903, true},
+		{"79927398713", 79927398713, true},
+		{"12345678900", 0, false},
+		{"1234567890a", 0, false},
+		{"-12345678903", 0, false},
+		{"", 0, false},
+		{"99999999999999999999", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.order, func(t *testing.T) {
+			got, ok := Number(tt.order)
+			require.Equal(t, tt.ok, ok)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
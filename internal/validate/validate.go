@@ -0,0 +1,266 @@
+package validate
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"net/mail"
+	"os"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// DefaultLoginPattern - допустимые символы логина по умолчанию: буквы, цифры и . _ - + @.
+const DefaultLoginPattern = `^[\p{L}\p{N}._\-+@]+$`
+
+var ErrValidation = errors.New("validation failed")
+
+// FieldError - нарушение правила для одного поля запроса.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Errors - список нарушений по полям, возвращаемый как одна ошибка.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, f := range e {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return fmt.Sprintf("%s - %s", ErrValidation.Error(), strings.Join(msgs, "; "))
+}
+
+func (e Errors) Unwrap() error {
+	return ErrValidation
+}
+
+// Policy - правила для логина и пароля при регистрации.
+type Policy struct {
+	LoginMinLength    int
+	LoginMaxLength    int
+	LoginPattern      *regexp.Regexp
+	PasswordMinLength int
+	PasswordMaxBytes  int
+	common            map[string]struct{}
+}
+
+// NewPolicy - конструктор правил. Пустой pattern означает DefaultLoginPattern, пустой commonFile - без списка распространенных паролей.
+func NewPolicy(loginMin, loginMax int, pattern string, passwordMin, passwordMaxBytes int, commonFile string) (*Policy, error) {
+	if pattern == "" {
+		pattern = DefaultLoginPattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid login pattern - %s", err.Error())
+	}
+	p := &Policy{
+		LoginMinLength:    loginMin,
+		LoginMaxLength:    loginMax,
+		LoginPattern:      re,
+		PasswordMinLength: passwordMin,
+		PasswordMaxBytes:  passwordMaxBytes,
+		common:            make(map[string]struct{}),
+	}
+	if commonFile != "" {
+		err = p.loadCommon(commonFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// loadCommon - метод, загружающий список распространенных или утекших паролей: по одному в строке, строки с # пропускаются.
+func (p *Policy) loadCommon(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open password list - %s", err.Error())
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.common[strings.ToLower(line)] = struct{}{}
+	}
+	if err = sc.Err(); err != nil {
+		return fmt.Errorf("failed to read password list - %s", err.Error())
+	}
+	return nil
+}
+
+// CheckCredentials - метод, проверяющий логин и пароль нового пользователя. Возвращает Errors со всеми нарушениями.
+func (p *Policy) CheckCredentials(login, password string) error {
+	errs := p.checkLogin(login)
+	errs = append(errs, p.checkPassword(login, password)...)
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CheckPassword - метод, проверяющий новый пароль пользователя с логином login.
+func (p *Policy) CheckPassword(login, password string) error {
+	if errs := p.checkPassword(login, password); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// CheckEmail - метод, проверяющий адрес почты для восстановления пароля. Пустой адрес допустим.
+func (p *Policy) CheckEmail(email string) error {
+	if email == "" {
+		return nil
+	}
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return Errors{FieldError{"email", "invalid_format", "email is not a valid address"}}
+	}
+	return nil
+}
+
+// apiKeyNameMaxLength - предельная длина названия API-ключа.
+const apiKeyNameMaxLength = 64
+
+// CheckAPIKey - функция, проверяющая название и области действия нового API-ключа. allowed - все допустимые области.
+func CheckAPIKey(name string, scopes, allowed []string) error {
+	errs := make(Errors, 0)
+	if strings.TrimSpace(name) == "" {
+		errs = append(errs, FieldError{"name", "required", "name is required"})
+	} else if utf8.RuneCountInString(name) > apiKeyNameMaxLength {
+		errs = append(errs, FieldError{"name", "too_long", fmt.Sprintf("name must be at most %d characters", apiKeyNameMaxLength)})
+	}
+	if len(scopes) == 0 {
+		errs = append(errs, FieldError{"scopes", "required", "at least one scope is required"})
+	}
+	for _, scope := range scopes {
+		if !contains(allowed, scope) {
+			errs = append(errs, FieldError{"scopes", "invalid_value", fmt.Sprintf("unknown scope `%s`", scope)})
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// adjustmentCommentMaxLength - предельная длина комментария к корректировке баланса.
+const adjustmentCommentMaxLength = 500
+
+// AdjustmentMaxAmount - предельная сумма ручной корректировки баланса по модулю в баллах.
+const AdjustmentMaxAmount = 1000000
+
+// CheckAdjustment - функция, проверяющая ручную корректировку баланса: ненулевую сумму с точностью до копейки
+// не больше AdjustmentMaxAmount по модулю, код причины из reasons и комментарий.
+func CheckAdjustment(amount float64, reason, comment string, reasons []string) error {
+	errs := make(Errors, 0)
+	if math.IsNaN(amount) || math.Abs(amount) > AdjustmentMaxAmount {
+		errs = append(errs, FieldError{"amount", "too_large", fmt.Sprintf("amount must be at most %d in absolute value", AdjustmentMaxAmount)})
+	} else if math.Round(amount*100) == 0 {
+		errs = append(errs, FieldError{"amount", "invalid_value", "amount must not be zero"})
+	}
+	if reason == "" {
+		errs = append(errs, FieldError{"reason", "required", "reason is required"})
+	} else if !contains(reasons, reason) {
+		errs = append(errs, FieldError{"reason", "invalid_value", fmt.Sprintf("unknown reason `%s`", reason)})
+	}
+	if strings.TrimSpace(comment) == "" {
+		errs = append(errs, FieldError{"comment", "required", "comment is required"})
+	} else if utf8.RuneCountInString(comment) > adjustmentCommentMaxLength {
+		errs = append(errs, FieldError{"comment", "too_long", fmt.Sprintf("comment must be at most %d characters", adjustmentCommentMaxLength)})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// transferCommentMaxLength - предельная длина комментария к переводу баллов.
+const transferCommentMaxLength = 200
+
+// CheckTransfer - функция, проверяющая перевод баллов: логин получателя, положительную сумму с точностью до копейки
+// и необязательный комментарий.
+func CheckTransfer(to string, amount float64, comment string) error {
+	errs := make(Errors, 0)
+	if strings.TrimSpace(to) == "" {
+		errs = append(errs, FieldError{"to", "required", "recipient login is required"})
+	}
+	if math.Round(amount*100) <= 0 {
+		errs = append(errs, FieldError{"amount", "invalid_value", "amount must be positive"})
+	}
+	if utf8.RuneCountInString(comment) > transferCommentMaxLength {
+		errs = append(errs, FieldError{"comment", "too_long", fmt.Sprintf("comment must be at most %d characters", transferCommentMaxLength)})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// contains - функция, проверяющая наличие строки в списке.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// checkLogin - метод, проверяющий формат и длину логина.
+func (p *Policy) checkLogin(login string) Errors {
+	errs := make(Errors, 0)
+	if strings.TrimSpace(login) == "" {
+		return append(errs, FieldError{"login", "required", "login is required"})
+	}
+	if !utf8.ValidString(login) {
+		return append(errs, FieldError{"login", "invalid_format", "login is not valid UTF-8"})
+	}
+	length := utf8.RuneCountInString(norm.NFKC.String(login))
+	if p.LoginMinLength > 0 && length < p.LoginMinLength {
+		errs = append(errs, FieldError{"login", "too_short", fmt.Sprintf("login must be at least %d characters", p.LoginMinLength)})
+	}
+	if p.LoginMaxLength > 0 && length > p.LoginMaxLength {
+		errs = append(errs, FieldError{"login", "too_long", fmt.Sprintf("login must be at most %d characters", p.LoginMaxLength)})
+	}
+	if !p.LoginPattern.MatchString(login) {
+		errs = append(errs, FieldError{"login", "invalid_format", "login contains forbidden characters"})
+	}
+	return errs
+}
+
+// checkPassword - метод, проверяющий длину пароля и его отсутствие в списке распространенных.
+func (p *Policy) checkPassword(login, password string) Errors {
+	errs := make(Errors, 0)
+	if password == "" {
+		return append(errs, FieldError{"password", "required", "password is required"})
+	}
+	if p.PasswordMinLength > 0 && utf8.RuneCountInString(password) < p.PasswordMinLength {
+		errs = append(errs, FieldError{"password", "too_short", fmt.Sprintf("password must be at least %d characters", p.PasswordMinLength)})
+	}
+	if p.PasswordMaxBytes > 0 && len(password) > p.PasswordMaxBytes {
+		errs = append(errs, FieldError{"password", "too_long", fmt.Sprintf("password must be at most %d bytes", p.PasswordMaxBytes)})
+	}
+	if _, ok := p.common[strings.ToLower(password)]; ok {
+		errs = append(errs, FieldError{"password", "too_common", "password is too common"})
+	}
+	if login != "" && CanonicalLogin(password) == CanonicalLogin(login) {
+		errs = append(errs, FieldError{"password", "same_as_login", "password must differ from login"})
+	}
+	return errs
+}
+
+// CanonicalLogin - функция, приводящая логин к виду для проверки уникальности: NFKC и свертка регистра.
+func CanonicalLogin(login string) string {
+	return cases.Fold().String(norm.NFKC.String(login))
+}
@@ -0,0 +1,274 @@
+package validate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCredentials(t *testing.T) {
+	common := filepath.Join(t.TempDir(), "common.txt")
+	require.NoError(t, os.WriteFile(common, []byte("# top passwords\nqwerty123\nPassword1\n"), 0o600))
+	policy, err := NewPolicy(3, 16, "", 8, 72, common)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		codes    []string
+	}{
+		{
+			name:     "Positive",
+			login:    "gopher",
+			password: "correct horse",
+		},
+		{
+			name:     "Empty",
+			login:    "   ",
+			password: "",
+			codes:    []string{"required", "required"},
+		},
+		{
+			name:     "Short login and password",
+			login:    "go",
+			password: "short",
+			codes:    []string{"too_short", "too_short"},
+		},
+		{
+			name:     "Forbidden characters",
+			login:    "go pher",
+			password: "correct horse",
+			codes:    []string{"invalid_format"},
+		},
+		{
+			name:     "Long login",
+			login:    "gopher_gopher_gopher",
+			password: "correct horse",
+			codes:    []string{"too_long"},
+		},
+		{
+			name:     "Password over bcrypt limit",
+			login:    "gopher",
+			password: string(make([]byte, 73)),
+			codes:    []string{"too_long"},
+		},
+		{
+			name:     "Common password",
+			login:    "gopher",
+			password: "PASSWORD1",
+			codes:    []string{"too_common"},
+		},
+		{
+			name:     "Password same as login",
+			login:    "Gopher_2022",
+			password: "gopher_2022",
+			codes:    []string{"same_as_login"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.CheckCredentials(tt.login, tt.password)
+			if tt.codes == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrValidation)
+			var errs Errors
+			require.ErrorAs(t, err, &errs)
+			codes := make([]string, 0, len(errs))
+			for _, e := range errs {
+				codes = append(codes, e.Code)
+			}
+			require.Equal(t, tt.codes, codes)
+		})
+	}
+}
+
+func TestCheckEmail(t *testing.T) {
+	policy, err := NewPolicy(3, 16, "", 8, 72, "")
+	require.NoError(t, err)
+	require.NoError(t, policy.CheckEmail(""))
+	require.NoError(t, policy.CheckEmail("gopher@example.com"))
+	require.ErrorIs(t, policy.CheckEmail("gopher"), ErrValidation)
+	require.ErrorIs(t, policy.CheckEmail("Gopher <gopher@example.com>"), ErrValidation)
+	require.ErrorIs(t, policy.CheckEmail("gopher@example.com\r\nBcc: spam@example.com"), ErrValidation)
+}
+
+func TestCheckAPIKey(t *testing.T) {
+	allowed := []string{"orders:write", "balance:read"}
+	tests := []struct {
+		name   string
+		key    string
+		scopes []string
+		fields []string
+	}{
+		{
+			name:   "Valid key",
+			key:    "checkout",
+			scopes: []string{"orders:write", "balance:read"},
+		},
+		{
+			name:   "Empty name and scopes",
+			key:    " ",
+			fields: []string{"name", "scopes"},
+		},
+		{
+			name:   "Unknown scope",
+			key:    "checkout",
+			scopes: []string{"orders:write", "admin"},
+			fields: []string{"scopes"},
+		},
+		{
+			name:   "Too long name",
+			key:    strings.Repeat("k", 65),
+			scopes: []string{"balance:read"},
+			fields: []string{"name"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckAPIKey(tt.key, tt.scopes, allowed)
+			if len(tt.fields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var errs Errors
+			require.ErrorAs(t, err, &errs)
+			fields := make([]string, 0, len(errs))
+			for _, f := range errs {
+				fields = append(fields, f.Field)
+			}
+			require.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestCheckAdjustment(t *testing.T) {
+	reasons := []string{"missing_accrual", "goodwill"}
+	tests := []struct {
+		name    string
+		amount  float64
+		reason  string
+		comment string
+		fields  []string
+	}{
+		{
+			name:    "Credit",
+			amount:  150.5,
+			reason:  "missing_accrual",
+			comment: "order 12345678903 was not accrued",
+		},
+		{
+			name:    "Debit",
+			amount:  -20,
+			reason:  "goodwill",
+			comment: "duplicate bonus",
+		},
+		{
+			name:   "Empty",
+			fields: []string{"amount", "reason", "comment"},
+		},
+		{
+			name:    "Less than a kopeck",
+			amount:  0.001,
+			reason:  "goodwill",
+			comment: "rounding",
+			fields:  []string{"amount"},
+		},
+		{
+			name:    "Unknown reason and long comment",
+			amount:  10,
+			reason:  "because",
+			comment: strings.Repeat("c", 501),
+			fields:  []string{"reason", "comment"},
+		},
+		{
+			name:    "Too large",
+			amount:  -1e18,
+			reason:  "goodwill",
+			comment: "overflow",
+			fields:  []string{"amount"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckAdjustment(tt.amount, tt.reason, tt.comment, reasons)
+			if len(tt.fields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var errs Errors
+			require.ErrorAs(t, err, &errs)
+			fields := make([]string, 0, len(errs))
+			for _, f := range errs {
+				fields = append(fields, f.Field)
+			}
+			require.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestCheckTransfer(t *testing.T) {
+	tests := []struct {
+		name    string
+		to      string
+		amount  float64
+		comment string
+		fields  []string
+	}{
+		{
+			name:    "Valid",
+			to:      "gopher",
+			amount:  12.5,
+			comment: "for dinner",
+		},
+		{
+			name:   "Without comment",
+			to:     "gopher",
+			amount: 0.01,
+		},
+		{
+			name:   "Empty",
+			fields: []string{"to", "amount"},
+		},
+		{
+			name:   "Negative amount",
+			to:     "gopher",
+			amount: -5,
+			fields: []string{"amount"},
+		},
+		{
+			name:    "Less than a kopeck and long comment",
+			to:      "gopher",
+			amount:  0.004,
+			comment: strings.Repeat("c", 201),
+			fields:  []string{"amount", "comment"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckTransfer(tt.to, tt.amount, tt.comment)
+			if len(tt.fields) == 0 {
+				require.NoError(t, err)
+				return
+			}
+			var errs Errors
+			require.ErrorAs(t, err, &errs)
+			fields := make([]string, 0, len(errs))
+			for _, f := range errs {
+				fields = append(fields, f.Field)
+			}
+			require.Equal(t, tt.fields, fields)
+		})
+	}
+}
+
+func TestCanonicalLogin(t *testing.T) {
+	require.Equal(t, CanonicalLogin("gopher"), CanonicalLogin("GOPHER"))
+	require.Equal(t, CanonicalLogin("ﬁle"), CanonicalLogin("FILE"))
+	require.Equal(t, CanonicalLogin("Straße"), CanonicalLogin("STRASSE"))
+}